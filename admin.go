@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"librecash/objects"
+	"librecash/repository"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runAdminCommand groups operator tasks that run without starting the bot.
+//
+// Usage:
+//
+//	librecash admin dlq list|replay [-limit N] [-queue NAME]
+//	librecash admin user [-db CONN] USER_ID
+//	librecash admin fanout [-db CONN] [-n N] [EXCHANGE_ID]
+func runAdminCommand(args []string) int {
+	if len(args) == 0 {
+		printAdminUsage()
+		return 2
+	}
+
+	switch args[0] {
+	case "dlq":
+		return runDeadLetterCommand(args[1:])
+	case "user":
+		return runAdminUserCommand(args[1:])
+	case "fanout":
+		return runAdminFanoutCommand(args[1:])
+	default:
+		printAdminUsage()
+		return 2
+	}
+}
+
+// runAdminUserCommand prints what the bot knows about a user, for answering support requests
+func runAdminUserCommand(args []string) int {
+	flags := flag.NewFlagSet("admin user", flag.ContinueOnError)
+	dbConnStr := flags.String("db", "", "PostgreSQL connection string (default: db_conn_str from librecash.yml)")
+	flags.Usage = printAdminUsage
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		printAdminUsage()
+		return 2
+	}
+	userID, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid user ID %q\n", flags.Arg(0))
+		return 2
+	}
+
+	db, err := openDatabase(*dbConnStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	repo := repository.NewRepository(db)
+
+	user := repo.FindUser(userID)
+	if user == nil {
+		fmt.Fprintf(os.Stderr, "User %d not found\n", userID)
+		return 1
+	}
+
+	radius := "not set"
+	if user.SearchRadiusKm != nil {
+		radius = fmt.Sprintf("%d km", *user.SearchRadiusKm)
+	}
+	fmt.Printf("user:        %d @%s (%s %s)\n", user.UserId, user.Username, user.FirstName, user.LastName)
+	fmt.Printf("language:    %s\n", user.LanguageCode)
+	fmt.Printf("menu:        %d\n", user.MenuId)
+	fmt.Printf("location:    %.5f,%.5f\n", user.Lat, user.Lon)
+	fmt.Printf("radius:      %s\n", radius)
+	fmt.Printf("phone:       %t\n", user.PhoneNumber != "")
+	fmt.Printf("unreachable: %t\n", user.Unreachable)
+
+	exchange, err := repo.GetLastUserExchange(userID)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Failed to load last exchange: %v\n", err)
+		return 1
+	case exchange == nil:
+		fmt.Println("last exchange: none")
+	default:
+		fmt.Printf("last exchange: #%d %s $%s %s at %s\n", exchange.ID, exchange.ExchangeDirection,
+			formatAmount(exchange.AmountUSD), exchange.Status, exchange.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	return 0
+}
+
+// runAdminFanoutCommand prints the stats of recent fanout jobs, or of the job of one exchange
+func runAdminFanoutCommand(args []string) int {
+	flags := flag.NewFlagSet("admin fanout", flag.ContinueOnError)
+	dbConnStr := flags.String("db", "", "PostgreSQL connection string (default: db_conn_str from librecash.yml)")
+	limit := flags.Int("n", 20, "number of recent jobs to list")
+	flags.Usage = printAdminUsage
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		printAdminUsage()
+		return 2
+	}
+
+	var exchangeID int64
+	if flags.NArg() == 1 {
+		var err error
+		if exchangeID, err = strconv.ParseInt(flags.Arg(0), 10, 64); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid exchange ID %q\n", flags.Arg(0))
+			return 2
+		}
+	}
+
+	db, err := openDatabase(*dbConnStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	repo := repository.NewRepository(db)
+
+	var jobs []*objects.FanoutJob
+	if exchangeID != 0 {
+		job, err := repo.GetFanoutJobByExchange(exchangeID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load fanout job: %v\n", err)
+			return 1
+		}
+		if job == nil {
+			fmt.Fprintf(os.Stderr, "Exchange %d has no fanout job\n", exchangeID)
+			return 1
+		}
+		jobs = append(jobs, job)
+	} else if jobs, err = repo.ListFanoutJobs(*limit); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list fanout jobs: %v\n", err)
+		return 1
+	}
+
+	fmt.Print(formatFanoutJobs(jobs))
+	return 0
+}
+
+// formatFanoutJobs renders one line of stats per job
+func formatFanoutJobs(jobs []*objects.FanoutJob) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%-6s %-9s %-8s %6s %6s %6s %6s %8s  %s\n",
+		"job", "exchange", "status", "found", "queued", "sent", "failed", "attempts", "created")
+	for _, job := range jobs {
+		fmt.Fprintf(&out, "%-6d %-9d %-8s %6d %6d %6d %6d %8d  %s\n",
+			job.ID, job.ExchangeID, job.Status, job.Found, job.Queued, job.Sent, job.Failed, job.Attempts,
+			job.CreatedAt.Format("2006-01-02 15:04:05"))
+		if job.LastError != nil && job.Status != objects.FanoutJobDone {
+			fmt.Fprintf(&out, "       last error: %s\n", *job.LastError)
+		}
+	}
+	return out.String()
+}
+
+func formatAmount(amount *int) string {
+	if amount == nil {
+		return "?"
+	}
+	return strconv.Itoa(*amount)
+}
+
+func printAdminUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  librecash admin dlq list|replay [-limit N] [-queue NAME]  Inspect or replay the dead-letter queue")
+	fmt.Fprintln(os.Stderr, "  librecash admin user [-db CONN] USER_ID                   Show a user's state and last exchange")
+	fmt.Fprintln(os.Stderr, "  librecash admin fanout [-db CONN] [-n N] [EXCHANGE_ID]    Show fanout job stats")
+}
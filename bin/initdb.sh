@@ -31,26 +31,29 @@ for i in {1..30}; do
     sleep 1
 done
 
-# Initialize database schema
-echo -e "${YELLOW}🔄 Initializing database schema...${NC}"
-echo -e "${YELLOW}  ⚠️  This will DROP and recreate all tables!${NC}"
+# Apply schema migrations
+echo -e "${YELLOW}🔄 Applying database migrations...${NC}"
 
-# Check for -y flag for non-interactive mode
-if [[ "$1" != "-y" ]]; then
-    read -p "Are you sure? (y/N): " -n 1 -r
-    echo
-    if [[ ! $REPLY =~ ^[Yy]$ ]]; then
-        echo -e "${YELLOW}❌ Database initialization cancelled${NC}"
-        exit 0
+# --reset drops everything first, for a clean development database
+if [[ "$1" == "--reset" ]]; then
+    echo -e "${YELLOW}  ⚠️  This will DROP all tables and data!${NC}"
+    if [[ "$2" != "-y" ]]; then
+        read -p "Are you sure? (y/N): " -n 1 -r
+        echo
+        if [[ ! $REPLY =~ ^[Yy]$ ]]; then
+            echo -e "${YELLOW}❌ Database reset cancelled${NC}"
+            exit 0
+        fi
     fi
+    docker compose exec -T -e PGPASSWORD=librecash db psql -h localhost -U librecash -d librecash -c "DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public; CREATE EXTENSION IF NOT EXISTS postgis;" >/dev/null
 fi
 
-docker compose exec -T -e PGPASSWORD=librecash db psql -h localhost -U librecash -d librecash < db/init.sql
+go run . migrate up
 
 if [ $? -eq 0 ]; then
-    echo -e "${GREEN}✅ Database schema initialized successfully${NC}"
+    echo -e "${GREEN}✅ Database schema is up to date${NC}"
 else
-    echo -e "${RED}❌ Failed to initialize database schema${NC}"
+    echo -e "${RED}❌ Failed to apply database migrations${NC}"
     exit 1
 fi
 
@@ -27,10 +27,10 @@ if ! docker compose exec -T -e PGPASSWORD=librecash db psql -h localhost -U libr
     exit 1
 fi
 
-# Check if database schema exists
-if ! docker compose exec -T -e PGPASSWORD=librecash db psql -h localhost -U librecash -d librecash -c "SELECT 1 FROM users LIMIT 1;" >/dev/null 2>&1; then
-    echo -e "${RED}❌ Database schema is not initialized!${NC}"
-    echo -e "${YELLOW}💡 Run ${BLUE}./initdb.sh${NC} to initialize the database schema${NC}"
+# Check that all schema migrations are applied
+if ! go run . migrate status >/dev/null 2>&1; then
+    echo -e "${RED}❌ Database schema is not up to date!${NC}"
+    echo -e "${YELLOW}💡 Run ${BLUE}./initdb.sh${NC} to apply pending migrations${NC}"
     exit 1
 fi
 
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"librecash/config"
+	"os"
+	"sync"
+)
+
+// command is a librecash subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// config loads librecash.yml before run. Commands that can work without it
+	// (replay, migrate -db) call loadConfig themselves when they need it.
+	config bool
+	run    func(args []string) int
+}
+
+// defaultCommand runs when the binary is started without arguments
+const defaultCommand = "run"
+
+var commands []command
+
+func init() {
+	// Assigned in init because the help command refers back to the table
+	commands = []command{
+		{name: "run", summary: "Start producer and consumer in one process (default)", config: true,
+			run: func(args []string) int { return runBotCommand("run", args, true, true) }},
+		{name: "producer", summary: "Receive Telegram updates and queue replies", config: true,
+			run: func(args []string) int { return runBotCommand("producer", args, true, false) }},
+		{name: "consumer", summary: "Send queued messages to Telegram", config: true,
+			run: func(args []string) int { return runBotCommand("consumer", args, false, true) }},
+		{name: "migrate", usage: "up|down|status", summary: "Apply or roll back database migrations",
+			run: runMigrateCommand},
+		{name: "admin", usage: "dlq|user|fanout", summary: "Operator tasks: dead-letter queue, user lookup, fanout jobs", config: true,
+			run: runAdminCommand},
+		{name: "export", usage: "exchanges|users", summary: "Export data as CSV without personal details", config: true,
+			run: runExportCommand},
+		{name: "replay", usage: "FILE", summary: "Replay recorded updates and print what the bot does",
+			run: runReplayCommand},
+		{name: "healthcheck", summary: "Check database, schema and RabbitMQ, exit 1 when unhealthy", config: true,
+			run: runHealthcheckCommand},
+		{name: "help", summary: "Show this help",
+			run: func(args []string) int { printUsage(); return 0 }},
+	}
+}
+
+var loadConfigOnce sync.Once
+
+// loadConfig reads librecash.yml once, every subcommand goes through it
+func loadConfig() {
+	loadConfigOnce.Do(func() {
+		config.Init("librecash")
+	})
+}
+
+// openDatabase connects to PostgreSQL, connStr defaults to db_conn_str from the configuration
+func openDatabase(connStr string) (*sql.DB, error) {
+	if connStr == "" {
+		loadConfig()
+		connStr = config.C().Db_Conn_Str
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// runCommand dispatches args (without the program name) to a subcommand and returns the exit code
+func runCommand(args []string) int {
+	name := defaultCommand
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	// Kept for scripts written before dlq moved under admin
+	if name == "dlq" {
+		fmt.Fprintln(os.Stderr, "Note: `librecash dlq` is now `librecash admin dlq`")
+		name, args = "admin", append([]string{"dlq"}, args...)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if cmd.config {
+			loadConfig()
+		}
+		return cmd.run(args)
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printUsage()
+	return 2
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: librecash [command] [arguments]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-28s %s\n", cmd.name+" "+cmd.usage, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Run `librecash <command> -h` for the flags of a command.")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"librecash/objects"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommandRejectsUnknownCommand(t *testing.T) {
+	assert.Equal(t, 2, runCommand([]string{"bogus"}))
+	assert.Equal(t, 0, runCommand([]string{"help"}))
+}
+
+func TestCommandTable(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, cmd := range commands {
+		assert.False(t, seen[cmd.name], "duplicate command %s", cmd.name)
+		seen[cmd.name] = true
+		assert.NotEmpty(t, cmd.summary, "command %s has no summary", cmd.name)
+		assert.NotNil(t, cmd.run, "command %s has no handler", cmd.name)
+	}
+	for _, name := range []string{"run", "producer", "consumer", "migrate", "admin", "export", "replay", "healthcheck"} {
+		assert.True(t, seen[name], "missing command %s", name)
+	}
+	assert.True(t, seen[defaultCommand])
+}
+
+func TestFormatExportValue(t *testing.T) {
+	assert.Equal(t, "", formatExportValue(nil))
+	assert.Equal(t, "13.76", formatExportValue(13.756331))
+	assert.Equal(t, "cash_to_crypto", formatExportValue([]byte("cash_to_crypto")))
+	assert.Equal(t, "100", formatExportValue(int64(100)))
+	assert.Equal(t, "true", formatExportValue(true))
+	assert.Equal(t, "2026-01-02T03:04:05Z", formatExportValue(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)))
+}
+
+func TestDescribeQueueDepths(t *testing.T) {
+	assert.Equal(t, "messages=2 messages.edits=0", describeQueueDepths(map[string]int{"messages.edits": 0, "messages": 2}))
+	assert.Equal(t, "", describeQueueDepths(nil))
+}
+
+func TestFormatFanoutJobs(t *testing.T) {
+	lastError := "broker down"
+	jobs := []*objects.FanoutJob{
+		{ID: 2, ExchangeID: 11, Status: objects.FanoutJobPending, Found: 250, Queued: 150, Sent: 140, Failed: 1,
+			Attempts: 1, LastError: &lastError, CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{ID: 1, ExchangeID: 10, Status: objects.FanoutJobDone, Found: 3, Queued: 3, Sent: 3,
+			CreatedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	lines := strings.Split(strings.TrimRight(formatFanoutJobs(jobs), "\n"), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, strings.Fields("job exchange status found queued sent failed attempts created"), strings.Fields(lines[0]))
+	assert.Equal(t, strings.Fields("2 11 pending 250 150 140 1 1 2026-01-02 03:04:05"), strings.Fields(lines[1]))
+	assert.Equal(t, "last error: broker down", strings.TrimSpace(lines[2]))
+	assert.Equal(t, strings.Fields("1 10 done 3 3 3 0 0 2026-01-01 00:00:00"), strings.Fields(lines[3]))
+}
@@ -14,6 +14,29 @@ type Config struct {
 	BugSink_Environment string
 	BugSink_Release     string
 	BugSink_Enabled     bool
+
+	// How updates are received: "polling" (default) or "webhook"
+	Telegram_Mode           string
+	Webhook_Url             string // public HTTPS URL registered with Telegram
+	Webhook_Listen          string // local listen address, default ":8443"
+	Webhook_Path            string // request path, default "/telegram/webhook"
+	Webhook_Secret          string // secret token Telegram sends with every update, required
+	Webhook_Tls_Cert        string // serve TLS directly, leave empty behind a TLS-terminating proxy
+	Webhook_Tls_Key         string
+	Webhook_Max_Connections int // 0 keeps Telegram's default of 40
+
+	// Updates handled in parallel by the producer, one user's updates stay in order (default 8)
+	Producer_Workers int
+
+	// Hours between two digests of a user in digest delivery mode (default 24)
+	Digest_Interval_Hours int
+
+	// Fanout notifications a user gets per hour, the rest is summarized in one message (default 20, -1 for no cap)
+	Fanout_Hourly_Cap int
+
+	// Incoming update recording for replay, disabled when the file is empty
+	Record_Updates_File string
+	Record_Redact       []string // names, usernames, phones, locations, text or all
 }
 
 var config Config
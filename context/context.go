@@ -11,9 +11,9 @@ import (
 
 type Context struct {
 	bot           *tgbotapi.BotAPI // private - only accessible through methods
-	Repo          *repository.Repository
-	RabbitPublish *rabbit.RabbitClient // for publishing only
-	RabbitConsume *rabbit.RabbitClient // for consuming only
+	Repo          repository.Repository
+	RabbitPublish rabbit.Publisher // for publishing only, routes each message class to its queue
+	RabbitConsume rabbit.Consumer  // for consuming only
 	Config        *config.Config
 }
 
@@ -23,11 +23,12 @@ func (context *Context) Send(message tgbotapi.MessageConfig) {
 
 	context.RabbitPublish.PublishTgMessage(rabbit.MessageBag{
 		Message:  message,
-		Priority: 220, // high priority for user messages, but lower than callbacks
+		Priority: rabbit.PriorityUserMessage, // high priority for user messages, but lower than callbacks
 	})
 }
 
-// SendWithPriority sends a message with specified priority through RabbitMQ
+// SendWithPriority sends a message with specified priority through RabbitMQ.
+// Priorities at or below rabbit.PriorityHistoricalFanout go to the fanout queue.
 func (context *Context) SendWithPriority(message tgbotapi.MessageConfig, priority uint8) {
 	log.Printf("[CONTEXT] Sending message to user %d via RabbitMQ with priority %d", message.ChatID, priority)
 
@@ -43,7 +44,7 @@ func (context *Context) AnswerCallbackQuery(callback tgbotapi.CallbackConfig) er
 
 	return context.RabbitPublish.PublishCallbackAnswer(rabbit.CallbackAnswerBag{
 		CallbackAnswer: callback,
-		Priority:       255, // Highest priority for instant response
+		Priority:       rabbit.PriorityCallbackAnswer, // Highest priority for instant response
 	})
 }
 
@@ -58,12 +59,12 @@ func (context *Context) SetBot(bot *tgbotapi.BotAPI) {
 	context.bot = bot
 }
 
-// EditMessage edits a message through RabbitMQ
+// EditMessage edits a message through RabbitMQ, using the edits queue
 func (context *Context) EditMessage(editMsg tgbotapi.EditMessageTextConfig) error {
 	log.Printf("[CONTEXT] Sending message edit for message %d in chat %d via RabbitMQ", editMsg.MessageID, editMsg.ChatID)
 
 	return context.RabbitPublish.PublishEditMessage(rabbit.EditMessageBag{
 		EditMessage: editMsg,
-		Priority:    200, // High priority for edits
+		Priority:    rabbit.PriorityEdit, // High priority for edits
 	})
 }
@@ -0,0 +1,43 @@
+package context
+
+import (
+	"encoding/json"
+	"librecash/rabbit"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextPublishesThroughTransport(t *testing.T) {
+	transport := rabbit.NewMemoryTransport(10)
+	defer transport.Close()
+	ctx := &Context{RabbitPublish: transport}
+
+	ctx.Send(tgbotapi.NewMessage(1, "reply"))
+	assert.NoError(t, ctx.AnswerCallbackQuery(tgbotapi.NewCallback("cb", "")))
+	assert.NoError(t, ctx.EditMessage(tgbotapi.NewEditMessageText(1, 2, "edited")))
+	ctx.SendWithPriority(tgbotapi.NewMessage(1, "after historical fanout"), rabbit.PriorityFanoutFollowUp)
+
+	reply, ok := transport.Receive(rabbit.QueueInteractive, time.Second)
+	assert.True(t, ok)
+	var messageBag rabbit.MessageBag
+	assert.NoError(t, json.Unmarshal(reply.Body, &messageBag))
+	assert.Equal(t, "reply", messageBag.Message.Text)
+	assert.Equal(t, rabbit.PriorityUserMessage, messageBag.Priority)
+
+	callback, ok := transport.Receive(rabbit.QueueInteractive, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "callback_answer", callback.Headers["message_type"])
+
+	edit, ok := transport.Receive(rabbit.QueueEdits, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "edit_message", edit.Headers["message_type"])
+
+	// Follow-ups of a historical fanout stay behind it in the fanout queue
+	followUp, ok := transport.Receive(rabbit.QueueFanout, time.Second)
+	assert.True(t, ok)
+	assert.NoError(t, json.Unmarshal(followUp.Body, &messageBag))
+	assert.Equal(t, "after historical fanout", messageBag.Message.Text)
+}
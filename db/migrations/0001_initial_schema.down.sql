@@ -0,0 +1,9 @@
+-- Drops the whole initial schema, all data is lost
+
+DROP TABLE IF EXISTS location_histories;
+DROP TABLE IF EXISTS contact_requests;
+DROP TABLE IF EXISTS timeline_records;
+DROP TABLE IF EXISTS exchanges;
+DROP FUNCTION IF EXISTS update_exchange_geog();
+DROP TABLE IF EXISTS dismissed_feature_callouts;
+DROP TABLE IF EXISTS users;
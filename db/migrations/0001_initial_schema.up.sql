@@ -1,11 +1,6 @@
--- LibreCash database schema with location support
--- Drop and recreate since we have zero users
+-- Initial LibreCash schema with location support (formerly db/init.sql)
 
--- Drop existing tables if they exist
-DROP TABLE IF EXISTS location_histories CASCADE;
-DROP TABLE IF EXISTS dismissed_feature_callouts CASCADE;
-DROP TABLE IF EXISTS exchanges CASCADE;
-DROP TABLE IF EXISTS users CASCADE;
+CREATE EXTENSION IF NOT EXISTS postgis;
 
 -- Create users table with location fields
 CREATE TABLE users (
@@ -128,4 +123,4 @@ CREATE TABLE location_histories (
 
 -- Indexes for performance
 CREATE INDEX idx_location_histories_user_id ON location_histories(user_id);
-CREATE INDEX idx_location_histories_created_at ON location_histories(user_id, created_at DESC);
\ No newline at end of file
+CREATE INDEX idx_location_histories_created_at ON location_histories(user_id, created_at DESC);
@@ -0,0 +1,4 @@
+-- Unreachable users are notified again
+
+ALTER TABLE users
+    DROP COLUMN "unreachable";
@@ -0,0 +1,4 @@
+-- Users who blocked the bot or whose chat is gone are flagged unreachable and skipped in fanout
+
+ALTER TABLE users
+    ADD COLUMN "unreachable" boolean NOT NULL DEFAULT FALSE; -- Bot blocked by user or chat gone; cleared on /start
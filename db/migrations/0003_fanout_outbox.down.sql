@@ -0,0 +1,3 @@
+-- Drops the outbox, pending fanouts are not relayed
+
+DROP TABLE IF EXISTS fanout_outbox;
@@ -0,0 +1,14 @@
+-- Transactional outbox for exchange fanout: rows are written together with the exchange
+-- status change and relayed to RabbitMQ until every notification is confirmed
+
+CREATE TABLE fanout_outbox (
+    id SERIAL PRIMARY KEY,
+    exchange_id BIGINT NOT NULL REFERENCES exchanges(id),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    locked_until TIMESTAMP,            -- lease of the relay currently publishing the row
+    published_at TIMESTAMP,            -- NULL while the fanout is pending
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_fanout_outbox_pending ON fanout_outbox(id) WHERE published_at IS NULL;
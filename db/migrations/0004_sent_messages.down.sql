@@ -0,0 +1,7 @@
+-- Drops the idempotency keys and the one record per recipient rule, redelivered messages may
+-- be sent twice again
+
+DROP TABLE IF EXISTS sent_messages;
+
+ALTER TABLE timeline_records
+    DROP CONSTRAINT IF EXISTS timeline_records_exchange_id_recipient_user_id_key;
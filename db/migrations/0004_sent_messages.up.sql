@@ -0,0 +1,27 @@
+-- Idempotent delivery: one timeline record per recipient per exchange, redelivered messages
+-- update the row, and the idempotency keys of messages already delivered to Telegram so a
+-- redelivered RabbitMQ message is not sent twice.
+
+-- Databases created by init.sql may hold several records for the same recipient and exchange,
+-- the one with a Telegram message is kept, the newest among equals
+DELETE FROM timeline_records
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (
+            PARTITION BY exchange_id, recipient_user_id
+            ORDER BY (telegram_message_id IS NOT NULL) DESC, id DESC
+        ) AS rank
+        FROM timeline_records
+    ) ranked
+    WHERE rank > 1
+);
+
+ALTER TABLE timeline_records
+    ADD CONSTRAINT timeline_records_exchange_id_recipient_user_id_key UNIQUE (exchange_id, recipient_user_id);
+
+CREATE TABLE sent_messages (
+    idempotency_key TEXT PRIMARY KEY,
+    sent_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_sent_messages_sent_at ON sent_messages(sent_at);
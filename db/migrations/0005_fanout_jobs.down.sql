@@ -0,0 +1,3 @@
+-- Drops the fanout jobs and their stats, pending fanouts are not resumed
+
+DROP TABLE IF EXISTS fanout_jobs;
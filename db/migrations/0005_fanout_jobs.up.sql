@@ -0,0 +1,21 @@
+-- Durable fanout jobs: the recipients of a posted exchange are notified in batches by the
+-- fanout job worker, which records its progress so a restart resumes instead of starting over
+
+CREATE TABLE fanout_jobs (
+    id SERIAL PRIMARY KEY,
+    exchange_id BIGINT NOT NULL UNIQUE REFERENCES exchanges(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, done
+    processed INTEGER NOT NULL DEFAULT 0,          -- recipients handled so far, the resume point
+    recipients_found INTEGER NOT NULL DEFAULT 0,
+    recipients_queued INTEGER NOT NULL DEFAULT 0,  -- notifications confirmed by RabbitMQ
+    recipients_sent INTEGER NOT NULL DEFAULT 0,    -- delivered by the consumer
+    recipients_failed INTEGER NOT NULL DEFAULT 0,  -- rejected by Telegram for good
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    locked_until TIMESTAMP,                        -- lease of the worker currently running the job
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_fanout_jobs_pending ON fanout_jobs(id) WHERE status = 'pending';
@@ -0,0 +1,6 @@
+-- Back to offset based progress, jobs in progress restart from their first recipient
+
+ALTER TABLE fanout_jobs
+    DROP COLUMN cursor_distance,
+    DROP COLUMN cursor_user_id,
+    ADD COLUMN processed INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- Fanout jobs page through the recipients with a keyset cursor on (distance, userId) instead of
+-- loading them all, the cursor is where a resumed job continues
+
+ALTER TABLE fanout_jobs
+    ADD COLUMN cursor_distance DOUBLE PRECISION NOT NULL DEFAULT -1, -- meters, -1 before the first page
+    ADD COLUMN cursor_user_id BIGINT NOT NULL DEFAULT 0,
+    DROP COLUMN processed;
@@ -0,0 +1,3 @@
+-- Drops the interests, every user gets every offer nearby again
+
+DROP TABLE IF EXISTS interests;
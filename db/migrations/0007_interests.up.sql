@@ -0,0 +1,12 @@
+-- Standing interests: a user with interests is only notified about the exchanges matching one
+-- of them, the opposite direction in the same asset within the amount limit
+
+CREATE TABLE interests (
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users("userId"),
+    direction VARCHAR(20) NOT NULL,        -- what the user wants to do, cash_to_crypto or crypto_to_cash
+    asset VARCHAR(10) NOT NULL DEFAULT 'USDT',
+    max_amount_usd INTEGER,                -- NULL for any amount
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (user_id, direction, asset)
+);
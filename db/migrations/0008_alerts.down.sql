@@ -0,0 +1,4 @@
+-- Drops the alerts, users only get offers near their location again
+
+DROP TABLE IF EXISTS alerts;
+DROP FUNCTION IF EXISTS update_alert_geog();
@@ -0,0 +1,32 @@
+-- Alerts: named areas with their own point and radius where a user gets offers in addition to
+-- the ones near their location, optionally filtered by direction and amount. An alert is a
+-- draft (active = FALSE) until the user finished setting it up.
+
+CREATE TABLE alerts (
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users("userId"),
+    name VARCHAR(64) NOT NULL,
+    lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+    lon DOUBLE PRECISION NOT NULL DEFAULT 0,
+    geog GEOGRAPHY(Point, 4326),
+    radius_km INTEGER NOT NULL DEFAULT 0,
+    direction VARCHAR(20),                 -- what the user wants to do, NULL for both
+    max_amount_usd INTEGER,                -- NULL for any amount
+    active BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_alerts_user_id ON alerts(user_id);
+CREATE INDEX idx_alerts_active_geog ON alerts USING GIST(geog) WHERE active;
+
+CREATE OR REPLACE FUNCTION update_alert_geog() RETURNS trigger AS $$
+BEGIN
+    NEW.geog := ST_SetSRID(ST_MakePoint(NEW.lon, NEW.lat), 4326);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER alerts_geog_trigger
+    BEFORE INSERT OR UPDATE ON alerts
+    FOR EACH ROW
+    EXECUTE FUNCTION update_alert_geog();
@@ -0,0 +1,8 @@
+-- Deferred notifications are dropped, busy recipients miss the offers again
+
+DROP INDEX IF EXISTS idx_timeline_records_deferred;
+
+DELETE FROM timeline_records WHERE status = 'deferred';
+
+ALTER TABLE timeline_records
+    DROP COLUMN distance_km;
@@ -0,0 +1,9 @@
+-- Recipients busy in another menu when an exchange is fanned out get a 'deferred' timeline
+-- record instead of the message, delivered when they are back in the main menu. The distance
+-- is kept for the message text.
+
+ALTER TABLE timeline_records
+    ADD COLUMN distance_km DOUBLE PRECISION; -- NULL unless the notification was deferred
+
+CREATE INDEX idx_timeline_records_deferred ON timeline_records(recipient_user_id)
+    WHERE status = 'deferred' AND is_deleted = FALSE;
@@ -0,0 +1,10 @@
+-- Drops the notification settings, held notifications are dropped with them
+
+DROP INDEX IF EXISTS idx_timeline_records_held;
+
+DELETE FROM timeline_records WHERE status = 'held';
+
+ALTER TABLE timeline_records
+    DROP COLUMN release_at;
+
+DROP TABLE IF EXISTS notification_settings;
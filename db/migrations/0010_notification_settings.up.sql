@@ -0,0 +1,18 @@
+-- Per-user notification settings: quiet hours in the user's local time, a pause, and the
+-- delivery mode. Notifications arriving during quiet hours get a 'held' timeline record that
+-- is released at release_at, when the quiet hours end.
+
+CREATE TABLE notification_settings (
+    user_id BIGINT PRIMARY KEY REFERENCES users("userId"),
+    quiet_start_hour SMALLINT,                       -- NULL when quiet hours are off
+    quiet_end_hour SMALLINT,
+    paused_until TIMESTAMP,                          -- NULL unless notifications are paused
+    delivery VARCHAR(10) NOT NULL DEFAULT 'instant', -- instant or digest
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE timeline_records
+    ADD COLUMN release_at TIMESTAMP; -- NULL unless the notification is held for quiet hours
+
+CREATE INDEX idx_timeline_records_held ON timeline_records(release_at)
+    WHERE status = 'held' AND is_deleted = FALSE;
@@ -0,0 +1,11 @@
+-- Drops the digests, offers collected for the next digest are dropped with them
+
+DROP INDEX IF EXISTS idx_timeline_records_collected;
+DROP INDEX IF EXISTS idx_timeline_records_digest_id;
+
+DELETE FROM timeline_records WHERE status = 'digest';
+
+ALTER TABLE timeline_records
+    DROP COLUMN digest_id;
+
+DROP TABLE IF EXISTS digests;
@@ -0,0 +1,19 @@
+-- Digest delivery: offers for users in digest mode get a 'digest' timeline record and are sent
+-- together in one scheduled message. The records of a sent digest point to it, so deleting an
+-- exchange edits the digest message instead of replacing it.
+
+CREATE TABLE digests (
+    id BIGSERIAL PRIMARY KEY,
+    recipient_user_id BIGINT NOT NULL REFERENCES users("userId"),
+    telegram_message_id INTEGER,  -- NULL until the digest is sent
+    created_at TIMESTAMP DEFAULT NOW(),
+    sent_at TIMESTAMP
+);
+
+ALTER TABLE timeline_records
+    ADD COLUMN digest_id BIGINT REFERENCES digests(id); -- NULL unless sent in a digest
+
+CREATE INDEX idx_timeline_records_digest_id ON timeline_records(digest_id);
+
+CREATE INDEX idx_timeline_records_collected ON timeline_records(recipient_user_id, created_at)
+    WHERE status = 'digest' AND is_deleted = FALSE;
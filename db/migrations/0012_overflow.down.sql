@@ -0,0 +1,6 @@
+-- Drops the hourly cap indexes, offers waiting for an overflow summary are dropped
+
+DROP INDEX IF EXISTS idx_timeline_records_sent;
+DROP INDEX IF EXISTS idx_timeline_records_overflow;
+
+DELETE FROM timeline_records WHERE status = 'overflow';
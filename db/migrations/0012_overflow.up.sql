@@ -0,0 +1,9 @@
+-- Hourly notification cap: fanout notifications beyond a recipient's cap get an 'overflow'
+-- timeline record and are summarized in one message, sent like a digest.
+
+CREATE INDEX idx_timeline_records_overflow ON timeline_records(recipient_user_id, created_at)
+    WHERE status = 'overflow' AND is_deleted = FALSE;
+
+-- Counting the notifications a recipient got in the last hour
+CREATE INDEX idx_timeline_records_sent ON timeline_records(recipient_user_id, updated_at)
+    WHERE status = 'sent';
@@ -0,0 +1,7 @@
+-- Counts the hourly cap on updated_at again
+
+DROP INDEX IF EXISTS idx_timeline_records_sent_at;
+CREATE INDEX idx_timeline_records_sent ON timeline_records(recipient_user_id, updated_at)
+    WHERE status = 'sent';
+
+ALTER TABLE timeline_records DROP COLUMN IF EXISTS sent_at;
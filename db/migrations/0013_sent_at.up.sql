@@ -0,0 +1,11 @@
+-- When a notification was sent. The hourly cap counts on it, updated_at also moves when the
+-- record changes later, e.g. when the exchange is deleted.
+
+ALTER TABLE timeline_records
+    ADD COLUMN sent_at TIMESTAMP; -- NULL until sent, kept when the send is replayed
+
+UPDATE timeline_records SET sent_at = updated_at WHERE status = 'sent';
+
+DROP INDEX IF EXISTS idx_timeline_records_sent;
+CREATE INDEX idx_timeline_records_sent_at ON timeline_records(recipient_user_id, sent_at)
+    WHERE sent_at IS NOT NULL;
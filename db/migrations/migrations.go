@@ -0,0 +1,348 @@
+// Package migrations applies the versioned database schema. Migrations are numbered SQL files
+// embedded in the binary, NNNN_name.up.sql and NNNN_name.down.sql, applied in order and
+// tracked in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// advisoryLockKey serializes migrators running against the same database
+const advisoryLockKey = 7426318
+
+// Migration is one schema version with its apply and rollback SQL
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is the state of one migration in a database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load returns the migrations embedded in the binary, ordered by version
+func Load() ([]Migration, error) {
+	return parse(files)
+}
+
+// parse reads migration files from fsys. Versions must start at 1 without gaps and every
+// version needs both an up and a down file.
+func parse(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1, found %d at position %d", migration.Version, i+1)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies and rolls back migrations on a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New creates a migrator for the embedded migrations
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest returns the newest version the migrator knows about
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// baselineTables are the tables created by the old init.sql, which is migration 1
+var baselineTables = []string{"users", "dismissed_feature_callouts", "exchanges", "timeline_records",
+	"contact_requests", "location_histories"}
+
+// ensureTable creates schema_migrations and baselines databases created by the old init.sql:
+// those already have the initial schema, so version 1 is recorded without running it and the
+// later migrations bring them up to date. A partial schema is refused rather than baselined.
+func (m *Migrator) ensureTable(conn *sql.Conn) error {
+	ctx := context.Background()
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version int NOT NULL PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamp NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	var recorded int
+	if err := conn.QueryRowContext(ctx, `SELECT count(*) FROM schema_migrations`).Scan(&recorded); err != nil {
+		return err
+	}
+	if recorded > 0 || len(m.migrations) == 0 {
+		return nil
+	}
+
+	var usersTable sql.NullString
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('users')::text`).Scan(&usersTable); err != nil {
+		return err
+	}
+	if !usersTable.Valid {
+		return nil
+	}
+	for _, table := range baselineTables {
+		var found sql.NullString
+		if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1)::text`, table).Scan(&found); err != nil {
+			return err
+		}
+		if !found.Valid {
+			return fmt.Errorf("found table users but not %s, the schema does not match migration 1 and is not baselined", table)
+		}
+	}
+
+	log.Printf("[MIGRATE] Found a schema created by init.sql, recording migration 1 as applied")
+	_, err = conn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+		m.migrations[0].Version, m.migrations[0].Name)
+	return err
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory lock
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, advisoryLockKey)
+
+	if err := m.ensureTable(conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func currentVersion(conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(context.Background(),
+		`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Up applies up to steps pending migrations, all of them when steps <= 0.
+// Every migration runs in its own transaction. Returns the applied migrations.
+func (m *Migrator) Up(steps int) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, err := currentVersion(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if migration.Version <= current {
+				continue
+			}
+			if steps > 0 && len(applied) == steps {
+				break
+			}
+
+			log.Printf("[MIGRATE] Applying %04d_%s", migration.Version, migration.Name)
+			err := inTransaction(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+					migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the newest steps applied migrations, one when steps <= 0.
+// Returns the rolled back migrations, newest first.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var reverted []Migration
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, err := currentVersion(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if migration.Version > current {
+				continue
+			}
+
+			log.Printf("[MIGRATE] Rolling back %04d_%s", migration.Version, migration.Name)
+			err := inTransaction(conn, func(tx *sql.Tx) error {
+				if _, err := tx.Exec(migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rollback of %04d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration and whether it is applied
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(func(conn *sql.Conn) error {
+		rows, err := conn.QueryContext(context.Background(), `SELECT version, applied_at FROM schema_migrations`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		appliedAt := make(map[int]time.Time)
+		for rows.Next() {
+			var version int
+			var at time.Time
+			if err := rows.Scan(&version, &at); err != nil {
+				return err
+			}
+			appliedAt[version] = at
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			at, applied := appliedAt[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: applied, AppliedAt: at})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Current returns the version of the newest applied migration, 0 for an empty database
+func (m *Migrator) Current() (int, error) {
+	var version int
+	err := m.withLock(func(conn *sql.Conn) (err error) {
+		version, err = currentVersion(conn)
+		return err
+	})
+	return version, err
+}
+
+// CheckSchema fails when the database schema is older than the binary expects.
+// A newer schema is only logged, so a rolled back binary keeps running.
+func CheckSchema(db *sql.DB) error {
+	migrator, err := New(db)
+	if err != nil {
+		return err
+	}
+	current, err := migrator.Current()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	latest := migrator.Latest()
+	switch {
+	case current < latest:
+		return fmt.Errorf("database schema is at version %d, this binary needs %d", current, latest)
+	case current > latest:
+		log.Printf("[MIGRATE] Warning: database schema version %d is newer than this binary (%d)", current, latest)
+	}
+	return nil
+}
+
+func inTransaction(conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,166 @@
+package migrations
+
+import (
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "initial_schema", migrations[0].Name)
+	assert.Contains(t, migrations[0].Up, "CREATE TABLE users")
+	assert.NotContains(t, migrations[0].Up, "DROP TABLE", "Up migrations must not destroy data")
+	assert.Contains(t, migrations[0].Down, "DROP TABLE IF EXISTS users")
+}
+
+func TestParseValidatesFiles(t *testing.T) {
+	file := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+
+	migrations, err := parse(fstest.MapFS{
+		"0002_add_column.up.sql":   file("ALTER TABLE t ADD c int;"),
+		"0002_add_column.down.sql": file("ALTER TABLE t DROP c;"),
+		"0001_create.up.sql":       file("CREATE TABLE t (id int);"),
+		"0001_create.down.sql":     file("DROP TABLE t;"),
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "create", migrations[0].Name)
+	assert.Equal(t, "ALTER TABLE t DROP c;", migrations[1].Down)
+
+	_, err = parse(fstest.MapFS{
+		"0001_create.up.sql":   file("CREATE TABLE t (id int);"),
+		"0001_create.down.sql": file("DROP TABLE t;"),
+		"0003_gap.up.sql":      file("SELECT 1;"),
+		"0003_gap.down.sql":    file("SELECT 1;"),
+	})
+	assert.EqualError(t, err, "migration versions must be contiguous from 1, found 3 at position 2")
+
+	_, err = parse(fstest.MapFS{"0001_create.up.sql": file("CREATE TABLE t (id int);")})
+	assert.EqualError(t, err, "migration 1_create needs both an up and a down file")
+
+	_, err = parse(fstest.MapFS{"create_table.sql": file("CREATE TABLE t (id int);")})
+	assert.EqualError(t, err, "unexpected migration file name create_table.sql")
+}
+
+// setupTestDB connects to the test database with a private schema, so the migrations
+// do not touch the tables the other packages test against
+func setupTestDB(t *testing.T) *sql.DB {
+	connStr := "host=localhost port=15433 user=librecash password=librecash dbname=librecash_test sslmode=disable"
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Skip("Database tests require PostgreSQL connection")
+		return nil
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Skip("Database tests require PostgreSQL connection")
+		return nil
+	}
+
+	_, err = db.Exec(`DROP SCHEMA IF EXISTS migrate_test CASCADE; CREATE SCHEMA migrate_test`)
+	require.NoError(t, err)
+	db.Close()
+
+	db, err = sql.Open("postgres", connStr+" search_path=migrate_test,public")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Exec(`DROP SCHEMA IF EXISTS migrate_test CASCADE`)
+		db.Close()
+	})
+	return db
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db := setupTestDB(t)
+
+	migrator, err := New(db)
+	require.NoError(t, err)
+
+	assert.Error(t, CheckSchema(db), "An empty database is behind")
+
+	applied, err := migrator.Up(0)
+	require.NoError(t, err)
+	assert.Len(t, applied, migrator.Latest())
+	assert.NoError(t, CheckSchema(db))
+
+	applied, err = migrator.Up(0)
+	require.NoError(t, err)
+	assert.Empty(t, applied, "Up is idempotent")
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied, "migration %d", status.Version)
+	}
+
+	reverted, err := migrator.Down(migrator.Latest())
+	require.NoError(t, err)
+	assert.Len(t, reverted, migrator.Latest())
+
+	current, err := migrator.Current()
+	require.NoError(t, err)
+	assert.Equal(t, 0, current)
+
+	var usersTable sql.NullString
+	require.NoError(t, db.QueryRow(`SELECT to_regclass('migrate_test.users')::text`).Scan(&usersTable))
+	assert.False(t, usersTable.Valid, "Down removes the schema")
+}
+
+func TestBaselineExistingSchema(t *testing.T) {
+	db := setupTestDB(t)
+
+	// A database created by the old init.sql has the tables but no schema_migrations
+	migrations, err := Load()
+	require.NoError(t, err)
+	_, err = db.Exec(migrations[0].Up)
+	require.NoError(t, err)
+
+	migrator, err := New(db)
+	require.NoError(t, err)
+	current, err := migrator.Current()
+	require.NoError(t, err)
+	assert.Equal(t, 1, current, "The existing schema is recorded as migration 1")
+
+	// Duplicate notifications left by init.sql, the one with a Telegram message is kept
+	_, err = db.Exec(`INSERT INTO users ("userId") VALUES (1), (2)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO exchanges (user_id, exchange_direction, lat, lon, amount_usd) VALUES (1, 'cash_to_crypto', 13.75, 100.50, 100)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO timeline_records (exchange_id, recipient_user_id, telegram_message_id, status)
+		VALUES (1, 2, 42, 'sent'), (1, 2, NULL, 'pending')`)
+	require.NoError(t, err)
+
+	_, err = migrator.Up(0)
+	require.NoError(t, err, "Migration 1 is not run again")
+	assert.NoError(t, CheckSchema(db))
+
+	var messageID int
+	require.NoError(t, db.QueryRow(`SELECT telegram_message_id FROM timeline_records`).Scan(&messageID))
+	assert.Equal(t, 42, messageID)
+
+	var unreachable bool
+	require.NoError(t, db.QueryRow(`SELECT unreachable FROM users WHERE "userId" = 2`).Scan(&unreachable))
+	assert.False(t, unreachable, "The columns added after init.sql exist")
+}
+
+func TestRefuseBaselineOfPartialSchema(t *testing.T) {
+	db := setupTestDB(t)
+
+	_, err := db.Exec(`CREATE TABLE users ("userId" bigint PRIMARY KEY)`)
+	require.NoError(t, err)
+
+	migrator, err := New(db)
+	require.NoError(t, err)
+	_, err = migrator.Up(0)
+	assert.Error(t, err, "A schema that is not init.sql is not recorded as migration 1")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"librecash/config"
+	"librecash/rabbit"
+	"os"
+)
+
+// runDeadLetterCommand inspects or replays the dead-letter queue of failed Telegram sends.
+//
+// Usage:
+//
+//	librecash admin dlq list [-limit N]
+//	librecash admin dlq replay [-limit N]
+func runDeadLetterCommand(args []string) int {
+	if len(args) == 0 {
+		printDeadLetterUsage()
+		return 2
+	}
+
+	flags := flag.NewFlagSet("admin dlq "+args[0], flag.ContinueOnError)
+	limit := flags.Int("limit", 100, "maximum number of messages to process")
+	queue := flags.String("queue", rabbit.QueueInteractive,
+		fmt.Sprintf("queue whose dead letters to process, one of %v", rabbit.MessagingQueues))
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	client := rabbit.NewRabbitClient(config.C().Rabbit_Url, *queue)
+	defer client.Close()
+
+	switch args[0] {
+	case "list":
+		letters, err := client.InspectDeadLetters(*limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to inspect dead-letter queue: %v\n", err)
+			return 1
+		}
+		if len(letters) == 0 {
+			fmt.Println("Dead-letter queue is empty")
+			return 0
+		}
+		for i, letter := range letters {
+			fmt.Printf("#%d type=%s attempts=%d priority=%d dead_lettered_at=%s\n",
+				i+1, letter.MessageType, letter.Attempts, letter.Priority, letter.DeadLetteredAt)
+			fmt.Printf("    error: %s\n", letter.LastError)
+			fmt.Printf("    body:  %s\n", letter.Body)
+		}
+		fmt.Printf("%d message(s) shown\n", len(letters))
+		return 0
+	case "replay":
+		replayed, err := client.ReplayDeadLetters(*limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Replay stopped after %d message(s): %v\n", replayed, err)
+			return 1
+		}
+		fmt.Printf("Replayed %d message(s) to %s\n", replayed, *queue)
+		return 0
+	default:
+		printDeadLetterUsage()
+		return 2
+	}
+}
+
+func printDeadLetterUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  librecash admin dlq list [-limit N] [-queue NAME]    Show dead-lettered messages")
+	fmt.Fprintln(os.Stderr, "  librecash admin dlq replay [-limit N] [-queue NAME]  Move dead-lettered messages back to the queue")
+}
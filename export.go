@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// exportLocationPrecision keeps exported coordinates at ~1 km, enough for regional statistics
+const exportLocationPrecision = 2
+
+// exportQueries select the exported columns, users are identified by ID only
+var exportQueries = map[string]struct {
+	header []string
+	query  string
+}{
+	"exchanges": {
+		header: []string{"id", "user_id", "direction", "status", "amount_usd", "lat", "lon", "is_deleted", "created_at"},
+		query: `SELECT id, user_id, exchange_direction, status, amount_usd, lat, lon, is_deleted, created_at
+			FROM exchanges WHERE created_at >= $1 ORDER BY id`,
+	},
+	"users": {
+		header: []string{"user_id", "language_code", "menu_id", "search_radius_km", "lat", "lon", "has_phone", "unreachable", "created_at"},
+		query: `SELECT "userId", "languageCode", "menuId", search_radius_km, lat, lon,
+				COALESCE(phone_number, '') <> '', unreachable, "createdAtUtc"
+			FROM users WHERE "createdAtUtc" >= $1 ORDER BY "userId"`,
+	},
+}
+
+// runExportCommand writes exchanges or users as CSV. Names, usernames and phone numbers are never
+// exported and coordinates are rounded.
+//
+// Usage:
+//
+//	librecash export exchanges|users [-since YYYY-MM-DD] [-o FILE] [-db CONN]
+func runExportCommand(args []string) int {
+	if len(args) == 0 {
+		printExportUsage()
+		return 2
+	}
+	export, ok := exportQueries[args[0]]
+	if !ok {
+		printExportUsage()
+		return 2
+	}
+
+	flags := flag.NewFlagSet("export "+args[0], flag.ContinueOnError)
+	since := flags.String("since", "", "only rows created on or after this date (YYYY-MM-DD)")
+	output := flags.String("o", "", "output file (default: stdout)")
+	dbConnStr := flags.String("db", "", "PostgreSQL connection string (default: db_conn_str from librecash.yml)")
+	flags.Usage = printExportUsage
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	sinceTime := time.Time{}
+	if *since != "" {
+		parsed, err := time.Parse("2006-01-02", *since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -since date %q, expected YYYY-MM-DD\n", *since)
+			return 2
+		}
+		sinceTime = parsed
+	}
+
+	db, err := openDatabase(*dbConnStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	count, err := writeExport(db, out, export.header, export.query, sinceTime)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export stopped after %d row(s): %v\n", count, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d %s\n", count, args[0])
+	return 0
+}
+
+// writeExport runs query and writes every row as CSV, returns the number of rows written
+func writeExport(db *sql.DB, out io.Writer, header []string, query string, since time.Time) (int, error) {
+	rows, err := db.Query(query, since)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	writer := csv.NewWriter(out)
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	values := make([]interface{}, len(header))
+	pointers := make([]interface{}, len(header))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return count, err
+		}
+		record := make([]string, len(values))
+		for i, value := range values {
+			record[i] = formatExportValue(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	writer.Flush()
+	return count, writer.Error()
+}
+
+// formatExportValue renders a scanned column, NULL becomes an empty field
+func formatExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', exportLocationPrecision, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func printExportUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  librecash export exchanges [-since YYYY-MM-DD] [-o FILE] [-db CONN]  Export exchanges as CSV")
+	fmt.Fprintln(os.Stderr, "  librecash export users [-since YYYY-MM-DD] [-o FILE] [-db CONN]      Export users as CSV, without names or phones")
+}
@@ -0,0 +1,131 @@
+package fanout
+
+import (
+	"fmt"
+	"librecash/metrics"
+	"librecash/objects"
+	"log"
+	"time"
+)
+
+// isBusy reports whether a recipient is in the middle of another menu, e.g. entering an amount
+// or changing the language. Their notification is deferred until they are back in the main
+// menu, so it does not interrupt the flow. The author always gets the message right away.
+func isBusy(exchange *objects.Exchange, user *objects.User) bool {
+	return user.UserId != exchange.UserID && user.MenuId != objects.Menu_Main
+}
+
+// deferNotification records a deferred timeline record for a busy recipient
+func (f *FanoutService) deferNotification(exchange *objects.Exchange, recipient *objects.User, distance float64) error {
+	log.Printf("[FANOUT] Deferring notification for user %d about exchange %d (state: %d)",
+		recipient.UserId, exchange.ID, recipient.MenuId)
+
+	record := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	record.Status = objects.TimelineStatusDeferred
+	record.DistanceKm = &distance
+	err := f.context.Repo.CreateDeferredTimelineRecord(record)
+
+	metrics.RecordFanoutMessage("deferred_notification", recipient.GetSupportedLanguageCode(), err == nil)
+	return err
+}
+
+// DeliverDeferredNotifications delivers the notifications deferred while the user was in another
+// menu. The records are claimed first, so the sender's write of the sent message is never
+// overwritten, and each one goes through the same decision chain as a fanout job: exchanges that
+// were deleted or are no longer posted, and everything while the user paused notifications, are
+// dropped, offers for users in digest mode are collected for the digest, in quiet hours the
+// notifications are held, and those over the hourly cap are collected for the overflow summary.
+// It returns how many notifications were queued.
+func (f *FanoutService) DeliverDeferredNotifications(user *objects.User) (int, error) {
+	records, err := f.context.Repo.ClaimDeferredTimelineRecords(user.UserId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim deferred notifications: %v", err)
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	log.Printf("[FANOUT] Delivering %d deferred notification(s) to user %d", len(records), user.UserId)
+
+	now := time.Now()
+	settings, err := f.context.Repo.GetNotificationSettings(user.UserId)
+	if err != nil {
+		f.deferAgain(records)
+		return 0, fmt.Errorf("failed to load notification settings: %v", err)
+	}
+	sent, err := f.sentLastHour(user.UserId, now)
+	if err != nil {
+		f.deferAgain(records)
+		return 0, err
+	}
+
+	queued := 0
+	for i, record := range records {
+		ok, err := f.deliverDeferredNotification(record, user, settings, sent, now)
+		if err != nil {
+			f.deferAgain(records[i:])
+			return queued, err
+		}
+		if ok {
+			queued++
+			sent++
+		}
+	}
+
+	log.Printf("[FANOUT] Queued %d deferred notification(s) to user %d", queued, user.UserId)
+	return queued, nil
+}
+
+// deliverDeferredNotification queues a claimed deferred notification, it reports false if the
+// notification was dropped, collected or held instead
+func (f *FanoutService) deliverDeferredNotification(record *objects.TimelineRecord, user *objects.User,
+	settings *objects.NotificationSettings, sent int, now time.Time) (bool, error) {
+	exchange, err := f.context.Repo.GetExchangeByID(record.ExchangeID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load exchange %d: %v", record.ExchangeID, err)
+	}
+	if exchange == nil || exchange.IsDeleted || exchange.Status != objects.ExchangeStatusPosted {
+		log.Printf("[FANOUT] Dropping deferred notification of exchange %d for user %d (no longer posted)",
+			record.ExchangeID, user.UserId)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDeleted)
+	}
+
+	action, releaseAt := f.decideDelivery(exchange, user, settings, sent, now)
+	switch action {
+	case deliverSkip:
+		log.Printf("[FANOUT] Dropping deferred notification of exchange %d for user %d (notifications paused)",
+			exchange.ID, user.UserId)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDeleted)
+	case deliverDigest:
+		log.Printf("[FANOUT] Collecting deferred notification of exchange %d for the digest of user %d", exchange.ID, user.UserId)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDigest)
+	case deliverHold:
+		log.Printf("[FANOUT] Holding deferred notification of exchange %d for user %d until %s",
+			exchange.ID, user.UserId, releaseAt.Format(time.RFC3339))
+		return false, f.context.Repo.HoldTimelineRecord(record.ID, releaseAt)
+	case deliverDefer:
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDeferred)
+	case deliverOverflow:
+		log.Printf("[FANOUT] Deferred notification of exchange %d is over the hourly cap of user %d", exchange.ID, user.UserId)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusOverflow)
+	}
+
+	distance := f.calculateDistance(exchange.Lat, exchange.Lon, user.Lat, user.Lon)
+	if record.DistanceKm != nil {
+		distance = *record.DistanceKm
+	}
+	// The record stays pending until the sender records the sent message
+	if err := f.queueNotificationMessage(exchange, user, distance, 0); err != nil {
+		return false, fmt.Errorf("failed to queue deferred notification of exchange %d: %v", exchange.ID, err)
+	}
+	return true, nil
+}
+
+// deferAgain puts claimed records that were not delivered back, the next visit of the main menu
+// retries them
+func (f *FanoutService) deferAgain(records []*objects.TimelineRecord) {
+	for _, record := range records {
+		if err := f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDeferred); err != nil {
+			log.Printf("[FANOUT] Failed to defer notification %d again: %v", record.ID, err)
+		}
+	}
+}
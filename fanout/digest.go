@@ -0,0 +1,384 @@
+package fanout
+
+import (
+	"fmt"
+	"librecash/geo"
+	"librecash/metrics"
+	"librecash/objects"
+	"librecash/rabbit"
+	"log"
+	"math"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	defaultDigestInterval = 24 * time.Hour   // how often a user in digest mode gets a digest
+	digestPollInterval    = time.Minute      // how often the worker looks for due digests
+	digestMaxOffers       = 20               // offers listed in one digest, the rest are counted
+	digestButtonsPerRow   = 4                // contact buttons per keyboard row
+	digestIdempotencyKind = "digest_message" // idempotency key kind of digest messages
+)
+
+// digestOffer is an offer listed in a digest
+type digestOffer struct {
+	record   *objects.TimelineRecord
+	exchange *objects.Exchange
+}
+
+// digestInterval returns the configured interval between digests
+func (f *FanoutService) digestInterval() time.Duration {
+	if f.context.Config != nil && f.context.Config.Digest_Interval_Hours > 0 {
+		return time.Duration(f.context.Config.Digest_Interval_Hours) * time.Hour
+	}
+	return defaultDigestInterval
+}
+
+// wantsDigest reports whether a recipient gets new offers in a digest instead of one by one.
+// The author always gets the message right away.
+func wantsDigest(exchange *objects.Exchange, user *objects.User, settings *objects.NotificationSettings) bool {
+	return user.UserId != exchange.UserID && settings != nil && settings.Delivery == objects.DeliveryDigest
+}
+
+// collectOffer records an offer for the recipient's next digest or overflow summary, as given by
+// the status, instead of sending it
+func (f *FanoutService) collectOffer(exchange *objects.Exchange, recipient *objects.User, distance float64, status string) error {
+	log.Printf("[DIGEST] Collecting exchange %d for user %d (%s)", exchange.ID, recipient.UserId, status)
+
+	record := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	record.Status = status
+	record.DistanceKm = &distance
+	err := f.context.Repo.CreateDeferredTimelineRecord(record)
+
+	metrics.RecordFanoutMessage(status+"_offer", recipient.GetSupportedLanguageCode(), err == nil)
+	return err
+}
+
+// RunDigests sends the due digests and overflow summaries until stop is closed
+func (f *FanoutService) RunDigests(stop <-chan struct{}) {
+	log.Printf("[DIGEST] Starting digest worker, one digest every %v, at most %d notifications per hour",
+		f.digestInterval(), f.hourlyCap())
+
+	ticker := time.NewTicker(digestPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := f.SendDueDigests(time.Now()); err != nil {
+			log.Printf("[DIGEST] Failed to send digests: %v", err)
+		}
+		if _, err := f.SendOverflowSummaries(time.Now()); err != nil {
+			log.Printf("[DIGEST] Failed to send overflow summaries: %v", err)
+		}
+
+		select {
+		case <-stop:
+			log.Printf("[DIGEST] Digest worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDueDigests queues a digest for every user whose oldest collected offer is at least one
+// digest interval old, so a user gets at most one digest per interval. Users in their quiet
+// hours get theirs once the quiet hours end. It returns how many digests were queued.
+func (f *FanoutService) SendDueDigests(now time.Time) (int, error) {
+	return f.sendDueCollected(objects.TimelineStatusDigest, now.Add(-f.digestInterval()), now)
+}
+
+// sendDueCollected queues a digest or overflow summary, as given by the status, for every user
+// with an offer collected at or before collectedBefore
+func (f *FanoutService) sendDueCollected(status string, collectedBefore, now time.Time) (int, error) {
+	userIDs, err := f.context.Repo.GetDueCollectedRecipients(status, collectedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due %s recipients: %v", status, err)
+	}
+
+	queued := 0
+	for _, userID := range userIDs {
+		sent, err := f.sendCollected(userID, status, now)
+		if err != nil {
+			// The offers stay collected, the next run retries
+			log.Printf("[DIGEST] Failed to send the %s of user %d: %v", status, userID, err)
+			continue
+		}
+		if sent {
+			queued++
+		}
+	}
+
+	if queued > 0 {
+		log.Printf("[DIGEST] Queued %d %s message(s)", queued, status)
+	}
+	return queued, nil
+}
+
+// sendCollected queues the digest or overflow summary of a user's collected offers, it reports
+// false if there was nothing to send or the user is in their quiet hours
+func (f *FanoutService) sendCollected(userID int64, status string, now time.Time) (bool, error) {
+	records, err := f.context.Repo.GetCollectedRecords(userID, status)
+	if err != nil {
+		return false, fmt.Errorf("failed to load collected offers: %v", err)
+	}
+
+	user := f.context.Repo.FindUser(userID)
+	settings, err := f.context.Repo.GetNotificationSettings(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load notification settings: %v", err)
+	}
+	if user == nil || user.Unreachable || user.MenuId == objects.Menu_Blocked || user.MenuId == objects.Menu_Ban ||
+		settings.IsPaused(now) {
+		log.Printf("[DIGEST] Dropping %d collected offer(s) of user %d (unreachable or paused)", len(records), userID)
+		return false, f.dropDigestRecords(records)
+	}
+	if _, quiet := settings.QuietUntil(now, geo.TimezoneAt(user.Lat, user.Lon)); quiet {
+		log.Printf("[DIGEST] Digest of user %d waits for the end of quiet hours", userID)
+		return false, nil
+	}
+
+	offers, withdrawn, err := f.liveDigestOffers(records)
+	if err != nil {
+		return false, err
+	}
+	if err := f.dropDigestRecords(withdrawn); err != nil {
+		return false, err
+	}
+	if len(offers) == 0 {
+		return false, nil
+	}
+
+	// The oldest offers beyond the limit of a digest are only counted. An overflow summary keeps
+	// them all, its list is paged.
+	more := 0
+	if status == objects.TimelineStatusDigest && len(offers) > digestMaxOffers {
+		more = len(offers) - digestMaxOffers
+		var skipped []*objects.TimelineRecord
+		for _, offer := range offers[:more] {
+			skipped = append(skipped, offer.record)
+		}
+		if err := f.dropDigestRecords(skipped); err != nil {
+			return false, err
+		}
+		offers = offers[more:]
+	}
+
+	recordIDs := make([]int64, len(offers))
+	for i, offer := range offers {
+		recordIDs[i] = offer.record.ID
+	}
+	digest, err := f.context.Repo.CreateDigest(userID, recordIDs)
+	if err != nil {
+		return false, fmt.Errorf("failed to create digest: %v", err)
+	}
+
+	// An overflow summary only counts the offers, they are listed on demand
+	var text string
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	kind := digestIdempotencyKind
+	if status == objects.TimelineStatusOverflow {
+		text, keyboard = buildOverflowSummary(user, digest.ID, len(offers))
+		kind = overflowIdempotencyKind
+	} else {
+		text, keyboard = f.buildDigestMessage(user, digest.ID, offers, more, 0)
+	}
+	msg := tgbotapi.NewMessage(userID, text)
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = keyboard
+
+	err = f.context.RabbitPublish.PublishExchangeNotification(rabbit.ExchangeNotificationBag{
+		RecipientUserID: userID,
+		Message:         msg,
+		Priority:        rabbit.PriorityFanout,
+		IdempotencyKey:  rabbit.IdempotencyKey(kind, digest.ID, userID),
+		DigestID:        digest.ID,
+	})
+	metrics.RecordFanoutMessage(status, user.GetSupportedLanguageCode(), err == nil)
+	if err != nil {
+		// Collected again for the next run
+		f.context.Repo.RecordDigestDelivery(digest.ID, nil, status)
+		return false, fmt.Errorf("failed to queue %s %d: %v", status, digest.ID, err)
+	}
+
+	log.Printf("[DIGEST] Queued %s %d of %d offer(s) to user %d", status, digest.ID, len(offers), userID)
+	return true, nil
+}
+
+// liveDigestOffers loads the exchanges of the records. Records of exchanges deleted or no
+// longer posted are returned as withdrawn.
+func (f *FanoutService) liveDigestOffers(records []*objects.TimelineRecord) ([]digestOffer, []*objects.TimelineRecord, error) {
+	var offers []digestOffer
+	var withdrawn []*objects.TimelineRecord
+	for _, record := range records {
+		if record.IsDeleted {
+			continue
+		}
+		exchange, err := f.context.Repo.GetExchangeByID(record.ExchangeID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load exchange %d: %v", record.ExchangeID, err)
+		}
+		if exchange == nil || exchange.IsDeleted || exchange.Status != objects.ExchangeStatusPosted {
+			withdrawn = append(withdrawn, record)
+			continue
+		}
+		offers = append(offers, digestOffer{record: record, exchange: exchange})
+	}
+	return offers, withdrawn, nil
+}
+
+// dropDigestRecords marks collected offers that will not be sent as deleted
+func (f *FanoutService) dropDigestRecords(records []*objects.TimelineRecord) error {
+	for _, record := range records {
+		if err := f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDeleted); err != nil {
+			return fmt.Errorf("failed to drop collected offer %d: %v", record.ID, err)
+		}
+	}
+	return nil
+}
+
+// buildDigestMessage renders a page of a digest: a numbered line per offer and a numbered contact
+// button for each, how many more offers there were if any, and the page buttons when the offers
+// do not fit on one page
+func (f *FanoutService) buildDigestMessage(recipient *objects.User, digestID int64, offers []digestOffer, more int, page int) (string, tgbotapi.InlineKeyboardMarkup) {
+	locale := recipient.Locale()
+
+	pages := (len(offers) + digestMaxOffers - 1) / digestMaxOffers
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	first := page * digestMaxOffers
+	last := first + digestMaxOffers
+	if last > len(offers) {
+		last = len(offers)
+	}
+
+	listed := make([]listedOffer, 0, last-first)
+	for _, offer := range offers[first:last] {
+		distance := f.calculateDistance(offer.exchange.Lat, offer.exchange.Lon, recipient.Lat, recipient.Lon)
+		if offer.record.DistanceKm != nil {
+			distance = *offer.record.DistanceKm
+		}
+		listed = append(listed, listedOffer{exchange: offer.exchange, distance: distance})
+	}
+	lines, rows := offerList(recipient, listed, first+1)
+
+	text := fmt.Sprintf(locale.Get("digest.header"), len(offers)+more) + "\n" + lines
+	if more > 0 {
+		text += "\n\n" + fmt.Sprintf(locale.Get("digest.more"), more)
+	}
+
+	var pageButtons []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		pageButtons = append(pageButtons, tgbotapi.NewInlineKeyboardButtonData("◀️", fmt.Sprintf("digest:%d:%d", digestID, page-1)))
+	}
+	if page < pages-1 {
+		pageButtons = append(pageButtons, tgbotapi.NewInlineKeyboardButtonData("▶️", fmt.Sprintf("digest:%d:%d", digestID, page+1)))
+	}
+	if len(pageButtons) > 0 {
+		rows = append(rows, pageButtons)
+	}
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// OfferList renders exchanges the way digests list them: numbered lines starting at number
+// first with the distance from the recipient's location, and a numbered contact button for each
+func (f *FanoutService) OfferList(recipient *objects.User, exchanges []*objects.Exchange, first int) (string, [][]tgbotapi.InlineKeyboardButton) {
+	listed := make([]listedOffer, len(exchanges))
+	for i, exchange := range exchanges {
+		listed[i] = listedOffer{exchange: exchange, distance: f.calculateDistance(exchange.Lat, exchange.Lon, recipient.Lat, recipient.Lon)}
+	}
+	return offerList(recipient, listed, first)
+}
+
+// listedOffer is an exchange in a list of offers and its distance from the recipient
+type listedOffer struct {
+	exchange *objects.Exchange
+	distance float64
+}
+
+// offerList renders a line per offer, numbered from first, and rows of numbered contact buttons
+func offerList(recipient *objects.User, offers []listedOffer, first int) (string, [][]tgbotapi.InlineKeyboardButton) {
+	locale := recipient.Locale()
+
+	text := ""
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for i, offer := range offers {
+		exchange := offer.exchange
+		number := first + i
+		line := fmt.Sprintf("\n<b>%d.</b> ", number)
+		if exchange.ExchangeDirection == objects.ExchangeDirectionCashToCrypto {
+			line += locale.Get("digest.has_cash")
+		} else {
+			line += locale.Get("digest.has_crypto")
+		}
+		if exchange.AmountUSD != nil {
+			line += fmt.Sprintf(" · $%d", *exchange.AmountUSD)
+		}
+		text += line + " · " + fmt.Sprintf(locale.Get("digest.distance"), int(math.Round(offer.distance)))
+
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf(locale.Get("digest.button_contact"), number), fmt.Sprintf("contact:%d", exchange.ID)))
+		if len(row) == digestButtonsPerRow {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	return text, rows
+}
+
+// RefreshDigest edits a sent digest or overflow summary to list the offers that are still
+// posted, e.g. after one of them was deleted or when the summary is opened. A digest without any
+// offer left says so.
+func (f *FanoutService) RefreshDigest(digestID int64) error {
+	return f.ShowDigestPage(digestID, 0)
+}
+
+// ShowDigestPage edits a sent digest or overflow summary to list the given page of the offers
+// that are still posted, the last page if there are fewer pages now
+func (f *FanoutService) ShowDigestPage(digestID int64, page int) error {
+	digest, err := f.context.Repo.GetDigest(digestID)
+	if err != nil {
+		return fmt.Errorf("failed to load digest %d: %v", digestID, err)
+	}
+	if digest == nil || digest.TelegramMessageID == nil {
+		log.Printf("[DIGEST] Digest %d was not sent, nothing to refresh", digestID)
+		return nil
+	}
+	recipient := f.context.Repo.FindUser(digest.RecipientUserID)
+	if recipient == nil {
+		return fmt.Errorf("recipient %d of digest %d not found", digest.RecipientUserID, digestID)
+	}
+
+	records, err := f.context.Repo.GetTimelineRecordsByDigest(digestID)
+	if err != nil {
+		return fmt.Errorf("failed to load offers of digest %d: %v", digestID, err)
+	}
+	offers, _, err := f.liveDigestOffers(records)
+	if err != nil {
+		return err
+	}
+
+	var editMsg tgbotapi.EditMessageTextConfig
+	if len(offers) == 0 {
+		editMsg = tgbotapi.NewEditMessageText(recipient.UserId, *digest.TelegramMessageID,
+			recipient.Locale().Get("digest.all_withdrawn"))
+	} else {
+		text, keyboard := f.buildDigestMessage(recipient, digestID, offers, 0, page)
+		editMsg = tgbotapi.NewEditMessageText(recipient.UserId, *digest.TelegramMessageID, text)
+		editMsg.ReplyMarkup = &keyboard
+	}
+	editMsg.ParseMode = "HTML"
+
+	log.Printf("[DIGEST] Refreshing digest %d of user %d, %d offer(s) left", digestID, recipient.UserId, len(offers))
+	return f.context.RabbitPublish.PublishEditMessage(rabbit.EditMessageBag{
+		EditMessage: editMsg,
+		Priority:    rabbit.PriorityEdit,
+	})
+}
@@ -3,6 +3,7 @@ package fanout
 import (
 	"fmt"
 	"librecash/context"
+	"librecash/geo"
 	"librecash/metrics"
 	"librecash/objects"
 	"librecash/rabbit"
@@ -25,64 +26,76 @@ func NewFanoutService(context *context.Context) *FanoutService {
 	}
 }
 
-// BroadcastExchange broadcasts an exchange offer to all nearby users
+// BroadcastExchange enqueues the fanout of an exchange offer to all nearby users as a durable
+// job and returns right away. The job worker queues the notifications, see RunFanoutJobs.
 func (f *FanoutService) BroadcastExchange(exchange *objects.Exchange) error {
-	log.Printf("[FANOUT] Broadcasting exchange %d to nearby users", exchange.ID)
+	log.Printf("[FANOUT] Enqueuing fanout job for exchange %d", exchange.ID)
 
-	// 1. Get initiator's search radius
+	job, err := f.context.Repo.CreateFanoutJob(exchange.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create fanout job: %v", err)
+	}
+
+	log.Printf("[FANOUT] Fanout job %d for exchange %d is %s", job.ID, exchange.ID, job.Status)
+	WakeFanoutJobs()
+	return nil
+}
+
+// findInitiator returns the exchange author. The author's search radius bounds the fanout, the
+// radius search also leaves out recipients whose own radius does not reach the exchange.
+func (f *FanoutService) findInitiator(exchange *objects.Exchange) (*objects.User, error) {
 	initiator := f.context.Repo.FindUser(exchange.UserID)
 	if initiator == nil {
-		return fmt.Errorf("initiator user %d not found", exchange.UserID)
+		return nil, fmt.Errorf("initiator user %d not found", exchange.UserID)
 	}
 	if initiator.SearchRadiusKm == nil {
-		return fmt.Errorf("initiator user %d has no search radius set", exchange.UserID)
+		return nil, fmt.Errorf("initiator user %d has no search radius set", exchange.UserID)
 	}
 
 	log.Printf("[FANOUT] Initiator %d has search radius %d km", exchange.UserID, *initiator.SearchRadiusKm)
+	return initiator, nil
+}
 
-	// 2. Find nearby users (including initiator for debugging/future features)
-	nearbyUsers, err := f.context.Repo.FindUsersInRadius(
-		exchange.Lat, exchange.Lon,
-		*initiator.SearchRadiusKm,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to find nearby users: %v", err)
+// matchRecipient reports whether a nearby user is notified and how far in km the exchange is
+// from them. The author gets the notification with the delete button. For other users either
+// their location matches along with their interests, or one of their alerts matches with its
+// filters; the distance is to the closest matching point. Blocked and banned users never match.
+func matchRecipient(exchange *objects.Exchange, nearby *objects.NearbyUser, interests []*objects.Interest, alerts []*objects.Alert, authorRadiusKm int) (float64, bool) {
+	user := nearby.User
+	if user.UserId == exchange.UserID {
+		return 0, true
+	}
+	if user.MenuId == objects.Menu_Blocked || user.MenuId == objects.Menu_Ban {
+		log.Printf("[FANOUT] Skipping user %d (blocked, state: %d)", user.UserId, user.MenuId)
+		return 0, false
 	}
 
-	log.Printf("[FANOUT] Found %d nearby users for exchange %d", len(nearbyUsers), exchange.ID)
-
-	// 3. Queue notification messages via RabbitMQ (users in main menu OR exchange author)
-	for _, user := range nearbyUsers {
-		// Send to users in main menu OR exchange author (needs delete button)
-		if user.MenuId == objects.Menu_Main {
-			if err := f.queueNotificationMessage(exchange, user, initiator); err != nil {
-				log.Printf("[FANOUT] Failed to queue notification for user %d: %v", user.UserId, err)
-				// Continue with other users even if one fails
-			} else {
-				log.Printf("[FANOUT] Queuing notification for user %d about exchange %d", user.UserId, exchange.ID)
-			}
-		} else if user.UserId == exchange.UserID {
-			if err := f.queueNotificationMessage(exchange, user, initiator); err != nil {
-				log.Printf("[FANOUT] Failed to queue notification for author %d: %v", user.UserId, err)
-			} else {
-				log.Printf("[FANOUT] Sending to author %d (own exchange %d, state: %d)",
-					user.UserId, exchange.ID, user.MenuId)
-			}
-		} else {
-			log.Printf("[FANOUT] Skipping user %d (not in main menu, state: %d)", user.UserId, user.MenuId)
+	distance, matched := 0.0, false
+	if nearby.ByLocation && objects.MatchesInterests(interests, exchange) {
+		distance, matched = geo.DistanceKm(exchange.Lat, exchange.Lon, user.Lat, user.Lon), true
+	}
+	for _, alert := range alerts {
+		if !geo.WithinRadius(exchange.Lat, exchange.Lon, alert.Lat, alert.Lon, alert.RadiusKm) ||
+			!geo.WithinRadius(exchange.Lat, exchange.Lon, alert.Lat, alert.Lon, authorRadiusKm) ||
+			!alert.MatchesFilters(exchange) {
+			continue
+		}
+		alertDistance := geo.DistanceKm(exchange.Lat, exchange.Lon, alert.Lat, alert.Lon)
+		if !matched || alertDistance < distance {
+			distance, matched = alertDistance, true
 		}
 	}
 
-	log.Printf("[FANOUT] Successfully queued notifications for exchange %d", exchange.ID)
-	return nil
+	if !matched {
+		log.Printf("[FANOUT] Skipping user %d (no matching interest or alert)", user.UserId)
+	}
+	return distance, matched
 }
 
 // queueNotificationMessage creates and queues a notification message for a specific user
-func (f *FanoutService) queueNotificationMessage(exchange *objects.Exchange, recipient *objects.User, initiator *objects.User) error {
+func (f *FanoutService) queueNotificationMessage(exchange *objects.Exchange, recipient *objects.User, distance float64, jobID int64) error {
 	log.Printf("[FANOUT] Queuing notification for user %d about exchange %d", recipient.UserId, exchange.ID)
 
-	// Calculate distance between initiator and recipient
-	distance := f.calculateDistance(exchange.Lat, exchange.Lon, recipient.Lat, recipient.Lon)
 	distanceKm := int(math.Round(distance))
 
 	// Build notification message
@@ -122,7 +135,9 @@ func (f *FanoutService) queueNotificationMessage(exchange *objects.Exchange, rec
 		ExchangeID:      exchange.ID,
 		RecipientUserID: recipient.UserId,
 		Message:         msg,
-		Priority:        100, // Medium priority for fanout notifications
+		Priority:        rabbit.PriorityFanout, // Medium priority for fanout notifications
+		IdempotencyKey:  rabbit.IdempotencyKey("exchange_notification", exchange.ID, recipient.UserId),
+		FanoutJobID:     jobID,
 	}
 
 	// Queue the message
@@ -181,22 +196,7 @@ func (f *FanoutService) buildNotificationMessage(exchange *objects.Exchange, rec
 
 // calculateDistance calculates the distance between two points in kilometers using Haversine formula
 func (f *FanoutService) calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
-	const earthRadiusKm = 6371.0
-
-	// Convert degrees to radians
-	lat1Rad := lat1 * math.Pi / 180
-	lon1Rad := lon1 * math.Pi / 180
-	lat2Rad := lat2 * math.Pi / 180
-	lon2Rad := lon2 * math.Pi / 180
-
-	// Haversine formula
-	dlat := lat2Rad - lat1Rad
-	dlon := lon2Rad - lon1Rad
-
-	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1Rad)*math.Cos(lat2Rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-	return earthRadiusKm * c
+	return geo.DistanceKm(lat1, lon1, lat2, lon2)
 }
 
 // BroadcastHistoricalExchanges broadcasts historical exchanges to a user who changed location
@@ -227,10 +227,20 @@ func (f *FanoutService) BroadcastHistoricalExchanges(userID int64, lat, lon floa
 
 	log.Printf("[HISTORICAL_FANOUT] Found %d historical exchanges for user %d", len(historicalExchanges), userID)
 
+	interests, err := f.context.Repo.GetUserInterests(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load interests: %v", err)
+	}
+
 	// 3. Queue historical notification messages via RabbitMQ
 	sentCount := 0
 	for _, exchange := range historicalExchanges {
-		if err := f.queueHistoricalNotificationMessage(exchange, user); err != nil {
+		if !objects.MatchesInterests(interests, exchange) {
+			log.Printf("[HISTORICAL_FANOUT] Skipping exchange %d (no matching interest of user %d)", exchange.ID, userID)
+			continue
+		}
+		distance := f.calculateDistance(exchange.Lat, exchange.Lon, lat, lon)
+		if err := f.queueHistoricalNotificationMessage(exchange, user, distance); err != nil {
 			log.Printf("[HISTORICAL_FANOUT] Failed to queue historical notification for exchange %d: %v", exchange.ID, err)
 			// Continue with other exchanges even if one fails
 		} else {
@@ -244,8 +254,44 @@ func (f *FanoutService) BroadcastHistoricalExchanges(userID int64, lat, lon floa
 	return nil
 }
 
-// queueHistoricalNotificationMessage creates and queues a historical notification message
-func (f *FanoutService) queueHistoricalNotificationMessage(exchange *objects.Exchange, recipient *objects.User) error {
+// BroadcastHistoricalExchangesForAlert sends the historical exchanges around a new alert that
+// pass its filters, like BroadcastHistoricalExchanges does for a new location. It returns how
+// many were queued.
+func (f *FanoutService) BroadcastHistoricalExchangesForAlert(alert *objects.Alert) (int, error) {
+	log.Printf("[HISTORICAL_FANOUT] Broadcasting historical exchanges to user %d for alert %d (%f, %f, %d km)",
+		alert.UserID, alert.ID, alert.Lat, alert.Lon, alert.RadiusKm)
+
+	user := f.context.Repo.FindUser(alert.UserID)
+	if user == nil {
+		return 0, fmt.Errorf("user %d not found", alert.UserID)
+	}
+
+	historicalExchanges, err := f.context.Repo.FindHistoricalExchangesInRadius(alert.Lat, alert.Lon, alert.RadiusKm, alert.UserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find historical exchanges: %v", err)
+	}
+
+	sentCount := 0
+	for _, exchange := range historicalExchanges {
+		if !alert.MatchesFilters(exchange) {
+			log.Printf("[HISTORICAL_FANOUT] Skipping exchange %d (filtered out by alert %d)", exchange.ID, alert.ID)
+			continue
+		}
+		distance := f.calculateDistance(exchange.Lat, exchange.Lon, alert.Lat, alert.Lon)
+		if err := f.queueHistoricalNotificationMessage(exchange, user, distance); err != nil {
+			log.Printf("[HISTORICAL_FANOUT] Failed to queue historical notification for exchange %d: %v", exchange.ID, err)
+			continue
+		}
+		sentCount++
+	}
+
+	log.Printf("[HISTORICAL_FANOUT] Queued %d historical notifications for alert %d", sentCount, alert.ID)
+	return sentCount, nil
+}
+
+// queueHistoricalNotificationMessage creates and queues a historical notification message,
+// distance is in km from the point the exchange was found around
+func (f *FanoutService) queueHistoricalNotificationMessage(exchange *objects.Exchange, recipient *objects.User, distance float64) error {
 	log.Printf("[FANOUT] Queuing historical notification for user %d about exchange %d", recipient.UserId, exchange.ID)
 
 	// Get exchange author for distance calculation
@@ -254,8 +300,6 @@ func (f *FanoutService) queueHistoricalNotificationMessage(exchange *objects.Exc
 		return fmt.Errorf("exchange author %d not found", exchange.UserID)
 	}
 
-	// Calculate distance between initiator and recipient
-	distance := f.calculateDistance(exchange.Lat, exchange.Lon, recipient.Lat, recipient.Lon)
 	distanceKm := int(math.Round(distance))
 
 	// Build historical notification message with time ago
@@ -281,7 +325,8 @@ func (f *FanoutService) queueHistoricalNotificationMessage(exchange *objects.Exc
 		ExchangeID:      exchange.ID,
 		RecipientUserID: recipient.UserId,
 		Message:         msg,
-		Priority:        80, // Lower priority for historical notifications
+		Priority:        rabbit.PriorityHistoricalFanout, // Lower priority for historical notifications
+		IdempotencyKey:  rabbit.IdempotencyKey("historical_notification", exchange.ID, recipient.UserId),
 	}
 
 	// Queue the message
@@ -1035,3 +1035,23 @@ func (m *MockRepository) FindHistoricalExchangesInRadius(lat, lon float64, radiu
 	}
 	return result, nil
 }
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, outboxBackoff(1))
+	assert.Equal(t, 4*time.Second, outboxBackoff(2))
+	assert.Equal(t, 64*time.Second, outboxBackoff(6))
+	assert.Equal(t, outboxMaxBackoff, outboxBackoff(10))
+	assert.Equal(t, outboxMaxBackoff, outboxBackoff(100))
+}
+
+func TestWakeOutboxRelayDoesNotBlock(t *testing.T) {
+	// Waking an idle relay repeatedly must never block the caller
+	for i := 0; i < 5; i++ {
+		WakeOutboxRelay()
+	}
+	select {
+	case <-outboxWakeup:
+	default:
+		t.Fatal("expected a pending wakeup")
+	}
+}
@@ -0,0 +1,272 @@
+package fanout
+
+import (
+	"errors"
+	"fmt"
+	"librecash/metrics"
+	"librecash/objects"
+	"log"
+	"time"
+)
+
+const (
+	fanoutJobPollInterval = 2 * time.Second // how often the worker looks for pending jobs
+	fanoutJobsPerClaim    = 5               // jobs claimed per poll
+	fanoutJobBatchSize    = 100             // users loaded per page, the progress is saved after each page
+	fanoutJobLease        = 5 * time.Minute // how long a claimed job is hidden from other workers, renewed per page
+)
+
+// errJobInterrupted stops a job between pages when the worker shuts down
+var errJobInterrupted = errors.New("fanout job interrupted")
+
+// fanoutJobWakeup lets BroadcastExchange start the worker right away instead of at the next poll
+var fanoutJobWakeup = make(chan struct{}, 1)
+
+// WakeFanoutJobs asks the worker to run pending jobs now
+func WakeFanoutJobs() {
+	select {
+	case fanoutJobWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// RunFanoutJobs runs pending fanout jobs until stop is closed. A job interrupted by stop or
+// by a crash continues after its last completed page.
+func (f *FanoutService) RunFanoutJobs(stop <-chan struct{}) {
+	log.Printf("[FANOUT_JOB] Starting fanout job worker")
+
+	ticker := time.NewTicker(fanoutJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		f.runPendingJobs(stop)
+
+		select {
+		case <-stop:
+			log.Printf("[FANOUT_JOB] Fanout job worker stopped")
+			return
+		case <-ticker.C:
+		case <-fanoutJobWakeup:
+		}
+	}
+}
+
+// RunPendingJobs runs jobs until none is pending, replay calls it directly after each update
+func (f *FanoutService) RunPendingJobs() {
+	f.runPendingJobs(nil)
+}
+
+func (f *FanoutService) runPendingJobs(stop <-chan struct{}) {
+	for {
+		jobs, err := f.context.Repo.ClaimFanoutJobs(fanoutJobsPerClaim, fanoutJobLease)
+		if err != nil {
+			log.Printf("[FANOUT_JOB] Failed to claim fanout jobs: %v", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+
+		for _, job := range jobs {
+			f.runJobTracked(job, stop)
+		}
+
+		// Interrupted jobs are released, don't claim them again
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// runJobTracked runs a job and records how it ended. A failed job is retried with backoff,
+// an interrupted one is released for the next worker.
+func (f *FanoutService) runJobTracked(job *objects.FanoutJob, stop <-chan struct{}) {
+	log.Printf("[FANOUT_JOB] Running fanout job %d for exchange %d (attempt %d, %d user(s) done)",
+		job.ID, job.ExchangeID, job.Attempts+1, job.Found)
+
+	err := f.runJob(job, stop)
+	switch {
+	case err == errJobInterrupted:
+		log.Printf("[FANOUT_JOB] Fanout job %d interrupted after %d user(s)", job.ID, job.Found)
+		if err := f.context.Repo.SaveFanoutJobProgress(job, 0); err != nil {
+			log.Printf("[FANOUT_JOB] Error releasing fanout job %d: %v", job.ID, err)
+		}
+		metrics.RecordFanoutJob("interrupted")
+	case err != nil:
+		retryIn := outboxBackoff(job.Attempts + 1)
+		log.Printf("[FANOUT_JOB] Fanout job %d failed after %d user(s): %v. Retrying in %v",
+			job.ID, job.Found, err, retryIn)
+		if err := f.context.Repo.MarkFanoutJobFailed(job.ID, err, retryIn); err != nil {
+			log.Printf("[FANOUT_JOB] Error marking fanout job %d failed: %v", job.ID, err)
+		}
+		metrics.RecordFanoutJob("failed")
+	default:
+		log.Printf("[FANOUT_JOB] Fanout job %d done: %d user(s) found, %d queued",
+			job.ID, job.Found, job.Queued)
+		metrics.RecordFanoutJob("done")
+	}
+}
+
+// delivery is what fanout does with a notification for one recipient
+type delivery int
+
+const (
+	deliverNow      delivery = iota // queue the message
+	deliverSkip                     // notifications paused
+	deliverDigest                   // collect the offer for the next digest
+	deliverHold                     // hold the message until the quiet hours end
+	deliverDefer                    // defer the message until the recipient is back in the main menu
+	deliverOverflow                 // collect the offer for the overflow summary
+)
+
+// decideDelivery is the per-recipient decision chain of fanout, used by fanout jobs and by the
+// delivery of deferred and held notifications alike: paused, digest mode, quiet hours, busy in
+// another menu, and the hourly cap, in this order. sent is the number of notifications the
+// recipient got in the last hour. The release time is set for deliverHold.
+func (f *FanoutService) decideDelivery(exchange *objects.Exchange, user *objects.User, settings *objects.NotificationSettings,
+	sent int, now time.Time) (delivery, time.Time) {
+	releaseAt, quiet := quietUntil(exchange, user, settings, now)
+	switch {
+	case isPaused(exchange, user, settings, now):
+		return deliverSkip, time.Time{}
+	case wantsDigest(exchange, user, settings):
+		return deliverDigest, time.Time{}
+	case quiet:
+		return deliverHold, releaseAt
+	case isBusy(exchange, user):
+		return deliverDefer, time.Time{}
+	case overCap(exchange, user, sent, f.hourlyCap()):
+		return deliverOverflow, time.Time{}
+	}
+	return deliverNow, time.Time{}
+}
+
+// runJob queues the notifications of a job page by page, saving the cursor after each page, so
+// memory stays bounded however many users are nearby. A notification queued twice anyway, e.g.
+// when the process died before the cursor was saved, is dropped by the sender's idempotency check.
+func (f *FanoutService) runJob(job *objects.FanoutJob, stop <-chan struct{}) error {
+	exchange, err := f.context.Repo.GetExchangeByID(job.ExchangeID)
+	if err != nil {
+		return fmt.Errorf("failed to load exchange: %v", err)
+	}
+	if exchange == nil || exchange.IsDeleted || exchange.Status != objects.ExchangeStatusPosted {
+		log.Printf("[FANOUT_JOB] Exchange %d is no longer posted, closing job %d", job.ExchangeID, job.ID)
+		job.Status = objects.FanoutJobDone
+		return f.context.Repo.SaveFanoutJobProgress(job, 0)
+	}
+
+	initiator, err := f.findInitiator(exchange)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stop:
+			return errJobInterrupted
+		default:
+		}
+
+		page, err := f.context.Repo.FindUsersInRadiusPage(exchange.Lat, exchange.Lon,
+			*initiator.SearchRadiusKm, job.Cursor, fanoutJobBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to find nearby users: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		userIDs := nearbyUserIDs(page)
+		interests, err := f.context.Repo.GetInterestsByUsers(userIDs)
+		if err != nil {
+			return fmt.Errorf("failed to load interests: %v", err)
+		}
+		alerts, err := f.context.Repo.GetAlertsByUsers(userIDs)
+		if err != nil {
+			return fmt.Errorf("failed to load alerts: %v", err)
+		}
+		settings, err := f.context.Repo.GetNotificationSettingsByUsers(userIDs)
+		if err != nil {
+			return fmt.Errorf("failed to load notification settings: %v", err)
+		}
+
+		// Notifications count once sent. The ones this job queues are added as it goes, but the
+		// ones other jobs queued and the sender hasn't sent yet are not seen, so exchanges fanned
+		// out at the same time can take a recipient over their cap by the jobs running at once.
+		now := time.Now()
+		sent, err := f.context.Repo.CountSentNotificationsByUsers(userIDs, now.Add(-time.Hour))
+		if err != nil {
+			return fmt.Errorf("failed to count sent notifications: %v", err)
+		}
+
+		for _, nearby := range page {
+			userID := nearby.User.UserId
+			distance, ok := matchRecipient(exchange, nearby, interests[userID], alerts[userID], *initiator.SearchRadiusKm)
+			if !ok {
+				job.Cursor = nearby.Cursor()
+				continue
+			}
+			action, releaseAt := f.decideDelivery(exchange, nearby.User, settings[userID], sent[userID], now)
+			switch action {
+			case deliverSkip:
+				log.Printf("[FANOUT_JOB] Skipping user %d, notifications paused", userID)
+			case deliverDigest:
+				if err := f.collectOffer(exchange, nearby.User, distance, objects.TimelineStatusDigest); err != nil {
+					f.saveProgress(job)
+					return fmt.Errorf("failed to collect offer for user %d: %v", userID, err)
+				}
+			case deliverHold:
+				if err := f.holdNotification(exchange, nearby.User, distance, releaseAt); err != nil {
+					f.saveProgress(job)
+					return fmt.Errorf("failed to hold notification for user %d: %v", userID, err)
+				}
+			case deliverDefer:
+				if err := f.deferNotification(exchange, nearby.User, distance); err != nil {
+					f.saveProgress(job)
+					return fmt.Errorf("failed to defer notification for user %d: %v", userID, err)
+				}
+			case deliverOverflow:
+				if err := f.collectOffer(exchange, nearby.User, distance, objects.TimelineStatusOverflow); err != nil {
+					f.saveProgress(job)
+					return fmt.Errorf("failed to collect offer for user %d: %v", userID, err)
+				}
+			default:
+				if err := f.queueNotificationMessage(exchange, nearby.User, distance, job.ID); err != nil {
+					f.saveProgress(job)
+					return fmt.Errorf("failed to queue notification for user %d: %v", userID, err)
+				}
+				job.Queued++
+				sent[userID]++
+			}
+			job.Found++
+			job.Cursor = nearby.Cursor()
+		}
+
+		if err := f.context.Repo.SaveFanoutJobProgress(job, fanoutJobLease); err != nil {
+			return fmt.Errorf("failed to save progress: %v", err)
+		}
+	}
+
+	job.Status = objects.FanoutJobDone
+	return f.context.Repo.SaveFanoutJobProgress(job, 0)
+}
+
+// saveProgress keeps what a failing page did so far, the retry continues with the failed user.
+// The job still fails with the original error, a lost save only repeats recipients, which the
+// sender's idempotency check drops.
+func (f *FanoutService) saveProgress(job *objects.FanoutJob) {
+	if err := f.context.Repo.SaveFanoutJobProgress(job, fanoutJobLease); err != nil {
+		log.Printf("[FANOUT_JOB] Error saving progress of fanout job %d: %v", job.ID, err)
+	}
+}
+
+// nearbyUserIDs returns the user IDs of a page
+func nearbyUserIDs(page []*objects.NearbyUser) []int64 {
+	userIDs := make([]int64, len(page))
+	for i, nearby := range page {
+		userIDs[i] = nearby.User.UserId
+	}
+	return userIDs
+}
@@ -0,0 +1,737 @@
+package fanout
+
+import (
+	"errors"
+	"fmt"
+	"librecash/config"
+	"librecash/context"
+	"librecash/objects"
+	"librecash/rabbit"
+	"librecash/repository"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingPublisher keeps the queued notifications and edits, onPublish may reject one
+type recordingPublisher struct {
+	rabbit.Publisher
+	notifications []rabbit.ExchangeNotificationBag
+	edits         []rabbit.EditMessageBag
+	onPublish     func(count int) error
+}
+
+func (p *recordingPublisher) PublishEditMessage(bag rabbit.EditMessageBag) error {
+	p.edits = append(p.edits, bag)
+	return nil
+}
+
+func (p *recordingPublisher) PublishExchangeNotification(bag rabbit.ExchangeNotificationBag) error {
+	if p.onPublish != nil {
+		if err := p.onPublish(len(p.notifications)); err != nil {
+			return err
+		}
+	}
+	p.notifications = append(p.notifications, bag)
+	return nil
+}
+
+// newJobFixture posts an exchange by user 1 with recipients nearby users in the main menu
+func newJobFixture(t *testing.T, recipients int) (*FanoutService, *repository.MemoryRepository, *recordingPublisher, *objects.Exchange) {
+	repo := repository.NewMemoryRepository()
+	publisher := &recordingPublisher{}
+	service := NewFanoutService(&context.Context{Repo: repo, RabbitPublish: publisher})
+
+	radius := 10
+	author := &objects.User{UserId: 1, LanguageCode: "en", MenuId: objects.Menu_Amount, Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &radius}
+	require.NoError(t, repo.SaveUser(author))
+	for i := 0; i < recipients; i++ {
+		user := &objects.User{UserId: int64(100 + i), LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 13.7563, Lon: 100.5018}
+		require.NoError(t, repo.SaveUser(user))
+	}
+	busy := &objects.User{UserId: 99, LanguageCode: "en", MenuId: objects.Menu_AskLocation, Lat: 13.7563, Lon: 100.5018}
+	require.NoError(t, repo.SaveUser(busy))
+
+	exchange := objects.NewExchange(author.UserId, objects.ExchangeDirectionCashToCrypto, author.Lat, author.Lon)
+	require.NoError(t, repo.CreateExchange(exchange))
+	require.NoError(t, repo.PostExchange(exchange))
+	return service, repo, publisher, exchange
+}
+
+func TestBroadcastExchangeEnqueuesJob(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 3)
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	require.NoError(t, service.BroadcastExchange(exchange))
+	assert.Empty(t, publisher.notifications, "Nothing is queued in the caller's path")
+
+	jobs, err := repo.ListFanoutJobs(10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1, "Broadcasting again keeps the one job")
+	assert.Equal(t, exchange.ID, jobs[0].ExchangeID)
+	assert.Equal(t, objects.FanoutJobPending, jobs[0].Status)
+}
+
+// unmarkableOutboxRepository can't store that a relayed outbox entry was published
+type unmarkableOutboxRepository struct {
+	*repository.MemoryRepository
+}
+
+func (r *unmarkableOutboxRepository) MarkOutboxEntryPublished(id int64) error {
+	return errors.New("database is closed")
+}
+
+func TestOutboxRelayBacksOffWhenMarkingFails(t *testing.T) {
+	repo := &unmarkableOutboxRepository{repository.NewMemoryRepository()}
+	service := NewFanoutService(&context.Context{Repo: repo, RabbitPublish: &recordingPublisher{}})
+
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: 1, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 13.7563, Lon: 100.5018}))
+	for i := 0; i < 2; i++ {
+		exchange := objects.NewExchange(1, objects.ExchangeDirectionCashToCrypto, 13.7563, 100.5018)
+		require.NoError(t, repo.CreateExchange(exchange))
+		require.NoError(t, repo.PostExchange(exchange))
+	}
+
+	service.RelayPendingEntries()
+
+	jobs, err := repo.ListFanoutJobs(10)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1, "The relay stops at the first entry it can't mark")
+}
+
+func TestFanoutJobQueuesRecipientsInPages(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 2*fanoutJobBatchSize+10)
+	require.NoError(t, service.BroadcastExchange(exchange))
+
+	service.RunPendingJobs()
+
+	job, err := repo.GetFanoutJobByExchange(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.FanoutJobDone, job.Status)
+	assert.NotNil(t, job.CompletedAt)
+	assert.Equal(t, 2*fanoutJobBatchSize+12, job.Found, "Everyone nearby")
+	assert.Equal(t, 2*fanoutJobBatchSize+11, job.Queued, "Recipients in the main menu and the author")
+
+	require.Len(t, publisher.notifications, job.Queued)
+	for _, bag := range publisher.notifications {
+		assert.Equal(t, job.ID, bag.FanoutJobID)
+		assert.NotEqual(t, int64(99), bag.RecipientUserID, "Users outside the main menu are skipped")
+	}
+
+	service.RunPendingJobs()
+	assert.Len(t, publisher.notifications, job.Queued, "Done jobs do not run again")
+}
+
+func TestFanoutJobResumesAfterInterruption(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 2*fanoutJobBatchSize)
+	require.NoError(t, service.BroadcastExchange(exchange))
+
+	// Shut down while the first page is being queued
+	stop := make(chan struct{})
+	publisher.onPublish = func(count int) error {
+		if count == fanoutJobBatchSize/2 {
+			close(stop)
+		}
+		return nil
+	}
+	service.runPendingJobs(stop)
+
+	job, err := repo.GetFanoutJobByExchange(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.FanoutJobPending, job.Status)
+	assert.Equal(t, fanoutJobBatchSize, job.Found, "The page in progress is finished")
+	assert.NotEqual(t, objects.FirstPage, job.Cursor)
+
+	// The next worker continues after the saved page
+	publisher.onPublish = nil
+	service.RunPendingJobs()
+
+	job, err = repo.GetFanoutJobByExchange(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.FanoutJobDone, job.Status)
+	assert.Equal(t, 2*fanoutJobBatchSize+2, job.Found)
+	assert.Equal(t, 2*fanoutJobBatchSize+1, job.Queued)
+
+	recipients := map[int64]int{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID]++
+	}
+	assert.Len(t, recipients, job.Queued)
+	assert.Len(t, publisher.notifications, job.Queued, "No recipient is notified twice")
+}
+
+func TestFanoutJobKeepsProgressWhenQueueingFails(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 2*fanoutJobBatchSize)
+	require.NoError(t, service.BroadcastExchange(exchange))
+
+	failAt := fanoutJobBatchSize + 50
+	publisher.onPublish = func(count int) error {
+		if count == failAt {
+			return errors.New("broker down")
+		}
+		return nil
+	}
+	service.RunPendingJobs()
+
+	job, err := repo.GetFanoutJobByExchange(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.FanoutJobPending, job.Status)
+	assert.Equal(t, failAt, job.Queued, "Progress up to the failed recipient is kept")
+	assert.Equal(t, 1, job.Attempts)
+	require.NotNil(t, job.LastError)
+	assert.Contains(t, *job.LastError, "broker down")
+
+	jobs, err := repo.ClaimFanoutJobs(10, fanoutJobLease)
+	require.NoError(t, err)
+	assert.Empty(t, jobs, "A failed job waits for its retry delay")
+}
+
+func TestFanoutJobCountsOnlyMatchedRecipients(t *testing.T) {
+	service, repo, _, exchange := newJobFixture(t, 3)
+	blocked := &objects.User{UserId: 300, LanguageCode: "en", MenuId: objects.Menu_Blocked, Lat: 13.7563, Lon: 100.5018}
+	require.NoError(t, repo.SaveUser(blocked))
+	require.NoError(t, service.BroadcastExchange(exchange))
+
+	service.RunPendingJobs()
+
+	job, err := repo.GetFanoutJobByExchange(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.FanoutJobDone, job.Status)
+	assert.Equal(t, 5, job.Found, "Recipients, the busy user and the author, not the blocked user")
+	assert.Equal(t, 4, job.Queued)
+}
+
+func TestFanoutJobClosesForWithdrawnExchange(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 3)
+	require.NoError(t, service.BroadcastExchange(exchange))
+	require.NoError(t, repo.SoftDeleteExchange(exchange.ID))
+
+	service.RunPendingJobs()
+
+	job, err := repo.GetFanoutJobByExchange(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.FanoutJobDone, job.Status)
+	assert.Empty(t, publisher.notifications)
+}
+
+func TestFanoutJobSkipsUsersWhoseRadiusDoesNotReach(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 0)
+
+	// ~5 km from the exchange, within the author's 10 km
+	small, large := 2, 10
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: 200, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 13.7563, Lon: 100.5480, SearchRadiusKm: &small}))
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: 201, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 13.7563, Lon: 100.5480, SearchRadiusKm: &large}))
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	recipients := map[int64]bool{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 201: true}, recipients, "Only users whose own radius covers the exchange")
+}
+
+func TestFanoutJobNotifiesOnlyMatchingInterests(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 3)
+	amount := 50
+	exchange.AmountUSD = &amount
+	require.NoError(t, repo.UpdateExchange(exchange))
+
+	// The author has cash and wants crypto, user 100 wants the same, user 101 only small amounts
+	// and user 102 has no interests
+	limit := 25
+	require.NoError(t, repo.SaveInterest(&objects.Interest{UserID: 100, Direction: objects.ExchangeDirectionCashToCrypto, Asset: objects.AssetUSDT}))
+	require.NoError(t, repo.SaveInterest(&objects.Interest{UserID: 101, Direction: objects.ExchangeDirectionCryptoToCash, Asset: objects.AssetUSDT, MaxAmountUSD: &limit}))
+	require.NoError(t, repo.SaveInterest(&objects.Interest{UserID: 1, Direction: objects.ExchangeDirectionCryptoToCash, Asset: objects.AssetUSDT}))
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	recipients := map[int64]bool{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 102: true}, recipients, "The author and users without interests")
+}
+
+func TestFanoutJobNotifiesMatchingAlerts(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 0)
+
+	// Users 200 and 201 live far away and have an alert ~5 km from the exchange, only 200's
+	// direction fits. User 202 lives next to the exchange with a non-matching interest, but
+	// one of its alerts matches.
+	for _, userID := range []int64{200, 201} {
+		require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 12.9236, Lon: 100.8825}))
+	}
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: 202, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 13.7563, Lon: 100.5018}))
+	require.NoError(t, repo.SaveInterest(&objects.Interest{UserID: 202, Direction: objects.ExchangeDirectionCashToCrypto, Asset: objects.AssetUSDT}))
+
+	require.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 200, Name: "Work", Lat: 13.7563, Lon: 100.5480, RadiusKm: 15,
+		Direction: objects.ExchangeDirectionCryptoToCash, Active: true}))
+	require.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 201, Name: "Work", Lat: 13.7563, Lon: 100.5480, RadiusKm: 15,
+		Direction: objects.ExchangeDirectionCashToCrypto, Active: true}))
+	require.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 202, Name: "Office", Lat: 13.7563, Lon: 100.5018, RadiusKm: 5, Active: true}))
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	recipients := map[int64]bool{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 200: true, 202: true}, recipients, "The author and users with a matching alert")
+}
+
+func TestBroadcastHistoricalExchangesForAlert(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 0)
+	amount := 50
+	exchange.AmountUSD = &amount
+	require.NoError(t, repo.UpdateExchange(exchange))
+
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: 200, LanguageCode: "en", MenuId: objects.Menu_AlertLocation, Lat: 12.9236, Lon: 100.8825}))
+
+	limit := 25
+	small := &objects.Alert{UserID: 200, Name: "Work", Lat: 13.7563, Lon: 100.5480, RadiusKm: 15, MaxAmountUSD: &limit, Active: true}
+	require.NoError(t, repo.CreateAlert(small))
+	sent, err := service.BroadcastHistoricalExchangesForAlert(small)
+	require.NoError(t, err)
+	assert.Zero(t, sent, "The amount is over the alert's limit")
+
+	unfiltered := &objects.Alert{UserID: 200, Name: "Office", Lat: 13.7563, Lon: 100.5480, RadiusKm: 15, Active: true}
+	require.NoError(t, repo.CreateAlert(unfiltered))
+	sent, err = service.BroadcastHistoricalExchangesForAlert(unfiltered)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	if assert.Len(t, publisher.notifications, 1) {
+		assert.Equal(t, int64(200), publisher.notifications[0].RecipientUserID)
+	}
+}
+
+func TestFanoutJobDefersBusyRecipients(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 1)
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	recipients := map[int64]bool{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 100: true}, recipients, "The author is notified even outside the main menu")
+
+	records, err := repo.GetDeferredTimelineRecords(99)
+	require.NoError(t, err)
+	require.Len(t, records, 1, "The busy user gets a deferred record")
+	assert.Equal(t, exchange.ID, records[0].ExchangeID)
+
+	// Delivered once the user is back in the main menu
+	busy := repo.FindUser(99)
+	busy.MenuId = objects.Menu_Main
+	require.NoError(t, repo.SaveUser(busy))
+	queued, err := service.DeliverDeferredNotifications(busy)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	last := publisher.notifications[len(publisher.notifications)-1]
+	assert.Equal(t, int64(99), last.RecipientUserID)
+	assert.Equal(t, exchange.ID, last.ExchangeID)
+
+	queued, err = service.DeliverDeferredNotifications(busy)
+	require.NoError(t, err)
+	assert.Zero(t, queued, "A deferred notification is delivered once")
+}
+
+func TestDeliverDeferredNotificationsDropsWithdrawnExchanges(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 0)
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+	require.NoError(t, repo.SoftDeleteExchange(exchange.ID))
+	sent := len(publisher.notifications)
+
+	busy := repo.FindUser(99)
+	busy.MenuId = objects.Menu_Main
+	queued, err := service.DeliverDeferredNotifications(busy)
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+	assert.Len(t, publisher.notifications, sent)
+
+	records, err := repo.GetDeferredTimelineRecords(99)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+// timelineStatus returns the status of a recipient's timeline record of the exchange
+func timelineStatus(t *testing.T, repo *repository.MemoryRepository, exchangeID, userID int64) string {
+	records, err := repo.GetTimelineRecordsByExchange(exchangeID)
+	require.NoError(t, err)
+	for _, record := range records {
+		if record.RecipientUserID == userID {
+			return record.Status
+		}
+	}
+	return ""
+}
+
+func TestDeliverDeferredNotificationsClaimsBeforePublishing(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 0)
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	busy := repo.FindUser(99)
+	busy.MenuId = objects.Menu_Main
+	publisher.onPublish = func(count int) error {
+		if count == 1 {
+			assert.Equal(t, objects.TimelineStatusPending, timelineStatus(t, repo, exchange.ID, 99),
+				"The record is claimed before the sender can record the sent message")
+			return errors.New("channel closed")
+		}
+		return nil
+	}
+	_, err := service.DeliverDeferredNotifications(busy)
+	assert.Error(t, err)
+	assert.Equal(t, objects.TimelineStatusDeferred, timelineStatus(t, repo, exchange.ID, 99),
+		"A notification that could not be queued is deferred again")
+
+	publisher.onPublish = nil
+	queued, err := service.DeliverDeferredNotifications(busy)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+}
+
+func TestDeliverDeferredNotificationsFollowsSettings(t *testing.T) {
+	// Quiet hours around the current hour in Bangkok, where the recipients are
+	bangkok, err := time.LoadLocation("Asia/Bangkok")
+	require.NoError(t, err)
+	start := time.Now().In(bangkok).Hour()
+	end := (start + 2) % 24
+	until := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		settings *objects.NotificationSettings
+		status   string
+	}{
+		{"paused", &objects.NotificationSettings{PausedUntil: &until, Delivery: objects.DeliveryInstant}, objects.TimelineStatusDeleted},
+		{"digest", &objects.NotificationSettings{Delivery: objects.DeliveryDigest}, objects.TimelineStatusDigest},
+		{"quiet hours", &objects.NotificationSettings{QuietStartHour: &start, QuietEndHour: &end, Delivery: objects.DeliveryInstant}, objects.TimelineStatusHeld},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, repo, publisher, exchange := newJobFixture(t, 0)
+			require.NoError(t, service.BroadcastExchange(exchange))
+			service.RunPendingJobs()
+			sent := len(publisher.notifications)
+
+			tt.settings.UserID = 99
+			require.NoError(t, repo.SaveNotificationSettings(tt.settings))
+			busy := repo.FindUser(99)
+			busy.MenuId = objects.Menu_Main
+			queued, err := service.DeliverDeferredNotifications(busy)
+			require.NoError(t, err)
+			assert.Zero(t, queued)
+			assert.Len(t, publisher.notifications, sent)
+			assert.Equal(t, tt.status, timelineStatus(t, repo, exchange.ID, 99))
+		})
+	}
+}
+
+func TestFanoutJobSkipsBlockedUsers(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 0)
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: 200, LanguageCode: "en", MenuId: objects.Menu_Blocked, Lat: 13.7563, Lon: 100.5018}))
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	for _, bag := range publisher.notifications {
+		assert.NotEqual(t, int64(200), bag.RecipientUserID)
+	}
+	records, err := repo.GetDeferredTimelineRecords(200)
+	require.NoError(t, err)
+	assert.Empty(t, records, "Blocked users are not deferred either")
+}
+
+func TestFanoutJobSkipsPausedRecipients(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 2)
+	until := time.Now().Add(24 * time.Hour)
+	require.NoError(t, repo.SaveNotificationSettings(&objects.NotificationSettings{UserID: 100, PausedUntil: &until, Delivery: objects.DeliveryInstant}))
+	require.NoError(t, repo.SaveNotificationSettings(&objects.NotificationSettings{UserID: 1, PausedUntil: &until, Delivery: objects.DeliveryInstant}))
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	recipients := map[int64]bool{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 101: true}, recipients, "The author is notified even when paused")
+}
+
+func TestFanoutJobHoldsNotificationsDuringQuietHours(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 2)
+
+	// Quiet hours around the current hour in Bangkok, where the recipients are
+	bangkok, err := time.LoadLocation("Asia/Bangkok")
+	require.NoError(t, err)
+	start := time.Now().In(bangkok).Hour()
+	end := (start + 2) % 24
+	require.NoError(t, repo.SaveNotificationSettings(&objects.NotificationSettings{UserID: 100, QuietStartHour: &start, QuietEndHour: &end, Delivery: objects.DeliveryInstant}))
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+	for _, bag := range publisher.notifications {
+		assert.NotEqual(t, int64(100), bag.RecipientUserID, "Nothing is sent during quiet hours")
+	}
+
+	queued, err := service.ReleaseHeldNotifications(time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, queued, "Held until the quiet hours end")
+
+	sent := len(publisher.notifications)
+	queued, err = service.ReleaseHeldNotifications(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	require.Len(t, publisher.notifications, sent+1)
+	last := publisher.notifications[sent]
+	assert.Equal(t, int64(100), last.RecipientUserID)
+	assert.Equal(t, exchange.ID, last.ExchangeID)
+
+	queued, err = service.ReleaseHeldNotifications(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, queued, "A held notification is released once")
+}
+
+func TestReleaseHeldNotificationsDropsWithdrawnExchanges(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 1)
+	releaseAt := time.Now()
+	require.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{
+		ExchangeID: exchange.ID, RecipientUserID: 100, Status: objects.TimelineStatusHeld, ReleaseAt: &releaseAt}))
+	require.NoError(t, repo.UpdateExchangeStatus(exchange.ID, objects.ExchangeStatusCanceled))
+
+	queued, err := service.ReleaseHeldNotifications(time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+	assert.Empty(t, publisher.notifications)
+
+	records, err := repo.GetTimelineRecordsByExchange(exchange.ID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, objects.TimelineStatusDeleted, records[0].Status)
+}
+
+func TestFanoutJobCollectsOffersForDigest(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 2)
+	require.NoError(t, repo.SaveNotificationSettings(&objects.NotificationSettings{UserID: 100, Delivery: objects.DeliveryDigest}))
+	require.NoError(t, repo.SaveNotificationSettings(&objects.NotificationSettings{UserID: 1, Delivery: objects.DeliveryDigest}))
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	recipients := map[int64]bool{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 101: true}, recipients, "The author is notified even in digest mode")
+
+	collected, err := repo.GetCollectedRecords(100, objects.TimelineStatusDigest)
+	require.NoError(t, err)
+	require.Len(t, collected, 1)
+	assert.Equal(t, exchange.ID, collected[0].ExchangeID)
+}
+
+func TestSendDueDigests(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 1)
+	other := objects.NewExchange(1, objects.ExchangeDirectionCryptoToCash, 13.7563, 100.5018)
+	require.NoError(t, repo.CreateExchange(other))
+	require.NoError(t, repo.PostExchange(other))
+	for _, exchangeID := range []int64{exchange.ID, other.ID} {
+		require.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{
+			ExchangeID: exchangeID, RecipientUserID: 100, Status: objects.TimelineStatusDigest}))
+	}
+
+	queued, err := service.SendDueDigests(time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, queued, "Not due before the digest interval has passed")
+
+	queued, err = service.SendDueDigests(time.Now().Add(defaultDigestInterval))
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	require.Len(t, publisher.notifications, 1)
+
+	bag := publisher.notifications[0]
+	assert.Equal(t, int64(100), bag.RecipientUserID)
+	assert.NotZero(t, bag.DigestID)
+	keyboard := bag.Message.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	require.Len(t, keyboard.InlineKeyboard, 1)
+	require.Len(t, keyboard.InlineKeyboard[0], 2)
+	assert.Equal(t, fmt.Sprintf("contact:%d", exchange.ID), *keyboard.InlineKeyboard[0][0].CallbackData)
+	assert.Equal(t, fmt.Sprintf("contact:%d", other.ID), *keyboard.InlineKeyboard[0][1].CallbackData)
+
+	collected, err := repo.GetCollectedRecords(100, objects.TimelineStatusDigest)
+	require.NoError(t, err)
+	assert.Empty(t, collected, "Sent offers are no longer collected")
+
+	queued, err = service.SendDueDigests(time.Now().Add(2 * defaultDigestInterval))
+	require.NoError(t, err)
+	assert.Zero(t, queued, "Nothing new to send")
+}
+
+func TestSendDueDigestsWaitsForQuietHours(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 1)
+	require.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{
+		ExchangeID: exchange.ID, RecipientUserID: 100, Status: objects.TimelineStatusDigest}))
+
+	bangkok, err := time.LoadLocation("Asia/Bangkok")
+	require.NoError(t, err)
+	now := time.Now().Add(defaultDigestInterval)
+	start := now.In(bangkok).Hour()
+	end := (start + 2) % 24
+	require.NoError(t, repo.SaveNotificationSettings(&objects.NotificationSettings{UserID: 100, QuietStartHour: &start, QuietEndHour: &end, Delivery: objects.DeliveryDigest}))
+
+	queued, err := service.SendDueDigests(now)
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+	assert.Empty(t, publisher.notifications)
+
+	queued, err = service.SendDueDigests(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+}
+
+func TestRefreshDigestAfterDeletion(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 1)
+	require.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{
+		ExchangeID: exchange.ID, RecipientUserID: 100, Status: objects.TimelineStatusDigest}))
+	_, err := service.SendDueDigests(time.Now().Add(defaultDigestInterval))
+	require.NoError(t, err)
+	require.Len(t, publisher.notifications, 1)
+
+	// Not sent yet, nothing to edit
+	digestID := publisher.notifications[0].DigestID
+	require.NoError(t, service.RefreshDigest(digestID))
+	assert.Empty(t, publisher.edits)
+
+	messageID := 42
+	require.NoError(t, repo.RecordDigestDelivery(digestID, &messageID, objects.TimelineStatusSent))
+	require.NoError(t, repo.SoftDeleteExchangeTimeline(exchange.ID))
+	require.NoError(t, service.RefreshDigest(digestID))
+
+	require.Len(t, publisher.edits, 1)
+	assert.Equal(t, messageID, publisher.edits[0].EditMessage.MessageID)
+	assert.Equal(t, "digest.all_withdrawn", publisher.edits[0].EditMessage.Text)
+}
+
+// sendNotifications records n notifications sent to the user in the last minutes
+func sendNotifications(t *testing.T, repo *repository.MemoryRepository, userID int64, n int) {
+	for i := 0; i < n; i++ {
+		exchange := objects.NewExchange(2, objects.ExchangeDirectionCashToCrypto, 13.7563, 100.5018)
+		require.NoError(t, repo.CreateExchange(exchange))
+		record := objects.NewTimelineRecord(exchange.ID, userID)
+		record.Status, record.SentAt = objects.TimelineStatusSent, &record.CreatedAt
+		require.NoError(t, repo.CreateTimelineRecord(record))
+	}
+}
+
+func TestFanoutJobCollectsOffersOverHourlyCap(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 2)
+	sendNotifications(t, repo, 100, defaultHourlyCap)
+	sendNotifications(t, repo, 1, defaultHourlyCap)
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+
+	recipients := map[int64]bool{}
+	for _, bag := range publisher.notifications {
+		recipients[bag.RecipientUserID] = true
+	}
+	assert.Equal(t, map[int64]bool{1: true, 101: true}, recipients, "The author is notified over the cap too")
+
+	queued, err := service.SendOverflowSummaries(time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, queued, "Offers over the cap are collected for a while first")
+
+	queued, err = service.SendOverflowSummaries(time.Now().Add(overflowSummaryDelay))
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	require.Len(t, publisher.notifications, 3)
+
+	summary := publisher.notifications[2]
+	assert.Equal(t, int64(100), summary.RecipientUserID)
+	assert.Contains(t, summary.Message.Text, "overflow.summary")
+	keyboard := summary.Message.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	assert.Equal(t, fmt.Sprintf("digest:%d", summary.DigestID), *keyboard.InlineKeyboard[0][0].CallbackData)
+
+	records, err := repo.GetTimelineRecordsByDigest(summary.DigestID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, exchange.ID, records[0].ExchangeID)
+}
+
+func TestFanoutJobHourlyCapIsConfigurable(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 1)
+	service.context.Config = &config.Config{Fanout_Hourly_Cap: 1}
+	sendNotifications(t, repo, 100, 1)
+
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+	for _, bag := range publisher.notifications {
+		assert.NotEqual(t, int64(100), bag.RecipientUserID)
+	}
+
+	service.context.Config.Fanout_Hourly_Cap = -1
+	assert.Zero(t, service.hourlyCap(), "A negative cap turns it off")
+}
+
+func TestOverflowSummaryKeepsEveryOffer(t *testing.T) {
+	service, repo, publisher, _ := newJobFixture(t, 1)
+	recipient := repo.FindUser(100)
+	const offers = digestMaxOffers + 5
+	for i := 0; i < offers; i++ {
+		exchange := objects.NewExchange(1, objects.ExchangeDirectionCashToCrypto, 13.7563, 100.5018)
+		require.NoError(t, repo.CreateExchange(exchange))
+		require.NoError(t, repo.PostExchange(exchange))
+		require.NoError(t, service.collectOffer(exchange, recipient, 1, objects.TimelineStatusOverflow))
+	}
+
+	queued, err := service.SendOverflowSummaries(time.Now().Add(overflowSummaryDelay))
+	require.NoError(t, err)
+	require.Equal(t, 1, queued)
+	digestID := publisher.notifications[0].DigestID
+	records, err := repo.GetTimelineRecordsByDigest(digestID)
+	require.NoError(t, err)
+	assert.Len(t, records, offers, "Every offer counted in the summary can be viewed")
+
+	messageID := 42
+	require.NoError(t, repo.RecordDigestDelivery(digestID, &messageID, objects.TimelineStatusSent))
+	require.NoError(t, service.ShowDigestPage(digestID, 1))
+	require.Len(t, publisher.edits, 1)
+	keyboard := publisher.edits[0].EditMessage.ReplyMarkup.InlineKeyboard
+	pageRow := keyboard[len(keyboard)-1]
+	require.Len(t, pageRow, 1, "The last page only goes back")
+	assert.Equal(t, fmt.Sprintf("digest:%d:0", digestID), *pageRow[0].CallbackData)
+
+	contacts := 0
+	for _, row := range keyboard[:len(keyboard)-1] {
+		contacts += len(row)
+	}
+	assert.Equal(t, offers-digestMaxOffers, contacts)
+}
+
+func TestDeliverDeferredNotificationsAppliesHourlyCap(t *testing.T) {
+	service, repo, publisher, exchange := newJobFixture(t, 0)
+	require.NoError(t, service.BroadcastExchange(exchange))
+	service.RunPendingJobs()
+	sent := len(publisher.notifications)
+	sendNotifications(t, repo, 99, defaultHourlyCap)
+
+	busy := repo.FindUser(99)
+	busy.MenuId = objects.Menu_Main
+	queued, err := service.DeliverDeferredNotifications(busy)
+	require.NoError(t, err)
+	assert.Zero(t, queued)
+	assert.Len(t, publisher.notifications, sent)
+	assert.Equal(t, objects.TimelineStatusOverflow, timelineStatus(t, repo, exchange.ID, 99))
+}
@@ -0,0 +1,121 @@
+package fanout
+
+import (
+	"fmt"
+	"librecash/objects"
+	"log"
+	"time"
+)
+
+const (
+	outboxPollInterval = 2 * time.Second  // how often the relay looks for pending entries
+	outboxBatchSize    = 10               // entries claimed per poll
+	outboxLease        = 5 * time.Minute  // how long a claimed entry is hidden from other relays
+	outboxMaxBackoff   = 10 * time.Minute // upper bound of the retry delay of a failing entry
+)
+
+// outboxWakeup lets the producer start the relay right after posting an exchange
+// instead of waiting for the next poll
+var outboxWakeup = make(chan struct{}, 1)
+
+// WakeOutboxRelay asks the relay to process pending entries now
+func WakeOutboxRelay() {
+	select {
+	case outboxWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutboxRelay turns pending fanout outbox entries into fanout jobs until stop is closed.
+// An entry leaves the pending set only after its job was stored, the job worker then
+// notifies the recipients, so they may get a notification twice but never miss one.
+func (f *FanoutService) RunOutboxRelay(stop <-chan struct{}) {
+	log.Printf("[OUTBOX] Starting fanout outbox relay")
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		f.RelayPendingEntries()
+
+		select {
+		case <-stop:
+			log.Printf("[OUTBOX] Fanout outbox relay stopped")
+			return
+		case <-ticker.C:
+		case <-outboxWakeup:
+		}
+	}
+}
+
+// RelayPendingEntries drains the outbox batch by batch, replay calls it directly after each update
+func (f *FanoutService) RelayPendingEntries() {
+	for {
+		entries, err := f.context.Repo.ClaimOutboxEntries(outboxBatchSize, outboxLease)
+		if err != nil {
+			log.Printf("[OUTBOX] Failed to claim outbox entries: %v", err)
+			return
+		}
+		if len(entries) == 0 {
+			return
+		}
+
+		for _, entry := range entries {
+			// The rest of the batch stays claimed and comes back once its lease runs out
+			if err := f.relayEntry(entry); err != nil {
+				log.Printf("[OUTBOX] Stopping relay until the next poll: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// relayEntry enqueues the fanout job of a single outbox entry. A failed fanout is scheduled
+// for a retry; the returned error means the outcome could not be stored.
+func (f *FanoutService) relayEntry(entry *objects.OutboxEntry) error {
+	log.Printf("[OUTBOX] Relaying outbox entry %d for exchange %d (attempt %d)",
+		entry.ID, entry.ExchangeID, entry.Attempts+1)
+
+	err := f.broadcastOutboxExchange(entry.ExchangeID)
+	if err != nil {
+		retryIn := outboxBackoff(entry.Attempts + 1)
+		log.Printf("[OUTBOX] Outbox entry %d failed: %v. Retrying in %v", entry.ID, err, retryIn)
+		if markErr := f.context.Repo.MarkOutboxEntryFailed(entry.ID, err, retryIn); markErr != nil {
+			log.Printf("[OUTBOX] Failed to mark outbox entry %d as failed: %v", entry.ID, markErr)
+			return fmt.Errorf("failed to mark outbox entry %d as failed: %v", entry.ID, markErr)
+		}
+		return nil
+	}
+
+	if err := f.context.Repo.MarkOutboxEntryPublished(entry.ID); err != nil {
+		log.Printf("[OUTBOX] Failed to mark outbox entry %d as published: %v", entry.ID, err)
+		return fmt.Errorf("failed to mark outbox entry %d as published: %v", entry.ID, err)
+	}
+	return nil
+}
+
+// broadcastOutboxExchange enqueues the fanout unless the exchange was deleted or canceled meanwhile
+func (f *FanoutService) broadcastOutboxExchange(exchangeID int64) error {
+	exchange, err := f.context.Repo.GetExchangeByID(exchangeID)
+	if err != nil {
+		return fmt.Errorf("failed to load exchange: %v", err)
+	}
+	if exchange == nil || exchange.IsDeleted || exchange.Status != objects.ExchangeStatusPosted {
+		log.Printf("[OUTBOX] Exchange %d is no longer posted, skipping fanout", exchangeID)
+		return nil
+	}
+
+	return f.BroadcastExchange(exchange)
+}
+
+// outboxBackoff returns the delay before the next relay attempt (1-based)
+func outboxBackoff(attempt int) time.Duration {
+	if attempt > 10 {
+		return outboxMaxBackoff
+	}
+	delay := outboxPollInterval * time.Duration(1<<uint(attempt-1))
+	if delay > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return delay
+}
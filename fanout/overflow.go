@@ -0,0 +1,58 @@
+package fanout
+
+import (
+	"fmt"
+	"librecash/objects"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	defaultHourlyCap        = 20               // fanout notifications a recipient gets per hour
+	overflowSummaryDelay    = 10 * time.Minute // how long offers over the cap are collected before the summary
+	overflowIdempotencyKind = "overflow_message"
+)
+
+// hourlyCap returns the configured number of fanout notifications a recipient gets per hour,
+// 0 for no cap
+func (f *FanoutService) hourlyCap() int {
+	if f.context.Config != nil && f.context.Config.Fanout_Hourly_Cap != 0 {
+		if f.context.Config.Fanout_Hourly_Cap < 0 {
+			return 0
+		}
+		return f.context.Config.Fanout_Hourly_Cap
+	}
+	return defaultHourlyCap
+}
+
+// overCap reports whether a recipient got their hourly cap of notifications already. The
+// author always gets the message right away.
+func overCap(exchange *objects.Exchange, user *objects.User, sent int, limit int) bool {
+	return user.UserId != exchange.UserID && limit > 0 && sent >= limit
+}
+
+// sentLastHour counts the notifications sent to a user in the hour before now
+func (f *FanoutService) sentLastHour(userID int64, now time.Time) (int, error) {
+	counts, err := f.context.Repo.CountSentNotificationsByUsers([]int64{userID}, now.Add(-time.Hour))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count sent notifications: %v", err)
+	}
+	return counts[userID], nil
+}
+
+// SendOverflowSummaries queues one "more offers near you" message for every user whose oldest
+// offer over the cap was collected at least overflowSummaryDelay ago, so a burst of offers
+// becomes one message. It returns how many summaries were queued.
+func (f *FanoutService) SendOverflowSummaries(now time.Time) (int, error) {
+	return f.sendDueCollected(objects.TimelineStatusOverflow, now.Add(-overflowSummaryDelay), now)
+}
+
+// buildOverflowSummary renders the summary of offers over the cap, its button opens the list
+func buildOverflowSummary(recipient *objects.User, digestID int64, count int) (string, tgbotapi.InlineKeyboardMarkup) {
+	locale := recipient.Locale()
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(locale.Get("overflow.button_view"), fmt.Sprintf("digest:%d", digestID)),
+	))
+	return fmt.Sprintf(locale.Get("overflow.summary"), count), keyboard
+}
@@ -0,0 +1,167 @@
+package fanout
+
+import (
+	"fmt"
+	"librecash/geo"
+	"librecash/metrics"
+	"librecash/objects"
+	"log"
+	"time"
+)
+
+const (
+	heldReleaseInterval  = time.Minute // how often the worker releases held notifications
+	heldReleaseBatchSize = 100         // held notifications claimed at once
+)
+
+// isPaused reports whether a recipient paused their notifications. The author still gets the
+// message about their own exchange.
+func isPaused(exchange *objects.Exchange, user *objects.User, settings *objects.NotificationSettings, now time.Time) bool {
+	return user.UserId != exchange.UserID && settings != nil && settings.IsPaused(now)
+}
+
+// quietUntil reports whether a recipient is in their quiet hours, in the timezone of their
+// location, and when the quiet hours end. The author always gets the message right away.
+func quietUntil(exchange *objects.Exchange, user *objects.User, settings *objects.NotificationSettings, now time.Time) (time.Time, bool) {
+	if user.UserId == exchange.UserID || settings == nil {
+		return time.Time{}, false
+	}
+	return settings.QuietUntil(now, geo.TimezoneAt(user.Lat, user.Lon))
+}
+
+// holdNotification records a held timeline record, released when the recipient's quiet hours end
+func (f *FanoutService) holdNotification(exchange *objects.Exchange, recipient *objects.User, distance float64, releaseAt time.Time) error {
+	log.Printf("[FANOUT] Holding notification for user %d about exchange %d until %s",
+		recipient.UserId, exchange.ID, releaseAt.Format(time.RFC3339))
+
+	record := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	record.Status = objects.TimelineStatusHeld
+	record.DistanceKm = &distance
+	record.ReleaseAt = &releaseAt
+	err := f.context.Repo.CreateDeferredTimelineRecord(record)
+
+	metrics.RecordFanoutMessage("held_notification", recipient.GetSupportedLanguageCode(), err == nil)
+	return err
+}
+
+// RunHeldNotifications releases the notifications held for quiet hours once they end, until
+// stop is closed
+func (f *FanoutService) RunHeldNotifications(stop <-chan struct{}) {
+	log.Printf("[FANOUT] Starting held notification worker")
+
+	ticker := time.NewTicker(heldReleaseInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := f.ReleaseHeldNotifications(time.Now()); err != nil {
+			log.Printf("[FANOUT] Failed to release held notifications: %v", err)
+		}
+
+		select {
+		case <-stop:
+			log.Printf("[FANOUT] Held notification worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseHeldNotifications queues the held notifications due at now. Notifications of exchanges
+// no longer posted are dropped, the others go through the same decision chain as a fanout job,
+// e.g. those of users busy in another menu are deferred to the main menu. It returns how many
+// were queued.
+func (f *FanoutService) ReleaseHeldNotifications(now time.Time) (int, error) {
+	queued := 0
+	for {
+		records, err := f.context.Repo.ClaimHeldTimelineRecords(now, heldReleaseBatchSize)
+		if err != nil {
+			return queued, fmt.Errorf("failed to claim held notifications: %v", err)
+		}
+		if len(records) == 0 {
+			break
+		}
+
+		var releaseErr error
+		for _, record := range records {
+			released, err := f.releaseHeldNotification(record, now)
+			if err != nil {
+				// Held again, the next run retries it
+				log.Printf("[FANOUT] Failed to release held notification %d: %v", record.ID, err)
+				f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusHeld)
+				releaseErr = err
+				continue
+			}
+			if released {
+				queued++
+			}
+		}
+		// Don't claim the failed ones again right away
+		if releaseErr != nil {
+			return queued, releaseErr
+		}
+	}
+
+	if queued > 0 {
+		log.Printf("[FANOUT] Released %d held notification(s)", queued)
+	}
+	return queued, nil
+}
+
+// releaseHeldNotification queues a claimed held notification, it reports false if the
+// notification was dropped, collected, held again or deferred instead
+func (f *FanoutService) releaseHeldNotification(record *objects.TimelineRecord, now time.Time) (bool, error) {
+	drop := func(reason string) (bool, error) {
+		log.Printf("[FANOUT] Dropping held notification of exchange %d for user %d (%s)",
+			record.ExchangeID, record.RecipientUserID, reason)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDeleted)
+	}
+
+	exchange, err := f.context.Repo.GetExchangeByID(record.ExchangeID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load exchange %d: %v", record.ExchangeID, err)
+	}
+	if exchange == nil || exchange.IsDeleted || exchange.Status != objects.ExchangeStatusPosted {
+		return drop("no longer posted")
+	}
+
+	user := f.context.Repo.FindUser(record.RecipientUserID)
+	if user == nil || user.Unreachable || user.MenuId == objects.Menu_Blocked || user.MenuId == objects.Menu_Ban {
+		return drop("recipient unreachable")
+	}
+	settings, err := f.context.Repo.GetNotificationSettings(user.UserId)
+	if err != nil {
+		return false, fmt.Errorf("failed to load notification settings: %v", err)
+	}
+	sent, err := f.sentLastHour(user.UserId, now)
+	if err != nil {
+		return false, err
+	}
+
+	action, releaseAt := f.decideDelivery(exchange, user, settings, sent, now)
+	switch action {
+	case deliverSkip:
+		return drop("notifications paused")
+	case deliverDigest:
+		log.Printf("[FANOUT] Collecting held notification of exchange %d for the digest of user %d", exchange.ID, user.UserId)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDigest)
+	case deliverHold:
+		// The quiet hours were changed in the meantime
+		return false, f.context.Repo.HoldTimelineRecord(record.ID, releaseAt)
+	case deliverDefer:
+		log.Printf("[FANOUT] Deferring held notification of exchange %d for busy user %d", exchange.ID, user.UserId)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusDeferred)
+	case deliverOverflow:
+		log.Printf("[FANOUT] Held notification of exchange %d is over the hourly cap of user %d", exchange.ID, user.UserId)
+		return false, f.context.Repo.UpdateTimelineRecordStatus(record.ID, objects.TimelineStatusOverflow)
+	}
+
+	distance := f.calculateDistance(exchange.Lat, exchange.Lon, user.Lat, user.Lon)
+	if record.DistanceKm != nil {
+		distance = *record.DistanceKm
+	}
+	// The record stays pending until the sender records the sent message
+	if err := f.queueNotificationMessage(exchange, user, distance, 0); err != nil {
+		return false, fmt.Errorf("failed to queue notification of exchange %d: %v", exchange.ID, err)
+	}
+	return true, nil
+}
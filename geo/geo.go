@@ -0,0 +1,29 @@
+package geo
+
+import "math"
+
+// EarthRadiusKm is the mean Earth radius used for distance calculations
+const EarthRadiusKm = 6371.0
+
+// DistanceKm returns the great-circle distance between two points in kilometers (Haversine formula)
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	// Convert degrees to radians
+	lat1Rad := lat1 * math.Pi / 180
+	lon1Rad := lon1 * math.Pi / 180
+	lat2Rad := lat2 * math.Pi / 180
+	lon2Rad := lon2 * math.Pi / 180
+
+	// Haversine formula
+	dlat := lat2Rad - lat1Rad
+	dlon := lon2Rad - lon1Rad
+
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1Rad)*math.Cos(lat2Rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	return EarthRadiusKm * c
+}
+
+// WithinRadius reports whether the second point lies within radiusKm of the first
+func WithinRadius(lat1, lon1, lat2, lon2 float64, radiusKm int) bool {
+	return DistanceKm(lat1, lon1, lat2, lon2) <= float64(radiusKm)
+}
@@ -0,0 +1,36 @@
+package geo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistanceKm(t *testing.T) {
+	// Same point
+	assert.Equal(t, 0.0, DistanceKm(40.7128, -74.0060, 40.7128, -74.0060))
+
+	// New York to Los Angeles is about 3936 km
+	assert.InDelta(t, 3936, DistanceKm(40.7128, -74.0060, 34.0522, -118.2437), 10)
+
+	// Symmetric
+	assert.Equal(t,
+		DistanceKm(13.7563, 100.5018, 12.9236, 100.8825),
+		DistanceKm(12.9236, 100.8825, 13.7563, 100.5018))
+}
+
+func TestWithinRadius(t *testing.T) {
+	// Bangkok to Pattaya is about 100 km
+	assert.True(t, WithinRadius(13.7563, 100.5018, 12.9236, 100.8825, 150))
+	assert.False(t, WithinRadius(13.7563, 100.5018, 12.9236, 100.8825, 50))
+}
+
+func TestTimezoneAt(t *testing.T) {
+	assert.Equal(t, "Asia/Bangkok", TimezoneAt(13.7563, 100.5018).String())
+	assert.Equal(t, "America/New_York", TimezoneAt(40.7128, -74.0060).String())
+	assert.Equal(t, "Europe/Berlin", TimezoneAt(52.5200, 13.4050).String())
+
+	// No location and the open ocean fall back to UTC
+	assert.Equal(t, "UTC", TimezoneAt(0, 0).String())
+	assert.Equal(t, "UTC", TimezoneAt(-40, -30).String())
+}
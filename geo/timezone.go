@@ -0,0 +1,30 @@
+package geo
+
+import (
+	"log"
+	"time"
+	// The zone database is embedded, so the lookup works on hosts without /usr/share/zoneinfo
+	_ "time/tzdata"
+
+	"github.com/bradfitz/latlong"
+)
+
+// TimezoneAt returns the local timezone at a point. The zone is looked up in the timezone
+// boundary dataset compiled into the latlong package, which is coarse close to borders. Points
+// outside any zone (e.g. at sea) or without a location get UTC.
+func TimezoneAt(lat, lon float64) *time.Location {
+	if lat == 0 && lon == 0 {
+		return time.UTC
+	}
+
+	name := latlong.LookupZoneName(lat, lon)
+	if name == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("[GEO] Unknown timezone %s at (%f, %f): %v", name, lat, lon, err)
+		return time.UTC
+	}
+	return location
+}
@@ -3,6 +3,9 @@ module librecash
 go 1.25
 
 require (
+	github.com/VictoriaMetrics/metrics v1.40.1
+	github.com/bradfitz/latlong v0.0.0-20170410180902-f3db6d0dff40
+	github.com/getsentry/sentry-go v0.35.3
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
 	github.com/golang/mock v1.4.4
 	github.com/leonelquinteros/gotext v1.5.0
@@ -14,11 +17,9 @@ require (
 )
 
 require (
-	github.com/VictoriaMetrics/metrics v1.40.1 // indirect
 	github.com/benbjohnson/clock v1.3.0 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/fsnotify/fsnotify v1.6.0 // indirect
-	github.com/getsentry/sentry-go v0.35.3 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
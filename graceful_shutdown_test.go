@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"os/exec"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGracefulShutdown(t *testing.T) {
@@ -117,3 +122,47 @@ func TestGracefulShutdownTimeout(t *testing.T) {
 		t.Logf("Process exited in %v", duration)
 	}
 }
+
+func TestShutdownSequenceRunsStagesInOrder(t *testing.T) {
+	sequence := &shutdownSequence{}
+	var order []string
+	step := func(name string) func(ctx context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			order = append(order, name)
+			return "done", nil
+		}
+	}
+
+	// Registered in the order main1 and main2 happen to start
+	sequence.register(stageConnections, "database", step("database"))
+	sequence.register(stageDrain, "consumer", step("consumer"))
+	sequence.register(stageConnections, "transport", step("transport"))
+	sequence.register(stageIntake, "producer", step("producer"))
+
+	report := sequence.run(context.Background())
+	assert.Equal(t, []string{"producer", "consumer", "database", "transport"}, order)
+	require.Len(t, report, 4)
+	assert.True(t, strings.HasPrefix(report[0], "producer: done"))
+
+	assert.Empty(t, sequence.run(context.Background()), "Steps run only once")
+}
+
+func TestShutdownSequenceClosesConnectionsAfterTimeout(t *testing.T) {
+	sequence := &shutdownSequence{}
+	closed := false
+	sequence.register(stageDrain, "consumer", func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "finished 0 in-flight message(s)", ctx.Err()
+	})
+	sequence.register(stageConnections, "database", func(ctx context.Context) (string, error) {
+		closed = true
+		return "closed", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report := sequence.run(ctx)
+
+	assert.True(t, closed, "Connections are closed even when draining timed out")
+	assert.Contains(t, report[0], "incomplete: context deadline exceeded")
+}
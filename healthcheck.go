@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"librecash/config"
+	"librecash/db/migrations"
+	"librecash/rabbit"
+	"sort"
+	"strings"
+)
+
+// runHealthcheckCommand checks the dependencies the bot needs, for container health checks
+// and deploy scripts. Prints one line per check and exits with 1 when any check fails.
+//
+// Usage:
+//
+//	librecash healthcheck [-q]
+func runHealthcheckCommand(args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	quiet := flags.Bool("q", false, "print failures only")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	healthy := true
+	report := func(name string, err error, detail string) {
+		if err != nil {
+			healthy = false
+			fmt.Printf("FAIL %-9s %v\n", name, err)
+		} else if !*quiet {
+			fmt.Printf("ok   %-9s %s\n", name, detail)
+		}
+	}
+
+	db, err := openDatabase("")
+	report("database", err, "connected")
+	if err == nil {
+		defer db.Close()
+		report("schema", migrations.CheckSchema(db), "up to date")
+	}
+
+	if config.C().Rabbit_Url == memoryTransportUrl {
+		report("rabbitmq", nil, "in-memory transport, nothing to check")
+	} else {
+		depths, err := rabbit.Ping(config.C().Rabbit_Url, rabbit.MessagingQueues)
+		report("rabbitmq", err, describeQueueDepths(depths))
+	}
+
+	if !healthy {
+		return 1
+	}
+	return 0
+}
+
+// describeQueueDepths lists ready messages per queue, e.g. "messages=0 messages.edits=3"
+func describeQueueDepths(depths map[string]int) string {
+	names := make([]string, 0, len(depths))
+	for name := range depths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%d", name, depths[name])
+	}
+	return strings.Join(parts, " ")
+}
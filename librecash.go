@@ -7,16 +7,20 @@ import (
 	"librecash/bugsink"
 	"librecash/config"
 	librecashContext "librecash/context"
+	"librecash/db/migrations"
+	"librecash/fanout"
 	"librecash/menu"
 	"librecash/metrics"
 	"librecash/rabbit"
+	"librecash/replay"
 	"librecash/repository"
 	"librecash/sender"
+	"librecash/webhook"
 	"log"
 	"math/rand"
 	"os"
-	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,12 +30,15 @@ import (
 
 const PID_FILE = "librecash_bot.pid"
 
+// pidFile is the PID file of this process, producer and consumer processes get their own
+var pidFile = PID_FILE
+
 // createPidFile creates a PID file and locks it to prevent multiple instances
 func createPidFile() error {
 	// Check if PID file already exists
-	if _, err := os.Stat(PID_FILE); err == nil {
+	if _, err := os.Stat(pidFile); err == nil {
 		// PID file exists, check if process is still running
-		pidBytes, err := os.ReadFile(PID_FILE)
+		pidBytes, err := os.ReadFile(pidFile)
 		if err == nil {
 			if pid, err := strconv.Atoi(string(pidBytes)); err == nil {
 				// Check if process with this PID is still running
@@ -45,32 +52,33 @@ func createPidFile() error {
 		}
 		// If we reach here, the PID file exists but process is not running
 		log.Printf("[MAIN] Found stale PID file, removing it")
-		os.Remove(PID_FILE)
+		os.Remove(pidFile)
 	}
 
 	// Create new PID file
 	currentPid := os.Getpid()
 	pidContent := fmt.Sprintf("%d", currentPid)
 
-	if err := os.WriteFile(PID_FILE, []byte(pidContent), 0644); err != nil {
+	if err := os.WriteFile(pidFile, []byte(pidContent), 0644); err != nil {
 		return fmt.Errorf("failed to create PID file: %v", err)
 	}
 
-	log.Printf("[MAIN] Created PID file %s with PID %d", PID_FILE, currentPid)
+	log.Printf("[MAIN] Created PID file %s with PID %d", pidFile, currentPid)
 	return nil
 }
 
 // removePidFile removes the PID file on shutdown
 func removePidFile() {
-	if err := os.Remove(PID_FILE); err != nil {
+	if err := os.Remove(pidFile); err != nil {
 		log.Printf("[MAIN] Warning: failed to remove PID file: %v", err)
 	} else {
-		log.Printf("[MAIN] Removed PID file %s", PID_FILE)
+		log.Printf("[MAIN] Removed PID file %s", pidFile)
 	}
 }
 
-func initContext() *librecashContext.Context {
-	log.Println("[MAIN] Initializing application context")
+// initContext connects to Telegram and the database, role names the connection in the shutdown report
+func initContext(role string) *librecashContext.Context {
+	log.Printf("[MAIN] Initializing application context for %s", role)
 
 	log.Printf("[MAIN] Using Telegram token: %s...", config.C().Telegram_Token[:10])
 	log.Printf("[MAIN] Using database connection string: %s", config.C().Db_Conn_Str)
@@ -112,6 +120,19 @@ func initContext() *librecashContext.Context {
 	}
 	log.Println("[MAIN] Successfully connected to the database")
 
+	// Refuse to run against a schema older than the code expects
+	if err := migrations.CheckSchema(db); err != nil {
+		bugsink.CaptureError(err, map[string]interface{}{
+			"component": "database",
+			"operation": "schema_check",
+		})
+		log.Fatalf("[MAIN] %v, run `librecash migrate up` first", err)
+	}
+
+	appShutdown.register(stageConnections, "database ("+role+")", func(ctx context.Context) (string, error) {
+		return "closed", db.Close()
+	})
+
 	// Set up context
 	appContext.SetBot(bot)
 	appContext.Repo = repository.NewRepository(db)
@@ -120,133 +141,285 @@ func initContext() *librecashContext.Context {
 	return appContext
 }
 
+// memoryTransportUrl selects the in-process transport instead of RabbitMQ (single binary dev mode)
+const memoryTransportUrl = "memory"
+
+var (
+	memoryTransport     *rabbit.MemoryTransport
+	memoryTransportOnce sync.Once
+)
+
+// newTransport returns the message transport configured by rabbit_url. In memory mode producer
+// and consumer share one transport, so queued messages are lost on exit.
+func newTransport() rabbit.Transport {
+	if config.C().Rabbit_Url == memoryTransportUrl {
+		memoryTransportOnce.Do(func() {
+			log.Println("[MAIN] Using in-memory message transport, queued messages are lost on exit")
+			memoryTransport = rabbit.NewMemoryTransport(10000)
+		})
+		return memoryTransport
+	}
+	return rabbit.NewRabbitClient(config.C().Rabbit_Url,
+		rabbit.QueueInteractive, rabbit.QueueEdits, rabbit.QueueFanout)
+}
+
+// closeTransportOnShutdown closes the transport once producer and consumer are drained
+func closeTransportOnShutdown(role string, transport rabbit.Transport) {
+	appShutdown.register(stageConnections, "transport ("+role+")", func(ctx context.Context) (string, error) {
+		transport.Close()
+		return "closed", nil
+	})
+}
+
 // Message producer - handles incoming Telegram updates
 func main1() {
 	defer bugsink.Recover()
 	log.Println("[MAIN1] Starting message producer goroutine")
 
-	appContext := initContext()
-	appContext.RabbitPublish = rabbit.NewRabbitClient(config.C().Rabbit_Url, "messages")
+	appContext := initContext("producer")
+	transport := newTransport()
+	appContext.RabbitPublish = transport
+	closeTransportOnShutdown("producer", transport)
+
+	// Turn posted exchanges from the fanout outbox into fanout jobs and run them, release the
+	// notifications held for quiet hours and send the digests
+	fanoutService := fanout.NewFanoutService(appContext)
+	fanoutStop := make(chan struct{})
+	fanoutDone := make(chan struct{})
+	var fanoutWorkers sync.WaitGroup
+	fanoutWorkers.Add(4)
+	go func() {
+		defer fanoutWorkers.Done()
+		fanoutService.RunOutboxRelay(fanoutStop)
+	}()
+	go func() {
+		defer fanoutWorkers.Done()
+		fanoutService.RunFanoutJobs(fanoutStop)
+	}()
+	go func() {
+		defer fanoutWorkers.Done()
+		fanoutService.RunHeldNotifications(fanoutStop)
+	}()
+	go func() {
+		defer fanoutWorkers.Done()
+		fanoutService.RunDigests(fanoutStop)
+	}()
+	go func() {
+		fanoutWorkers.Wait()
+		close(fanoutDone)
+	}()
+
+	bot := appContext.GetBot()
+	updates, stopReceiving := receiveUpdates(bot)
 
-	// Configure updates
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	u.Limit = 99
+	recorder := newUpdateRecorder()
 
-	log.Println("[MAIN1] Starting to receive Telegram updates...")
-	updates, err := appContext.GetBot().GetUpdatesChan(u)
-	if err != nil {
-		bugsink.CaptureError(err, map[string]interface{}{
-			"component": "telegram",
-			"operation": "updates_channel",
-		})
-		log.Fatalf("[MAIN1] Failed to get updates channel: %v", err)
+	workers := config.C().Producer_Workers
+	if workers <= 0 {
+		workers = defaultUpdateWorkers
 	}
+	log.Printf("[MAIN1] Handling updates with %d workers", workers)
 
-	log.Println("[MAIN1] Message producer ready, waiting for messages...")
-
-	for update := range updates {
-		// Handle regular messages
-		if update.Message != nil {
-			// Ignore messages from chats (not from users)
-			if update.Message.From == nil {
-				log.Println("[MAIN1] Ignoring message without From field (probably from a channel)")
-				continue
+	loop := newUpdateLoop(updates, workers, func(update tgbotapi.Update) {
+		if recorder != nil {
+			if err := recorder.Record(update); err != nil {
+				log.Printf("[MAIN1] Failed to record update %d: %v", update.UpdateID, err)
 			}
+		}
 
-			userId := update.Message.Chat.ID
-			startTime := time.Now()
-
-			log.Printf("[MAIN1] Received message from user %d (@%s): %s",
-				userId, update.Message.From.UserName, update.Message.Text)
-
-			// Handle the message
-			menu.HandleMessage(appContext, userId, update.Message)
+		menu.HandleUpdate(appContext, update)
+	})
 
-			duration := time.Since(startTime)
-			log.Printf("[MAIN1] Message processing completed for user %d (total duration: %v)", userId, duration)
+	// Stop receiving first, then finish the updates already received, then fan out what they posted
+	appShutdown.register(stageIntake, "producer", func(ctx context.Context) (string, error) {
+		if err := stopReceiving(ctx); err != nil {
+			log.Printf("[MAIN1] Failed to stop receiving updates: %v", err)
+		}
+		drained, err := loop.shutdown(ctx)
+		if err != nil {
+			return drained, err
 		}
 
-		// Handle callback queries (inline button presses)
-		if update.CallbackQuery != nil {
-			userId := int64(update.CallbackQuery.From.ID)
-			log.Printf("[MAIN1] Received callback from user %d: %s", userId, update.CallbackQuery.Data)
+		// A running fanout job stops after its current batch and resumes at the next start
+		close(fanoutStop)
+		select {
+		case <-fanoutDone:
+		case <-ctx.Done():
+			return drained + ", fanout still running", ctx.Err()
+		}
 
-			// Handle the callback
-			menu.HandleCallback(appContext, userId, update.CallbackQuery)
+		if recorder != nil {
+			if err := recorder.Close(); err != nil {
+				return drained, err
+			}
 		}
-	}
+		return drained + ", outbox relay and fanout jobs stopped", nil
+	})
+
+	log.Println("[MAIN1] Message producer ready, waiting for messages...")
+	loop.run()
+	log.Println("[MAIN1] Message producer stopped")
 }
 
-// Message consumer - sends messages to Telegram with rate limiting
-func main2() {
-	defer bugsink.Recover()
-	log.Println("[MAIN2] Starting message consumer goroutine")
+// receiveUpdates starts long polling or the webhook server, as selected by telegram_mode.
+// The returned function stops receiving, updates already received stay in the channel.
+func receiveUpdates(bot *tgbotapi.BotAPI) (tgbotapi.UpdatesChannel, func(ctx context.Context) error) {
+	switch config.C().Telegram_Mode {
+	case "", telegramModePolling:
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+		u.Limit = 99
+
+		// getUpdates is refused while a webhook is registered, e.g. after switching modes
+		if _, err := bot.RemoveWebhook(); err != nil {
+			log.Printf("[MAIN1] Failed to remove webhook: %v", err)
+		}
 
-	appContext := initContext()
-	appContext.RabbitConsume = rabbit.NewRabbitClient(config.C().Rabbit_Url, "messages")
+		log.Println("[MAIN1] Starting to receive Telegram updates by long polling...")
+		updates, err := bot.GetUpdatesChan(u)
+		if err != nil {
+			bugsink.CaptureError(err, map[string]interface{}{
+				"component": "telegram",
+				"operation": "updates_channel",
+			})
+			log.Fatalf("[MAIN1] Failed to get updates channel: %v", err)
+		}
+		return updates, func(ctx context.Context) error {
+			bot.StopReceivingUpdates()
+			return nil
+		}
+	case telegramModeWebhook:
+		server, err := webhook.NewServer(webhookConfig(), bot, bot.Buffer)
+		if err != nil {
+			log.Fatalf("[MAIN1] Invalid webhook configuration: %v", err)
+		}
 
-	// Create and start sender
-	s := sender.NewSender(appContext)
-	s.Start()
+		log.Println("[MAIN1] Starting to receive Telegram updates by webhook...")
+		updates, err := server.Start()
+		if err != nil {
+			bugsink.CaptureError(err, map[string]interface{}{
+				"component": "webhook",
+				"operation": "start",
+			})
+			log.Fatalf("[MAIN1] Failed to start webhook: %v", err)
+		}
 
-	log.Println("[MAIN2] Message consumer ready")
+		go func() {
+			err := <-server.Errors()
+			bugsink.CaptureError(err, map[string]interface{}{
+				"component": "webhook",
+				"operation": "serve",
+			})
+			log.Fatalf("[MAIN1] Webhook server failed: %v", err)
+		}()
+		return updates, server.Shutdown
+	default:
+		log.Fatalf("[MAIN1] Unknown telegram_mode %q, use %q or %q",
+			config.C().Telegram_Mode, telegramModePolling, telegramModeWebhook)
+	}
+	return nil, nil
 }
 
-var (
-	isShuttingDown bool
+const (
+	telegramModePolling = "polling"
+	telegramModeWebhook = "webhook"
 )
 
-func setupGracefulShutdown() {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		sig := <-sigChan
-		log.Printf("Received signal %v, starting graceful shutdown", sig)
-		gracefulShutdown()
-		os.Exit(0)
-	}()
+// webhookConfig reads the webhook settings, filling in the defaults
+func webhookConfig() webhook.Config {
+	c := webhook.Config{
+		URL:            config.C().Webhook_Url,
+		Listen:         config.C().Webhook_Listen,
+		Path:           config.C().Webhook_Path,
+		Secret:         config.C().Webhook_Secret,
+		TLSCert:        config.C().Webhook_Tls_Cert,
+		TLSKey:         config.C().Webhook_Tls_Key,
+		MaxConnections: config.C().Webhook_Max_Connections,
+	}
+	if c.Listen == "" {
+		c.Listen = ":8443"
+	}
+	if c.Path == "" {
+		c.Path = "/telegram/webhook"
+	}
+	return c
 }
 
-func gracefulShutdown() {
-	log.Println("Starting graceful shutdown (max 30 seconds)")
+// newUpdateRecorder returns the recorder configured by record_updates_file, nil when recording is off
+func newUpdateRecorder() *replay.Recorder {
+	if config.C().Record_Updates_File == "" {
+		return nil
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	redaction, err := replay.ParseRedaction(config.C().Record_Redact)
+	if err != nil {
+		log.Fatalf("[MAIN1] Invalid record_redact: %v", err)
+	}
 
-	isShuttingDown = true
+	recorder, err := replay.NewRecorder(config.C().Record_Updates_File, redaction)
+	if err != nil {
+		bugsink.CaptureError(err, map[string]interface{}{
+			"component": "replay",
+			"operation": "recorder_open",
+		})
+		log.Printf("[MAIN1] Update recording disabled: %v", err)
+		return nil
+	}
+	return recorder
+}
 
-	// Wait for current operations to complete (simplified)
-	log.Println("Waiting for operations to complete...")
+// Message consumer - sends messages to Telegram with rate limiting
+func main2() {
+	defer bugsink.Recover()
+	log.Println("[MAIN2] Starting message consumer goroutine")
 
-	// In a real implementation, we would:
-	// - Wait for main1 and main2 to finish current operations
-	// - Close database connections gracefully
-	// - Close RabbitMQ connections
-	// - Flush remaining metrics
-	// - Flush BugSink events
+	appContext := initContext("consumer")
+	transport := newTransport()
+	appContext.RabbitConsume = transport
+	closeTransportOnShutdown("consumer", transport)
 
-	// For now, just wait a bit to simulate graceful shutdown
-	select {
-	case <-time.After(2 * time.Second):
-		log.Println("Operations completed")
-	case <-ctx.Done():
-		log.Println("Timeout reached, forcing shutdown")
-	}
+	// Create and start sender
+	s := sender.NewSender(appContext)
+	s.Start()
 
-	// Close BugSink error tracking
-	bugsink.Close()
+	appShutdown.register(stageDrain, "consumer", func(ctx context.Context) (string, error) {
+		inFlight, err := transport.StopConsuming(ctx)
+		if err != nil {
+			return fmt.Sprintf("finished %d in-flight message(s)", inFlight), err
+		}
+		// Before the database is closed
+		if err := s.Stop(ctx); err != nil {
+			return fmt.Sprintf("finished %d in-flight message(s), pruning still running", inFlight), err
+		}
+		return fmt.Sprintf("finished %d in-flight message(s), pruning stopped", inFlight), nil
+	})
 
-	log.Println("Graceful shutdown completed")
+	log.Println("[MAIN2] Message consumer ready")
 }
 
 func main() {
 	// Initialize random seed
 	rand.Seed(time.Now().UnixNano())
 
-	// Initialize configuration
-	config.Init("librecash")
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// runBotCommand starts the producer, the consumer or both and blocks until a shutdown signal.
+// Separate producer and consumer processes need a shared RabbitMQ and, on one host,
+// different METRICS_PORT values.
+func runBotCommand(name string, args []string, producer, consumer bool) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Usage: librecash %s\n", name)
+		return 2
+	}
+	if config.C().Rabbit_Url == memoryTransportUrl && !(producer && consumer) {
+		fmt.Fprintf(os.Stderr, "rabbit_url %q keeps messages in process, use `librecash run` instead of %s\n",
+			memoryTransportUrl, name)
+		return 2
+	}
+	if name != defaultCommand {
+		pidFile = fmt.Sprintf("librecash_%s.pid", name)
+	}
 
 	// Create PID file to prevent multiple instances
 	if err := createPidFile(); err != nil {
@@ -274,17 +447,19 @@ func main() {
 		log.Fatalf("[MAIN] Failed to initialize metrics: %v", err)
 	}
 
-	// Setup graceful shutdown
-	setupGracefulShutdown()
-
-	log.Println("[MAIN] Starting LibreCash bot...")
+	log.Printf("[MAIN] Starting LibreCash bot (%s)...", name)
 	log.Println("[MAIN] Press Ctrl+C to stop")
 
 	// Start producer and consumer in separate goroutines
-	go main1()
-	go main2()
+	if producer {
+		go main1()
+	}
+	if consumer {
+		go main2()
+	}
 
-	// Keep the main goroutine alive
-	forever := make(chan bool)
-	<-forever
+	sig := waitForShutdownSignal()
+	log.Printf("[MAIN] Received signal %v, starting graceful shutdown", sig)
+	gracefulShutdown(shutdownTimeout)
+	return 0
 }
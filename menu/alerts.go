@@ -0,0 +1,370 @@
+package menu
+
+import (
+	"fmt"
+	"librecash/context"
+	"librecash/fanout"
+	"librecash/metrics"
+	"librecash/objects"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// maxAlertsPerUser limits the active alerts of a user
+const maxAlertsPerUser = 5
+
+// maxAlertNameLength matches the name column of the alerts table
+const maxAlertNameLength = 64
+
+// alertRadii are the radius options of an alert in km, the same as for the user's own location
+var alertRadii = []int{5, 15, 50}
+
+// ShowAlerts sends the user's alerts with buttons to add or delete them. It works from any menu
+// and does not change the user's state.
+func ShowAlerts(user *objects.User, c *context.Context) {
+	log.Printf("[ALERT] Showing alerts to user %d", user.UserId)
+
+	text, keyboard, err := alertsView(user, c)
+	if err != nil {
+		log.Printf("[ALERT] Error loading alerts of user %d: %v", user.UserId, err)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(user.UserId, text)
+	msg.ReplyMarkup = keyboard
+	msg.ParseMode = "HTML"
+	c.Send(msg)
+}
+
+// alertsView renders the alerts message: the active alerts with a delete button each, or a note
+// explaining alerts, and the add button
+func alertsView(user *objects.User, c *context.Context) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	locale := user.Locale()
+
+	alerts, err := c.Repo.GetUserAlerts(user.UserId)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	var text string
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(alerts) == 0 {
+		text = locale.Get("alerts.none")
+	} else {
+		text = locale.Get("alerts.list_header") + "\n"
+		for _, alert := range alerts {
+			text += "\n• " + describeAlert(user, alert)
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				"🗑️ "+alert.Name, fmt.Sprintf("alert:delete:%d", alert.ID))))
+		}
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+		locale.Get("alerts.button_add"), "alert:add")))
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+// describeAlert renders an alert as one line, e.g. "Work: 5 km, Buy USDT with cash, up to $50"
+func describeAlert(user *objects.User, alert *objects.Alert) string {
+	locale := user.Locale()
+
+	description := fmt.Sprintf(locale.Get("alerts.line"), htmlEscapeString(alert.Name), alert.RadiusKm)
+	switch alert.Direction {
+	case objects.ExchangeDirectionCashToCrypto:
+		description += ", " + locale.Get("interest.want_crypto")
+	case objects.ExchangeDirectionCryptoToCash:
+		description += ", " + locale.Get("interest.want_cash")
+	default:
+		description += ", " + locale.Get("alerts.both_directions")
+	}
+	if alert.MaxAmountUSD == nil {
+		return description + ", " + locale.Get("interest.any_amount")
+	}
+	return description + ", " + fmt.Sprintf(locale.Get("interest.up_to"), *alert.MaxAmountUSD)
+}
+
+// AlertNameMenu asks for the name of a new alert and creates its draft
+type AlertNameMenu struct{}
+
+func NewAlertNameMenu() *AlertNameMenu {
+	return &AlertNameMenu{}
+}
+
+func (menu *AlertNameMenu) Handle(user *objects.User, c *context.Context, message *tgbotapi.Message) {
+	log.Printf("[ALERT] Alert name menu for user %d", user.UserId)
+
+	name := strings.TrimSpace(message.Text)
+	if name == "" {
+		c.Send(tgbotapi.NewMessage(user.UserId, user.Locale().Get("alerts.ask_name")))
+		return
+	}
+	if runes := []rune(name); len(runes) > maxAlertNameLength {
+		name = string(runes[:maxAlertNameLength])
+	}
+
+	draft := &objects.Alert{UserID: user.UserId, Name: name}
+	if err := c.Repo.CreateAlert(draft); err != nil {
+		log.Printf("[ALERT] Error creating alert draft for user %d: %v", user.UserId, err)
+		return
+	}
+
+	oldMenuId := user.MenuId
+	user.MenuId = objects.Menu_AlertLocation
+	c.Repo.SaveUser(user)
+	metrics.RecordMenuTransition(oldMenuId, user.MenuId, user.GetSupportedLanguageCode())
+
+	log.Printf("[ALERT] Created alert draft %d for user %d", draft.ID, user.UserId)
+}
+
+// AlertLocationMenu asks for the location of the alert draft, then for its radius
+type AlertLocationMenu struct{}
+
+func NewAlertLocationMenu() *AlertLocationMenu {
+	return &AlertLocationMenu{}
+}
+
+func (menu *AlertLocationMenu) Handle(user *objects.User, c *context.Context, message *tgbotapi.Message) {
+	log.Printf("[ALERT] Alert location menu for user %d", user.UserId)
+	locale := user.Locale()
+
+	if message.Location == nil {
+		locationButton := tgbotapi.NewKeyboardButtonLocation(locale.Get("ask_location_menu.next_button"))
+		keyboard := tgbotapi.NewReplyKeyboard([]tgbotapi.KeyboardButton{locationButton})
+		keyboard.OneTimeKeyboard = true
+		keyboard.ResizeKeyboard = true
+
+		msg := tgbotapi.NewMessage(user.UserId, locale.Get("alerts.ask_location"))
+		msg.ReplyMarkup = keyboard
+		c.Send(msg)
+		return
+	}
+
+	draft, err := c.Repo.GetAlertDraft(user.UserId)
+	if err != nil || draft == nil {
+		log.Printf("[ALERT] No alert draft for user %d: %v", user.UserId, err)
+		TransitionToMainMenu(c, user)
+		return
+	}
+
+	draft.Lat = message.Location.Latitude
+	draft.Lon = message.Location.Longitude
+	if err := c.Repo.UpdateAlert(draft); err != nil {
+		log.Printf("[ALERT] Error saving location of alert draft %d: %v", draft.ID, err)
+		return
+	}
+
+	// Remove the location keyboard
+	removeKeyboard := tgbotapi.NewMessage(user.UserId, locale.Get("ask_location_menu.location_received"))
+	removeKeyboard.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	c.Send(removeKeyboard)
+
+	msg := tgbotapi.NewMessage(user.UserId, locale.Get("alerts.ask_radius"))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			locale.Get("select_radius_menu.big_city"), fmt.Sprintf("alert:radius:%d", alertRadii[0]))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			locale.Get("select_radius_menu.suburbs"), fmt.Sprintf("alert:radius:%d", alertRadii[1]))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			locale.Get("select_radius_menu.rural"), fmt.Sprintf("alert:radius:%d", alertRadii[2]))),
+	)
+	c.Send(msg)
+}
+
+// HandleAlertCallback processes the alert buttons:
+//   - alert:add starts a new alert, asking for its name and location
+//   - alert:radius:KM sets the radius of the draft and asks for the direction
+//   - alert:dir:DIRECTION sets the direction of the draft ("any" for both) and asks for the amount
+//   - alert:amount:AMOUNT sets the amount limit (0 is any amount) and activates the alert
+//   - alert:delete:ID deletes an alert
+func HandleAlertCallback(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	log.Printf("[ALERT] Processing callback: %s for user %d", callback.Data, user.UserId)
+	locale := user.Locale()
+	answer := ""
+	continueMenu := false
+
+	parts := strings.Split(callback.Data, ":")
+	switch {
+	case len(parts) == 2 && parts[1] == "add":
+		if user.Lat == 0 || user.Lon == 0 || user.SearchRadiusKm == nil {
+			answer = locale.Get("exchange_command.not_initialized")
+			break
+		}
+		alerts, err := c.Repo.GetUserAlerts(user.UserId)
+		if err != nil {
+			log.Printf("[ALERT] Error loading alerts of user %d: %v", user.UserId, err)
+			break
+		}
+		if len(alerts) >= maxAlertsPerUser {
+			answer = fmt.Sprintf(locale.Get("alerts.limit"), maxAlertsPerUser)
+			break
+		}
+		if err := c.Repo.DeleteAlertDrafts(user.UserId); err != nil {
+			log.Printf("[ALERT] Error deleting alert drafts of user %d: %v", user.UserId, err)
+			break
+		}
+
+		oldMenuId := user.MenuId
+		user.MenuId = objects.Menu_AlertName
+		c.Repo.SaveUser(user)
+		metrics.RecordMenuTransition(oldMenuId, user.MenuId, user.GetSupportedLanguageCode())
+		continueMenu = true
+
+	case len(parts) == 3 && parts[1] == "radius":
+		radius, err := strconv.Atoi(parts[2])
+		if err != nil || !isAlertRadius(radius) {
+			log.Printf("[ALERT] Invalid radius: %s", parts[2])
+			break
+		}
+		draft := updateAlertDraft(c, user, func(draft *objects.Alert) { draft.RadiusKm = radius })
+		if draft == nil {
+			break
+		}
+
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				locale.Get("interest.want_crypto"), "alert:dir:"+objects.ExchangeDirectionCashToCrypto)),
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				locale.Get("interest.want_cash"), "alert:dir:"+objects.ExchangeDirectionCryptoToCash)),
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				locale.Get("alerts.both_directions"), "alert:dir:any")),
+		)
+		editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID, locale.Get("alerts.ask_direction"))
+		editMsg.ReplyMarkup = &keyboard
+		editMsg.ParseMode = "HTML"
+		c.EditMessage(editMsg)
+
+	case len(parts) == 3 && parts[1] == "dir" && (parts[2] == "any" || isExchangeDirection(parts[2])):
+		direction := parts[2]
+		if direction == "any" {
+			direction = ""
+		}
+		draft := updateAlertDraft(c, user, func(draft *objects.Alert) { draft.Direction = direction })
+		if draft == nil {
+			break
+		}
+
+		var buttons []tgbotapi.InlineKeyboardButton
+		for _, amount := range interestAmounts {
+			label := locale.Get("interest.any_amount")
+			if amount > 0 {
+				label = fmt.Sprintf("$%d", amount)
+			}
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("alert:amount:%d", amount)))
+		}
+
+		editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID, locale.Get("interest.ask_amount"))
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons[:len(buttons)-1], buttons[len(buttons)-1:])
+		editMsg.ReplyMarkup = &keyboard
+		editMsg.ParseMode = "HTML"
+		c.EditMessage(editMsg)
+
+	case len(parts) == 3 && parts[1] == "amount":
+		amount, err := strconv.Atoi(parts[2])
+		if err != nil || amount < 0 {
+			log.Printf("[ALERT] Invalid amount: %s", parts[2])
+			break
+		}
+		draft := updateAlertDraft(c, user, func(draft *objects.Alert) {
+			draft.MaxAmountUSD = nil
+			if amount > 0 {
+				draft.MaxAmountUSD = &amount
+			}
+			draft.Active = true
+		})
+		if draft == nil {
+			break
+		}
+		log.Printf("[ALERT] Activated alert %d of user %d", draft.ID, user.UserId)
+
+		editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID,
+			fmt.Sprintf(locale.Get("alerts.saved"), htmlEscapeString(draft.Name)))
+		editMsg.ParseMode = "HTML"
+		c.EditMessage(editMsg)
+
+		// Send the recent offers around the new alert, like for a new location
+		sent, err := fanout.NewFanoutService(c).BroadcastHistoricalExchangesForAlert(draft)
+		if err != nil {
+			log.Printf("[ALERT] Error broadcasting historical exchanges for alert %d: %v", draft.ID, err)
+		} else {
+			log.Printf("[ALERT] Sent %d historical exchanges for alert %d", sent, draft.ID)
+		}
+
+		if user.MenuId != objects.Menu_Main {
+			TransitionToMainMenu(c, user)
+			continueMenu = true
+		}
+
+	case len(parts) == 3 && parts[1] == "delete":
+		alertID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			log.Printf("[ALERT] Invalid alert id: %s", parts[2])
+			break
+		}
+		if err := c.Repo.DeleteAlert(user.UserId, alertID); err != nil {
+			log.Printf("[ALERT] Error deleting alert %d of user %d: %v", alertID, user.UserId, err)
+			break
+		}
+		answer = locale.Get("alerts.deleted")
+		editAlertsView(c, callback, user)
+
+	default:
+		log.Printf("[ALERT] Invalid callback data: %s", callback.Data)
+	}
+
+	// Answer the callback to stop the loading animation
+	callbackAnswer := tgbotapi.NewCallback(callback.ID, answer)
+	if err := c.AnswerCallbackQuery(callbackAnswer); err != nil {
+		log.Printf("[ALERT] Error answering callback: %v", err)
+	}
+
+	if continueMenu {
+		ContinueMenuProcessing(c, user.UserId)
+	}
+}
+
+// updateAlertDraft applies the change to the user's alert draft and saves it. It returns nil
+// if there is no draft, e.g. for a button of an alert that was already saved.
+func updateAlertDraft(c *context.Context, user *objects.User, change func(draft *objects.Alert)) *objects.Alert {
+	draft, err := c.Repo.GetAlertDraft(user.UserId)
+	if err != nil {
+		log.Printf("[ALERT] Error loading alert draft of user %d: %v", user.UserId, err)
+		return nil
+	}
+	if draft == nil || draft.Lat == 0 && draft.Lon == 0 {
+		log.Printf("[ALERT] No alert draft with a location for user %d", user.UserId)
+		return nil
+	}
+
+	change(draft)
+	if err := c.Repo.UpdateAlert(draft); err != nil {
+		log.Printf("[ALERT] Error saving alert draft %d: %v", draft.ID, err)
+		return nil
+	}
+	return draft
+}
+
+// editAlertsView replaces the message of the pressed button with the current alerts
+func editAlertsView(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	text, keyboard, err := alertsView(user, c)
+	if err != nil {
+		log.Printf("[ALERT] Error loading alerts of user %d: %v", user.UserId, err)
+		return
+	}
+
+	editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID, text)
+	editMsg.ReplyMarkup = &keyboard
+	editMsg.ParseMode = "HTML"
+	c.EditMessage(editMsg)
+}
+
+func isAlertRadius(radius int) bool {
+	for _, option := range alertRadii {
+		if option == radius {
+			return true
+		}
+	}
+	return false
+}
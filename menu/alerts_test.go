@@ -0,0 +1,109 @@
+package menu
+
+import (
+	"fmt"
+	"librecash/objects"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertsCommandAndFlow(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const userID = int64(1001)
+	radius := 10
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main,
+		Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &radius}))
+
+	// /alerts works from any menu and keeps the state
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "/Alerts", From: &tgbotapi.User{ID: int(userID)}})
+	messages := drainMessages(t, transport)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "alerts.none", messages[0].Text)
+	assert.Equal(t, objects.Menu_Main, repo.FindUser(userID).MenuId)
+
+	// Adding an alert asks for the name, then for the location
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:add"))
+	assert.Equal(t, objects.Menu_AlertName, repo.FindUser(userID).MenuId)
+	messages = drainMessages(t, transport)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "alerts.ask_name", messages[0].Text)
+
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "  Work  ", From: &tgbotapi.User{ID: int(userID)}})
+	assert.Equal(t, objects.Menu_AlertLocation, repo.FindUser(userID).MenuId)
+	messages = drainMessages(t, transport)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "alerts.ask_location", messages[0].Text)
+
+	HandleMessage(ctx, userID, &tgbotapi.Message{
+		Location: &tgbotapi.Location{Latitude: 13.7563, Longitude: 100.5480},
+		From:     &tgbotapi.User{ID: int(userID)},
+	})
+	messages = drainMessages(t, transport)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "alerts.ask_radius", messages[1].Text)
+
+	alerts, err := repo.GetUserAlerts(userID)
+	require.NoError(t, err)
+	assert.Empty(t, alerts, "The alert is a draft until the last step")
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:radius:7"))
+	draft, err := repo.GetAlertDraft(userID)
+	require.NoError(t, err)
+	require.NotNil(t, draft)
+	assert.Zero(t, draft.RadiusKm, "Only the offered radii are accepted")
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:radius:15"))
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:dir:any"))
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:amount:50"))
+
+	alerts, err = repo.GetUserAlerts(userID)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "Work", alerts[0].Name)
+	assert.Equal(t, 13.7563, alerts[0].Lat)
+	assert.Equal(t, 100.5480, alerts[0].Lon)
+	assert.Equal(t, 15, alerts[0].RadiusKm)
+	assert.Empty(t, alerts[0].Direction)
+	if assert.NotNil(t, alerts[0].MaxAmountUSD) {
+		assert.Equal(t, 50, *alerts[0].MaxAmountUSD)
+	}
+	assert.Equal(t, objects.Menu_Main, repo.FindUser(userID).MenuId)
+
+	// Buttons of a finished alert do nothing
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:amount:10"))
+	alerts, err = repo.GetUserAlerts(userID)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 50, *alerts[0].MaxAmountUSD)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, fmt.Sprintf("alert:delete:%d", alerts[0].ID)))
+	alerts, err = repo.GetUserAlerts(userID)
+	require.NoError(t, err)
+	assert.Empty(t, alerts)
+}
+
+func TestAlertsLimit(t *testing.T) {
+	ctx, repo, _ := setupMemoryContext(t)
+	const userID = int64(1001)
+	radius := 10
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main,
+		Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &radius}))
+	for i := 0; i < maxAlertsPerUser; i++ {
+		require.NoError(t, repo.CreateAlert(&objects.Alert{UserID: userID, Name: "Area", RadiusKm: 5, Active: true}))
+	}
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:add"))
+	assert.Equal(t, objects.Menu_Main, repo.FindUser(userID).MenuId)
+}
+
+func TestAlertsRequireInitializedUser(t *testing.T) {
+	ctx, repo, _ := setupMemoryContext(t)
+	const userID = int64(1001)
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_AskLocation}))
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "alert:add"))
+	assert.Equal(t, objects.Menu_AskLocation, repo.FindUser(userID).MenuId)
+}
@@ -147,11 +147,10 @@ func HandleAmountMenuCallback(c *context.Context, callback *tgbotapi.CallbackQue
 			return
 		}
 
-		// Update exchange record with amount and status
+		// Post the exchange and enqueue its fanout in one transaction
 		lastExchange.AmountUSD = &amount
-		lastExchange.Status = objects.ExchangeStatusPosted
-		if err := c.Repo.UpdateExchange(lastExchange); err != nil {
-			log.Printf("[AMOUNT_MENU] Error updating exchange with amount: %v", err)
+		if err := c.Repo.PostExchange(lastExchange); err != nil {
+			log.Printf("[AMOUNT_MENU] Error posting exchange with amount: %v", err)
 		}
 
 		// Record listing creation metric with USD amount
@@ -164,16 +163,8 @@ func HandleAmountMenuCallback(c *context.Context, callback *tgbotapi.CallbackQue
 		)
 		log.Printf("[AMOUNT_MENU] User %d selected amount: $%d", user.UserId, amount)
 
-		// Trigger fanout in background after showing confirmation to user
-		go func() {
-			fanoutService := fanout.NewFanoutService(c)
-			if err := fanoutService.BroadcastExchange(lastExchange); err != nil {
-				log.Printf("[AMOUNT_MENU] Fanout failed for exchange %d: %v", lastExchange.ID, err)
-				// Fanout failure is non-critical, user already sees success
-			} else {
-				log.Printf("[AMOUNT_MENU] Fanout completed successfully for exchange %d", lastExchange.ID)
-			}
-		}()
+		// The outbox relay broadcasts the exchange in background, retrying until RabbitMQ confirms it
+		fanout.WakeOutboxRelay()
 	}
 
 	// Edit the message to show confirmation and remove keyboard
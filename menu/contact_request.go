@@ -3,6 +3,7 @@ package menu
 import (
 	"fmt"
 	"librecash/context"
+	"librecash/fanout"
 	"librecash/metrics"
 	"librecash/objects"
 	"librecash/rabbit"
@@ -184,8 +185,9 @@ func sendInitiatorNotification(c *context.Context, exchange *objects.Exchange, i
 
 	// Send via RabbitMQ
 	messageBag := rabbit.MessageBag{
-		Message:  msg,
-		Priority: 100, // Normal priority
+		Message:        msg,
+		Priority:       rabbit.PriorityNotification, // Normal priority
+		IdempotencyKey: rabbit.IdempotencyKey("contact_request", exchange.ID, requester.UserId),
 	}
 
 	err := c.RabbitPublish.PublishTgMessage(messageBag)
@@ -420,6 +422,14 @@ func editRecipientMessages(c *context.Context, exchange *objects.Exchange, timel
 			continue
 		}
 
+		// Offers sent in a digest share its message, which is refreshed without them
+		if record.DigestID != nil {
+			if err := fanout.NewFanoutService(c).RefreshDigest(*record.DigestID); err != nil {
+				log.Printf("[DELETE_EXCHANGE] Failed to refresh digest %d: %v", *record.DigestID, err)
+			}
+			continue
+		}
+
 		// Skip if no telegram message ID
 		if record.TelegramMessageID == nil {
 			log.Printf("[DELETE_EXCHANGE] No telegram message ID for recipient %d, skipping", record.RecipientUserID)
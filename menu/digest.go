@@ -0,0 +1,58 @@
+package menu
+
+import (
+	"librecash/context"
+	"librecash/fanout"
+	"librecash/objects"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// HandleDigestCallback opens an overflow summary (digest:<id>) or turns the page of its list
+// (digest:<id>:<page>): the message is edited to list the offers it summarizes that are still
+// posted, each with its contact button
+func HandleDigestCallback(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	log.Printf("[DIGEST] Processing callback: %s for user %d", callback.Data, user.UserId)
+
+	// Answer the callback to stop the loading animation
+	defer func() {
+		if err := c.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "")); err != nil {
+			log.Printf("[DIGEST] Error answering callback: %v", err)
+		}
+	}()
+
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		log.Printf("[DIGEST] Invalid callback data: %s", callback.Data)
+		return
+	}
+	digestID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		log.Printf("[DIGEST] Invalid digest ID: %s", parts[1])
+		return
+	}
+	page := 0
+	if len(parts) == 3 {
+		if page, err = strconv.Atoi(parts[2]); err != nil || page < 0 {
+			log.Printf("[DIGEST] Invalid page: %s", parts[2])
+			return
+		}
+	}
+
+	digest, err := c.Repo.GetDigest(digestID)
+	if err != nil {
+		log.Printf("[DIGEST] Error getting digest %d: %v", digestID, err)
+		return
+	}
+	if digest == nil || digest.RecipientUserID != user.UserId {
+		log.Printf("[DIGEST] Digest %d not found for user %d", digestID, user.UserId)
+		return
+	}
+
+	if err := fanout.NewFanoutService(c).ShowDigestPage(digestID, page); err != nil {
+		log.Printf("[DIGEST] Error opening digest %d: %v", digestID, err)
+	}
+}
@@ -0,0 +1,50 @@
+package menu
+
+import (
+	"fmt"
+	"librecash/objects"
+	"librecash/rabbit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestCallbackOpensOverflowSummary(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const userID, otherID = int64(1001), int64(1002)
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: 1, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 13.7563, Lon: 100.5018}))
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 13.7563, Lon: 100.5018}))
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: otherID, LanguageCode: "en", MenuId: objects.Menu_Main}))
+
+	exchange := objects.NewExchange(1, objects.ExchangeDirectionCashToCrypto, 13.7563, 100.5018)
+	require.NoError(t, repo.CreateExchange(exchange))
+	require.NoError(t, repo.PostExchange(exchange))
+	record := &objects.TimelineRecord{ExchangeID: exchange.ID, RecipientUserID: userID, Status: objects.TimelineStatusOverflow}
+	require.NoError(t, repo.CreateDeferredTimelineRecord(record))
+	digest, err := repo.CreateDigest(userID, []int64{record.ID})
+	require.NoError(t, err)
+	messageID := 7
+	require.NoError(t, repo.RecordDigestDelivery(digest.ID, &messageID, objects.TimelineStatusSent))
+
+	// Someone else's summary is not opened
+	HandleCallback(ctx, otherID, callbackFrom(otherID, fmt.Sprintf("digest:%d", digest.ID)))
+	_, ok := transport.Receive(rabbit.QueueEdits, 10*time.Millisecond)
+	assert.False(t, ok)
+
+	// Invalid pages are ignored
+	HandleCallback(ctx, userID, callbackFrom(userID, fmt.Sprintf("digest:%d:x", digest.ID)))
+	_, ok = transport.Receive(rabbit.QueueEdits, 10*time.Millisecond)
+	assert.False(t, ok)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, fmt.Sprintf("digest:%d", digest.ID)))
+	edit := nextEdit(t, transport)
+	assert.Equal(t, messageID, edit.MessageID)
+	assert.Contains(t, edit.Text, "digest.header")
+
+	keyboard := edit.ReplyMarkup
+	require.NotNil(t, keyboard)
+	require.Len(t, keyboard.InlineKeyboard, 1)
+	assert.Equal(t, fmt.Sprintf("contact:%d", exchange.ID), *keyboard.InlineKeyboard[0][0].CallbackData)
+}
@@ -4,6 +4,7 @@ import (
 	"librecash/context"
 	"librecash/metrics"
 	"librecash/objects"
+	"librecash/rabbit"
 	"log"
 	"strings"
 
@@ -80,8 +81,8 @@ func (h *HistoricalFanoutWaitMenu) showContinuationMessage(user *objects.User, c
 	msg.ReplyMarkup = keyboard
 	msg.ParseMode = "HTML"
 
-	// Send with priority 70 to ensure it comes after historical fanout messages (priority 80)
-	context.SendWithPriority(msg, 70)
+	// Send with follow-up priority to ensure it comes after historical fanout messages
+	context.SendWithPriority(msg, rabbit.PriorityFanoutFollowUp)
 }
 
 func (h *HistoricalFanoutWaitMenu) transitionToMain(user *objects.User, context *context.Context) {
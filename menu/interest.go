@@ -0,0 +1,162 @@
+package menu
+
+import (
+	"fmt"
+	"librecash/context"
+	"librecash/objects"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// interestAmounts are the amount limits offered for an interest, 0 stands for any amount
+var interestAmounts = []int{10, 25, 50, 100, 0}
+
+// ShowInterests sends the user's interests with buttons to add or clear them. It works from any
+// menu and does not change the user's state.
+func ShowInterests(user *objects.User, c *context.Context) {
+	log.Printf("[INTEREST] Showing interests to user %d", user.UserId)
+
+	text, keyboard, err := interestsView(user, c)
+	if err != nil {
+		log.Printf("[INTEREST] Error loading interests of user %d: %v", user.UserId, err)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(user.UserId, text)
+	msg.ReplyMarkup = keyboard
+	msg.ParseMode = "HTML"
+	c.Send(msg)
+}
+
+// interestsView renders the interests message: the current interests, or a note that the user
+// gets every offer, and the buttons
+func interestsView(user *objects.User, c *context.Context) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	locale := user.Locale()
+
+	interests, err := c.Repo.GetUserInterests(user.UserId)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	var text string
+	if len(interests) == 0 {
+		text = locale.Get("interest.none")
+	} else {
+		text = locale.Get("interest.list_header") + "\n"
+		for _, interest := range interests {
+			text += "\n• " + describeInterest(user, interest)
+		}
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			locale.Get("interest.want_crypto"), "interest:dir:"+objects.ExchangeDirectionCashToCrypto)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			locale.Get("interest.want_cash"), "interest:dir:"+objects.ExchangeDirectionCryptoToCash)),
+	}
+	if len(interests) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			locale.Get("interest.button_clear"), "interest:clear")))
+	}
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+// describeInterest renders an interest as one line, e.g. "Buy USDT with cash, up to $50"
+func describeInterest(user *objects.User, interest *objects.Interest) string {
+	locale := user.Locale()
+
+	description := locale.Get("interest.want_cash")
+	if interest.Direction == objects.ExchangeDirectionCashToCrypto {
+		description = locale.Get("interest.want_crypto")
+	}
+	if interest.MaxAmountUSD == nil {
+		return description + ", " + locale.Get("interest.any_amount")
+	}
+	return description + ", " + fmt.Sprintf(locale.Get("interest.up_to"), *interest.MaxAmountUSD)
+}
+
+// HandleInterestCallback processes the interest buttons:
+//   - interest:dir:DIRECTION asks for the amount limit
+//   - interest:set:DIRECTION:AMOUNT saves the interest, amount 0 is any amount
+//   - interest:clear removes all interests
+func HandleInterestCallback(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	log.Printf("[INTEREST] Processing callback: %s for user %d", callback.Data, user.UserId)
+	locale := user.Locale()
+	answer := ""
+
+	parts := strings.Split(callback.Data, ":")
+	switch {
+	case len(parts) == 3 && parts[1] == "dir" && isExchangeDirection(parts[2]):
+		var buttons []tgbotapi.InlineKeyboardButton
+		for _, amount := range interestAmounts {
+			label := locale.Get("interest.any_amount")
+			if amount > 0 {
+				label = fmt.Sprintf("$%d", amount)
+			}
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+				label, fmt.Sprintf("interest:set:%s:%d", parts[2], amount)))
+		}
+
+		editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID, locale.Get("interest.ask_amount"))
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons[:len(buttons)-1], buttons[len(buttons)-1:])
+		editMsg.ReplyMarkup = &keyboard
+		editMsg.ParseMode = "HTML"
+		c.EditMessage(editMsg)
+
+	case len(parts) == 4 && parts[1] == "set" && isExchangeDirection(parts[2]):
+		amount, err := strconv.Atoi(parts[3])
+		if err != nil || amount < 0 {
+			log.Printf("[INTEREST] Invalid amount: %s", parts[3])
+			break
+		}
+
+		interest := &objects.Interest{UserID: user.UserId, Direction: parts[2], Asset: objects.AssetUSDT}
+		if amount > 0 {
+			interest.MaxAmountUSD = &amount
+		}
+		if err := c.Repo.SaveInterest(interest); err != nil {
+			log.Printf("[INTEREST] Error saving interest of user %d: %v", user.UserId, err)
+			break
+		}
+		answer = locale.Get("interest.saved")
+		editInterestsView(c, callback, user)
+
+	case len(parts) == 2 && parts[1] == "clear":
+		if err := c.Repo.DeleteUserInterests(user.UserId); err != nil {
+			log.Printf("[INTEREST] Error clearing interests of user %d: %v", user.UserId, err)
+			break
+		}
+		answer = locale.Get("interest.cleared")
+		editInterestsView(c, callback, user)
+
+	default:
+		log.Printf("[INTEREST] Invalid callback data: %s", callback.Data)
+	}
+
+	// Answer the callback to stop the loading animation
+	callbackAnswer := tgbotapi.NewCallback(callback.ID, answer)
+	if err := c.AnswerCallbackQuery(callbackAnswer); err != nil {
+		log.Printf("[INTEREST] Error answering callback: %v", err)
+	}
+}
+
+// editInterestsView replaces the message of the pressed button with the current interests
+func editInterestsView(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	text, keyboard, err := interestsView(user, c)
+	if err != nil {
+		log.Printf("[INTEREST] Error loading interests of user %d: %v", user.UserId, err)
+		return
+	}
+
+	editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID, text)
+	editMsg.ReplyMarkup = &keyboard
+	editMsg.ParseMode = "HTML"
+	c.EditMessage(editMsg)
+}
+
+func isExchangeDirection(direction string) bool {
+	return direction == objects.ExchangeDirectionCashToCrypto || direction == objects.ExchangeDirectionCryptoToCash
+}
@@ -0,0 +1,51 @@
+package menu
+
+import (
+	"librecash/objects"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterestCommandAndCallbacks(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const userID = int64(1001)
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Amount}))
+
+	// /interest works from any menu and keeps the state
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "/Interest", From: &tgbotapi.User{ID: int(userID)}})
+	messages := drainMessages(t, transport)
+	require.Len(t, messages, 1)
+	keyboard, ok := messages[0].ReplyMarkup.(map[string]interface{})
+	require.True(t, ok)
+	assert.Len(t, keyboard["inline_keyboard"], 2, "No clear button without interests")
+	assert.Equal(t, objects.Menu_Amount, repo.FindUser(userID).MenuId)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "interest:dir:cash_to_crypto"))
+	HandleCallback(ctx, userID, callbackFrom(userID, "interest:set:cash_to_crypto:50"))
+	HandleCallback(ctx, userID, callbackFrom(userID, "interest:set:crypto_to_cash:0"))
+	HandleCallback(ctx, userID, callbackFrom(userID, "interest:set:cash_to_crypto:25"))
+
+	interests, err := repo.GetUserInterests(userID)
+	require.NoError(t, err)
+	require.Len(t, interests, 2, "Saving the same direction again replaces the interest")
+	assert.Equal(t, objects.ExchangeDirectionCashToCrypto, interests[0].Direction)
+	assert.Equal(t, objects.AssetUSDT, interests[0].Asset)
+	if assert.NotNil(t, interests[0].MaxAmountUSD) {
+		assert.Equal(t, 25, *interests[0].MaxAmountUSD)
+	}
+	assert.Nil(t, interests[1].MaxAmountUSD, "0 stands for any amount")
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "interest:set:sideways:10"))
+	interests, err = repo.GetUserInterests(userID)
+	require.NoError(t, err)
+	assert.Len(t, interests, 2, "Invalid directions are ignored")
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "interest:clear"))
+	interests, err = repo.GetUserInterests(userID)
+	require.NoError(t, err)
+	assert.Empty(t, interests)
+	assert.Equal(t, objects.Menu_Amount, repo.FindUser(userID).MenuId)
+}
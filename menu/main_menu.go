@@ -2,6 +2,7 @@ package menu
 
 import (
 	"librecash/context"
+	"librecash/fanout"
 	"librecash/metrics"
 	"librecash/objects"
 	"log"
@@ -45,6 +46,11 @@ func (handler *MainMenuHandler) Handle() {
 	msg.ParseMode = "HTML"
 
 	handler.context.Send(msg)
+
+	// Offers posted while the user was busy in another menu
+	if _, err := fanout.NewFanoutService(handler.context).DeliverDeferredNotifications(handler.user); err != nil {
+		log.Printf("[MAIN_MENU] Error delivering deferred notifications to user %d: %v", handler.user.UserId, err)
+	}
 }
 
 // HandleCallback processes inline button callbacks for main menu
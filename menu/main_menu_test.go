@@ -38,7 +38,7 @@ func TestMainMenuHandler_LocalizationSupport(t *testing.T) {
 */
 
 // Helper function to setup test repository
-func setupTestRepository(t *testing.T) (*repository.Repository, func()) {
+func setupTestRepository(t *testing.T) (repository.Repository, func()) {
 	// Skip if database is not available
 	t.Skip("Database tests require PostgreSQL connection")
 	return nil, func() {}
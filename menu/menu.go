@@ -15,6 +15,39 @@ type Handler interface {
 	Handle(user *objects.User, context *context.Context, message *tgbotapi.Message)
 }
 
+// HandleUpdate routes an incoming Telegram update to the message or callback handler
+func HandleUpdate(context *context.Context, update tgbotapi.Update) {
+	// Handle regular messages
+	if update.Message != nil {
+		// Ignore messages from chats (not from users)
+		if update.Message.From == nil {
+			log.Println("[MENU] Ignoring message without From field (probably from a channel)")
+			return
+		}
+
+		userId := update.Message.Chat.ID
+		startTime := time.Now()
+
+		log.Printf("[MENU] Received message from user %d (@%s): %s",
+			userId, update.Message.From.UserName, update.Message.Text)
+
+		// Handle the message
+		HandleMessage(context, userId, update.Message)
+
+		duration := time.Since(startTime)
+		log.Printf("[MENU] Message processing completed for user %d (total duration: %v)", userId, duration)
+	}
+
+	// Handle callback queries (inline button presses)
+	if update.CallbackQuery != nil {
+		userId := int64(update.CallbackQuery.From.ID)
+		log.Printf("[MENU] Received callback from user %d: %s", userId, update.CallbackQuery.Data)
+
+		// Handle the callback
+		HandleCallback(context, userId, update.CallbackQuery)
+	}
+}
+
 func HandleMessage(context *context.Context, userId int64, message *tgbotapi.Message) {
 	startTime := time.Now()
 	log.Printf("[MENU] Handling message from user %d: '%s'", userId, message.Text)
@@ -104,6 +137,16 @@ func HandleMessage(context *context.Context, userId int64, message *tgbotapi.Mes
 			message.Text = ""
 			context.Repo.SaveUser(user)
 
+			// User is talking to us again, so fanout can reach them
+			if user.Unreachable {
+				log.Printf("[MENU] Clearing unreachable flag for user %d", userId)
+				if err := context.Repo.SetUserUnreachable(userId, false); err != nil {
+					log.Printf("[MENU] Error clearing unreachable flag for user %d: %v", userId, err)
+				} else {
+					user.Unreachable = false
+				}
+			}
+
 			// Record menu transition metric
 			metrics.RecordMenuTransition(oldMenuId, user.MenuId, user.GetSupportedLanguageCode())
 
@@ -148,6 +191,62 @@ func HandleMessage(context *context.Context, userId int64, message *tgbotapi.Mes
 			return
 		}
 
+		// Handle /interest command
+		if strings.ToLower(message.Text) == "/interest" {
+			log.Printf("[MENU] User %d sent /interest command", userId)
+
+			userType := "returning"
+			if isNewUser {
+				userType = "new"
+			}
+			metrics.RecordCommand("/interest", user.GetSupportedLanguageCode(), userType)
+
+			ShowInterests(user, context)
+			return
+		}
+
+		// Handle /alerts command
+		if strings.ToLower(message.Text) == "/alerts" {
+			log.Printf("[MENU] User %d sent /alerts command", userId)
+
+			userType := "returning"
+			if isNewUser {
+				userType = "new"
+			}
+			metrics.RecordCommand("/alerts", user.GetSupportedLanguageCode(), userType)
+
+			ShowAlerts(user, context)
+			return
+		}
+
+		// Handle /settings command
+		if strings.ToLower(message.Text) == "/settings" {
+			log.Printf("[MENU] User %d sent /settings command", userId)
+
+			userType := "returning"
+			if isNewUser {
+				userType = "new"
+			}
+			metrics.RecordCommand("/settings", user.GetSupportedLanguageCode(), userType)
+
+			ShowSettings(user, context)
+			return
+		}
+
+		// Handle /offers command
+		if strings.ToLower(message.Text) == "/offers" {
+			log.Printf("[MENU] User %d sent /offers command", userId)
+
+			userType := "returning"
+			if isNewUser {
+				userType = "new"
+			}
+			metrics.RecordCommand("/offers", user.GetSupportedLanguageCode(), userType)
+
+			ShowOffers(user, context)
+			return
+		}
+
 		// Handle /exchange command
 		if message.Text == "/exchange" {
 			log.Printf("[MENU] User %d sent /exchange command", userId)
@@ -207,6 +306,10 @@ func HandleMessage(context *context.Context, userId int64, message *tgbotapi.Mes
 			handler = NewHistoricalFanoutExecuteMenu()
 		case objects.Menu_HistoricalFanoutWait:
 			handler = NewHistoricalFanoutWaitMenu()
+		case objects.Menu_AlertName:
+			handler = NewAlertNameMenu()
+		case objects.Menu_AlertLocation:
+			handler = NewAlertLocationMenu()
 		case objects.Menu_Main:
 			// Show main menu
 			log.Printf("[MENU] Showing main menu to user %d", userId)
@@ -292,6 +395,30 @@ func HandleCallback(context *context.Context, userId int64, callback *tgbotapi.C
 		return
 	}
 
+	// Interests can be edited from any menu as well
+	if strings.HasPrefix(callback.Data, "interest:") {
+		HandleInterestCallback(context, callback, user)
+		return
+	}
+
+	// So can alerts
+	if strings.HasPrefix(callback.Data, "alert:") {
+		HandleAlertCallback(context, callback, user)
+		return
+	}
+
+	// And notification settings
+	if strings.HasPrefix(callback.Data, "settings:") {
+		HandleSettingsCallback(context, callback, user)
+		return
+	}
+
+	// And browsing offers
+	if strings.HasPrefix(callback.Data, "offers:") {
+		HandleOffersCallback(context, callback, user)
+		return
+	}
+
 	// Route to appropriate handler based on menu state and callback data
 	if user.MenuId == objects.Menu_USComplianceCheck {
 		handler := NewUSComplianceMenu()
@@ -337,6 +464,9 @@ func HandleCallback(context *context.Context, userId int64, callback *tgbotapi.C
 	} else if strings.HasPrefix(callback.Data, "contact:") {
 		// Handle contact request callbacks
 		HandleContactRequestCallback(context, callback, user)
+	} else if strings.HasPrefix(callback.Data, "digest:") {
+		// Handle opening an overflow summary
+		HandleDigestCallback(context, callback, user)
 	} else if strings.HasPrefix(callback.Data, "delete:") {
 		// Handle delete exchange callbacks
 		HandleDeleteExchangeCallback(context, callback, user)
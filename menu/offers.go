@@ -0,0 +1,222 @@
+package menu
+
+import (
+	"fmt"
+	"librecash/context"
+	"librecash/fanout"
+	"librecash/objects"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// offersPageSize is the number of offers listed on one page of /offers
+const offersPageSize = 8
+
+// offersAnyDirection stands for both directions in the offers callback data
+const offersAnyDirection = "any"
+
+// offersView is what the offers message shows: the filters, the sort order and the page
+type offersView struct {
+	direction string // what the user wants to do, empty for both directions
+	maxAmount int    // 0 for any amount
+	sort      string
+	page      int
+}
+
+// callbackData encodes the view as offers:DIRECTION:AMOUNT:SORT:PAGE
+func (v offersView) callbackData() string {
+	direction := v.direction
+	if direction == "" {
+		direction = offersAnyDirection
+	}
+	return fmt.Sprintf("offers:%s:%d:%s:%d", direction, v.maxAmount, v.sort, v.page)
+}
+
+// parseOffersView decodes the callback data of the offers buttons
+func parseOffersView(data string) (offersView, bool) {
+	parts := strings.Split(data, ":")
+	if len(parts) != 5 || parts[0] != "offers" {
+		return offersView{}, false
+	}
+
+	view := offersView{direction: parts[1], sort: parts[3]}
+	if view.direction == offersAnyDirection {
+		view.direction = ""
+	} else if !isExchangeDirection(view.direction) {
+		return offersView{}, false
+	}
+	if view.sort != objects.OfferSortDistance && view.sort != objects.OfferSortRecent {
+		return offersView{}, false
+	}
+
+	var err error
+	if view.maxAmount, err = strconv.Atoi(parts[2]); err != nil || view.maxAmount < 0 {
+		return offersView{}, false
+	}
+	if view.page, err = strconv.Atoi(parts[4]); err != nil || view.page < 0 {
+		return offersView{}, false
+	}
+	return view, true
+}
+
+// ShowOffers sends the first page of the live offers around the user's location within their
+// radius, nearest first, with buttons to filter, sort and page through them. It works from any
+// menu and does not change the user's state.
+func ShowOffers(user *objects.User, c *context.Context) {
+	log.Printf("[OFFERS] Showing offers to user %d", user.UserId)
+
+	if user.Lat == 0 || user.Lon == 0 || user.SearchRadiusKm == nil {
+		c.Send(tgbotapi.NewMessage(user.UserId, user.Locale().Get("exchange_command.not_initialized")))
+		return
+	}
+
+	text, keyboard, err := offersMessage(user, c, offersView{sort: objects.OfferSortDistance})
+	if err != nil {
+		log.Printf("[OFFERS] Error finding offers for user %d: %v", user.UserId, err)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(user.UserId, text)
+	msg.ReplyMarkup = keyboard
+	msg.ParseMode = "HTML"
+	c.Send(msg)
+}
+
+// offersMessage renders a page of offers: the list with a contact button per offer, a button
+// to cycle each filter, one to switch the sort order, and the page buttons
+func offersMessage(user *objects.User, c *context.Context, view offersView) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	locale := user.Locale()
+
+	query := &objects.OfferQuery{
+		Lat:           user.Lat,
+		Lon:           user.Lon,
+		RadiusKm:      *user.SearchRadiusKm,
+		ExcludeUserID: user.UserId,
+		Direction:     view.direction,
+		Sort:          view.sort,
+		Offset:        view.page * offersPageSize,
+		Limit:         offersPageSize + 1, // one more tells whether there is a next page
+	}
+	if view.maxAmount > 0 {
+		query.MaxAmountUSD = &view.maxAmount
+	}
+	exchanges, err := c.Repo.FindOffers(query)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	hasNext := len(exchanges) > offersPageSize
+	if hasNext {
+		exchanges = exchanges[:offersPageSize]
+	}
+
+	text := fmt.Sprintf(locale.Get("offers.header"), *user.SearchRadiusKm)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(exchanges) == 0 {
+		text += "\n\n" + locale.Get("offers.none")
+	} else {
+		lines, contactRows := fanout.NewFanoutService(c).OfferList(user, exchanges, query.Offset+1)
+		text += "\n" + lines
+		rows = append(rows, contactRows...)
+	}
+
+	// Changing a filter or the sort order starts over at the first page
+	directionView, amountView, sortView := view, view, view
+	directionView.page, amountView.page, sortView.page = 0, 0, 0
+
+	directionLabel := locale.Get("offers.all_directions")
+	switch view.direction {
+	case "":
+		directionView.direction = objects.ExchangeDirectionCashToCrypto
+	case objects.ExchangeDirectionCashToCrypto:
+		directionLabel = locale.Get("interest.want_crypto")
+		directionView.direction = objects.ExchangeDirectionCryptoToCash
+	default:
+		directionLabel = locale.Get("interest.want_cash")
+		directionView.direction = ""
+	}
+
+	amountLabel := locale.Get("offers.any_amount")
+	if view.maxAmount > 0 {
+		amountLabel = fmt.Sprintf(locale.Get("offers.up_to"), view.maxAmount)
+	}
+	amountView.maxAmount = nextInterestAmount(view.maxAmount)
+
+	sortLabel := locale.Get("offers.sort_distance")
+	sortView.sort = objects.OfferSortRecent
+	if view.sort == objects.OfferSortRecent {
+		sortLabel = locale.Get("offers.sort_recent")
+		sortView.sort = objects.OfferSortDistance
+	}
+
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(directionLabel, directionView.callbackData())),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(amountLabel, amountView.callbackData()),
+			tgbotapi.NewInlineKeyboardButtonData(sortLabel, sortView.callbackData()),
+		),
+	)
+
+	var pageButtons []tgbotapi.InlineKeyboardButton
+	if view.page > 0 {
+		previous := view
+		previous.page--
+		pageButtons = append(pageButtons, tgbotapi.NewInlineKeyboardButtonData("◀️", previous.callbackData()))
+	}
+	if hasNext {
+		next := view
+		next.page++
+		pageButtons = append(pageButtons, tgbotapi.NewInlineKeyboardButtonData("▶️", next.callbackData()))
+	}
+	if len(pageButtons) > 0 {
+		rows = append(rows, pageButtons)
+	}
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+// nextInterestAmount returns the amount limit after the given one in interestAmounts, any amount
+// is followed by the smallest limit
+func nextInterestAmount(amount int) int {
+	for i, option := range interestAmounts {
+		if option == amount {
+			return interestAmounts[(i+1)%len(interestAmounts)]
+		}
+	}
+	return 0
+}
+
+// HandleOffersCallback handles the buttons of the offers message, the callback data is the view
+// to show: offers:DIRECTION:AMOUNT:SORT:PAGE
+func HandleOffersCallback(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	log.Printf("[OFFERS] Processing callback: %s for user %d", callback.Data, user.UserId)
+	answer := ""
+
+	view, ok := parseOffersView(callback.Data)
+	switch {
+	case !ok:
+		log.Printf("[OFFERS] Invalid callback data: %s", callback.Data)
+
+	case user.Lat == 0 || user.Lon == 0 || user.SearchRadiusKm == nil:
+		answer = user.Locale().Get("exchange_command.not_initialized")
+
+	default:
+		text, keyboard, err := offersMessage(user, c, view)
+		if err != nil {
+			log.Printf("[OFFERS] Error finding offers for user %d: %v", user.UserId, err)
+			break
+		}
+		editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID, text)
+		editMsg.ReplyMarkup = &keyboard
+		editMsg.ParseMode = "HTML"
+		c.EditMessage(editMsg)
+	}
+
+	// Answer the callback to stop the loading animation
+	callbackAnswer := tgbotapi.NewCallback(callback.ID, answer)
+	if err := c.AnswerCallbackQuery(callbackAnswer); err != nil {
+		log.Printf("[OFFERS] Error answering callback: %v", err)
+	}
+}
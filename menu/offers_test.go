@@ -0,0 +1,138 @@
+package menu
+
+import (
+	"encoding/json"
+	"fmt"
+	"librecash/objects"
+	"librecash/rabbit"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEdit returns the next message edit queued on the edits queue
+func nextEdit(t *testing.T, transport *rabbit.MemoryTransport) tgbotapi.EditMessageTextConfig {
+	delivery, ok := transport.Receive(rabbit.QueueEdits, time.Second)
+	require.True(t, ok, "An edit is queued")
+	var bag rabbit.EditMessageBag
+	require.NoError(t, json.Unmarshal(delivery.Body, &bag))
+	return bag.EditMessage
+}
+
+// inlineKeyboard decodes the reply markup of a queued message
+func inlineKeyboard(t *testing.T, markup interface{}) tgbotapi.InlineKeyboardMarkup {
+	data, err := json.Marshal(markup)
+	require.NoError(t, err)
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	require.NoError(t, json.Unmarshal(data, &keyboard))
+	return keyboard
+}
+
+// contactButtons returns the exchange IDs of the contact buttons, in order
+func contactButtons(keyboard tgbotapi.InlineKeyboardMarkup) []string {
+	var contacts []string
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData == nil {
+				continue
+			}
+			if exchangeID, ok := strings.CutPrefix(*button.CallbackData, "contact:"); ok {
+				contacts = append(contacts, exchangeID)
+			}
+		}
+	}
+	return contacts
+}
+
+// buttonData returns the callback data of the button with the given label
+func buttonData(t *testing.T, keyboard tgbotapi.InlineKeyboardMarkup, label string) string {
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == label && button.CallbackData != nil {
+				return *button.CallbackData
+			}
+		}
+	}
+	require.Failf(t, "Button not found", "no %q button", label)
+	return ""
+}
+
+func TestOffersCommandPagesFiltersAndSorts(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const userID = int64(1001)
+	radius := 10
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Amount,
+		Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &radius}))
+
+	// Ten offers 0.1 to 1 km north, the farther ones posted first, and one out of the radius
+	var exchangeIDs []string
+	for i := 0; i < 10; i++ {
+		direction := objects.ExchangeDirectionCashToCrypto
+		if i%2 == 1 {
+			direction = objects.ExchangeDirectionCryptoToCash
+		}
+		exchange := objects.NewExchange(int64(2000+i), direction, 13.7563+0.0009*float64(i+1), 100.5018)
+		exchange.CreatedAt = time.Now().Add(-time.Duration(10-i) * time.Minute)
+		amount := 10 * (i + 1)
+		exchange.AmountUSD = &amount
+		require.NoError(t, repo.CreateExchange(exchange))
+		require.NoError(t, repo.PostExchange(exchange))
+		exchangeIDs = append(exchangeIDs, fmt.Sprint(exchange.ID))
+	}
+	far := objects.NewExchange(3000, objects.ExchangeDirectionCashToCrypto, 14.5, 100.5018)
+	require.NoError(t, repo.CreateExchange(far))
+	require.NoError(t, repo.PostExchange(far))
+
+	// /offers works from any menu and keeps the state
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "/offers", From: &tgbotapi.User{ID: int(userID)}})
+	messages := drainMessages(t, transport)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].Text, "offers.header")
+	assert.Equal(t, objects.Menu_Amount, repo.FindUser(userID).MenuId)
+	keyboard := inlineKeyboard(t, messages[0].ReplyMarkup)
+	assert.Equal(t, exchangeIDs[:offersPageSize], contactButtons(keyboard), "Nearest first")
+
+	HandleCallback(ctx, userID, callbackFrom(userID, buttonData(t, keyboard, "▶️")))
+	edit := nextEdit(t, transport)
+	assert.Equal(t, exchangeIDs[offersPageSize:], contactButtons(*edit.ReplyMarkup), "The rest on the next page")
+	buttonData(t, *edit.ReplyMarkup, "◀️")
+
+	// Sorting by recency starts over at the first page, newest first
+	HandleCallback(ctx, userID, callbackFrom(userID, buttonData(t, *edit.ReplyMarkup, "offers.sort_distance")))
+	edit = nextEdit(t, transport)
+	contacts := contactButtons(*edit.ReplyMarkup)
+	require.Len(t, contacts, offersPageSize)
+	assert.Equal(t, exchangeIDs[9], contacts[0])
+
+	// Only offers of users who sell USDT for cash, i.e. who want cash for crypto
+	HandleCallback(ctx, userID, callbackFrom(userID, buttonData(t, *edit.ReplyMarkup, "offers.all_directions")))
+	edit = nextEdit(t, transport)
+	assert.Equal(t, []string{exchangeIDs[9], exchangeIDs[7], exchangeIDs[5], exchangeIDs[3], exchangeIDs[1]},
+		contactButtons(*edit.ReplyMarkup))
+
+	// Up to $10: only the first offer has that amount, and it is in the other direction
+	HandleCallback(ctx, userID, callbackFrom(userID, buttonData(t, *edit.ReplyMarkup, "offers.any_amount")))
+	edit = nextEdit(t, transport)
+	assert.Empty(t, contactButtons(*edit.ReplyMarkup))
+	assert.Contains(t, edit.Text, "offers.none")
+
+	// Invalid data is ignored
+	HandleCallback(ctx, userID, callbackFrom(userID, "offers:sideways:0:distance:0"))
+	_, ok := transport.Receive(rabbit.QueueEdits, 10*time.Millisecond)
+	assert.False(t, ok)
+}
+
+func TestOffersCommandNeedsLocation(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const userID = int64(1001)
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main}))
+
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "/offers", From: &tgbotapi.User{ID: int(userID)}})
+	messages := drainMessages(t, transport)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "exchange_command.not_initialized", messages[0].Text)
+}
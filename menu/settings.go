@@ -0,0 +1,221 @@
+package menu
+
+import (
+	"fmt"
+	"librecash/context"
+	"librecash/geo"
+	"librecash/objects"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// quietHourPresets are the quiet hours offered in the settings, start and end hour in local time
+var quietHourPresets = [][2]int{{22, 8}, {23, 7}, {0, 8}}
+
+// pauseDays are the pause durations offered in the settings
+var pauseDays = []int{1, 3, 7}
+
+// ShowSettings sends the user's notification settings with buttons to change them. It works
+// from any menu and does not change the user's state.
+func ShowSettings(user *objects.User, c *context.Context) {
+	log.Printf("[SETTINGS] Showing settings to user %d", user.UserId)
+
+	text, keyboard, err := settingsView(user, c)
+	if err != nil {
+		log.Printf("[SETTINGS] Error loading settings of user %d: %v", user.UserId, err)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(user.UserId, text)
+	msg.ReplyMarkup = keyboard
+	msg.ParseMode = "HTML"
+	c.Send(msg)
+}
+
+// settingsView renders the settings message: quiet hours, pause and delivery mode, with a
+// button to change each of them
+func settingsView(user *objects.User, c *context.Context) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	locale := user.Locale()
+
+	settings, err := c.Repo.GetNotificationSettings(user.UserId)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	location := geo.TimezoneAt(user.Lat, user.Lon)
+
+	quietHours := locale.Get("settings.quiet_hours_off")
+	if settings.QuietStartHour != nil && settings.QuietEndHour != nil {
+		quietHours = fmt.Sprintf(locale.Get("settings.quiet_hours"), fmt.Sprintf("%s (%s)",
+			formatQuietHours(*settings.QuietStartHour, *settings.QuietEndHour), location))
+	}
+	text := locale.Get("settings.header") + "\n\n" + quietHours
+
+	pauseButton := tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.button_pause"), "settings:pause")
+	if settings.IsPaused(time.Now()) {
+		text += "\n" + fmt.Sprintf(locale.Get("settings.paused_until"), settings.PausedUntil.In(location).Format("2006-01-02 15:04"))
+		pauseButton = tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.button_resume"), "settings:pause:0")
+	} else {
+		text += "\n" + locale.Get("settings.notifications_on")
+	}
+
+	deliveryButton := tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.button_digest"), "settings:delivery:"+objects.DeliveryDigest)
+	if settings.Delivery == objects.DeliveryDigest {
+		text += "\n" + locale.Get("settings.delivery_digest")
+		deliveryButton = tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.button_instant"), "settings:delivery:"+objects.DeliveryInstant)
+	} else {
+		text += "\n" + locale.Get("settings.delivery_instant")
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.button_quiet"), "settings:quiet")),
+		tgbotapi.NewInlineKeyboardRow(pauseButton),
+		tgbotapi.NewInlineKeyboardRow(deliveryButton),
+	)
+	return text, keyboard, nil
+}
+
+// formatQuietHours renders quiet hours as e.g. "22:00–08:00"
+func formatQuietHours(start, end int) string {
+	return fmt.Sprintf("%02d:00–%02d:00", start, end)
+}
+
+// HandleSettingsCallback handles the buttons of the settings message:
+// settings:quiet and settings:quiet:<start>:<end>|off choose the quiet hours,
+// settings:pause and settings:pause:<days> pause notifications (0 resumes them),
+// settings:delivery:<mode> switches between instant and digest delivery
+func HandleSettingsCallback(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	log.Printf("[SETTINGS] Processing callback: %s for user %d", callback.Data, user.UserId)
+	locale := user.Locale()
+	answer := ""
+
+	parts := strings.Split(callback.Data, ":")
+	switch {
+	case len(parts) == 2 && parts[1] == "quiet":
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for _, preset := range quietHourPresets {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				formatQuietHours(preset[0], preset[1]), fmt.Sprintf("settings:quiet:%d:%d", preset[0], preset[1]))))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			locale.Get("settings.button_quiet_off"), "settings:quiet:off")))
+
+		text := fmt.Sprintf(locale.Get("settings.ask_quiet"), geo.TimezoneAt(user.Lat, user.Lon))
+		editSettingsMessage(c, callback, user, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+
+	case len(parts) == 3 && parts[1] == "quiet" && parts[2] == "off":
+		if updateSettings(c, user, func(settings *objects.NotificationSettings) {
+			settings.QuietStartHour, settings.QuietEndHour = nil, nil
+		}) {
+			answer = locale.Get("settings.saved")
+			editSettingsView(c, callback, user)
+		}
+
+	case len(parts) == 4 && parts[1] == "quiet":
+		start, startErr := strconv.Atoi(parts[2])
+		end, endErr := strconv.Atoi(parts[3])
+		if startErr != nil || endErr != nil || !isHour(start) || !isHour(end) || start == end {
+			log.Printf("[SETTINGS] Invalid quiet hours: %s", callback.Data)
+			break
+		}
+		if updateSettings(c, user, func(settings *objects.NotificationSettings) {
+			settings.QuietStartHour, settings.QuietEndHour = &start, &end
+		}) {
+			answer = locale.Get("settings.saved")
+			editSettingsView(c, callback, user)
+		}
+
+	case len(parts) == 2 && parts[1] == "pause":
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.pause_1_day"), "settings:pause:1"),
+			tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.pause_3_days"), "settings:pause:3"),
+			tgbotapi.NewInlineKeyboardButtonData(locale.Get("settings.pause_7_days"), "settings:pause:7"),
+		))
+		editSettingsMessage(c, callback, user, locale.Get("settings.ask_pause"), keyboard)
+
+	case len(parts) == 3 && parts[1] == "pause":
+		days, err := strconv.Atoi(parts[2])
+		if err != nil || days != 0 && !isPauseDays(days) {
+			log.Printf("[SETTINGS] Invalid pause: %s", parts[2])
+			break
+		}
+		if updateSettings(c, user, func(settings *objects.NotificationSettings) {
+			settings.PausedUntil = nil
+			if days > 0 {
+				until := time.Now().UTC().AddDate(0, 0, days)
+				settings.PausedUntil = &until
+			}
+		}) {
+			log.Printf("[SETTINGS] User %d paused notifications for %d day(s)", user.UserId, days)
+			answer = locale.Get("settings.saved")
+			editSettingsView(c, callback, user)
+		}
+
+	case len(parts) == 3 && parts[1] == "delivery" &&
+		(parts[2] == objects.DeliveryInstant || parts[2] == objects.DeliveryDigest):
+		if updateSettings(c, user, func(settings *objects.NotificationSettings) { settings.Delivery = parts[2] }) {
+			answer = locale.Get("settings.saved")
+			editSettingsView(c, callback, user)
+		}
+
+	default:
+		log.Printf("[SETTINGS] Invalid callback data: %s", callback.Data)
+	}
+
+	// Answer the callback to stop the loading animation
+	callbackAnswer := tgbotapi.NewCallback(callback.ID, answer)
+	if err := c.AnswerCallbackQuery(callbackAnswer); err != nil {
+		log.Printf("[SETTINGS] Error answering callback: %v", err)
+	}
+}
+
+// updateSettings applies the change to the user's notification settings and saves them, it
+// reports whether they were saved
+func updateSettings(c *context.Context, user *objects.User, change func(settings *objects.NotificationSettings)) bool {
+	settings, err := c.Repo.GetNotificationSettings(user.UserId)
+	if err != nil {
+		log.Printf("[SETTINGS] Error loading settings of user %d: %v", user.UserId, err)
+		return false
+	}
+
+	change(settings)
+	if err := c.Repo.SaveNotificationSettings(settings); err != nil {
+		log.Printf("[SETTINGS] Error saving settings of user %d: %v", user.UserId, err)
+		return false
+	}
+	return true
+}
+
+// editSettingsView replaces the message of the pressed button with the current settings
+func editSettingsView(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User) {
+	text, keyboard, err := settingsView(user, c)
+	if err != nil {
+		log.Printf("[SETTINGS] Error loading settings of user %d: %v", user.UserId, err)
+		return
+	}
+	editSettingsMessage(c, callback, user, text, keyboard)
+}
+
+// editSettingsMessage replaces the message of the pressed button
+func editSettingsMessage(c *context.Context, callback *tgbotapi.CallbackQuery, user *objects.User, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	editMsg := tgbotapi.NewEditMessageText(user.UserId, callback.Message.MessageID, text)
+	editMsg.ReplyMarkup = &keyboard
+	editMsg.ParseMode = "HTML"
+	c.EditMessage(editMsg)
+}
+
+func isHour(hour int) bool {
+	return hour >= 0 && hour < 24
+}
+
+func isPauseDays(days int) bool {
+	for _, option := range pauseDays {
+		if option == days {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package menu
+
+import (
+	"librecash/objects"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsCommandAndCallbacks(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const userID = int64(1001)
+	radius := 10
+	require.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Amount,
+		Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &radius}))
+
+	// /settings works from any menu and keeps the state
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "/Settings", From: &tgbotapi.User{ID: int(userID)}})
+	messages := drainMessages(t, transport)
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0].Text, "settings.header")
+	assert.Contains(t, messages[0].Text, "settings.quiet_hours_off")
+	assert.Contains(t, messages[0].Text, "settings.notifications_on")
+	assert.Contains(t, messages[0].Text, "settings.delivery_instant")
+	assert.Equal(t, objects.Menu_Amount, repo.FindUser(userID).MenuId)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:quiet:22:8"))
+	settings, err := repo.GetNotificationSettings(userID)
+	require.NoError(t, err)
+	require.NotNil(t, settings.QuietStartHour)
+	assert.Equal(t, 22, *settings.QuietStartHour)
+	assert.Equal(t, 8, *settings.QuietEndHour)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:quiet:25:8"))
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:quiet:8:8"))
+	settings, err = repo.GetNotificationSettings(userID)
+	require.NoError(t, err)
+	assert.Equal(t, 22, *settings.QuietStartHour, "Invalid quiet hours are ignored")
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:quiet:off"))
+	settings, err = repo.GetNotificationSettings(userID)
+	require.NoError(t, err)
+	assert.Nil(t, settings.QuietStartHour)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:pause:2"))
+	settings, err = repo.GetNotificationSettings(userID)
+	require.NoError(t, err)
+	assert.Nil(t, settings.PausedUntil, "Only the offered durations are accepted")
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:pause:3"))
+	settings, err = repo.GetNotificationSettings(userID)
+	require.NoError(t, err)
+	require.NotNil(t, settings.PausedUntil)
+	assert.WithinDuration(t, time.Now().Add(72*time.Hour), *settings.PausedUntil, time.Minute)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:pause:0"))
+	settings, err = repo.GetNotificationSettings(userID)
+	require.NoError(t, err)
+	assert.Nil(t, settings.PausedUntil)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "settings:delivery:digest"))
+	settings, err = repo.GetNotificationSettings(userID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.DeliveryDigest, settings.Delivery)
+
+	assert.Equal(t, objects.Menu_Amount, repo.FindUser(userID).MenuId, "Settings never change the state")
+}
@@ -0,0 +1,171 @@
+package menu
+
+import (
+	"encoding/json"
+	"librecash/context"
+	"librecash/objects"
+	"librecash/rabbit"
+	"librecash/repository"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+)
+
+// Menu state machine tests running against the in-memory repository and transport, no database needed
+
+func setupMemoryContext(t *testing.T) (*context.Context, *repository.MemoryRepository, *rabbit.MemoryTransport) {
+	repo := repository.NewMemoryRepository()
+	transport := rabbit.NewMemoryTransport(100)
+	t.Cleanup(transport.Close)
+
+	return &context.Context{
+		Repo:          repo,
+		RabbitPublish: transport,
+	}, repo, transport
+}
+
+// drainMessages returns the chat messages queued on the interactive queue
+func drainMessages(t *testing.T, transport *rabbit.MemoryTransport) []tgbotapi.MessageConfig {
+	var messages []tgbotapi.MessageConfig
+	for {
+		delivery, ok := transport.Receive(rabbit.QueueInteractive, 10*time.Millisecond)
+		if !ok {
+			return messages
+		}
+		var bag rabbit.MessageBag
+		if err := json.Unmarshal(delivery.Body, &bag); err == nil && bag.Message.ChatID != 0 {
+			messages = append(messages, bag.Message)
+		}
+	}
+}
+
+func callbackFrom(userID int64, data string) *tgbotapi.CallbackQuery {
+	return &tgbotapi.CallbackQuery{
+		ID:   "callback",
+		From: &tgbotapi.User{ID: int(userID)},
+		Data: data,
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: userID},
+		},
+	}
+}
+
+func TestStateMachine_Onboarding(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const userID = int64(1001)
+
+	// /start creates the user and asks the compliance question
+	HandleMessage(ctx, userID, &tgbotapi.Message{
+		Text: "/start",
+		From: &tgbotapi.User{ID: int(userID), FirstName: "Alice", LanguageCode: "en"},
+	})
+	user := repo.FindUser(userID)
+	if assert.NotNil(t, user) {
+		assert.Equal(t, objects.Menu_USComplianceCheck, user.MenuId)
+		assert.Equal(t, "Alice", user.FirstName)
+	}
+	assert.Len(t, drainMessages(t, transport), 1, "Compliance question should be sent")
+
+	// Answering "no" runs the welcome screen and stops at radius selection
+	HandleCallback(ctx, userID, callbackFrom(userID, "us_compliance_no"))
+	assert.Equal(t, objects.Menu_SelectRadius, repo.FindUser(userID).MenuId)
+	drainMessages(t, transport)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "radius_15"))
+	user = repo.FindUser(userID)
+	assert.Equal(t, objects.Menu_AskLocation, user.MenuId)
+	if assert.NotNil(t, user.SearchRadiusKm) {
+		assert.Equal(t, 15, *user.SearchRadiusKm)
+	}
+
+	HandleMessage(ctx, userID, &tgbotapi.Message{
+		Location: &tgbotapi.Location{Latitude: 13.7563, Longitude: 100.5018},
+	})
+	user = repo.FindUser(userID)
+	assert.Equal(t, objects.Menu_AskPhone, user.MenuId)
+	assert.Equal(t, 13.7563, user.Lat)
+
+	// Skipping the phone finishes onboarding, there are no historical exchanges to show
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: user.Locale().Get("ask_phone_menu.skip_button")})
+	assert.Equal(t, objects.Menu_Main, repo.FindUser(userID).MenuId)
+}
+
+func TestStateMachine_ComplianceBlocksUser(t *testing.T) {
+	ctx, repo, _ := setupMemoryContext(t)
+	const userID = int64(1002)
+
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "/start", From: &tgbotapi.User{ID: int(userID)}})
+	HandleCallback(ctx, userID, callbackFrom(userID, "us_compliance_yes"))
+
+	assert.Equal(t, objects.Menu_Blocked, repo.FindUser(userID).MenuId)
+}
+
+func TestStateMachine_PostExchange(t *testing.T) {
+	ctx, repo, _ := setupMemoryContext(t)
+	const userID = int64(1003)
+
+	radius := 15
+	assert.NoError(t, repo.SaveUser(&objects.User{
+		UserId:         userID,
+		MenuId:         objects.Menu_Main,
+		LanguageCode:   "en",
+		Lat:            13.7563,
+		Lon:            100.5018,
+		SearchRadiusKm: &radius,
+	}))
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "main:cash_to_crypto"))
+	assert.Equal(t, objects.Menu_Amount, repo.FindUser(userID).MenuId)
+
+	HandleCallback(ctx, userID, callbackFrom(userID, "amount:100"))
+	assert.Equal(t, objects.Menu_Main, repo.FindUser(userID).MenuId)
+
+	exchange, err := repo.GetLastUserExchange(userID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, exchange) {
+		assert.Equal(t, objects.ExchangeStatusPosted, exchange.Status)
+		assert.Equal(t, objects.ExchangeDirectionCashToCrypto, exchange.ExchangeDirection)
+		assert.Equal(t, 100, *exchange.AmountUSD)
+	}
+
+	// Posting enqueues the fanout in the outbox
+	entries, err := repo.ClaimOutboxEntries(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestStateMachine_DeliversDeferredNotificationsInMainMenu(t *testing.T) {
+	ctx, repo, transport := setupMemoryContext(t)
+	const authorID, userID = int64(1004), int64(1005)
+
+	radius := 15
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: authorID, MenuId: objects.Menu_Main, LanguageCode: "en",
+		Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &radius}))
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: userID, MenuId: objects.Menu_Amount, LanguageCode: "en",
+		Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &radius}))
+
+	exchange := objects.NewExchange(authorID, objects.ExchangeDirectionCashToCrypto, 13.7563, 100.5018)
+	assert.NoError(t, repo.CreateExchange(exchange))
+	assert.NoError(t, repo.PostExchange(exchange))
+
+	// The offer came in while the user was entering an amount
+	record := objects.NewTimelineRecord(exchange.ID, userID)
+	record.Status = objects.TimelineStatusDeferred
+	assert.NoError(t, repo.CreateDeferredTimelineRecord(record))
+
+	HandleMessage(ctx, userID, &tgbotapi.Message{Text: "/exchange", From: &tgbotapi.User{ID: int(userID)}})
+	assert.Equal(t, objects.Menu_Main, repo.FindUser(userID).MenuId)
+
+	delivery, ok := transport.Receive(rabbit.QueueFanout, 10*time.Millisecond)
+	if assert.True(t, ok, "The deferred notification is queued") {
+		assert.Equal(t, exchange.ID, delivery.Headers["exchange_id"])
+		assert.Equal(t, userID, delivery.Headers["recipient_user_id"])
+	}
+
+	records, err := repo.GetDeferredTimelineRecords(userID)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
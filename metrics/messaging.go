@@ -19,3 +19,16 @@ func RecordFanoutMessage(messageType, languageCode string, success bool) {
 	counter.Inc()
 	log.Printf("[METRICS] Fanout message: type=%s, language=%s, success=%t", messageType, languageCode, success)
 }
+
+// RecordFanoutJob records how a fanout job run ended: done, failed or interrupted
+func RecordFanoutJob(outcome string) {
+	if !IsEnabled() {
+		return
+	}
+
+	// VictoriaMetrics/metrics API: include labels in metric name
+	metricName := `librecash_fanout_jobs_total{outcome="` + outcome + `"}`
+	counter := metrics.GetOrCreateCounter(metricName)
+	counter.Inc()
+	log.Printf("[METRICS] Fanout job: outcome=%s", outcome)
+}
@@ -1,11 +1,13 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/VictoriaMetrics/metrics"
 )
@@ -20,6 +22,11 @@ type Config struct {
 // Global configuration
 var config Config
 
+var (
+	serverMu sync.Mutex
+	server   *http.Server
+)
+
 // Initialize metrics system
 func Init() error {
 	// Load configuration from environment variables
@@ -61,11 +68,31 @@ func startMetricsServer() {
 	addr := fmt.Sprintf("127.0.0.1:%d", config.Port)
 	log.Printf("[METRICS] Starting metrics server on %s%s", addr, config.Path)
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	serverMu.Lock()
+	server = &http.Server{Addr: addr, Handler: mux}
+	srv := server
+	serverMu.Unlock()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Printf("[METRICS] Error starting metrics server: %v", err)
 	}
 }
 
+// Shutdown stops the metrics server after in-progress scrapes are answered, so the last
+// scrape still sees the counters of the drained work. Metrics are pulled, nothing is pushed.
+func Shutdown(ctx context.Context) error {
+	serverMu.Lock()
+	srv := server
+	server = nil
+	serverMu.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+	log.Printf("[METRICS] Stopping metrics server")
+	return srv.Shutdown(ctx)
+}
+
 // IsEnabled returns true if metrics collection is enabled
 func IsEnabled() bool {
 	return config.Enabled
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// SetUpdateQueueDepth records how many updates wait for one producer worker.
+// Called for every update, so unlike the counters it does not log.
+func SetUpdateQueueDepth(worker, depth int) {
+	if !IsEnabled() {
+		return
+	}
+
+	// VictoriaMetrics/metrics API: include labels in metric name
+	metricName := `librecash_update_queue_depth{worker="` + strconv.Itoa(worker) + `"}`
+	metrics.GetOrCreateGauge(metricName, nil).Set(float64(depth))
+}
+
+// RecordUpdateHandled records the time from receiving an update to finishing it, split into
+// waiting in the worker queue and handling
+func RecordUpdateHandled(updateType string, waited, handled time.Duration) {
+	if !IsEnabled() {
+		return
+	}
+
+	// VictoriaMetrics/metrics API: include labels in metric name
+	metrics.GetOrCreateHistogram(`librecash_update_wait_seconds{type="` + updateType + `"}`).Update(waited.Seconds())
+	metrics.GetOrCreateHistogram(`librecash_update_duration_seconds{type="` + updateType + `"}`).Update(handled.Seconds())
+}
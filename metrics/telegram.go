@@ -18,3 +18,16 @@ func RecordTelegramMessage(messageType, status, errorCode string) {
 	counter.Inc()
 	log.Printf("[METRICS] Telegram message: type=%s, status=%s, error=%s", messageType, status, errorCode)
 }
+
+// RecordUserUnreachable records a user flagged as unreachable (blocked the bot, chat gone)
+func RecordUserUnreachable(reason string) {
+	if !IsEnabled() {
+		return
+	}
+
+	// VictoriaMetrics/metrics API: include labels in metric name
+	metricName := `librecash_users_unreachable_total{reason="` + reason + `"}`
+	counter := metrics.GetOrCreateCounter(metricName)
+	counter.Inc()
+	log.Printf("[METRICS] User unreachable: reason=%s", reason)
+}
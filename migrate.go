@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"librecash/db/migrations"
+	"os"
+)
+
+// runMigrateCommand applies, rolls back or lists the versioned schema migrations.
+//
+// Usage:
+//
+//	librecash migrate up [-steps N] [-db CONN]
+//	librecash migrate down [-steps N] [-db CONN]
+//	librecash migrate status [-db CONN]
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		printMigrateUsage()
+		return 2
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dbConnStr := flags.String("db", "", "PostgreSQL connection string (default: db_conn_str from librecash.yml)")
+	defaultSteps := 0
+	if args[0] == "down" {
+		defaultSteps = 1
+	}
+	steps := flags.Int("steps", defaultSteps, "number of migrations to apply or roll back, 0 applies all pending")
+	flags.Usage = printMigrateUsage
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	db, err := openDatabase(*dbConnStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid migrations: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(*steps)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration stopped: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return 0
+	case "down":
+		if *steps <= 0 {
+			fmt.Fprintln(os.Stderr, "down needs -steps of at least 1")
+			return 2
+		}
+		reverted, err := migrator.Down(*steps)
+		for _, migration := range reverted {
+			fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Rollback stopped: %v\n", err)
+			return 1
+		}
+		if len(reverted) == 0 {
+			fmt.Println("Nothing to roll back")
+		}
+		return 0
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read migration status: %v\n", err)
+			return 1
+		}
+		pending := 0
+		for _, status := range statuses {
+			if status.Applied {
+				fmt.Printf("applied  %04d_%s  %s\n", status.Version, status.Name,
+					status.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				pending++
+				fmt.Printf("pending  %04d_%s\n", status.Version, status.Name)
+			}
+		}
+		fmt.Printf("%d pending migration(s)\n", pending)
+		if pending > 0 {
+			return 3
+		}
+		return 0
+	default:
+		printMigrateUsage()
+		return 2
+	}
+}
+
+func printMigrateUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  librecash migrate up [-steps N] [-db CONN]    Apply pending migrations (all by default)")
+	fmt.Fprintln(os.Stderr, "  librecash migrate down [-steps N] [-db CONN]  Roll back the newest migrations (1 by default)")
+	fmt.Fprintln(os.Stderr, "  librecash migrate status [-db CONN]           List migrations, exits with 3 when some are pending")
+}
@@ -0,0 +1,33 @@
+package objects
+
+import (
+	"time"
+)
+
+// Alert is a saved search: a named area with its own point and radius, e.g. "Work", where the
+// user gets offers in addition to the ones near their location. Direction and MaxAmountUSD
+// narrow it down like an interest. An alert is a draft until the user finished setting it up.
+type Alert struct {
+	ID           int64
+	UserID       int64
+	Name         string
+	Lat          float64
+	Lon          float64
+	RadiusKm     int
+	Direction    string // what the user wants to do, empty for both directions
+	MaxAmountUSD *int   // nil for any amount
+	Active       bool   // false while the alert is a draft
+	CreatedAt    time.Time
+}
+
+// MatchesFilters reports whether the exchange passes the alert's direction and amount filters,
+// the location is checked by the radius search
+func (a *Alert) MatchesFilters(exchange *Exchange) bool {
+	if a.Direction != "" && exchange.ExchangeDirection != OppositeDirection(a.Direction) {
+		return false
+	}
+	if a.MaxAmountUSD != nil && exchange.AmountUSD != nil && *exchange.AmountUSD > *a.MaxAmountUSD {
+		return false
+	}
+	return true
+}
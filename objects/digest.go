@@ -0,0 +1,16 @@
+package objects
+
+import (
+	"time"
+)
+
+// Digest is one summary message of new offers sent to a user in digest delivery mode. Its
+// offers are the timeline records with its DigestID, they all share the digest's Telegram
+// message, so deleting one of them edits the digest instead of replacing the message.
+type Digest struct {
+	ID                int64
+	RecipientUserID   int64
+	TelegramMessageID *int // nullable until the digest is sent
+	CreatedAt         time.Time
+	SentAt            *time.Time
+}
@@ -0,0 +1,29 @@
+package objects
+
+import (
+	"time"
+)
+
+// Fanout job statuses
+const (
+	FanoutJobPending = "pending" // waiting for or being run by a worker
+	FanoutJobDone    = "done"    // every recipient was handled
+)
+
+// FanoutJob notifies the recipients of a posted exchange page by page. Cursor is the last user
+// handled, a worker picking up the job after a restart continues after it.
+type FanoutJob struct {
+	ID          int64
+	ExchangeID  int64
+	Status      string
+	Cursor      RadiusCursor
+	Found       int // recipients the exchange matched so far, including paused ones
+	Queued      int // notifications confirmed by RabbitMQ
+	Sent        int // notifications delivered by the consumer
+	Failed      int // notifications Telegram rejected for good
+	Attempts    int
+	LastError   *string // error of the last failed run (nullable)
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	CompletedAt *time.Time // when the job was done (nullable)
+}
@@ -0,0 +1,57 @@
+package objects
+
+import (
+	"time"
+)
+
+// AssetUSDT is the crypto asset exchanged on this instance
+const AssetUSDT = "USDT"
+
+// Interest is a standing wish of a user, e.g. "buy USDT with cash up to 200 USD". A user with
+// interests only gets the offers matching one of them, a user without any gets every offer.
+type Interest struct {
+	ID           int64
+	UserID       int64
+	Direction    string // what the user wants to do, 'cash_to_crypto' or 'crypto_to_cash'
+	Asset        string
+	MaxAmountUSD *int // nil for any amount
+	CreatedAt    time.Time
+}
+
+// Asset returns the crypto asset of the exchange. Every exchange on this instance is in USDT.
+func (e *Exchange) Asset() string {
+	return AssetUSDT
+}
+
+// OppositeDirection returns the direction of the other side of an exchange
+func OppositeDirection(direction string) string {
+	if direction == ExchangeDirectionCashToCrypto {
+		return ExchangeDirectionCryptoToCash
+	}
+	return ExchangeDirectionCashToCrypto
+}
+
+// Matches reports whether the exchange is the other side of the interest: opposite direction,
+// same asset and an amount within the limit. An exchange without amount fits any limit.
+func (i *Interest) Matches(exchange *Exchange) bool {
+	if exchange.ExchangeDirection != OppositeDirection(i.Direction) || exchange.Asset() != i.Asset {
+		return false
+	}
+	if i.MaxAmountUSD != nil && exchange.AmountUSD != nil && *exchange.AmountUSD > *i.MaxAmountUSD {
+		return false
+	}
+	return true
+}
+
+// MatchesInterests reports whether a user with the given interests wants the exchange
+func MatchesInterests(interests []*Interest, exchange *Exchange) bool {
+	if len(interests) == 0 {
+		return true
+	}
+	for _, interest := range interests {
+		if interest.Matches(exchange) {
+			return true
+		}
+	}
+	return false
+}
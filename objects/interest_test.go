@@ -0,0 +1,53 @@
+package objects
+
+import (
+	"testing"
+)
+
+func TestInterestMatches(t *testing.T) {
+	limit, small, large := 50, 25, 100
+	exchange := func(direction string, amount *int) *Exchange {
+		return &Exchange{ExchangeDirection: direction, AmountUSD: amount}
+	}
+
+	tests := []struct {
+		name     string
+		interest Interest
+		exchange *Exchange
+		expected bool
+	}{
+		{"Opposite direction", Interest{Direction: ExchangeDirectionCashToCrypto, Asset: AssetUSDT}, exchange(ExchangeDirectionCryptoToCash, nil), true},
+		{"Same direction", Interest{Direction: ExchangeDirectionCashToCrypto, Asset: AssetUSDT}, exchange(ExchangeDirectionCashToCrypto, nil), false},
+		{"Other asset", Interest{Direction: ExchangeDirectionCryptoToCash, Asset: "BTC"}, exchange(ExchangeDirectionCashToCrypto, nil), false},
+		{"Amount within limit", Interest{Direction: ExchangeDirectionCryptoToCash, Asset: AssetUSDT, MaxAmountUSD: &limit}, exchange(ExchangeDirectionCashToCrypto, &small), true},
+		{"Amount at limit", Interest{Direction: ExchangeDirectionCryptoToCash, Asset: AssetUSDT, MaxAmountUSD: &limit}, exchange(ExchangeDirectionCashToCrypto, &limit), true},
+		{"Amount over limit", Interest{Direction: ExchangeDirectionCryptoToCash, Asset: AssetUSDT, MaxAmountUSD: &limit}, exchange(ExchangeDirectionCashToCrypto, &large), false},
+		{"Any amount", Interest{Direction: ExchangeDirectionCryptoToCash, Asset: AssetUSDT}, exchange(ExchangeDirectionCashToCrypto, &large), true},
+		{"Exchange without amount", Interest{Direction: ExchangeDirectionCryptoToCash, Asset: AssetUSDT, MaxAmountUSD: &limit}, exchange(ExchangeDirectionCashToCrypto, nil), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.interest.Matches(tt.exchange); got != tt.expected {
+				t.Errorf("Matches() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMatchesInterests(t *testing.T) {
+	exchange := &Exchange{ExchangeDirection: ExchangeDirectionCashToCrypto}
+
+	if !MatchesInterests(nil, exchange) {
+		t.Error("Users without interests should get every exchange")
+	}
+
+	sameSide := &Interest{Direction: ExchangeDirectionCashToCrypto, Asset: AssetUSDT}
+	otherSide := &Interest{Direction: ExchangeDirectionCryptoToCash, Asset: AssetUSDT}
+	if MatchesInterests([]*Interest{sameSide}, exchange) {
+		t.Error("An exchange matching no interest should be skipped")
+	}
+	if !MatchesInterests([]*Interest{sameSide, otherSide}, exchange) {
+		t.Error("One matching interest should be enough")
+	}
+}
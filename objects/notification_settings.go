@@ -0,0 +1,59 @@
+package objects
+
+import (
+	"time"
+)
+
+// Delivery modes of fanout notifications
+const (
+	DeliveryInstant = "instant" // one message per offer as soon as it is posted
+	DeliveryDigest  = "digest"  // a periodic summary of the new offers
+)
+
+// NotificationSettings are a user's fanout preferences. Quiet hours are whole hours in the
+// user's local timezone, which is derived from their location. A user without stored settings
+// gets DefaultNotificationSettings.
+type NotificationSettings struct {
+	UserID         int64
+	QuietStartHour *int       // local hour quiet hours start at, nil when off
+	QuietEndHour   *int       // local hour quiet hours end at, nil when off
+	PausedUntil    *time.Time // no notifications until then (nullable)
+	Delivery       string     // DeliveryInstant or DeliveryDigest
+	UpdatedAt      time.Time
+}
+
+// DefaultNotificationSettings returns the settings of a user who never changed them: every offer
+// right away, at any hour
+func DefaultNotificationSettings(userID int64) *NotificationSettings {
+	return &NotificationSettings{UserID: userID, Delivery: DeliveryInstant}
+}
+
+// IsPaused reports whether notifications are paused at now
+func (s *NotificationSettings) IsPaused(now time.Time) bool {
+	return s.PausedUntil != nil && now.Before(*s.PausedUntil)
+}
+
+// QuietUntil reports whether now is within the quiet hours in the given timezone, and if so
+// when they end. Quiet hours may wrap around midnight, e.g. 22 to 8.
+func (s *NotificationSettings) QuietUntil(now time.Time, location *time.Location) (time.Time, bool) {
+	if s.QuietStartHour == nil || s.QuietEndHour == nil || *s.QuietStartHour == *s.QuietEndHour {
+		return time.Time{}, false
+	}
+	start, end := *s.QuietStartHour, *s.QuietEndHour
+
+	local := now.In(location)
+	hour := local.Hour()
+	quiet := start <= hour && hour < end
+	if start > end {
+		quiet = hour >= start || hour < end
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	until := time.Date(local.Year(), local.Month(), local.Day(), end, 0, 0, 0, location)
+	if !until.After(local) {
+		until = time.Date(local.Year(), local.Month(), local.Day()+1, end, 0, 0, 0, location)
+	}
+	return until.UTC(), true
+}
@@ -0,0 +1,61 @@
+package objects
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationSettingsQuietUntil(t *testing.T) {
+	bangkok, err := time.LoadLocation("Asia/Bangkok")
+	assert.NoError(t, err)
+
+	start, end := 22, 8
+	settings := &NotificationSettings{QuietStartHour: &start, QuietEndHour: &end}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, bangkok)
+	}
+
+	// Before midnight the window ends the next morning, after midnight the same morning
+	until, quiet := settings.QuietUntil(at(18, 23, 30), bangkok)
+	assert.True(t, quiet)
+	assert.Equal(t, at(19, 8, 0).UTC(), until)
+
+	until, quiet = settings.QuietUntil(at(19, 3, 0), bangkok)
+	assert.True(t, quiet)
+	assert.Equal(t, at(19, 8, 0).UTC(), until)
+
+	_, quiet = settings.QuietUntil(at(19, 8, 0), bangkok)
+	assert.False(t, quiet, "The end hour is no longer quiet")
+	_, quiet = settings.QuietUntil(at(19, 21, 59), bangkok)
+	assert.False(t, quiet)
+
+	// The hours are local: 23:30 in Bangkok is 16:30 UTC
+	_, quiet = settings.QuietUntil(time.Date(2026, 10, 18, 16, 30, 0, 0, time.UTC), bangkok)
+	assert.True(t, quiet)
+	_, quiet = settings.QuietUntil(time.Date(2026, 10, 18, 16, 30, 0, 0, time.UTC), time.UTC)
+	assert.False(t, quiet)
+
+	// A window within the same day
+	start, end = 1, 9
+	until, quiet = settings.QuietUntil(at(19, 1, 0), bangkok)
+	assert.True(t, quiet)
+	assert.Equal(t, at(19, 9, 0).UTC(), until)
+	_, quiet = settings.QuietUntil(at(19, 23, 0), bangkok)
+	assert.False(t, quiet)
+
+	_, quiet = DefaultNotificationSettings(1).QuietUntil(at(19, 3, 0), bangkok)
+	assert.False(t, quiet, "No quiet hours by default")
+}
+
+func TestNotificationSettingsIsPaused(t *testing.T) {
+	now := time.Now()
+	settings := DefaultNotificationSettings(1)
+	assert.False(t, settings.IsPaused(now))
+
+	until := now.Add(time.Hour)
+	settings.PausedUntil = &until
+	assert.True(t, settings.IsPaused(now))
+	assert.False(t, settings.IsPaused(until))
+}
@@ -0,0 +1,34 @@
+package objects
+
+// Sort orders of an offer query
+const (
+	OfferSortDistance = "distance" // nearest first
+	OfferSortRecent   = "recent"   // newest first
+)
+
+// OfferQuery selects the live exchanges a user browses with /offers: posted exchanges of other
+// users within the radius around a point, whose author's radius reaches the point as well.
+// Direction and MaxAmountUSD narrow it down like an interest.
+type OfferQuery struct {
+	Lat           float64
+	Lon           float64
+	RadiusKm      int
+	ExcludeUserID int64
+	Direction     string // what the user wants to do, empty for both directions
+	MaxAmountUSD  *int   // nil for any amount
+	Sort          string // OfferSortDistance or OfferSortRecent
+	Offset        int
+	Limit         int
+}
+
+// MatchesFilters reports whether the exchange passes the query's direction and amount filters,
+// the location is checked by the radius search
+func (q *OfferQuery) MatchesFilters(exchange *Exchange) bool {
+	if q.Direction != "" && exchange.ExchangeDirection != OppositeDirection(q.Direction) {
+		return false
+	}
+	if q.MaxAmountUSD != nil && exchange.AmountUSD != nil && *exchange.AmountUSD > *q.MaxAmountUSD {
+		return false
+	}
+	return true
+}
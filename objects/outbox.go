@@ -0,0 +1,17 @@
+package objects
+
+import (
+	"time"
+)
+
+// OutboxEntry is a pending fanout of a posted exchange. It is written in the same
+// transaction as the exchange status change and removed from the pending set only
+// after every notification was confirmed by RabbitMQ.
+type OutboxEntry struct {
+	ID          int64
+	ExchangeID  int64
+	Attempts    int
+	LastError   *string    // error of the last failed relay attempt (nullable)
+	PublishedAt *time.Time // when the fanout was fully published (nullable)
+	CreatedAt   time.Time
+}
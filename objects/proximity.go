@@ -0,0 +1,32 @@
+package objects
+
+// RadiusCursor marks a position in a radius search ordered by distance and then user ID.
+// The next page starts after it.
+type RadiusCursor struct {
+	DistanceM float64 // meters from the search center
+	UserID    int64
+}
+
+// FirstPage is the cursor before the closest user
+var FirstPage = RadiusCursor{DistanceM: -1}
+
+// NearbyUser is a user found by a paged radius search, by their location or by one of their
+// alerts. Only the fields fanout needs are loaded: UserId, MenuId, LanguageCode, Lat and Lon.
+type NearbyUser struct {
+	User       *User
+	DistanceM  float64 // to the closest matching point
+	ByLocation bool    // the user's own location matched, otherwise only alerts did
+}
+
+// Cursor returns the position right after this user
+func (u *NearbyUser) Cursor() RadiusCursor {
+	return RadiusCursor{DistanceM: u.DistanceM, UserID: u.User.UserId}
+}
+
+// After reports whether c comes after other in the search order
+func (c RadiusCursor) After(other RadiusCursor) bool {
+	if c.DistanceM != other.DistanceM {
+		return c.DistanceM > other.DistanceM
+	}
+	return c.UserID > other.UserID
+}
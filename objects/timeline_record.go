@@ -10,7 +10,11 @@ type TimelineRecord struct {
 	ExchangeID        int64
 	RecipientUserID   int64
 	TelegramMessageID *int       // nullable until message is sent
-	Status            string     // 'pending', 'sent', 'failed', 'deleted'
+	Status            string     // 'deferred', 'held', 'digest', 'overflow', 'pending', 'sent', 'failed', 'deleted'
+	DistanceKm        *float64   // distance to the exchange, kept while the notification is deferred or held
+	ReleaseAt         *time.Time // when a held notification is released (nullable)
+	DigestID          *int64     // the digest the offer was sent in (nullable)
+	SentAt            *time.Time // when the notification was sent, kept when the send is replayed (nullable)
 	IsDeleted         bool       // soft delete flag
 	DeletedAt         *time.Time // when message was deleted (nullable)
 	CreatedAt         time.Time
@@ -19,10 +23,14 @@ type TimelineRecord struct {
 
 // Status constants
 const (
-	TimelineStatusPending = "pending"
-	TimelineStatusSent    = "sent"
-	TimelineStatusFailed  = "failed"
-	TimelineStatusDeleted = "deleted"
+	TimelineStatusDeferred = "deferred" // the recipient was in another menu, delivered once back in the main menu
+	TimelineStatusHeld     = "held"     // the recipient's quiet hours, released at ReleaseAt
+	TimelineStatusDigest   = "digest"   // collected for the recipient's next digest
+	TimelineStatusOverflow = "overflow" // over the recipient's hourly cap, collected for an overflow summary
+	TimelineStatusPending  = "pending"
+	TimelineStatusSent     = "sent"
+	TimelineStatusFailed   = "failed"
+	TimelineStatusDeleted  = "deleted"
 )
 
 // NewTimelineRecord creates a new timeline record with initial values
@@ -21,6 +21,8 @@ const (
 	Menu_HistoricalFanoutWait    MenuId = 295 // Historical fanout wait menu
 	Menu_Main                    MenuId = 400 // Main exchange selection menu
 	Menu_Amount                  MenuId = 500 // Select exchange amount
+	Menu_AlertName               MenuId = 600 // Ask for the name of a new alert
+	Menu_AlertLocation           MenuId = 610 // Ask for the location of a new alert
 	Menu_Ban                     MenuId = 999999
 )
 
@@ -35,6 +37,7 @@ type User struct {
 	Lat            float64    // Latitude
 	SearchRadiusKm *int       // Search radius in kilometers (nullable)
 	PhoneNumber    string     // Phone number (optional)
+	Unreachable    bool       // Bot was blocked by the user or the chat is gone
 	po             *gotext.Po // Direct Po object for translations
 }
 
@@ -0,0 +1,48 @@
+package rabbit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// headerIdempotencyKey carries the key the sender uses to skip messages it already delivered
+const headerIdempotencyKey = "idempotency_key"
+
+// IdempotencyKey builds a deterministic key from the message kind and the ids it is about,
+// e.g. IdempotencyKey("exchange_notification", exchangeID, recipientID)
+func IdempotencyKey(kind string, ids ...int64) string {
+	parts := []string{kind}
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%d", id))
+	}
+	return strings.Join(parts, ":")
+}
+
+// newIdempotencyKey returns a random key for messages without a natural identity.
+// It still protects against redelivery of the very same publish.
+func newIdempotencyKey() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return "random:" + hex.EncodeToString(buf)
+}
+
+// keyOrRandom returns key, or a fresh random key when it is empty
+func keyOrRandom(key string) string {
+	if key == "" {
+		return newIdempotencyKey()
+	}
+	return key
+}
+
+// IdempotencyKeyFromHeaders returns the idempotency key of a delivery, or "" for
+// messages published before keys were introduced
+func IdempotencyKeyFromHeaders(headers amqp.Table) string {
+	key, _ := headers[headerIdempotencyKey].(string)
+	return key
+}
@@ -0,0 +1,31 @@
+package rabbit
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	assert.Equal(t, "exchange_notification:12:345", IdempotencyKey("exchange_notification", 12, 345))
+	assert.Equal(t, "contact_request:7:-100", IdempotencyKey("contact_request", 7, -100))
+	assert.NotEqual(t,
+		IdempotencyKey("exchange_notification", 12, 345),
+		IdempotencyKey("historical_notification", 12, 345))
+}
+
+func TestKeyOrRandom(t *testing.T) {
+	assert.Equal(t, "given", keyOrRandom("given"))
+
+	first := keyOrRandom("")
+	second := keyOrRandom("")
+	assert.NotEmpty(t, first)
+	assert.NotEqual(t, first, second)
+}
+
+func TestIdempotencyKeyFromHeaders(t *testing.T) {
+	assert.Equal(t, "", IdempotencyKeyFromHeaders(nil))
+	assert.Equal(t, "", IdempotencyKeyFromHeaders(amqp.Table{headerIdempotencyKey: int32(1)}))
+	assert.Equal(t, "k", IdempotencyKeyFromHeaders(amqp.Table{headerIdempotencyKey: "k"}))
+}
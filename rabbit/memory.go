@@ -0,0 +1,213 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.uber.org/ratelimit"
+)
+
+// ErrTransportClosed is returned when publishing to a closed MemoryTransport
+var ErrTransportClosed = errors.New("transport closed")
+
+// Delivery is a message queued in a MemoryTransport
+type Delivery struct {
+	Body     []byte
+	Headers  amqp.Table
+	Priority uint8 // bag priority, 0..255
+}
+
+// MemoryTransport is an in-process Transport backed by Go channels, for tests and for running
+// producer and consumer in one binary without a broker. It keeps the queue split, retries and
+// dead-lettering of RabbitClient, but messages are delivered in FIFO order within a queue
+// and are lost when the process exits.
+type MemoryTransport struct {
+	queues      map[string]chan Delivery
+	closed      chan struct{}
+	closeOnce   sync.Once
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+	retryDelay  func(attempt int) time.Duration
+	consumers   consumerGroup
+}
+
+// NewMemoryTransport creates a transport whose queues buffer up to bufferSize messages each.
+// Publishing to a full queue blocks until a consumer catches up.
+func NewMemoryTransport(bufferSize int) *MemoryTransport {
+	log.Printf("[MEMORY_TRANSPORT] Creating in-memory transport for queues: %v", MessagingQueues)
+
+	queues := make(map[string]chan Delivery)
+	for _, queueName := range MessagingQueues {
+		queues[queueName] = make(chan Delivery, bufferSize)
+	}
+
+	return &MemoryTransport{
+		queues:     queues,
+		closed:     make(chan struct{}),
+		retryDelay: retryDelay,
+	}
+}
+
+func (t *MemoryTransport) PublishTgMessage(messageBag MessageBag) error {
+	env, err := encodeTgMessage(messageBag)
+	if err != nil {
+		return err
+	}
+	return t.enqueue(env.queueName, Delivery{Body: env.body, Headers: env.headers, Priority: env.priority})
+}
+
+func (t *MemoryTransport) PublishCallbackAnswer(callbackBag CallbackAnswerBag) error {
+	env, err := encodeCallbackAnswer(callbackBag)
+	if err != nil {
+		return err
+	}
+	return t.enqueue(env.queueName, Delivery{Body: env.body, Headers: env.headers, Priority: env.priority})
+}
+
+func (t *MemoryTransport) PublishEditMessage(editBag EditMessageBag) error {
+	env, err := encodeEditMessage(editBag)
+	if err != nil {
+		return err
+	}
+	return t.enqueue(env.queueName, Delivery{Body: env.body, Headers: env.headers, Priority: env.priority})
+}
+
+func (t *MemoryTransport) PublishExchangeNotification(notificationBag ExchangeNotificationBag) error {
+	env, err := encodeExchangeNotification(notificationBag)
+	if err != nil {
+		return err
+	}
+	return t.enqueue(env.queueName, Delivery{Body: env.body, Headers: env.headers, Priority: env.priority})
+}
+
+// enqueue puts a delivery into the queue, blocking while the queue is full
+func (t *MemoryTransport) enqueue(queueName string, delivery Delivery) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+
+	select {
+	case t.queues[queueName] <- delivery:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+// Receive takes the next message from the queue, waiting up to timeout. Meant for tests
+// that inspect what was published instead of registering a handler.
+func (t *MemoryTransport) Receive(queueName string, timeout time.Duration) (Delivery, bool) {
+	select {
+	case delivery := <-t.queues[queueName]:
+		return delivery, true
+	case <-time.After(timeout):
+		return Delivery{}, false
+	}
+}
+
+// RegisterHandler starts one consumer per queue, rate limited like RabbitClient
+func (t *MemoryTransport) RegisterHandler(handler Handler) {
+	log.Printf("[MEMORY_TRANSPORT] Registering message handler")
+
+	global := ratelimit.New(TelegramRateLimit)
+	for queueName := range t.queues {
+		t.consumers.running.Add(1)
+		go t.consume(queueName, handler, global)
+	}
+}
+
+// StopConsuming stops the consumers between messages, queued messages stay in the queues
+func (t *MemoryTransport) StopConsuming(ctx context.Context) (int, error) {
+	log.Printf("[MEMORY_TRANSPORT] Stopping consumers")
+	return t.consumers.stop(ctx)
+}
+
+func (t *MemoryTransport) consume(queueName string, handler Handler, global ratelimit.Limiter) {
+	defer t.consumers.running.Done()
+	rl := ratelimit.New(queueRateLimit(queueName))
+
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-t.consumers.done():
+			return
+		case delivery := <-t.queues[queueName]:
+			if !t.consumers.begin() {
+				// Lost the race with StopConsuming, put the message back
+				go t.enqueue(queueName, delivery)
+				return
+			}
+
+			rl.Take()     // Queue budget
+			global.Take() // Telegram budget
+
+			if err := handler(delivery.Body, delivery.Headers); err != nil {
+				t.handleFailure(queueName, delivery, err)
+			}
+			t.consumers.end()
+		}
+	}
+}
+
+// handleFailure schedules a retry with backoff or keeps the message as a dead letter
+func (t *MemoryTransport) handleFailure(queueName string, delivery Delivery, handlerErr error) {
+	attempts := deliveryAttempts(delivery.Headers) + 1
+
+	headers := amqp.Table{}
+	for key, value := range delivery.Headers {
+		headers[key] = value
+	}
+	headers[headerAttempts] = int32(attempts)
+	headers[headerLastError] = handlerErr.Error()
+	delivery.Headers = headers
+
+	if IsPermanent(handlerErr) || attempts >= MaxDeliveryAttempts {
+		log.Printf("[MEMORY_TRANSPORT] Dead-lettering message from %s after %d attempt(s): %v",
+			queueName, attempts, handlerErr)
+		headers[headerDeadLetteredAt] = time.Now().UTC().Format(time.RFC3339)
+
+		t.mu.Lock()
+		t.deadLetters = append(t.deadLetters, newDeadLetter(amqp.Delivery{
+			Headers:  headers,
+			Body:     delivery.Body,
+			Priority: brokerPriority(delivery.Priority),
+		}))
+		t.mu.Unlock()
+		return
+	}
+
+	delay := t.retryDelay(attempts)
+	if after := retryAfterOf(handlerErr); after > delay {
+		delay = after
+	}
+	log.Printf("[MEMORY_TRANSPORT] Scheduling retry %d/%d in %v for %s: %v",
+		attempts, MaxDeliveryAttempts-1, delay, queueName, handlerErr)
+	time.AfterFunc(delay, func() {
+		if err := t.enqueue(queueName, delivery); err != nil {
+			log.Printf("[MEMORY_TRANSPORT] Dropping retry for %s: %v", queueName, err)
+		}
+	})
+}
+
+// DeadLetters returns the messages that exhausted their attempts or failed permanently
+func (t *MemoryTransport) DeadLetters() []DeadLetter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]DeadLetter(nil), t.deadLetters...)
+}
+
+// Close stops the consumers and rejects further publishes. Queued messages are discarded.
+func (t *MemoryTransport) Close() {
+	t.closeOnce.Do(func() {
+		log.Printf("[MEMORY_TRANSPORT] Closing in-memory transport")
+		close(t.closed)
+	})
+}
@@ -0,0 +1,204 @@
+package rabbit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTransportRoutesByMessageClass(t *testing.T) {
+	transport := NewMemoryTransport(10)
+	defer transport.Close()
+
+	assert.NoError(t, transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "hi"), Priority: PriorityUserMessage}))
+	assert.NoError(t, transport.PublishCallbackAnswer(CallbackAnswerBag{CallbackAnswer: tgbotapi.NewCallback("cb", ""), Priority: PriorityCallbackAnswer}))
+	assert.NoError(t, transport.PublishEditMessage(EditMessageBag{EditMessage: tgbotapi.NewEditMessageText(1, 2, "edited"), Priority: PriorityEdit}))
+	assert.NoError(t, transport.PublishExchangeNotification(ExchangeNotificationBag{
+		ExchangeID:      7,
+		RecipientUserID: 1,
+		Message:         tgbotapi.NewMessage(1, "offer"),
+		Priority:        PriorityFanout,
+		IdempotencyKey:  IdempotencyKey("exchange_notification", 7, 1),
+	}))
+	assert.NoError(t, transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "done"), Priority: PriorityFanoutFollowUp}))
+
+	message, ok := transport.Receive(QueueInteractive, time.Second)
+	assert.True(t, ok)
+	var messageBag MessageBag
+	assert.NoError(t, json.Unmarshal(message.Body, &messageBag))
+	assert.Equal(t, "hi", messageBag.Message.Text)
+	assert.NotEmpty(t, IdempotencyKeyFromHeaders(message.Headers))
+
+	callback, ok := transport.Receive(QueueInteractive, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "callback_answer", callback.Headers["message_type"])
+
+	edit, ok := transport.Receive(QueueEdits, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "edit_message", edit.Headers["message_type"])
+
+	notification, ok := transport.Receive(QueueFanout, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), notification.Headers["exchange_id"])
+	assert.Equal(t, "exchange_notification:7:1", IdempotencyKeyFromHeaders(notification.Headers))
+	assert.NotContains(t, notification.Headers, "fanout_job_id", "Only notifications of a fanout job carry its ID")
+	assert.NotContains(t, notification.Headers, "digest_id", "Only digests carry their ID")
+
+	followUp, ok := transport.Receive(QueueFanout, time.Second)
+	assert.True(t, ok)
+	assert.NoError(t, json.Unmarshal(followUp.Body, &messageBag))
+	assert.Equal(t, "done", messageBag.Message.Text, "Fanout follow-ups wait behind the fanout")
+
+	_, ok = transport.Receive(QueueFanout, 10*time.Millisecond)
+	assert.False(t, ok)
+}
+
+func TestMemoryTransportRetriesAndDeadLetters(t *testing.T) {
+	transport := NewMemoryTransport(10)
+	transport.retryDelay = func(int) time.Duration { return time.Millisecond }
+	defer transport.Close()
+
+	var calls int32
+	done := make(chan struct{}, 10)
+	transport.RegisterHandler(func(data []byte, headers amqp.Table) error {
+		defer func() { done <- struct{}{} }()
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("temporary failure")
+		}
+		return Permanent(errors.New("bad request"))
+	})
+
+	assert.NoError(t, transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "hi"), Priority: PriorityUserMessage}))
+
+	// First attempt fails temporarily, the retry fails permanently
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler was not called")
+		}
+	}
+
+	assert.Eventually(t, func() bool { return len(transport.DeadLetters()) == 1 }, time.Second, 5*time.Millisecond)
+	letter := transport.DeadLetters()[0]
+	assert.Equal(t, 2, letter.Attempts)
+	assert.Equal(t, "bad request", letter.LastError)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestMemoryTransportWaitsForRetryAfter(t *testing.T) {
+	transport := NewMemoryTransport(10)
+	transport.retryDelay = func(int) time.Duration { return time.Millisecond }
+	defer transport.Close()
+
+	var calls int32
+	done := make(chan time.Time, 10)
+	transport.RegisterHandler(func(data []byte, headers amqp.Table) error {
+		done <- time.Now()
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return RetryAfter(errors.New("Too Many Requests"), 100*time.Millisecond)
+		}
+		return nil
+	})
+
+	assert.NoError(t, transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "hi"), Priority: PriorityUserMessage}))
+
+	var attempts []time.Time
+	for i := 0; i < 2; i++ {
+		select {
+		case at := <-done:
+			attempts = append(attempts, at)
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler was not called")
+		}
+	}
+	assert.GreaterOrEqual(t, attempts[1].Sub(attempts[0]), 100*time.Millisecond, "The retry waits for retry_after, not the backoff")
+}
+
+func TestMemoryTransportRejectsPublishAfterClose(t *testing.T) {
+	transport := NewMemoryTransport(1)
+	transport.Close()
+	transport.Close() // closing twice is safe
+
+	err := transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "hi")})
+	assert.Equal(t, ErrTransportClosed, err)
+}
+
+func TestMemoryTransportStopConsumingWaitsForHandler(t *testing.T) {
+	transport := NewMemoryTransport(10)
+	defer transport.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled int32
+	transport.RegisterHandler(func(data []byte, headers amqp.Table) error {
+		if atomic.AddInt32(&handled, 1) == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+
+	assert.NoError(t, transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "first"), Priority: PriorityUserMessage}))
+	<-started
+	assert.NoError(t, transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "second"), Priority: PriorityUserMessage}))
+
+	type result struct {
+		inFlight int
+		err      error
+	}
+	stopped := make(chan result, 1)
+	go func() {
+		inFlight, err := transport.StopConsuming(context.Background())
+		stopped <- result{inFlight, err}
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("StopConsuming returned while a message was being handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case r := <-stopped:
+		assert.NoError(t, r.err)
+		assert.Equal(t, 1, r.inFlight)
+	case <-time.After(2 * time.Second):
+		t.Fatal("StopConsuming did not return")
+	}
+
+	// The second message was not taken and stays queued
+	assert.Equal(t, int32(1), atomic.LoadInt32(&handled))
+	_, ok := transport.Receive(QueueInteractive, time.Second)
+	assert.True(t, ok)
+}
+
+func TestMemoryTransportStopConsumingTimeout(t *testing.T) {
+	transport := NewMemoryTransport(10)
+	defer transport.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	transport.RegisterHandler(func(data []byte, headers amqp.Table) error {
+		close(started)
+		<-release
+		return nil
+	})
+	assert.NoError(t, transport.PublishTgMessage(MessageBag{Message: tgbotapi.NewMessage(1, "slow"), Priority: PriorityUserMessage}))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	inFlight, err := transport.StopConsuming(ctx)
+	assert.Equal(t, 1, inFlight)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,22 @@
+package rabbit
+
+// MaxBrokerPriority is the x-max-priority the queue is declared with.
+// RabbitMQ caps message priorities at this value, so bag priorities must be scaled to fit.
+const MaxBrokerPriority = 10
+
+// Priority classes for message bags. Bags keep the full 0..255 range and
+// brokerPriority maps each class onto its own broker priority level.
+const (
+	PriorityCallbackAnswer   uint8 = 255 // Instant response to button presses
+	PriorityUserMessage      uint8 = 220 // Replies to the user's own actions
+	PriorityEdit             uint8 = 200 // Edits of already sent messages
+	PriorityNotification     uint8 = 100 // Notifications triggered by other users (contact requests)
+	PriorityFanout           uint8 = 100 // New exchange notifications
+	PriorityHistoricalFanout uint8 = 80  // Historical exchanges for a new location
+	PriorityFanoutFollowUp   uint8 = 70  // Messages that must arrive after a historical fanout batch
+)
+
+// brokerPriority scales a 0..255 bag priority onto 0..MaxBrokerPriority, rounding to the nearest level
+func brokerPriority(priority uint8) uint8 {
+	return uint8((int(priority)*MaxBrokerPriority + 127) / 255)
+}
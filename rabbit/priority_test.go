@@ -0,0 +1,124 @@
+package rabbit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerPriorityStaysWithinQueueLimit(t *testing.T) {
+	for priority := 0; priority <= 255; priority++ {
+		assert.LessOrEqual(t, int(brokerPriority(uint8(priority))), MaxBrokerPriority)
+	}
+
+	tests := []struct {
+		priority uint8
+		expected uint8
+	}{
+		{0, 0},
+		{12, 0},
+		{13, 1}, // rounds to the nearest level
+		{38, 1},
+		{39, 2},
+		{127, 5},
+		{128, 5},
+		{242, 9},
+		{243, 10},
+		{255, MaxBrokerPriority},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d", tt.priority), func(t *testing.T) {
+			assert.Equal(t, tt.expected, brokerPriority(tt.priority))
+		})
+	}
+}
+
+func TestBrokerPriorityKeepsClassesApart(t *testing.T) {
+	// Broker levels never reorder classes against their bag priorities. Notification and
+	// fanout share a level, and so do historical fanout and fanout follow-ups, which only
+	// meet in the fanout queue. A changed class value shows up here before it reorders traffic.
+	tests := []struct {
+		name     string
+		priority uint8
+		expected uint8
+	}{
+		{"callback answer", PriorityCallbackAnswer, 10},
+		{"user message", PriorityUserMessage, 9},
+		{"edit", PriorityEdit, 8},
+		{"notification", PriorityNotification, 4},
+		{"fanout", PriorityFanout, 4},
+		{"historical fanout", PriorityHistoricalFanout, 3},
+		{"fanout follow-up", PriorityFanoutFollowUp, 3},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, brokerPriority(tt.priority))
+			if i > 0 {
+				assert.GreaterOrEqual(t, tests[i-1].priority, tt.priority, "Classes are listed by bag priority")
+			}
+		})
+	}
+}
+
+func TestCallbackAnswerOvertakesFanoutBacklog(t *testing.T) {
+	transport := NewMemoryTransport(100)
+	defer transport.Close()
+
+	// Build a backlog of historical and regular fanout notifications
+	const backlog = 50
+	for i := 0; i < backlog; i++ {
+		priority := PriorityFanout
+		if i%2 == 0 {
+			priority = PriorityHistoricalFanout
+		}
+		err := transport.PublishExchangeNotification(ExchangeNotificationBag{
+			ExchangeID:      int64(i),
+			RecipientUserID: 1,
+			Message:         tgbotapi.NewMessage(1, "fanout"),
+			Priority:        priority,
+		})
+		assert.NoError(t, err)
+	}
+
+	err := transport.PublishCallbackAnswer(CallbackAnswerBag{
+		CallbackAnswer: tgbotapi.NewCallback("callback", ""),
+		Priority:       PriorityCallbackAnswer,
+	})
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	handled, fanoutBefore := 0, 0
+	answered := make(chan struct{})
+	transport.RegisterHandler(func(data []byte, headers amqp.Table) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if headers["message_type"] == "callback_answer" {
+			fanoutBefore = handled
+			close(answered)
+		}
+		handled++
+		return nil
+	})
+
+	// The callback answer was published last, but its own queue doesn't wait for the
+	// rate limited fanout backlog
+	select {
+	case <-answered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback answer was not delivered")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = transport.StopConsuming(ctx)
+	assert.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Less(t, fanoutBefore, backlog, "Delivered before the backlog drained")
+}
@@ -0,0 +1,60 @@
+package rabbit
+
+// Queues used for outgoing Telegram traffic. Each queue has its own consumer
+// so a large fanout backlog can't delay replies to users who are pressing buttons.
+const (
+	QueueInteractive = "messages"        // Callback answers and replies to the user's own actions
+	QueueEdits       = "messages.edits"  // Edits of already sent messages
+	QueueFanout      = "messages.fanout" // Exchange notifications and other bulk traffic
+)
+
+// MessagingQueues lists all queues of the outgoing Telegram pipeline
+var MessagingQueues = []string{QueueInteractive, QueueEdits, QueueFanout}
+
+// TelegramRateLimit is the global per-second budget shared by all consumers of a client.
+// Telegram allows about 30 messages per second per bot.
+const TelegramRateLimit = 30
+
+// queueRateLimits caps how much of the global budget a single queue may take,
+// so the interactive queue always has room left even while fanout is draining
+var queueRateLimits = map[string]int{
+	QueueInteractive: 30,
+	QueueEdits:       20,
+	QueueFanout:      20,
+}
+
+// queueRateLimit returns the per-second budget of the queue
+func queueRateLimit(queueName string) int {
+	if limit, ok := queueRateLimits[queueName]; ok {
+		return limit
+	}
+	return TelegramRateLimit
+}
+
+// hasQueue reports whether the client declared the queue
+func (c *RabbitClient) hasQueue(queueName string) bool {
+	for _, name := range c.queueNames {
+		if name == queueName {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the queue to publish to. Clients that don't own the preferred queue
+// publish everything to their primary queue.
+func (c *RabbitClient) route(preferred string) string {
+	if c.hasQueue(preferred) {
+		return preferred
+	}
+	return c.queueName
+}
+
+// messageQueue picks the queue for a regular message. Messages prioritized at or below
+// historical fanout are follow-ups of a fanout batch and must stay behind it in the same queue.
+func messageQueue(priority uint8) string {
+	if priority <= PriorityHistoricalFanout {
+		return QueueFanout
+	}
+	return QueueInteractive
+}
@@ -0,0 +1,51 @@
+package rabbit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageQueueKeepsFanoutFollowUpsBehindFanout(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority uint8
+		expected string
+	}{
+		{"lowest", 0, QueueFanout},
+		{"fanout follow-up", PriorityFanoutFollowUp, QueueFanout},
+		{"historical fanout", PriorityHistoricalFanout, QueueFanout},
+		{"just above historical fanout", PriorityHistoricalFanout + 1, QueueInteractive},
+		{"notification", PriorityNotification, QueueInteractive},
+		{"fanout priority as a message", PriorityFanout, QueueInteractive},
+		{"edit priority as a message", PriorityEdit, QueueInteractive},
+		{"user message", PriorityUserMessage, QueueInteractive},
+		{"highest", 255, QueueInteractive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, messageQueue(tt.priority))
+		})
+	}
+}
+
+func TestRouteFallsBackToPrimaryQueue(t *testing.T) {
+	single := &RabbitClient{queueName: "messages", queueNames: []string{"messages"}}
+	assert.Equal(t, "messages", single.route(QueueFanout))
+	assert.Equal(t, "messages", single.route(QueueEdits))
+
+	full := &RabbitClient{queueName: QueueInteractive, queueNames: MessagingQueues}
+	assert.Equal(t, QueueFanout, full.route(QueueFanout))
+	assert.Equal(t, QueueEdits, full.route(QueueEdits))
+	assert.Equal(t, QueueInteractive, full.route(QueueInteractive))
+}
+
+func TestQueueRateLimitsFitGlobalBudget(t *testing.T) {
+	for _, queueName := range MessagingQueues {
+		assert.LessOrEqual(t, queueRateLimit(queueName), TelegramRateLimit)
+	}
+	// Bulk queues must leave room for interactive traffic
+	assert.Less(t, queueRateLimit(QueueFanout), TelegramRateLimit)
+	assert.Less(t, queueRateLimit(QueueEdits), TelegramRateLimit)
+	assert.Equal(t, TelegramRateLimit, queueRateLimit("unknown"))
+}
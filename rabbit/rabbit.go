@@ -1,9 +1,11 @@
 package rabbit
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"librecash/metrics"
 	"log"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
@@ -11,30 +13,44 @@ import (
 	"go.uber.org/ratelimit"
 )
 
+// confirmTimeout is how long a publish waits for the broker confirm
+const confirmTimeout = 5 * time.Second
+
 type RabbitClient struct {
 	url        string
-	queueName  string
+	queueName  string   // primary queue, receives messages whose own queue the client doesn't declare
+	queueNames []string // all queues declared and consumed by the client
+	mu         sync.Mutex
 	connection *amqp.Connection
-	channel    *amqp.Channel
+	channel    *amqp.Channel                     // publishing channel, in confirm mode
+	publishSeq uint64                            // delivery tag of the last publish on channel
+	waitersMu  sync.Mutex                        // guards waiters, never held while talking to the broker
+	waiters    map[uint64]chan amqp.Confirmation // publishes on channel waiting for their confirm, by delivery tag
+	consumers  consumerGroup
 }
 
-type Handler func(data []byte, headers amqp.Table)
+// Handler processes a single message. Returning an error schedules a retry with backoff,
+// or dead-letters the message right away when the error is wrapped with Permanent.
+type Handler func(data []byte, headers amqp.Table) error
 
 type MessageBag struct {
-	Message  tgbotapi.MessageConfig
-	Priority uint8 // 0..255
+	Message        tgbotapi.MessageConfig
+	Priority       uint8  // 0..255
+	IdempotencyKey string // random key is assigned on publish when empty
 }
 
 // CallbackAnswerBag represents a callback query answer
 type CallbackAnswerBag struct {
 	CallbackAnswer tgbotapi.CallbackConfig
-	Priority       uint8 // Should always be 255 for instant response
+	Priority       uint8  // Should always be PriorityCallbackAnswer for instant response
+	IdempotencyKey string // random key is assigned on publish when empty
 }
 
 // EditMessageBag represents a message edit operation
 type EditMessageBag struct {
-	EditMessage tgbotapi.EditMessageTextConfig
-	Priority    uint8
+	EditMessage    tgbotapi.EditMessageTextConfig
+	Priority       uint8
+	IdempotencyKey string // random key is assigned on publish when empty
 }
 
 // ExchangeNotificationBag represents a fanout notification message
@@ -43,14 +59,21 @@ type ExchangeNotificationBag struct {
 	RecipientUserID int64
 	Message         tgbotapi.MessageConfig
 	Priority        uint8
+	IdempotencyKey  string // should identify exchange, recipient and kind, so a re-broadcast is not sent twice
+	FanoutJobID     int64  // job the notification belongs to, counts its delivery; 0 for none
+	DigestID        int64  // digest the message summarizes, its offers are tracked on the digest; 0 for none
 }
 
-func NewRabbitClient(url string, queueName string) *RabbitClient {
-	log.Printf("[RABBIT] Creating new RabbitMQ client for queue: %s", queueName)
+// NewRabbitClient creates a client for queueName and any extra queues.
+// The first queue is the primary one, see RabbitClient.route.
+func NewRabbitClient(url string, queueName string, extraQueues ...string) *RabbitClient {
+	queueNames := append([]string{queueName}, extraQueues...)
+	log.Printf("[RABBIT] Creating new RabbitMQ client for queues: %v", queueNames)
 
 	client := &RabbitClient{
-		url:       url,
-		queueName: queueName,
+		url:        url,
+		queueName:  queueName,
+		queueNames: queueNames,
 	}
 
 	err := client.connect()
@@ -64,47 +87,66 @@ func NewRabbitClient(url string, queueName string) *RabbitClient {
 func (c *RabbitClient) connect() error {
 	log.Printf("[RABBIT] Connecting to RabbitMQ at %s", c.url)
 
-	// Close existing connection if any
-	if c.connection != nil && !c.connection.IsClosed() {
-		c.connection.Close()
-	}
+	// Close the existing channel if any, the connection is kept while it is open,
+	// so consumer channels on it keep running
 	if c.channel != nil {
 		c.channel.Close()
+		c.channel = nil
 	}
-
-	conn, err := amqp.Dial(c.url)
-	if err != nil {
-		return err
+	if c.connection == nil || c.connection.IsClosed() {
+		conn, err := amqp.Dial(c.url)
+		if err != nil {
+			return err
+		}
+		c.connection = conn
 	}
-	c.connection = conn
 
 	ch, err := c.connection.Channel()
 	if err != nil {
 		c.connection.Close()
 		return err
 	}
-	c.channel = ch
 
-	// Declare queue with priority support
-	args := amqp.Table{
-		"x-max-priority": int32(10),
+	// Publisher confirms: the broker acks every message once it has taken responsibility for it
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return err
 	}
+	c.channel = ch
+	c.publishSeq = 0
+	waiters := make(map[uint64]chan amqp.Confirmation)
+	c.waitersMu.Lock()
+	c.waiters = waiters
+	c.waitersMu.Unlock()
+	go c.dispatchConfirms(ch.NotifyPublish(make(chan amqp.Confirmation, 64)), waiters)
+
+	for _, queueName := range c.queueNames {
+		// Declare queue with priority support
+		args := amqp.Table{
+			"x-max-priority": int32(MaxBrokerPriority),
+		}
 
-	_, err = c.channel.QueueDeclare(
-		c.queueName,
-		true,  // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		args,  // arguments for priority queue
-	)
-	if err != nil {
-		c.channel.Close()
-		c.connection.Close()
-		return err
+		_, err = c.channel.QueueDeclare(
+			queueName,
+			true,  // durable
+			false, // auto-delete
+			false, // exclusive
+			false, // no-wait
+			args,  // arguments for priority queue
+		)
+		if err != nil {
+			c.closeChannel()
+			return err
+		}
+
+		// Declare delay queues for retries and the dead-letter queue
+		if err := c.declareRetryQueues(queueName); err != nil {
+			c.closeChannel()
+			return err
+		}
 	}
 
-	log.Printf("[RABBIT] Connected successfully to queue: %s", c.queueName)
+	log.Printf("[RABBIT] Connected successfully to queues: %v", c.queueNames)
 	return nil
 }
 
@@ -132,6 +174,9 @@ func (c *RabbitClient) isConnectionOpen() bool {
 }
 
 func (c *RabbitClient) ensureConnection() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if !c.isConnectionOpen() {
 		log.Printf("[RABBIT] Connection is closed, attempting to reconnect...")
 		return c.connect()
@@ -143,6 +188,16 @@ func (c *RabbitClient) PublishTgMessage(messageBag MessageBag) error {
 	log.Printf("[RABBIT] Publishing message to user %d with priority %d",
 		messageBag.Message.ChatID, messageBag.Priority)
 
+	env, err := encodeTgMessage(messageBag)
+	if err != nil {
+		log.Printf("[RABBIT] Failed to marshal message: %v", err)
+		return err
+	}
+	return c.publishEnvelope(env, "message")
+}
+
+// publishEnvelope publishes an encoded bag to its queue and records the publish metric
+func (c *RabbitClient) publishEnvelope(env envelope, kind string) error {
 	// Ensure we have a valid connection
 	if err := c.ensureConnection(); err != nil {
 		log.Printf("[RABBIT] Failed to establish connection: %v", err)
@@ -151,135 +206,153 @@ func (c *RabbitClient) PublishTgMessage(messageBag MessageBag) error {
 		return err
 	}
 
-	body, err := json.Marshal(messageBag)
-	if err != nil {
-		log.Printf("[RABBIT] Failed to marshal message: %v", err)
-		return err
-	}
-
-	err = c.channel.Publish(
-		"",          // exchange
-		c.queueName, // routing key
-		false,       // mandatory
-		false,       // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
-			Priority:     messageBag.Priority,
-		},
-	)
+	queueName := c.route(env.queueName)
+	err := c.publish(queueName, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		Body:         env.body,
+		Priority:     brokerPriority(env.priority),
+		Headers:      env.headers,
+	})
 
 	if err != nil {
-		log.Printf("[RABBIT] Failed to publish message: %v", err)
-		// Reset connection on publish error
-		c.channel = nil
-		c.connection = nil
+		log.Printf("[RABBIT] Failed to publish %s: %v", kind, err)
 		// Record failed publish metric
-		metrics.RecordRabbitMQMessage("published", c.queueName, false)
+		metrics.RecordRabbitMQMessage("published", queueName, false)
 		return err
 	}
 
-	log.Printf("[RABBIT] Message published successfully")
+	log.Printf("[RABBIT] %s published successfully to %s", kind, queueName)
 	// Record successful publish metric
-	metrics.RecordRabbitMQMessage("published", c.queueName, true)
+	metrics.RecordRabbitMQMessage("published", queueName, true)
 	return nil
 }
 
-// PublishCallbackAnswer publishes a callback query answer
-func (c *RabbitClient) PublishCallbackAnswer(callbackBag CallbackAnswerBag) error {
-	log.Printf("[RABBIT] Publishing callback answer %s with priority %d",
-		callbackBag.CallbackAnswer.CallbackQueryID, callbackBag.Priority)
-
-	// Ensure we have a valid connection
-	if err := c.ensureConnection(); err != nil {
-		log.Printf("[RABBIT] Failed to establish connection: %v", err)
-		// Record failed publish metric
-		metrics.RecordRabbitMQMessage("published", c.queueName, false)
+// publish sends a message to queueName and waits for the broker to confirm it.
+// A nil error means the message is safely stored in the queue. The lock only covers the
+// publish itself, confirms are matched to their publish by delivery tag, so concurrent
+// publishes wait for their confirms in parallel.
+func (c *RabbitClient) publish(queueName string, publishing amqp.Publishing) error {
+	c.mu.Lock()
+	ch := c.channel
+	if ch == nil {
+		c.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	// Register before publishing, the confirm may arrive before Publish returns
+	tag := c.publishSeq + 1
+	waiter := make(chan amqp.Confirmation, 1)
+	waiters := c.addWaiter(tag, waiter)
+	if err := ch.Publish(
+		"",        // exchange
+		queueName, // routing key
+		false,     // mandatory
+		false,     // immediate
+		publishing,
+	); err != nil {
+		c.removeWaiter(waiters, tag)
+		c.closeChannel()
+		c.mu.Unlock()
 		return err
 	}
+	c.publishSeq = tag
+	c.mu.Unlock()
 
-	body, err := json.Marshal(callbackBag)
-	if err != nil {
-		log.Printf("[RABBIT] Failed to marshal callback answer: %v", err)
-		return err
+	select {
+	case confirm, ok := <-waiter:
+		if !ok {
+			return amqp.ErrClosed
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("broker nacked message for queue %s", queueName)
+		}
+		return nil
+	case <-time.After(confirmTimeout):
+		// Reset the channel, its confirms can't be trusted anymore. Publishes still waiting
+		// on it fail with ErrClosed, the connection and the consumers on it stay up.
+		c.removeWaiter(waiters, tag)
+		c.mu.Lock()
+		if c.channel == ch {
+			c.closeChannel()
+		}
+		c.mu.Unlock()
+		return fmt.Errorf("no publisher confirm for queue %s within %v", queueName, confirmTimeout)
 	}
+}
 
-	err = c.channel.Publish(
-		"",          // exchange
-		c.queueName, // routing key
-		false,       // mandatory
-		false,       // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
-			Priority:     callbackBag.Priority,
-			Headers: amqp.Table{
-				"message_type": "callback_answer",
-			},
-		},
-	)
+// addWaiter registers a publish waiting for the confirm of tag on the current channel
+// and returns the waiters of that channel
+func (c *RabbitClient) addWaiter(tag uint64, waiter chan amqp.Confirmation) map[uint64]chan amqp.Confirmation {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
 
-	if err != nil {
-		log.Printf("[RABBIT] Failed to publish callback answer: %v", err)
-		// Reset connection on publish error
-		c.channel = nil
-		c.connection = nil
-		// Record failed publish metric
-		metrics.RecordRabbitMQMessage("published", c.queueName, false)
-		return err
-	}
+	c.waiters[tag] = waiter
+	return c.waiters
+}
 
-	log.Printf("[RABBIT] Callback answer published successfully")
-	// Record successful publish metric
-	metrics.RecordRabbitMQMessage("published", c.queueName, true)
-	return nil
+// removeWaiter forgets a publish that stopped waiting
+func (c *RabbitClient) removeWaiter(waiters map[uint64]chan amqp.Confirmation, tag uint64) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	delete(waiters, tag)
 }
 
-// PublishEditMessage publishes a message edit operation
-func (c *RabbitClient) PublishEditMessage(editBag EditMessageBag) error {
-	log.Printf("[RABBIT] Publishing message edit for message %d in chat %d with priority %d",
-		editBag.EditMessage.MessageID, editBag.EditMessage.ChatID, editBag.Priority)
+// dispatchConfirms hands the confirms of a publishing channel to the publishes waiting for them.
+// When the channel closes, publishes still waiting fail. It only takes waitersMu, so the library
+// can always deliver confirms, even while c.mu is held to close the channel.
+func (c *RabbitClient) dispatchConfirms(confirms chan amqp.Confirmation, waiters map[uint64]chan amqp.Confirmation) {
+	for confirm := range confirms {
+		c.waitersMu.Lock()
+		waiter := waiters[confirm.DeliveryTag]
+		delete(waiters, confirm.DeliveryTag)
+		c.waitersMu.Unlock()
+		if waiter != nil {
+			waiter <- confirm
+		}
+	}
 
-	// Ensure we have a valid connection
-	if err := c.ensureConnection(); err != nil {
-		log.Printf("[RABBIT] Failed to establish connection: %v", err)
-		return err
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+	for tag, waiter := range waiters {
+		close(waiter)
+		delete(waiters, tag)
 	}
+}
 
-	body, err := json.Marshal(editBag)
+// closeChannel closes the publishing channel, the next publish opens a new one.
+// Callers hold c.mu.
+func (c *RabbitClient) closeChannel() {
+	if c.channel != nil {
+		c.channel.Close()
+	}
+	c.channel = nil
+}
+
+// PublishCallbackAnswer publishes a callback query answer
+func (c *RabbitClient) PublishCallbackAnswer(callbackBag CallbackAnswerBag) error {
+	log.Printf("[RABBIT] Publishing callback answer %s with priority %d",
+		callbackBag.CallbackAnswer.CallbackQueryID, callbackBag.Priority)
+
+	env, err := encodeCallbackAnswer(callbackBag)
 	if err != nil {
-		log.Printf("[RABBIT] Failed to marshal edit message: %v", err)
+		log.Printf("[RABBIT] Failed to marshal callback answer: %v", err)
 		return err
 	}
+	return c.publishEnvelope(env, "callback answer")
+}
 
-	err = c.channel.Publish(
-		"",          // exchange
-		c.queueName, // routing key
-		false,       // mandatory
-		false,       // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
-			Priority:     editBag.Priority,
-			Headers: amqp.Table{
-				"message_type": "edit_message",
-			},
-		},
-	)
+// PublishEditMessage publishes a message edit operation
+func (c *RabbitClient) PublishEditMessage(editBag EditMessageBag) error {
+	log.Printf("[RABBIT] Publishing message edit for message %d in chat %d with priority %d",
+		editBag.EditMessage.MessageID, editBag.EditMessage.ChatID, editBag.Priority)
 
+	env, err := encodeEditMessage(editBag)
 	if err != nil {
-		log.Printf("[RABBIT] Failed to publish edit message: %v", err)
-		// Reset connection on publish error
-		c.channel = nil
-		c.connection = nil
+		log.Printf("[RABBIT] Failed to marshal edit message: %v", err)
 		return err
 	}
-
-	log.Printf("[RABBIT] Edit message published successfully")
-	return nil
+	return c.publishEnvelope(env, "edit message")
 }
 
 // PublishExchangeNotification publishes an exchange notification message
@@ -287,112 +360,142 @@ func (c *RabbitClient) PublishExchangeNotification(notificationBag ExchangeNotif
 	log.Printf("[RABBIT] Publishing exchange notification for exchange %d to user %d with priority %d",
 		notificationBag.ExchangeID, notificationBag.RecipientUserID, notificationBag.Priority)
 
-	// Ensure we have a valid connection
-	if err := c.ensureConnection(); err != nil {
-		log.Printf("[RABBIT] Failed to establish connection: %v", err)
-		return err
-	}
-
-	// Convert to regular MessageBag for now - we'll handle the exchange-specific data in the sender
-	messageBag := MessageBag{
-		Message:  notificationBag.Message,
-		Priority: notificationBag.Priority,
-	}
-
-	body, err := json.Marshal(messageBag)
+	env, err := encodeExchangeNotification(notificationBag)
 	if err != nil {
 		log.Printf("[RABBIT] Failed to marshal exchange notification: %v", err)
 		return err
 	}
+	return c.publishEnvelope(env, "exchange notification")
+}
 
-	err = c.channel.Publish(
-		"",          // exchange
-		c.queueName, // routing key
-		false,       // mandatory
-		false,       // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
-			Priority:     notificationBag.Priority,
-			Headers: amqp.Table{
-				"message_type":      "exchange_notification",
-				"exchange_id":       notificationBag.ExchangeID,
-				"recipient_user_id": notificationBag.RecipientUserID,
-			},
-		},
-	)
+// RegisterHandler starts one consumer per queue of the client. Every consumer is limited by
+// its own queue budget and by the global Telegram budget shared by all of them.
+func (c *RabbitClient) RegisterHandler(handler Handler) {
+	log.Printf("[RABBIT] Registering message handler for queues: %v", c.queueNames)
 
-	if err != nil {
-		log.Printf("[RABBIT] Failed to publish exchange notification: %v", err)
-		// Reset connection on publish error
-		c.channel = nil
-		c.connection = nil
-		return err
+	// Global rate limiter - shared by all queues of the client
+	global := ratelimit.New(TelegramRateLimit)
+
+	for _, queueName := range c.queueNames {
+		c.consumers.running.Add(1)
+		go c.consume(queueName, handler, global)
 	}
+}
 
-	log.Printf("[RABBIT] Exchange notification published successfully")
-	return nil
+// StopConsuming stops all consumers of the client between messages. Messages prefetched but
+// not yet handled go back to their queue when the consumer channels close.
+func (c *RabbitClient) StopConsuming(ctx context.Context) (int, error) {
+	log.Printf("[RABBIT] Stopping consumers for queues: %v", c.queueNames)
+	return c.consumers.stop(ctx)
 }
 
-func (c *RabbitClient) RegisterHandler(handler Handler) {
-	log.Printf("[RABBIT] Registering message handler for queue: %s", c.queueName)
-
-	// Rate limiter - 30 messages per second
-	rl := ratelimit.New(30)
-
-	go func() {
-		for {
-			// Ensure we have a valid connection
-			if err := c.ensureConnection(); err != nil {
-				log.Printf("[RABBIT] Reconnection failed: %v. Retrying in 5 seconds...", err)
-				time.Sleep(5 * time.Second)
-				continue
+// consume runs the consumer loop of a single queue on its own channel, reconnecting as needed
+func (c *RabbitClient) consume(queueName string, handler Handler, global ratelimit.Limiter) {
+	defer c.consumers.running.Done()
+	rl := ratelimit.New(queueRateLimit(queueName))
+
+	for {
+		ch, err := c.openConsumerChannel()
+		if err != nil {
+			log.Printf("[RABBIT] Reconnection failed for queue %s: %v. Retrying in 5 seconds...", queueName, err)
+			if !c.consumers.sleep(5 * time.Second) {
+				return
 			}
+			continue
+		}
 
-			msgs, err := c.channel.Consume(
-				c.queueName,
-				"",    // consumer tag
-				false, // auto-ack
-				false, // exclusive
-				false, // no-local
-				false, // no-wait
-				nil,   // args
-			)
-
-			if err != nil {
-				log.Printf("[RABBIT] Failed to register consumer: %v", err)
-				// Reset connection on consumer error
-				c.channel = nil
-				c.connection = nil
-				time.Sleep(5 * time.Second)
-				continue
+		// Take one message at a time, otherwise the broker pushes the whole backlog
+		// into the client buffer and priorities stop mattering
+		if err := ch.Qos(1, 0, false); err != nil {
+			log.Printf("[RABBIT] Failed to set prefetch for queue %s: %v", queueName, err)
+			ch.Close()
+			if !c.consumers.sleep(5 * time.Second) {
+				return
 			}
+			continue
+		}
 
-			log.Printf("[RABBIT] Consumer registered, waiting for messages...")
+		msgs, err := ch.Consume(
+			queueName,
+			"",    // consumer tag
+			false, // auto-ack
+			false, // exclusive
+			false, // no-local
+			false, // no-wait
+			nil,   // args
+		)
+
+		if err != nil {
+			log.Printf("[RABBIT] Failed to register consumer for queue %s: %v", queueName, err)
+			ch.Close()
+			if !c.consumers.sleep(5 * time.Second) {
+				return
+			}
+			continue
+		}
 
-			for msg := range msgs {
-				rl.Take() // Rate limiting
+		log.Printf("[RABBIT] Consumer registered for queue %s, waiting for messages...", queueName)
 
-				log.Printf("[RABBIT] Processing message")
-				handler(msg.Body, msg.Headers)
+		if c.deliverAll(ch, queueName, msgs, handler, rl, global) {
+			// Closing the channel returns the prefetched, unacked message to the queue
+			ch.Close()
+			log.Printf("[RABBIT] Consumer for queue %s stopped", queueName)
+			return
+		}
+
+		log.Printf("[RABBIT] Consumer channel for queue %s closed, reconnecting...", queueName)
+		ch.Close()
+	}
+}
 
-				if err := msg.Ack(false); err != nil {
-					log.Printf("[RABBIT] Failed to acknowledge message: %v", err)
-					// Record failed consume metric
-					metrics.RecordRabbitMQMessage("consumed", c.queueName, false)
-				} else {
-					// Record successful consume metric
-					metrics.RecordRabbitMQMessage("consumed", c.queueName, true)
-				}
+// deliverAll hands messages to handler until the channel closes or the consumers are stopped.
+// Returns true when stopped.
+func (c *RabbitClient) deliverAll(ch *amqp.Channel, queueName string, msgs <-chan amqp.Delivery,
+	handler Handler, rl, global ratelimit.Limiter) bool {
+	for {
+		select {
+		case <-c.consumers.done():
+			return true
+		case msg, ok := <-msgs:
+			if !ok {
+				return false
+			}
+			if !c.consumers.begin() {
+				return true
 			}
 
-			log.Printf("[RABBIT] Consumer channel closed, reconnecting...")
-			// Reset connection for reconnection
-			c.channel = nil
-			c.connection = nil
+			rl.Take()     // Queue budget
+			global.Take() // Telegram budget
+
+			log.Printf("[RABBIT] Processing message from queue %s", queueName)
+			if err := handler(msg.Body, msg.Headers); err != nil {
+				c.handleFailure(ch, queueName, msg, err)
+			} else if err := msg.Ack(false); err != nil {
+				log.Printf("[RABBIT] Failed to acknowledge message: %v", err)
+				// Record failed consume metric
+				metrics.RecordRabbitMQMessage("consumed", queueName, false)
+			} else {
+				// Record successful consume metric
+				metrics.RecordRabbitMQMessage("consumed", queueName, true)
+			}
+			c.consumers.end()
 		}
-	}()
+	}
+}
+
+// openConsumerChannel opens a dedicated channel so every consumer can have its own prefetch
+func (c *RabbitClient) openConsumerChannel() (*amqp.Channel, error) {
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connection == nil || c.connection.IsClosed() {
+		return nil, amqp.ErrClosed
+	}
+	return c.connection.Channel()
 }
 
 func (c *RabbitClient) Close() {
@@ -404,3 +507,29 @@ func (c *RabbitClient) Close() {
 		c.connection.Close()
 	}
 }
+
+// Ping connects to the broker and checks that the given queues exist, without declaring anything.
+// Returns the number of ready messages per queue.
+func Ping(url string, queueNames []string) (map[string]int, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	depths := make(map[string]int, len(queueNames))
+	for _, queueName := range queueNames {
+		// A failed passive declare closes the channel, use one per queue
+		ch, err := conn.Channel()
+		if err != nil {
+			return depths, err
+		}
+		queue, err := ch.QueueDeclarePassive(queueName, true, false, false, false, nil)
+		ch.Close()
+		if err != nil {
+			return depths, fmt.Errorf("queue %s: %v", queueName, err)
+		}
+		depths[queueName] = queue.Messages
+	}
+	return depths, nil
+}
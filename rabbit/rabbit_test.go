@@ -0,0 +1,36 @@
+package rabbit
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchConfirmsMatchesDeliveryTags(t *testing.T) {
+	client := &RabbitClient{waiters: make(map[uint64]chan amqp.Confirmation)}
+	first, second, third := make(chan amqp.Confirmation, 1), make(chan amqp.Confirmation, 1), make(chan amqp.Confirmation, 1)
+	waiters := client.addWaiter(1, first)
+	client.addWaiter(2, second)
+	client.addWaiter(3, third)
+
+	confirms := make(chan amqp.Confirmation, 2)
+	done := make(chan struct{})
+	go func() {
+		client.dispatchConfirms(confirms, waiters)
+		close(done)
+	}()
+
+	// Confirms reach their own publish, not the one that waits longest
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: false}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	assert.Equal(t, amqp.Confirmation{DeliveryTag: 1, Ack: true}, <-first)
+	assert.Equal(t, amqp.Confirmation{DeliveryTag: 2, Ack: false}, <-second)
+
+	// A closed channel fails the publishes still waiting
+	close(confirms)
+	<-done
+	_, ok := <-third
+	assert.False(t, ok)
+	assert.Empty(t, waiters)
+}
@@ -0,0 +1,363 @@
+package rabbit
+
+import (
+	"errors"
+	"fmt"
+	"librecash/metrics"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// MaxDeliveryAttempts is how many times a message is handed to the handler
+// before it is moved to the dead-letter queue
+const MaxDeliveryAttempts = 5
+
+// retryBaseDelay is the delay before the first retry, doubled for every next attempt
+const retryBaseDelay = 2 * time.Second
+
+// Headers used to track retries and dead-lettering
+const (
+	headerAttempts       = "x-librecash-attempts"
+	headerLastError      = "x-librecash-last-error"
+	headerDeadLetteredAt = "x-librecash-dead-lettered-at"
+)
+
+// PermanentError marks a handler failure that will never succeed on retry
+// (e.g. 400 Bad Request or 403 Forbidden from Telegram)
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err so the consumer dead-letters the message instead of retrying it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err was marked as permanent
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr)
+}
+
+// RetryAfterError marks a handler failure that may only be retried after the delay the remote
+// side asked for, e.g. Telegram's flood wait (429 with retry_after)
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter wraps err so the consumer waits at least after before the next attempt
+func RetryAfter(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryAfterError{Err: err, After: after}
+}
+
+// retryAfterOf returns the delay err asked for, 0 if none
+func retryAfterOf(err error) time.Duration {
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		return retryAfterErr.After
+	}
+	return 0
+}
+
+// IsLastAttempt reports whether a failure of the message with these headers sends it to the
+// dead-letter queue instead of retrying it
+func IsLastAttempt(headers amqp.Table) bool {
+	return deliveryAttempts(headers)+1 >= MaxDeliveryAttempts
+}
+
+// DeadLetter is a message parked in the dead-letter queue
+type DeadLetter struct {
+	MessageType    string
+	Attempts       int
+	LastError      string
+	DeadLetteredAt string
+	Priority       uint8
+	Body           []byte
+}
+
+// retryQueueName returns the delay queue used before the given retry attempt
+func retryQueueName(queueName string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, attempt)
+}
+
+// retryAfterQueueName returns the delay queue of retries that wait longer than the backoff,
+// it has no TTL of its own, every message carries its expiration
+func retryAfterQueueName(queueName string) string {
+	return queueName + ".retry.after"
+}
+
+// deadLetterQueueName returns the queue where exhausted or permanently failed messages are parked
+func deadLetterQueueName(queueName string) string {
+	return queueName + ".dead"
+}
+
+// retryDelay returns the exponential backoff delay before the given retry attempt (1-based)
+func retryDelay(attempt int) time.Duration {
+	return retryBaseDelay * time.Duration(1<<uint(attempt-1))
+}
+
+// nextRetryDelay returns the delay before the given retry attempt: the backoff, or the delay the
+// failure asked for if that is longer
+func nextRetryDelay(attempt int, err error) time.Duration {
+	delay := retryDelay(attempt)
+	if after := retryAfterOf(err); after > delay {
+		return after
+	}
+	return delay
+}
+
+// deliveryAttempts reads how many times the message was already handled
+func deliveryAttempts(headers amqp.Table) int {
+	switch value := headers[headerAttempts].(type) {
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	case int:
+		return value
+	default:
+		return 0
+	}
+}
+
+// declareRetryQueues declares the TTL delay queues and the dead-letter queue for queueName.
+// Expired messages in a delay queue are dead-lettered back to the main queue by the broker.
+func (c *RabbitClient) declareRetryQueues(queueName string) error {
+	for attempt := 1; attempt < MaxDeliveryAttempts; attempt++ {
+		args := amqp.Table{
+			"x-message-ttl":             int32(retryDelay(attempt) / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		}
+
+		_, err := c.channel.QueueDeclare(
+			retryQueueName(queueName, attempt),
+			true,  // durable
+			false, // auto-delete
+			false, // exclusive
+			false, // no-wait
+			args,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := c.channel.QueueDeclare(
+		retryAfterQueueName(queueName),
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.channel.QueueDeclare(
+		deadLetterQueueName(queueName),
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		nil,
+	)
+	return err
+}
+
+// handleFailure re-publishes a failed message of queueName with backoff, or moves it to the
+// dead-letter queue when the error is permanent or the message ran out of attempts
+func (c *RabbitClient) handleFailure(ch *amqp.Channel, queueName string, msg amqp.Delivery, handlerErr error) {
+	attempts := deliveryAttempts(msg.Headers) + 1
+
+	headers := amqp.Table{}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[headerAttempts] = int32(attempts)
+	headers[headerLastError] = handlerErr.Error()
+
+	var target string
+	var operation string
+	var expiration string
+	if IsPermanent(handlerErr) || attempts >= MaxDeliveryAttempts {
+		target = deadLetterQueueName(queueName)
+		operation = "dead_lettered"
+		headers[headerDeadLetteredAt] = time.Now().UTC().Format(time.RFC3339)
+		log.Printf("[RABBIT] Moving message to dead-letter queue %s after %d attempt(s): %v",
+			target, attempts, handlerErr)
+	} else {
+		target = retryQueueName(queueName, attempts)
+		operation = "retried"
+		delay := nextRetryDelay(attempts, handlerErr)
+		if delay > retryDelay(attempts) {
+			// The TTL of a backoff queue can't be extended per message, longer waits go through
+			// the queue without a TTL
+			target = retryAfterQueueName(queueName)
+			expiration = strconv.FormatInt(int64(delay/time.Millisecond), 10)
+		}
+		log.Printf("[RABBIT] Scheduling retry %d/%d in %v via %s: %v",
+			attempts, MaxDeliveryAttempts-1, delay, target, handlerErr)
+	}
+
+	err := ch.Publish(
+		"",     // exchange
+		target, // routing key
+		false,  // mandatory
+		false,  // immediate
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  msg.ContentType,
+			Body:         msg.Body,
+			Priority:     msg.Priority,
+			Headers:      headers,
+			Expiration:   expiration,
+		},
+	)
+	if err != nil {
+		log.Printf("[RABBIT] Failed to publish message to %s: %v. Requeueing original", target, err)
+		if nackErr := msg.Nack(false, true); nackErr != nil {
+			log.Printf("[RABBIT] Failed to requeue message: %v", nackErr)
+		}
+		metrics.RecordRabbitMQMessage(operation, queueName, false)
+		return
+	}
+
+	if err := msg.Ack(false); err != nil {
+		log.Printf("[RABBIT] Failed to acknowledge failed message: %v", err)
+	}
+	metrics.RecordRabbitMQMessage(operation, queueName, true)
+}
+
+// InspectDeadLetters returns up to limit messages from the dead-letter queue without removing them
+func (c *RabbitClient) InspectDeadLetters(limit int) ([]DeadLetter, error) {
+	log.Printf("[RABBIT] Inspecting up to %d dead letters in %s", limit, deadLetterQueueName(c.queueName))
+
+	if err := c.ensureConnection(); err != nil {
+		return nil, err
+	}
+
+	var deliveries []amqp.Delivery
+	// Return everything we looked at to the queue, in the original order
+	defer func() {
+		for _, delivery := range deliveries {
+			if err := delivery.Nack(false, true); err != nil {
+				log.Printf("[RABBIT] Failed to return dead letter to queue: %v", err)
+			}
+		}
+	}()
+
+	var letters []DeadLetter
+	for len(letters) < limit {
+		delivery, ok, err := c.channel.Get(deadLetterQueueName(c.queueName), false)
+		if err != nil {
+			return letters, err
+		}
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, delivery)
+		letters = append(letters, newDeadLetter(delivery))
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetters moves up to limit messages from the dead-letter queue back to the main queue
+// with a fresh attempt counter. Returns the number of replayed messages.
+func (c *RabbitClient) ReplayDeadLetters(limit int) (int, error) {
+	log.Printf("[RABBIT] Replaying up to %d dead letters from %s", limit, deadLetterQueueName(c.queueName))
+
+	if err := c.ensureConnection(); err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for replayed < limit {
+		delivery, ok, err := c.channel.Get(deadLetterQueueName(c.queueName), false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		headers := amqp.Table{}
+		for key, value := range delivery.Headers {
+			headers[key] = value
+		}
+		delete(headers, headerAttempts)
+		delete(headers, headerLastError)
+		delete(headers, headerDeadLetteredAt)
+
+		err = c.publish(c.queueName, amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  delivery.ContentType,
+			Body:         delivery.Body,
+			Priority:     delivery.Priority,
+			Headers:      headers,
+		})
+		if err != nil {
+			delivery.Nack(false, true)
+			return replayed, err
+		}
+
+		if err := delivery.Ack(false); err != nil {
+			return replayed, err
+		}
+		metrics.RecordRabbitMQMessage("replayed", c.queueName, true)
+		replayed++
+	}
+
+	log.Printf("[RABBIT] Replayed %d dead letters to %s", replayed, c.queueName)
+	return replayed, nil
+}
+
+func newDeadLetter(delivery amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		MessageType: "regular",
+		Attempts:    deliveryAttempts(delivery.Headers),
+		Priority:    delivery.Priority,
+		Body:        delivery.Body,
+	}
+	if messageType, ok := delivery.Headers["message_type"].(string); ok {
+		letter.MessageType = messageType
+	}
+	if lastError, ok := delivery.Headers[headerLastError].(string); ok {
+		letter.LastError = lastError
+	}
+	if deadLetteredAt, ok := delivery.Headers[headerDeadLetteredAt].(string); ok {
+		letter.DeadLetteredAt = deadLetteredAt
+	}
+	return letter
+}
@@ -0,0 +1,90 @@
+package rabbit
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelayIsExponential(t *testing.T) {
+	assert.Equal(t, 2*time.Second, retryDelay(1))
+	assert.Equal(t, 4*time.Second, retryDelay(2))
+	assert.Equal(t, 8*time.Second, retryDelay(3))
+	assert.Equal(t, 16*time.Second, retryDelay(4))
+}
+
+func TestRetryQueueNames(t *testing.T) {
+	assert.Equal(t, "messages.retry.1", retryQueueName("messages", 1))
+	assert.Equal(t, "messages.retry.4", retryQueueName("messages", 4))
+	assert.Equal(t, "messages.dead", deadLetterQueueName("messages"))
+}
+
+func TestDeliveryAttempts(t *testing.T) {
+	assert.Equal(t, 0, deliveryAttempts(nil))
+	assert.Equal(t, 0, deliveryAttempts(amqp.Table{}))
+	assert.Equal(t, 2, deliveryAttempts(amqp.Table{headerAttempts: int32(2)}))
+	assert.Equal(t, 3, deliveryAttempts(amqp.Table{headerAttempts: int64(3)}))
+	assert.Equal(t, 0, deliveryAttempts(amqp.Table{headerAttempts: "3"}))
+}
+
+func TestPermanentError(t *testing.T) {
+	cause := errors.New("Forbidden: bot was blocked by the user")
+
+	assert.Nil(t, Permanent(nil))
+	assert.False(t, IsPermanent(cause))
+	assert.True(t, IsPermanent(Permanent(cause)))
+	assert.True(t, IsPermanent(fmt.Errorf("send failed: %w", Permanent(cause))))
+	assert.True(t, errors.Is(Permanent(cause), cause))
+	assert.Equal(t, cause.Error(), Permanent(cause).Error())
+}
+
+func TestRetryAfterError(t *testing.T) {
+	cause := errors.New("Too Many Requests: retry after 30")
+
+	assert.Nil(t, RetryAfter(nil, time.Second))
+	assert.Zero(t, retryAfterOf(cause))
+	assert.Equal(t, 30*time.Second, retryAfterOf(fmt.Errorf("send failed: %w", RetryAfter(cause, 30*time.Second))))
+	assert.False(t, IsPermanent(RetryAfter(cause, time.Second)))
+	assert.True(t, errors.Is(RetryAfter(cause, time.Second), cause))
+	assert.Equal(t, "messages.retry.after", retryAfterQueueName("messages"))
+}
+
+func TestNextRetryDelayHonoursRetryAfter(t *testing.T) {
+	cause := errors.New("Too Many Requests")
+
+	assert.Equal(t, 2*time.Second, nextRetryDelay(1, cause), "Backoff without retry_after")
+	assert.Equal(t, 30*time.Second, nextRetryDelay(1, RetryAfter(cause, 30*time.Second)), "A longer flood wait wins")
+	assert.Equal(t, 16*time.Second, nextRetryDelay(4, RetryAfter(cause, time.Second)), "A longer backoff wins")
+}
+
+func TestIsLastAttempt(t *testing.T) {
+	assert.False(t, IsLastAttempt(nil))
+	assert.False(t, IsLastAttempt(amqp.Table{headerAttempts: int32(MaxDeliveryAttempts - 2)}))
+	assert.True(t, IsLastAttempt(amqp.Table{headerAttempts: int32(MaxDeliveryAttempts - 1)}))
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	letter := newDeadLetter(amqp.Delivery{
+		Headers: amqp.Table{
+			"message_type":       "edit_message",
+			headerAttempts:       int32(5),
+			headerLastError:      "Bad Gateway",
+			headerDeadLetteredAt: "2025-01-01T00:00:00Z",
+		},
+		Priority: 7,
+		Body:     []byte(`{}`),
+	})
+
+	assert.Equal(t, "edit_message", letter.MessageType)
+	assert.Equal(t, 5, letter.Attempts)
+	assert.Equal(t, "Bad Gateway", letter.LastError)
+	assert.Equal(t, "2025-01-01T00:00:00Z", letter.DeadLetteredAt)
+	assert.Equal(t, uint8(7), letter.Priority)
+
+	regular := newDeadLetter(amqp.Delivery{})
+	assert.Equal(t, "regular", regular.MessageType)
+}
@@ -0,0 +1,83 @@
+package rabbit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// consumerGroup tracks the consumer goroutines of a transport, so StopConsuming can stop them
+// between messages and wait for the ones being handled. The zero value is ready to use.
+type consumerGroup struct {
+	initOnce sync.Once
+	stopping chan struct{}
+	running  sync.WaitGroup
+
+	mu       sync.Mutex
+	stopped  bool
+	inFlight int
+}
+
+// done is closed once stopping starts
+func (g *consumerGroup) done() <-chan struct{} {
+	g.initOnce.Do(func() { g.stopping = make(chan struct{}) })
+	return g.stopping
+}
+
+// begin marks a delivery as in progress. Returns false once stopping started,
+// the caller must then give the delivery back instead of handling it.
+func (g *consumerGroup) begin() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.stopped {
+		return false
+	}
+	g.inFlight++
+	return true
+}
+
+// end marks a delivery begun with begin as handled and acknowledged
+func (g *consumerGroup) end() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.inFlight--
+}
+
+// sleep waits for d, returns false when stopping started in the meantime
+func (g *consumerGroup) sleep(d time.Duration) bool {
+	select {
+	case <-g.done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// stop closes the group and waits for the consumers to return or ctx to end.
+// Returns the number of deliveries that were being handled when stopping started.
+func (g *consumerGroup) stop(ctx context.Context) (int, error) {
+	g.done() // the channel must exist before it is closed
+
+	g.mu.Lock()
+	if !g.stopped {
+		g.stopped = true
+		close(g.stopping)
+	}
+	inFlight := g.inFlight
+	g.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		g.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return inFlight, nil
+	case <-ctx.Done():
+		return inFlight, ctx.Err()
+	}
+}
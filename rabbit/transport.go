@@ -0,0 +1,130 @@
+package rabbit
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/streadway/amqp"
+)
+
+// Publisher queues outgoing Telegram traffic
+type Publisher interface {
+	PublishTgMessage(messageBag MessageBag) error
+	PublishCallbackAnswer(callbackBag CallbackAnswerBag) error
+	PublishEditMessage(editBag EditMessageBag) error
+	PublishExchangeNotification(notificationBag ExchangeNotificationBag) error
+}
+
+// Consumer hands queued messages to a handler
+type Consumer interface {
+	RegisterHandler(handler Handler)
+	// StopConsuming stops taking new messages and waits until the messages being handled are
+	// acknowledged, or ctx ends. Returns how many messages were in progress.
+	StopConsuming(ctx context.Context) (int, error)
+}
+
+// Transport is a message transport with both sides, implemented by RabbitClient
+// and by the in-process MemoryTransport
+type Transport interface {
+	Publisher
+	Consumer
+	Close()
+}
+
+var (
+	_ Transport = (*RabbitClient)(nil)
+	_ Transport = (*MemoryTransport)(nil)
+)
+
+// envelope is an encoded bag ready to be queued by any transport
+type envelope struct {
+	queueName string // preferred queue, see RabbitClient.route
+	priority  uint8  // bag priority, 0..255
+	body      []byte
+	headers   amqp.Table
+}
+
+func encodeTgMessage(messageBag MessageBag) (envelope, error) {
+	messageBag.IdempotencyKey = keyOrRandom(messageBag.IdempotencyKey)
+
+	body, err := json.Marshal(messageBag)
+	if err != nil {
+		return envelope{}, err
+	}
+	return envelope{
+		queueName: messageQueue(messageBag.Priority),
+		priority:  messageBag.Priority,
+		body:      body,
+		headers: amqp.Table{
+			headerIdempotencyKey: messageBag.IdempotencyKey,
+		},
+	}, nil
+}
+
+func encodeCallbackAnswer(callbackBag CallbackAnswerBag) (envelope, error) {
+	callbackBag.IdempotencyKey = keyOrRandom(callbackBag.IdempotencyKey)
+
+	body, err := json.Marshal(callbackBag)
+	if err != nil {
+		return envelope{}, err
+	}
+	return envelope{
+		queueName: QueueInteractive,
+		priority:  callbackBag.Priority,
+		body:      body,
+		headers: amqp.Table{
+			"message_type":       "callback_answer",
+			headerIdempotencyKey: callbackBag.IdempotencyKey,
+		},
+	}, nil
+}
+
+func encodeEditMessage(editBag EditMessageBag) (envelope, error) {
+	editBag.IdempotencyKey = keyOrRandom(editBag.IdempotencyKey)
+
+	body, err := json.Marshal(editBag)
+	if err != nil {
+		return envelope{}, err
+	}
+	return envelope{
+		queueName: QueueEdits,
+		priority:  editBag.Priority,
+		body:      body,
+		headers: amqp.Table{
+			"message_type":       "edit_message",
+			headerIdempotencyKey: editBag.IdempotencyKey,
+		},
+	}, nil
+}
+
+func encodeExchangeNotification(notificationBag ExchangeNotificationBag) (envelope, error) {
+	// Sent as a regular MessageBag - the exchange-specific data travels in the headers
+	messageBag := MessageBag{
+		Message:        notificationBag.Message,
+		Priority:       notificationBag.Priority,
+		IdempotencyKey: keyOrRandom(notificationBag.IdempotencyKey),
+	}
+
+	body, err := json.Marshal(messageBag)
+	if err != nil {
+		return envelope{}, err
+	}
+	headers := amqp.Table{
+		"message_type":       "exchange_notification",
+		"exchange_id":        notificationBag.ExchangeID,
+		"recipient_user_id":  notificationBag.RecipientUserID,
+		headerIdempotencyKey: messageBag.IdempotencyKey,
+	}
+	if notificationBag.FanoutJobID != 0 {
+		headers["fanout_job_id"] = notificationBag.FanoutJobID
+	}
+	if notificationBag.DigestID != 0 {
+		headers["digest_id"] = notificationBag.DigestID
+	}
+	return envelope{
+		queueName: QueueFanout,
+		priority:  notificationBag.Priority,
+		body:      body,
+		headers:   headers,
+	}, nil
+}
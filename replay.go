@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"librecash/replay"
+	"librecash/repository"
+	"log"
+	"os"
+)
+
+// runReplayCommand plays a recorded update stream through the menus and prints the resulting
+// state transitions and outgoing messages. Nothing is sent to Telegram.
+//
+// Usage:
+//
+//	librecash replay [-db CONN] [-from N] [-limit N] [-v] FILE
+func runReplayCommand(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dbConnStr := flags.String("db", "",
+		"PostgreSQL connection string of a TEST database to replay against (default: in-memory repository)")
+	from := flags.Int("from", 1, "first record to replay (1-based)")
+	limit := flags.Int("limit", 0, "maximum number of records to replay, 0 for all")
+	verbose := flags.Bool("v", false, "show bot logs on stderr")
+	flags.Usage = printReplayUsage
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *from < 1 {
+		printReplayUsage()
+		return 2
+	}
+
+	records, err := replay.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read recording: %v\n", err)
+		return 1
+	}
+	if *from > len(records) {
+		fmt.Fprintf(os.Stderr, "Recording has only %d record(s)\n", len(records))
+		return 1
+	}
+	records = records[*from-1:]
+	if *limit > 0 && *limit < len(records) {
+		records = records[:*limit]
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	var repo repository.Repository = repository.NewMemoryRepository()
+	if *dbConnStr != "" {
+		db, err := openDatabase(*dbConnStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+		repo = repository.NewRepository(db)
+	}
+
+	replay.NewPlayer(repo).PlayAll(records, os.Stdout)
+	fmt.Printf("Replayed %d update(s)\n", len(records))
+	return 0
+}
+
+func printReplayUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  librecash replay [-db CONN] [-from N] [-limit N] [-v] FILE  Replay recorded updates and print what the bot does")
+}
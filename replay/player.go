@@ -0,0 +1,306 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"librecash/context"
+	"librecash/fanout"
+	"librecash/menu"
+	"librecash/objects"
+	"librecash/rabbit"
+	"librecash/repository"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// maxRecordSize bounds a single JSONL line, updates are a few KB at most
+const maxRecordSize = 1024 * 1024
+
+// ReadRecords parses a JSONL recording, skipping blank lines
+func ReadRecords(r io.Reader) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	var records []Record
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return records, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// ReadFile parses the JSONL recording at path
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadRecords(file)
+}
+
+// Outgoing is a Telegram call the bot queued while handling an update
+type Outgoing struct {
+	Kind     string // message, edit, callback_answer or exchange_notification
+	ChatID   int64
+	Text     string
+	Buttons  []string
+	Priority uint8
+}
+
+// capturePublisher is a rabbit.Publisher that collects outgoing traffic instead of queueing it
+type capturePublisher struct {
+	mu       sync.Mutex
+	outgoing []Outgoing
+}
+
+var _ rabbit.Publisher = (*capturePublisher)(nil)
+
+func (p *capturePublisher) add(outgoing Outgoing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.outgoing = append(p.outgoing, outgoing)
+	return nil
+}
+
+// take returns and clears the collected traffic
+func (p *capturePublisher) take() []Outgoing {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	outgoing := p.outgoing
+	p.outgoing = nil
+	return outgoing
+}
+
+func (p *capturePublisher) PublishTgMessage(messageBag rabbit.MessageBag) error {
+	return p.add(Outgoing{
+		Kind:     "message",
+		ChatID:   messageBag.Message.ChatID,
+		Text:     messageBag.Message.Text,
+		Buttons:  describeMarkup(messageBag.Message.ReplyMarkup),
+		Priority: messageBag.Priority,
+	})
+}
+
+func (p *capturePublisher) PublishCallbackAnswer(callbackBag rabbit.CallbackAnswerBag) error {
+	return p.add(Outgoing{
+		Kind:     "callback_answer",
+		Text:     callbackBag.CallbackAnswer.Text,
+		Priority: callbackBag.Priority,
+	})
+}
+
+func (p *capturePublisher) PublishEditMessage(editBag rabbit.EditMessageBag) error {
+	var buttons []string
+	if editBag.EditMessage.ReplyMarkup != nil {
+		buttons = describeMarkup(*editBag.EditMessage.ReplyMarkup)
+	}
+	return p.add(Outgoing{
+		Kind:     "edit",
+		ChatID:   editBag.EditMessage.ChatID,
+		Text:     editBag.EditMessage.Text,
+		Buttons:  buttons,
+		Priority: editBag.Priority,
+	})
+}
+
+func (p *capturePublisher) PublishExchangeNotification(notificationBag rabbit.ExchangeNotificationBag) error {
+	return p.add(Outgoing{
+		Kind:     "exchange_notification",
+		ChatID:   notificationBag.Message.ChatID,
+		Text:     notificationBag.Message.Text,
+		Buttons:  describeMarkup(notificationBag.Message.ReplyMarkup),
+		Priority: notificationBag.Priority,
+	})
+}
+
+// describeMarkup lists keyboard buttons as "text|callback_data" for inline and "text" for reply keyboards
+func describeMarkup(markup interface{}) []string {
+	var buttons []string
+	switch keyboard := markup.(type) {
+	case tgbotapi.InlineKeyboardMarkup:
+		for _, row := range keyboard.InlineKeyboard {
+			for _, button := range row {
+				if button.CallbackData != nil {
+					buttons = append(buttons, button.Text+"|"+*button.CallbackData)
+				} else {
+					buttons = append(buttons, button.Text)
+				}
+			}
+		}
+	case *tgbotapi.InlineKeyboardMarkup:
+		if keyboard != nil {
+			return describeMarkup(*keyboard)
+		}
+	case tgbotapi.ReplyKeyboardMarkup:
+		for _, row := range keyboard.Keyboard {
+			for _, button := range row {
+				switch {
+				case button.RequestLocation:
+					buttons = append(buttons, button.Text+"|request_location")
+				case button.RequestContact:
+					buttons = append(buttons, button.Text+"|request_contact")
+				default:
+					buttons = append(buttons, button.Text)
+				}
+			}
+		}
+	case tgbotapi.ReplyKeyboardRemove:
+		buttons = append(buttons, "remove_keyboard")
+	}
+	return buttons
+}
+
+// Step is the outcome of replaying one update
+type Step struct {
+	Record   Record
+	UserID   int64
+	Before   objects.MenuId // 0 when the user did not exist yet
+	After    objects.MenuId
+	Outgoing []Outgoing
+}
+
+// Player feeds recorded updates through the menus against the given repository.
+// Nothing is sent to Telegram, outgoing traffic is collected per update instead.
+type Player struct {
+	context   *context.Context
+	publisher *capturePublisher
+	fanout    *fanout.FanoutService
+}
+
+// NewPlayer creates a player, the repository should be a test database or a MemoryRepository
+func NewPlayer(repo repository.Repository) *Player {
+	publisher := &capturePublisher{}
+	appContext := &context.Context{Repo: repo, RabbitPublish: publisher}
+	return &Player{
+		context:   appContext,
+		publisher: publisher,
+		fanout:    fanout.NewFanoutService(appContext),
+	}
+}
+
+// Play handles one recorded update like the producer would, including the fanout of posted exchanges
+func (p *Player) Play(record Record) Step {
+	step := Step{Record: record, UserID: updateUserID(record.Update)}
+	if user := p.context.Repo.FindUser(step.UserID); user != nil {
+		step.Before = user.MenuId
+	}
+
+	// Menus consume the message text, hand them a copy so the step keeps the recorded update
+	update := record.Update
+	if update.Message != nil {
+		message := *update.Message
+		update.Message = &message
+	}
+	menu.HandleUpdate(p.context, update)
+
+	// Relay and fanout jobs run in background in production, run them inline to keep the output ordered
+	p.fanout.RelayPendingEntries()
+	p.fanout.RunPendingJobs()
+
+	if user := p.context.Repo.FindUser(step.UserID); user != nil {
+		step.After = user.MenuId
+	}
+	step.Outgoing = p.publisher.take()
+	return step
+}
+
+// PlayAll plays the records in order and writes every step to out
+func (p *Player) PlayAll(records []Record, out io.Writer) {
+	log.Printf("[REPLAY] Replaying %d update(s)", len(records))
+	for i, record := range records {
+		WriteStep(out, i+1, p.Play(record))
+	}
+}
+
+// updateUserID returns the user an update belongs to, 0 for unsupported updates
+func updateUserID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return int64(update.CallbackQuery.From.ID)
+	}
+	return 0
+}
+
+// describeUpdate summarizes the incoming update on one line
+func describeUpdate(update tgbotapi.Update) string {
+	switch {
+	case update.CallbackQuery != nil:
+		return fmt.Sprintf("callback %q", update.CallbackQuery.Data)
+	case update.Message == nil:
+		return "unsupported update"
+	case update.Message.Location != nil:
+		return fmt.Sprintf("location %.5f,%.5f", update.Message.Location.Latitude, update.Message.Location.Longitude)
+	case update.Message.Contact != nil:
+		return fmt.Sprintf("contact %q", update.Message.Contact.PhoneNumber)
+	default:
+		return fmt.Sprintf("message %q", update.Message.Text)
+	}
+}
+
+// menuNames labels menu states in the replay output
+var menuNames = map[objects.MenuId]string{
+	objects.Menu_USComplianceCheck:       "USComplianceCheck",
+	objects.Menu_Blocked:                 "Blocked",
+	objects.Menu_Init:                    "Init",
+	objects.Menu_AskLocation:             "AskLocation",
+	objects.Menu_SelectRadius:            "SelectRadius",
+	objects.Menu_AskPhone:                "AskPhone",
+	objects.Menu_HistoricalFanoutExecute: "HistoricalFanoutExecute",
+	objects.Menu_HistoricalFanoutWait:    "HistoricalFanoutWait",
+	objects.Menu_Main:                    "Main",
+	objects.Menu_Amount:                  "Amount",
+	objects.Menu_Ban:                     "Ban",
+}
+
+func menuName(menuId objects.MenuId) string {
+	if menuId == 0 {
+		return "none"
+	}
+	if name, ok := menuNames[menuId]; ok {
+		return fmt.Sprintf("%s(%d)", name, menuId)
+	}
+	return fmt.Sprintf("%d", menuId)
+}
+
+// WriteStep prints a replayed update with its state transition and outgoing traffic
+func WriteStep(out io.Writer, n int, step Step) {
+	fmt.Fprintf(out, "#%d update=%d user=%d at=%s %s\n", n, step.Record.Update.UpdateID, step.UserID,
+		step.Record.RecordedAt.Format("2006-01-02T15:04:05Z"), describeUpdate(step.Record.Update))
+
+	if step.Before != step.After {
+		fmt.Fprintf(out, "    state: %s -> %s\n", menuName(step.Before), menuName(step.After))
+	} else {
+		fmt.Fprintf(out, "    state: %s (unchanged)\n", menuName(step.After))
+	}
+
+	for _, outgoing := range step.Outgoing {
+		if outgoing.Kind == "callback_answer" {
+			fmt.Fprintf(out, "    -> %s %q\n", outgoing.Kind, outgoing.Text)
+			continue
+		}
+		fmt.Fprintf(out, "    -> %s chat=%d priority=%d %q\n", outgoing.Kind, outgoing.ChatID, outgoing.Priority, outgoing.Text)
+		if len(outgoing.Buttons) > 0 {
+			fmt.Fprintf(out, "       buttons: [%s]\n", strings.Join(outgoing.Buttons, "] ["))
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package replay records incoming Telegram updates as JSONL and plays them back through the menus,
+// so flows reported by users can be reproduced locally.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Record is one line of a recording
+type Record struct {
+	RecordedAt time.Time       `json:"recorded_at"`
+	Update     tgbotapi.Update `json:"update"`
+}
+
+// Redaction selects which personal data is removed before an update is written.
+// User and chat IDs are always kept, replay needs them to follow a user through the menus.
+type Redaction struct {
+	Names     bool // first and last names of users, chats and shared contacts
+	Usernames bool // @usernames, replaced by user<id>
+	Phones    bool // shared phone numbers, digits replaced by 0
+	Locations bool // shared locations, rounded to ~1 km
+	Text      bool // free text, commands are kept
+}
+
+// redactionOptions maps record_redact config values to redaction switches
+var redactionOptions = map[string]func(*Redaction){
+	"names":     func(r *Redaction) { r.Names = true },
+	"usernames": func(r *Redaction) { r.Usernames = true },
+	"phones":    func(r *Redaction) { r.Phones = true },
+	"locations": func(r *Redaction) { r.Locations = true },
+	"text":      func(r *Redaction) { r.Text = true },
+	"all": func(r *Redaction) {
+		*r = Redaction{Names: true, Usernames: true, Phones: true, Locations: true, Text: true}
+	},
+}
+
+// ParseRedaction builds a Redaction from option names such as "names" or "phones", "all" enables everything
+func ParseRedaction(options []string) (Redaction, error) {
+	var redaction Redaction
+	for _, option := range options {
+		enable, ok := redactionOptions[strings.ToLower(strings.TrimSpace(option))]
+		if !ok {
+			return Redaction{}, fmt.Errorf("unknown redaction option %q", option)
+		}
+		enable(&redaction)
+	}
+	return redaction, nil
+}
+
+// locationPrecision is the number of decimals kept for redacted coordinates (~1.1 km at the equator)
+const locationPrecision = 2
+
+const (
+	redactedName = "Redacted"
+	redactedText = "[redacted]"
+)
+
+// Redact returns a copy of the update with the selected personal data removed
+func Redact(update tgbotapi.Update, redaction Redaction) (tgbotapi.Update, error) {
+	if redaction == (Redaction{}) {
+		return update, nil
+	}
+
+	// Deep copy through JSON so the caller's update is left untouched
+	raw, err := json.Marshal(update)
+	if err != nil {
+		return update, err
+	}
+	var redacted tgbotapi.Update
+	if err := json.Unmarshal(raw, &redacted); err != nil {
+		return update, err
+	}
+
+	redaction.message(redacted.Message)
+	redaction.message(redacted.EditedMessage)
+	if callback := redacted.CallbackQuery; callback != nil {
+		redaction.user(callback.From)
+		redaction.message(callback.Message)
+	}
+	return redacted, nil
+}
+
+func (r Redaction) user(user *tgbotapi.User) {
+	if user == nil {
+		return
+	}
+	if r.Names {
+		user.FirstName = redactedName
+		if user.LastName != "" {
+			user.LastName = redactedName
+		}
+	}
+	if r.Usernames && user.UserName != "" {
+		user.UserName = fmt.Sprintf("user%d", user.ID)
+	}
+}
+
+func (r Redaction) chat(chat *tgbotapi.Chat) {
+	if chat == nil {
+		return
+	}
+	if r.Names {
+		if chat.FirstName != "" {
+			chat.FirstName = redactedName
+		}
+		if chat.LastName != "" {
+			chat.LastName = redactedName
+		}
+	}
+	if r.Usernames && chat.UserName != "" {
+		chat.UserName = fmt.Sprintf("user%d", chat.ID)
+	}
+}
+
+func (r Redaction) message(message *tgbotapi.Message) {
+	if message == nil {
+		return
+	}
+
+	r.user(message.From)
+	r.user(message.ForwardFrom)
+	r.chat(message.Chat)
+	r.message(message.ReplyToMessage)
+
+	if contact := message.Contact; contact != nil {
+		if r.Names {
+			contact.FirstName = redactedName
+			if contact.LastName != "" {
+				contact.LastName = redactedName
+			}
+		}
+		if r.Phones {
+			contact.PhoneNumber = redactDigits(contact.PhoneNumber)
+		}
+	}
+
+	if location := message.Location; location != nil && r.Locations {
+		location.Latitude = roundCoordinate(location.Latitude)
+		location.Longitude = roundCoordinate(location.Longitude)
+	}
+
+	if r.Text && message.Text != "" && !message.IsCommand() {
+		message.Text = redactedText
+		message.Entities = nil
+	}
+	if r.Phones && !r.Text {
+		message.Text = redactPhoneNumbers(message.Text)
+	}
+}
+
+// redactDigits replaces every digit with 0, keeping the format of the number
+func redactDigits(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '0'
+		}
+		return r
+	}, value)
+}
+
+// redactPhoneNumbers zeroes runs of 7 or more digits (optionally starting with +) in free text,
+// e.g. phone numbers shown in contact reveal messages
+func redactPhoneNumbers(text string) string {
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		end := start
+		for end < len(runes) && (runes[end] >= '0' && runes[end] <= '9') {
+			end++
+		}
+		if end-start >= 7 {
+			for i := start; i < end; i++ {
+				runes[i] = '0'
+			}
+		}
+		if end == start {
+			end++
+		}
+		start = end
+	}
+	return string(runes)
+}
+
+func roundCoordinate(value float64) float64 {
+	factor := math.Pow(10, locationPrecision)
+	return math.Round(value*factor) / factor
+}
+
+// Recorder appends incoming updates to a JSONL file
+type Recorder struct {
+	mu        sync.Mutex
+	file      *os.File
+	encoder   *json.Encoder
+	redaction Redaction
+}
+
+// NewRecorder opens path for appending, creating it if needed
+func NewRecorder(path string, redaction Redaction) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording %s: %v", path, err)
+	}
+
+	log.Printf("[REPLAY] Recording incoming updates to %s (redaction: %+v)", path, redaction)
+	return &Recorder{
+		file:      file,
+		encoder:   json.NewEncoder(file),
+		redaction: redaction,
+	}, nil
+}
+
+// Record appends the redacted update as one JSON line
+func (r *Recorder) Record(update tgbotapi.Update) error {
+	redacted, err := Redact(update, r.redaction)
+	if err != nil {
+		return fmt.Errorf("failed to redact update %d: %v", update.UpdateID, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.encoder.Encode(Record{RecordedAt: time.Now().UTC(), Update: redacted})
+}
+
+// Close closes the recording file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
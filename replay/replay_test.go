@@ -0,0 +1,177 @@
+package replay
+
+import (
+	"bytes"
+	"librecash/objects"
+	"librecash/repository"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testUser = tgbotapi.User{ID: 42, FirstName: "Alice", LastName: "Smith", UserName: "alice", LanguageCode: "en"}
+
+func messageUpdate(updateID int, fill func(*tgbotapi.Message)) tgbotapi.Update {
+	from := testUser
+	message := &tgbotapi.Message{
+		MessageID: updateID,
+		From:      &from,
+		Chat:      &tgbotapi.Chat{ID: int64(from.ID), Type: "private", FirstName: from.FirstName, UserName: from.UserName},
+	}
+	fill(message)
+	return tgbotapi.Update{UpdateID: updateID, Message: message}
+}
+
+func callbackUpdate(updateID int, data string) tgbotapi.Update {
+	from := testUser
+	return tgbotapi.Update{UpdateID: updateID, CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "cb",
+		From: &from,
+		Data: data,
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			Chat:      &tgbotapi.Chat{ID: int64(from.ID)},
+			Text:      "Contact: +66800000001",
+		},
+	}}
+}
+
+func TestParseRedaction(t *testing.T) {
+	redaction, err := ParseRedaction([]string{"names", " Phones "})
+	require.NoError(t, err)
+	assert.Equal(t, Redaction{Names: true, Phones: true}, redaction)
+
+	redaction, err = ParseRedaction([]string{"all"})
+	require.NoError(t, err)
+	assert.True(t, redaction.Names && redaction.Usernames && redaction.Phones && redaction.Locations && redaction.Text)
+
+	_, err = ParseRedaction([]string{"emails"})
+	assert.Error(t, err)
+}
+
+func TestRedact(t *testing.T) {
+	contact := messageUpdate(1, func(m *tgbotapi.Message) {
+		m.Contact = &tgbotapi.Contact{PhoneNumber: "+66800000001", FirstName: "Alice", UserID: 42}
+	})
+	redaction := Redaction{Names: true, Usernames: true, Phones: true}
+
+	redacted, err := Redact(contact, redaction)
+	require.NoError(t, err)
+	assert.Equal(t, "+00000000000", redacted.Message.Contact.PhoneNumber)
+	assert.Equal(t, redactedName, redacted.Message.From.FirstName)
+	assert.Equal(t, redactedName, redacted.Message.From.LastName)
+	assert.Equal(t, "user42", redacted.Message.From.UserName)
+	assert.Equal(t, int64(42), redacted.Message.Chat.ID, "IDs are kept for replay")
+
+	// The original update is untouched
+	assert.Equal(t, "+66800000001", contact.Message.Contact.PhoneNumber)
+	assert.Equal(t, "Alice", contact.Message.From.FirstName)
+
+	// Phone numbers in bot messages attached to callbacks are redacted too
+	redacted, err = Redact(callbackUpdate(2, "contact:1"), redaction)
+	require.NoError(t, err)
+	assert.Equal(t, "Contact: +00000000000", redacted.CallbackQuery.Message.Text)
+	assert.Equal(t, "contact:1", redacted.CallbackQuery.Data)
+}
+
+func TestRedactLocationAndText(t *testing.T) {
+	redaction := Redaction{Locations: true, Text: true}
+
+	location, err := Redact(messageUpdate(1, func(m *tgbotapi.Message) {
+		m.Location = &tgbotapi.Location{Latitude: 13.756331, Longitude: 100.501765}
+	}), redaction)
+	require.NoError(t, err)
+	assert.Equal(t, 13.76, location.Message.Location.Latitude)
+	assert.Equal(t, 100.5, location.Message.Location.Longitude)
+
+	text, err := Redact(messageUpdate(2, func(m *tgbotapi.Message) { m.Text = "my secret" }), redaction)
+	require.NoError(t, err)
+	assert.Equal(t, redactedText, text.Message.Text)
+
+	command, err := Redact(messageUpdate(3, func(m *tgbotapi.Message) {
+		m.Text = "/start"
+		m.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 6}}
+	}), redaction)
+	require.NoError(t, err)
+	assert.Equal(t, "/start", command.Message.Text, "Commands are kept")
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "updates.jsonl")
+
+	recorder, err := NewRecorder(path, Redaction{Phones: true})
+	require.NoError(t, err)
+	require.NoError(t, recorder.Record(messageUpdate(1, func(m *tgbotapi.Message) { m.Text = "/start" })))
+	require.NoError(t, recorder.Record(callbackUpdate(2, "us_compliance_no")))
+	require.NoError(t, recorder.Close())
+
+	// Recording appends to an existing file
+	recorder, err = NewRecorder(path, Redaction{})
+	require.NoError(t, err)
+	require.NoError(t, recorder.Record(callbackUpdate(3, "radius_5")))
+	require.NoError(t, recorder.Close())
+
+	records, err := ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "/start", records[0].Update.Message.Text)
+	assert.Equal(t, "us_compliance_no", records[1].Update.CallbackQuery.Data)
+	assert.Equal(t, 3, records[2].Update.UpdateID)
+	assert.False(t, records[0].RecordedAt.IsZero())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Recordings may contain personal data")
+}
+
+func TestReadRecordsReportsBadLine(t *testing.T) {
+	_, err := ReadRecords(strings.NewReader("{\"update\":{\"update_id\":1}}\n\nnot json\n"))
+	assert.EqualError(t, err, "line 3: invalid character 'o' in literal null (expecting 'u')")
+}
+
+func TestPlayerReplaysOnboarding(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	player := NewPlayer(repo)
+
+	records := []Record{
+		{Update: messageUpdate(1, func(m *tgbotapi.Message) { m.Text = "/start" })},
+		{Update: callbackUpdate(2, "us_compliance_no")},
+		{Update: callbackUpdate(3, "radius_5")},
+		{Update: messageUpdate(4, func(m *tgbotapi.Message) {
+			m.Location = &tgbotapi.Location{Latitude: 13.7563, Longitude: 100.5018}
+		})},
+		{Update: messageUpdate(5, func(m *tgbotapi.Message) {
+			m.Contact = &tgbotapi.Contact{PhoneNumber: "+66800000001", UserID: 42}
+		})},
+	}
+
+	start := player.Play(records[0])
+	assert.Equal(t, int64(42), start.UserID)
+	assert.Equal(t, "/start", start.Record.Update.Message.Text)
+	assert.Equal(t, objects.MenuId(0), start.Before)
+	assert.Equal(t, objects.Menu_USComplianceCheck, start.After)
+	require.Len(t, start.Outgoing, 1)
+	assert.Equal(t, "message", start.Outgoing[0].Kind)
+	assert.Contains(t, start.Outgoing[0].Buttons[0], "|us_compliance_yes")
+
+	compliance := player.Play(records[1])
+	assert.Equal(t, objects.Menu_SelectRadius, compliance.After)
+	assert.Equal(t, "callback_answer", compliance.Outgoing[0].Kind)
+
+	var out bytes.Buffer
+	for i, record := range records[2:] {
+		WriteStep(&out, i+3, player.Play(record))
+	}
+	assert.Equal(t, objects.Menu_Main, repo.FindUser(42).MenuId)
+
+	output := out.String()
+	assert.Contains(t, output, "#3 update=3 user=42")
+	assert.Contains(t, output, "state: SelectRadius(250) -> AskLocation(200)")
+	assert.Contains(t, output, "|request_location")
+	assert.Contains(t, output, "state: AskPhone(275) -> Main(400)")
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"librecash/objects"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlerts(t *testing.T) {
+	repo, cleanup := setupTestDBForExchange(t)
+	defer cleanup()
+	if repo == nil {
+		return
+	}
+
+	_, err := repo.db.Exec(`DELETE FROM alerts`)
+	assert.NoError(t, err)
+	defer repo.db.Exec(`DELETE FROM alerts`)
+
+	for _, userID := range []int64{123481, 123482} {
+		assert.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main}))
+	}
+
+	draft := &objects.Alert{UserID: 123481, Name: "Work"}
+	require.NoError(t, repo.CreateAlert(draft))
+	assert.NotZero(t, draft.ID)
+
+	found, err := repo.GetAlertDraft(123481)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, draft.ID, found.ID)
+
+	limit := 50
+	draft.Lat, draft.Lon, draft.RadiusKm = 40.7589, -73.9851, 5
+	draft.Direction, draft.MaxAmountUSD, draft.Active = objects.ExchangeDirectionCashToCrypto, &limit, true
+	require.NoError(t, repo.UpdateAlert(draft))
+
+	found, err = repo.GetAlertDraft(123481)
+	require.NoError(t, err)
+	assert.Nil(t, found, "An active alert is not a draft")
+
+	alerts, err := repo.GetUserAlerts(123481)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "Work", alerts[0].Name)
+	assert.Equal(t, 5, alerts[0].RadiusKm)
+	assert.Equal(t, objects.ExchangeDirectionCashToCrypto, alerts[0].Direction)
+	if assert.NotNil(t, alerts[0].MaxAmountUSD) {
+		assert.Equal(t, 50, *alerts[0].MaxAmountUSD)
+	}
+
+	require.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 123481, Name: "Home"}))
+	byUser, err := repo.GetAlertsByUsers([]int64{123481, 123482})
+	require.NoError(t, err)
+	assert.Len(t, byUser[123481], 1, "Drafts are left out")
+	assert.NotContains(t, byUser, int64(123482), "Users without alerts are missing")
+
+	// The alert is ~5 km from the center, far from where the user lives
+	page, err := repo.FindUsersInRadiusPage(40.7128, -74.0060, 10, objects.FirstPage, 10)
+	require.NoError(t, err)
+	var matched *objects.NearbyUser
+	for _, nearby := range page {
+		if nearby.User.UserId == 123481 {
+			matched = nearby
+		}
+	}
+	if assert.NotNil(t, matched, "The user is found through the alert") {
+		assert.False(t, matched.ByLocation)
+	}
+
+	require.NoError(t, repo.DeleteAlertDrafts(123481))
+	found, err = repo.GetAlertDraft(123481)
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	require.NoError(t, repo.DeleteAlert(123482, draft.ID))
+	require.NoError(t, repo.DeleteAlert(123481, draft.ID))
+	alerts, err = repo.GetUserAlerts(123481)
+	require.NoError(t, err)
+	assert.Empty(t, alerts)
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func setupTestDBForExchange(t *testing.T) (*Repository, func()) {
+func setupTestDBForExchange(t *testing.T) (*PostgresRepository, func()) {
 	db := setupTestDB(t)
 	if db == nil {
 		return nil, func() {}
@@ -941,3 +941,60 @@ func TestLocationHistory(t *testing.T) {
 func intPtr(i int) *int {
 	return &i
 }
+
+func TestFindOffers(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	_, err := db.Exec(`DELETE FROM exchanges WHERE user_id IN (123501, 123502, 123503)`)
+	assert.NoError(t, err)
+
+	for _, userID := range []int64{123501, 123502, 123503} {
+		assert.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main}))
+	}
+
+	postExchange := func(userID int64, direction string, lon float64, amount int) *objects.Exchange {
+		exchange := &objects.Exchange{
+			UserID:            userID,
+			ExchangeDirection: direction,
+			Status:            objects.ExchangeStatusPosted,
+			AmountUSD:         &amount,
+			Lat:               13.7563,
+			Lon:               lon,
+		}
+		assert.NoError(t, repo.CreateExchange(exchange))
+		return exchange
+	}
+
+	near := postExchange(123502, objects.ExchangeDirectionCashToCrypto, 100.5100, 50)
+	far := postExchange(123503, objects.ExchangeDirectionCryptoToCash, 100.5480, 200)
+	postExchange(123501, objects.ExchangeDirectionCashToCrypto, 100.5018, 50) // own exchange
+
+	query := &objects.OfferQuery{Lat: 13.7563, Lon: 100.5018, RadiusKm: 10, ExcludeUserID: 123501,
+		Sort: objects.OfferSortDistance, Limit: 10}
+	exchanges, err := repo.FindOffers(query)
+	assert.NoError(t, err)
+	var ids []int64
+	for _, exchange := range exchanges {
+		if exchange.UserID == 123502 || exchange.UserID == 123503 {
+			ids = append(ids, exchange.ID)
+		}
+	}
+	assert.Equal(t, []int64{near.ID, far.ID}, ids, "Nearest first")
+
+	maxAmount := 100
+	query.Direction = objects.ExchangeDirectionCryptoToCash
+	query.MaxAmountUSD = &maxAmount
+	exchanges, err = repo.FindOffers(query)
+	assert.NoError(t, err)
+	ids = nil
+	for _, exchange := range exchanges {
+		ids = append(ids, exchange.ID)
+	}
+	assert.Contains(t, ids, near.ID)
+	assert.NotContains(t, ids, far.ID)
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"errors"
+	"librecash/objects"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanoutJobLifecycle(t *testing.T) {
+	repo, cleanup := setupTestDBForExchange(t)
+	defer cleanup()
+	if repo == nil {
+		return
+	}
+
+	_, err := repo.db.Exec(`DELETE FROM fanout_jobs`)
+	assert.NoError(t, err)
+
+	user := &objects.User{
+		UserId:       123461,
+		Username:     "fanoutjobuser",
+		LanguageCode: "en",
+		MenuId:       objects.Menu_Main,
+		Lat:          40.7128,
+		Lon:          -74.0060,
+	}
+	assert.NoError(t, repo.SaveUser(user))
+
+	exchange := objects.NewExchange(user.UserId, objects.ExchangeDirectionCashToCrypto, user.Lat, user.Lon)
+	assert.NoError(t, repo.CreateExchange(exchange))
+
+	// An exchange has one job, creating it again returns the existing one
+	job, err := repo.CreateFanoutJob(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, objects.FanoutJobPending, job.Status)
+	again, err := repo.CreateFanoutJob(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job.ID, again.ID)
+
+	jobs, err := repo.ClaimFanoutJobs(10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	claimed, err := repo.ClaimFanoutJobs(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed, "Leased jobs are not claimed twice")
+
+	// Progress survives a release, the next claim continues from it
+	job = jobs[0]
+	job.Found, job.Queued = 100, 90
+	job.Cursor = objects.RadiusCursor{DistanceM: 812.5, UserID: 4242}
+	assert.NoError(t, repo.SaveFanoutJobProgress(job, 0))
+	assert.NoError(t, repo.RecordFanoutJobDelivery(job.ID, true))
+	assert.NoError(t, repo.RecordFanoutJobDelivery(job.ID, false))
+
+	jobs, err = repo.ClaimFanoutJobs(10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, objects.RadiusCursor{DistanceM: 812.5, UserID: 4242}, jobs[0].Cursor)
+	assert.Equal(t, 100, jobs[0].Found)
+	assert.Equal(t, 90, jobs[0].Queued)
+	assert.Equal(t, 1, jobs[0].Sent)
+	assert.Equal(t, 1, jobs[0].Failed)
+
+	assert.NoError(t, repo.MarkFanoutJobFailed(job.ID, errors.New("broker down"), 0))
+	jobs, err = repo.ClaimFanoutJobs(10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 1, jobs[0].Attempts)
+	assert.Equal(t, "broker down", *jobs[0].LastError)
+
+	// Done jobs are never claimed again
+	job = jobs[0]
+	job.Found, job.Queued, job.Status = 250, 240, objects.FanoutJobDone
+	assert.NoError(t, repo.SaveFanoutJobProgress(job, 0))
+	jobs, err = repo.ClaimFanoutJobs(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	stored, err := repo.GetFanoutJobByExchange(exchange.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 240, stored.Queued)
+	assert.NotNil(t, stored.CompletedAt)
+
+	listed, err := repo.ListFanoutJobs(10)
+	assert.NoError(t, err)
+	assert.Len(t, listed, 1)
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"librecash/objects"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterests(t *testing.T) {
+	repo, cleanup := setupTestDBForExchange(t)
+	defer cleanup()
+	if repo == nil {
+		return
+	}
+
+	_, err := repo.db.Exec(`DELETE FROM interests`)
+	assert.NoError(t, err)
+
+	for _, userID := range []int64{123471, 123472} {
+		assert.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main}))
+	}
+
+	limit := 50
+	assert.NoError(t, repo.SaveInterest(&objects.Interest{UserID: 123471, Direction: objects.ExchangeDirectionCashToCrypto, Asset: objects.AssetUSDT, MaxAmountUSD: &limit}))
+	assert.NoError(t, repo.SaveInterest(&objects.Interest{UserID: 123471, Direction: objects.ExchangeDirectionCryptoToCash, Asset: objects.AssetUSDT}))
+
+	// Saving the same direction and asset again replaces the limit
+	replaced := &objects.Interest{UserID: 123471, Direction: objects.ExchangeDirectionCashToCrypto, Asset: objects.AssetUSDT}
+	assert.NoError(t, repo.SaveInterest(replaced))
+
+	interests, err := repo.GetUserInterests(123471)
+	require.NoError(t, err)
+	require.Len(t, interests, 2)
+	assert.Equal(t, replaced.ID, interests[0].ID)
+	assert.Nil(t, interests[0].MaxAmountUSD)
+
+	byUser, err := repo.GetInterestsByUsers([]int64{123471, 123472})
+	require.NoError(t, err)
+	assert.Len(t, byUser[123471], 2)
+	assert.NotContains(t, byUser, int64(123472), "Users without interests are missing")
+
+	assert.NoError(t, repo.DeleteUserInterests(123471))
+	interests, err = repo.GetUserInterests(123471)
+	assert.NoError(t, err)
+	assert.Empty(t, interests)
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"librecash/objects"
+	"time"
+)
+
+// Repository is the storage used by menus, fanout and the sender.
+// PostgresRepository is the production implementation, MemoryRepository serves tests.
+type Repository interface {
+	// Users
+	FindUser(userId int64) *objects.User
+	SaveUser(user *objects.User) error
+	ShowCallout(userId int64, featureName string) bool
+	DismissCallout(userId int64, featureName string) error
+	UpdateUserLocation(userId int64, lon, lat float64) (bool, error)
+	UpdateUserSearchRadius(userId int64, radiusKm int) error
+	SetUserUnreachable(userId int64, unreachable bool) error
+
+	// Exchanges
+	CreateExchange(exchange *objects.Exchange) error
+	GetExchangeByID(id int64) (*objects.Exchange, error)
+	GetUserExchanges(userID int64) ([]*objects.Exchange, error)
+	UpdateExchangeStatus(id int64, status string) error
+	GetLastUserExchange(userID int64) (*objects.Exchange, error)
+	UpdateExchange(exchange *objects.Exchange) error
+	PostExchange(exchange *objects.Exchange) error
+	SoftDeleteExchange(exchangeID int64) error
+	GetActiveExchanges() ([]*objects.Exchange, error)
+
+	// Timeline records
+	CreateTimelineRecord(record *objects.TimelineRecord) error
+	GetTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error)
+	UpdateTimelineRecord(id int64, telegramMessageID int, status string) error
+	UpdateTimelineRecordStatus(id int64, status string) error
+	MarkTimelineRecordsAsDeleted(exchangeID int64) error
+	SoftDeleteExchangeTimeline(exchangeID int64) error
+	GetActiveTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error)
+	CreateDeferredTimelineRecord(record *objects.TimelineRecord) error
+	GetDeferredTimelineRecords(recipientUserID int64) ([]*objects.TimelineRecord, error)
+	ClaimDeferredTimelineRecords(recipientUserID int64) ([]*objects.TimelineRecord, error)
+	HoldTimelineRecord(id int64, releaseAt time.Time) error
+	ClaimHeldTimelineRecords(now time.Time, limit int) ([]*objects.TimelineRecord, error)
+
+	// Proximity
+	FindUsersInRadius(lat, lon float64, radiusKm int) ([]*objects.User, error)
+	FindUsersInRadiusPage(lat, lon float64, radiusKm int, after objects.RadiusCursor, limit int) ([]*objects.NearbyUser, error)
+	CountUsersInRadius(lat, lon float64, radiusKm int) (int, error)
+	FindHistoricalExchangesInRadius(lat, lon float64, radiusKm int, excludeUserID int64) ([]*objects.Exchange, error)
+	FindOffers(query *objects.OfferQuery) ([]*objects.Exchange, error)
+
+	// Contact requests
+	CheckContactRequestExists(exchangeID, requesterUserID int64) (bool, error)
+	CreateContactRequest(exchangeID, requesterUserID int64, username, firstName, lastName string) error
+
+	// Location history
+	CreateLocationHistory(userID int64, radiusKm int) error
+	UpdateLocationHistory(userID int64, lat, lon float64) error
+	ShouldTriggerHistoricalFanout(userID int64) (bool, error)
+
+	// Interests
+	SaveInterest(interest *objects.Interest) error
+	GetUserInterests(userID int64) ([]*objects.Interest, error)
+	GetInterestsByUsers(userIDs []int64) (map[int64][]*objects.Interest, error)
+	DeleteUserInterests(userID int64) error
+
+	// Alerts
+	CreateAlert(alert *objects.Alert) error
+	UpdateAlert(alert *objects.Alert) error
+	GetAlertDraft(userID int64) (*objects.Alert, error)
+	GetUserAlerts(userID int64) ([]*objects.Alert, error)
+	GetAlertsByUsers(userIDs []int64) (map[int64][]*objects.Alert, error)
+	DeleteAlert(userID, alertID int64) error
+	DeleteAlertDrafts(userID int64) error
+
+	// Digests and overflow summaries
+	GetCollectedRecords(recipientUserID int64, status string) ([]*objects.TimelineRecord, error)
+	GetDueCollectedRecipients(status string, collectedBefore time.Time) ([]int64, error)
+	CountSentNotificationsByUsers(userIDs []int64, since time.Time) (map[int64]int, error)
+	CreateDigest(recipientUserID int64, recordIDs []int64) (*objects.Digest, error)
+	GetDigest(id int64) (*objects.Digest, error)
+	GetTimelineRecordsByDigest(digestID int64) ([]*objects.TimelineRecord, error)
+	RecordDigestDelivery(digestID int64, telegramMessageID *int, status string) error
+
+	// Notification settings
+	GetNotificationSettings(userID int64) (*objects.NotificationSettings, error)
+	GetNotificationSettingsByUsers(userIDs []int64) (map[int64]*objects.NotificationSettings, error)
+	SaveNotificationSettings(settings *objects.NotificationSettings) error
+
+	// Fanout outbox
+	ClaimOutboxEntries(limit int, lease time.Duration) ([]*objects.OutboxEntry, error)
+	MarkOutboxEntryPublished(id int64) error
+	MarkOutboxEntryFailed(id int64, relayErr error, retryIn time.Duration) error
+
+	// Fanout jobs
+	CreateFanoutJob(exchangeID int64) (*objects.FanoutJob, error)
+	ClaimFanoutJobs(limit int, lease time.Duration) ([]*objects.FanoutJob, error)
+	SaveFanoutJobProgress(job *objects.FanoutJob, lease time.Duration) error
+	MarkFanoutJobFailed(id int64, jobErr error, retryIn time.Duration) error
+	RecordFanoutJobDelivery(id int64, sent bool) error
+	GetFanoutJobByExchange(exchangeID int64) (*objects.FanoutJob, error)
+	ListFanoutJobs(limit int) ([]*objects.FanoutJob, error)
+
+	// Sent message deduplication
+	IsMessageSent(idempotencyKey string) (bool, error)
+	MarkMessageSent(idempotencyKey string) error
+	PruneSentMessages(maxAge time.Duration) (int64, error)
+}
+
+var (
+	_ Repository = (*PostgresRepository)(nil)
+	_ Repository = (*MemoryRepository)(nil)
+)
@@ -0,0 +1,1373 @@
+package repository
+
+import (
+	"fmt"
+	"librecash/geo"
+	"librecash/objects"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepository is an in-memory Repository for tests. Radius searches use the haversine
+// distance instead of PostGIS, so results may differ from PostgresRepository by a few meters.
+type MemoryRepository struct {
+	mu sync.Mutex
+
+	users             map[int64]*objects.User
+	dismissedCallouts map[int64]map[string]bool
+	exchanges         map[int64]*objects.Exchange
+	timelineRecords   []*objects.TimelineRecord
+	contactRequests   map[[2]int64]time.Time
+	locationHistories []*memoryLocationHistory
+	outbox            []*memoryOutboxEntry
+	interests         []*objects.Interest
+	alerts            []*objects.Alert
+	settings          map[int64]*objects.NotificationSettings
+	digests           []*objects.Digest
+	fanoutJobs        []*memoryFanoutJob
+	sentMessages      map[string]time.Time
+
+	nextID int64
+}
+
+type memoryLocationHistory struct {
+	userID   int64
+	radiusKm int
+	lat, lon float64
+}
+
+type memoryOutboxEntry struct {
+	entry       objects.OutboxEntry
+	lockedUntil time.Time
+}
+
+type memoryFanoutJob struct {
+	job         objects.FanoutJob
+	lockedUntil time.Time
+}
+
+// NewMemoryRepository creates an empty in-memory repository
+func NewMemoryRepository() *MemoryRepository {
+	log.Println("[REPOSITORY] In-memory repository initialized")
+	return &MemoryRepository{
+		users:             make(map[int64]*objects.User),
+		dismissedCallouts: make(map[int64]map[string]bool),
+		exchanges:         make(map[int64]*objects.Exchange),
+		contactRequests:   make(map[[2]int64]time.Time),
+		settings:          make(map[int64]*objects.NotificationSettings),
+		sentMessages:      make(map[string]time.Time),
+	}
+}
+
+func (repo *MemoryRepository) newID() int64 {
+	repo.nextID++
+	return repo.nextID
+}
+
+func copyUser(user *objects.User) *objects.User {
+	userCopy := *user
+	if user.SearchRadiusKm != nil {
+		radius := *user.SearchRadiusKm
+		userCopy.SearchRadiusKm = &radius
+	}
+	return &userCopy
+}
+
+func copyExchange(exchange *objects.Exchange) *objects.Exchange {
+	exchangeCopy := *exchange
+	if exchange.AmountUSD != nil {
+		amount := *exchange.AmountUSD
+		exchangeCopy.AmountUSD = &amount
+	}
+	return &exchangeCopy
+}
+
+func copyInterest(interest *objects.Interest) *objects.Interest {
+	interestCopy := *interest
+	if interest.MaxAmountUSD != nil {
+		amount := *interest.MaxAmountUSD
+		interestCopy.MaxAmountUSD = &amount
+	}
+	return &interestCopy
+}
+
+func copyAlert(alert *objects.Alert) *objects.Alert {
+	alertCopy := *alert
+	if alert.MaxAmountUSD != nil {
+		amount := *alert.MaxAmountUSD
+		alertCopy.MaxAmountUSD = &amount
+	}
+	return &alertCopy
+}
+
+func copyNotificationSettings(settings *objects.NotificationSettings) *objects.NotificationSettings {
+	settingsCopy := *settings
+	if settings.QuietStartHour != nil {
+		hour := *settings.QuietStartHour
+		settingsCopy.QuietStartHour = &hour
+	}
+	if settings.QuietEndHour != nil {
+		hour := *settings.QuietEndHour
+		settingsCopy.QuietEndHour = &hour
+	}
+	if settings.PausedUntil != nil {
+		until := *settings.PausedUntil
+		settingsCopy.PausedUntil = &until
+	}
+	return &settingsCopy
+}
+
+func copyTimelineRecord(record *objects.TimelineRecord) *objects.TimelineRecord {
+	recordCopy := *record
+	if record.TelegramMessageID != nil {
+		messageID := *record.TelegramMessageID
+		recordCopy.TelegramMessageID = &messageID
+	}
+	if record.DistanceKm != nil {
+		distance := *record.DistanceKm
+		recordCopy.DistanceKm = &distance
+	}
+	if record.ReleaseAt != nil {
+		releaseAt := *record.ReleaseAt
+		recordCopy.ReleaseAt = &releaseAt
+	}
+	if record.DigestID != nil {
+		digestID := *record.DigestID
+		recordCopy.DigestID = &digestID
+	}
+	if record.SentAt != nil {
+		sentAt := *record.SentAt
+		recordCopy.SentAt = &sentAt
+	}
+	return &recordCopy
+}
+
+func copyDigest(digest *objects.Digest) *objects.Digest {
+	digestCopy := *digest
+	if digest.TelegramMessageID != nil {
+		messageID := *digest.TelegramMessageID
+		digestCopy.TelegramMessageID = &messageID
+	}
+	if digest.SentAt != nil {
+		sentAt := *digest.SentAt
+		digestCopy.SentAt = &sentAt
+	}
+	return &digestCopy
+}
+
+// hasLocation mirrors the "geog" IS NOT NULL check of the SQL queries
+func hasLocation(user *objects.User) bool {
+	return user.Lat != 0 || user.Lon != 0
+}
+
+// radiusCovers reports whether a point is within radiusKm of the center, a nil radius covers all
+func radiusCovers(radiusKm *int, centerLat, centerLon, lat, lon float64) bool {
+	return radiusKm == nil || geo.WithinRadius(centerLat, centerLon, lat, lon, *radiusKm)
+}
+
+// authorRadiusCovers reports whether a point is within the search radius of the exchange's author
+func (repo *MemoryRepository) authorRadiusCovers(exchange *objects.Exchange, lat, lon float64) bool {
+	author := repo.users[exchange.UserID]
+	return author == nil || radiusCovers(author.SearchRadiusKm, exchange.Lat, exchange.Lon, lat, lon)
+}
+
+// Users
+
+func (repo *MemoryRepository) FindUser(userId int64) *objects.User {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	user, ok := repo.users[userId]
+	if !ok {
+		return nil
+	}
+	return copyUser(user)
+}
+
+func (repo *MemoryRepository) SaveUser(user *objects.User) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	saved := copyUser(user)
+	// The unreachable flag is only changed by SetUserUnreachable
+	saved.Unreachable = false
+	if existing, ok := repo.users[user.UserId]; ok {
+		saved.Unreachable = existing.Unreachable
+	}
+	repo.users[user.UserId] = saved
+	return nil
+}
+
+func (repo *MemoryRepository) ShowCallout(userId int64, featureName string) bool {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return !repo.dismissedCallouts[userId][featureName]
+}
+
+func (repo *MemoryRepository) DismissCallout(userId int64, featureName string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.dismissedCallouts[userId] == nil {
+		repo.dismissedCallouts[userId] = make(map[string]bool)
+	}
+	repo.dismissedCallouts[userId][featureName] = true
+	return nil
+}
+
+func (repo *MemoryRepository) UpdateUserLocation(userId int64, lon, lat float64) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	user, ok := repo.users[userId]
+	if !ok {
+		return false, nil
+	}
+
+	locationChanged := !hasLocation(user) || user.Lat != lat || user.Lon != lon
+	user.Lat = lat
+	user.Lon = lon
+
+	return locationChanged && user.SearchRadiusKm != nil, nil
+}
+
+func (repo *MemoryRepository) UpdateUserSearchRadius(userId int64, radiusKm int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if user, ok := repo.users[userId]; ok {
+		user.SearchRadiusKm = &radiusKm
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) SetUserUnreachable(userId int64, unreachable bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if user, ok := repo.users[userId]; ok {
+		user.Unreachable = unreachable
+	}
+	return nil
+}
+
+// Exchanges
+
+func (repo *MemoryRepository) CreateExchange(exchange *objects.Exchange) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if exchange.CreatedAt.IsZero() {
+		exchange.CreatedAt = time.Now().UTC()
+	}
+	if exchange.UpdatedAt.IsZero() {
+		exchange.UpdatedAt = time.Now().UTC()
+	}
+	exchange.ID = repo.newID()
+	repo.exchanges[exchange.ID] = copyExchange(exchange)
+	return nil
+}
+
+func (repo *MemoryRepository) GetExchangeByID(id int64) (*objects.Exchange, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	exchange, ok := repo.exchanges[id]
+	if !ok || exchange.IsDeleted {
+		return nil, nil
+	}
+	return copyExchange(exchange), nil
+}
+
+// exchangesWhere returns copies of matching exchanges, newest first
+func (repo *MemoryRepository) exchangesWhere(match func(*objects.Exchange) bool) []*objects.Exchange {
+	var exchanges []*objects.Exchange
+	for _, exchange := range repo.exchanges {
+		if match(exchange) {
+			exchanges = append(exchanges, copyExchange(exchange))
+		}
+	}
+	sort.Slice(exchanges, func(i, j int) bool {
+		if !exchanges[i].CreatedAt.Equal(exchanges[j].CreatedAt) {
+			return exchanges[i].CreatedAt.After(exchanges[j].CreatedAt)
+		}
+		return exchanges[i].ID > exchanges[j].ID
+	})
+	return exchanges
+}
+
+func (repo *MemoryRepository) GetUserExchanges(userID int64) ([]*objects.Exchange, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.exchangesWhere(func(exchange *objects.Exchange) bool {
+		return exchange.UserID == userID && !exchange.IsDeleted
+	}), nil
+}
+
+func (repo *MemoryRepository) UpdateExchangeStatus(id int64, status string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if exchange, ok := repo.exchanges[id]; ok {
+		exchange.Status = status
+		exchange.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) GetLastUserExchange(userID int64) (*objects.Exchange, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	exchanges := repo.exchangesWhere(func(exchange *objects.Exchange) bool {
+		return exchange.UserID == userID && !exchange.IsDeleted
+	})
+	if len(exchanges) == 0 {
+		return nil, nil
+	}
+	return exchanges[0], nil
+}
+
+func (repo *MemoryRepository) UpdateExchange(exchange *objects.Exchange) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	exchange.UpdatedAt = time.Now()
+	if existing, ok := repo.exchanges[exchange.ID]; ok {
+		updated := copyExchange(exchange)
+		updated.UserID = existing.UserID
+		updated.CreatedAt = existing.CreatedAt
+		repo.exchanges[exchange.ID] = updated
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) PostExchange(exchange *objects.Exchange) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing, ok := repo.exchanges[exchange.ID]
+	if !ok {
+		return fmt.Errorf("exchange %d not found", exchange.ID)
+	}
+
+	exchange.Status = objects.ExchangeStatusPosted
+	exchange.UpdatedAt = time.Now()
+	existing.Status = exchange.Status
+	existing.UpdatedAt = exchange.UpdatedAt
+	existing.AmountUSD = nil
+	if exchange.AmountUSD != nil {
+		amount := *exchange.AmountUSD
+		existing.AmountUSD = &amount
+	}
+
+	repo.outbox = append(repo.outbox, &memoryOutboxEntry{
+		entry: objects.OutboxEntry{
+			ID:         repo.newID(),
+			ExchangeID: exchange.ID,
+			CreatedAt:  time.Now(),
+		},
+	})
+	return nil
+}
+
+func (repo *MemoryRepository) SoftDeleteExchange(exchangeID int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if exchange, ok := repo.exchanges[exchangeID]; ok && !exchange.IsDeleted {
+		now := time.Now()
+		exchange.IsDeleted = true
+		exchange.DeletedAt = &now
+		exchange.UpdatedAt = now
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) GetActiveExchanges() ([]*objects.Exchange, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.exchangesWhere(func(exchange *objects.Exchange) bool {
+		return !exchange.IsDeleted
+	}), nil
+}
+
+// Timeline records
+
+func (repo *MemoryRepository) CreateTimelineRecord(record *objects.TimelineRecord) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	// Same upsert semantics as the unique (exchange_id, recipient_user_id) constraint
+	for _, existing := range repo.timelineRecords {
+		if existing.ExchangeID == record.ExchangeID && existing.RecipientUserID == record.RecipientUserID {
+			if record.TelegramMessageID != nil {
+				messageID := *record.TelegramMessageID
+				existing.TelegramMessageID = &messageID
+			}
+			if record.DistanceKm != nil {
+				distance := *record.DistanceKm
+				existing.DistanceKm = &distance
+			}
+			existing.Status = record.Status
+			// A deletion sticks, a replayed send must not bring the record back
+			existing.IsDeleted = existing.IsDeleted || record.IsDeleted
+			if existing.DeletedAt == nil && record.DeletedAt != nil {
+				deletedAt := *record.DeletedAt
+				existing.DeletedAt = &deletedAt
+			}
+			if existing.SentAt == nil && record.SentAt != nil {
+				sentAt := *record.SentAt
+				existing.SentAt = &sentAt
+			}
+			existing.UpdatedAt = record.UpdatedAt
+			record.ID = existing.ID
+			return nil
+		}
+	}
+
+	record.ID = repo.newID()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	repo.timelineRecords = append(repo.timelineRecords, copyTimelineRecord(record))
+	return nil
+}
+
+// timelineRecordsWhere returns copies of matching records, newest first
+func (repo *MemoryRepository) timelineRecordsWhere(match func(*objects.TimelineRecord) bool) []*objects.TimelineRecord {
+	var records []*objects.TimelineRecord
+	for i := len(repo.timelineRecords) - 1; i >= 0; i-- {
+		if match(repo.timelineRecords[i]) {
+			records = append(records, copyTimelineRecord(repo.timelineRecords[i]))
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedAt.After(records[j].CreatedAt)
+	})
+	return records
+}
+
+func (repo *MemoryRepository) GetTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.timelineRecordsWhere(func(record *objects.TimelineRecord) bool {
+		return record.ExchangeID == exchangeID
+	}), nil
+}
+
+func (repo *MemoryRepository) UpdateTimelineRecord(id int64, telegramMessageID int, status string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, record := range repo.timelineRecords {
+		if record.ID == id {
+			record.TelegramMessageID = &telegramMessageID
+			record.Status = status
+			record.UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) UpdateTimelineRecordStatus(id int64, status string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, record := range repo.timelineRecords {
+		if record.ID == id {
+			record.Status = status
+			record.UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) MarkTimelineRecordsAsDeleted(exchangeID int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	for _, record := range repo.timelineRecords {
+		if record.ExchangeID == exchangeID && !record.IsDeleted {
+			record.IsDeleted = true
+			record.DeletedAt = &now
+			record.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) SoftDeleteExchangeTimeline(exchangeID int64) error {
+	return repo.MarkTimelineRecordsAsDeleted(exchangeID)
+}
+
+func (repo *MemoryRepository) GetActiveTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.timelineRecordsWhere(func(record *objects.TimelineRecord) bool {
+		return record.ExchangeID == exchangeID && !record.IsDeleted
+	}), nil
+}
+
+// CreateDeferredTimelineRecord stores a deferred or held record unless the recipient already has
+// one for the exchange, e.g. a historical notification that was sent already
+func (repo *MemoryRepository) CreateDeferredTimelineRecord(record *objects.TimelineRecord) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, existing := range repo.timelineRecords {
+		if existing.ExchangeID == record.ExchangeID && existing.RecipientUserID == record.RecipientUserID {
+			return nil
+		}
+	}
+
+	record.ID = repo.newID()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	repo.timelineRecords = append(repo.timelineRecords, copyTimelineRecord(record))
+	return nil
+}
+
+func (repo *MemoryRepository) GetDeferredTimelineRecords(recipientUserID int64) ([]*objects.TimelineRecord, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var records []*objects.TimelineRecord
+	for _, record := range repo.timelineRecords {
+		if record.RecipientUserID == recipientUserID && record.Status == objects.TimelineStatusDeferred && !record.IsDeleted {
+			records = append(records, copyTimelineRecord(record))
+		}
+	}
+	return records, nil
+}
+
+// ClaimDeferredTimelineRecords flips the deferred records of a recipient to pending and returns
+// them, oldest first
+func (repo *MemoryRepository) ClaimDeferredTimelineRecords(recipientUserID int64) ([]*objects.TimelineRecord, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var records []*objects.TimelineRecord
+	for _, record := range repo.timelineRecords {
+		if record.RecipientUserID == recipientUserID && record.Status == objects.TimelineStatusDeferred && !record.IsDeleted {
+			record.Status = objects.TimelineStatusPending
+			record.UpdatedAt = time.Now()
+			records = append(records, copyTimelineRecord(record))
+		}
+	}
+	return records, nil
+}
+
+func (repo *MemoryRepository) HoldTimelineRecord(id int64, releaseAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, record := range repo.timelineRecords {
+		if record.ID == id {
+			record.Status = objects.TimelineStatusHeld
+			record.ReleaseAt = &releaseAt
+			record.UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// ClaimHeldTimelineRecords flips the held records due at now to pending and returns them,
+// oldest release first
+func (repo *MemoryRepository) ClaimHeldTimelineRecords(now time.Time, limit int) ([]*objects.TimelineRecord, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var due []*objects.TimelineRecord
+	for _, record := range repo.timelineRecords {
+		if record.Status == objects.TimelineStatusHeld && !record.IsDeleted &&
+			record.ReleaseAt != nil && !record.ReleaseAt.After(now) {
+			due = append(due, record)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].ReleaseAt.Before(*due[j].ReleaseAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	records := make([]*objects.TimelineRecord, len(due))
+	for i, record := range due {
+		record.Status = objects.TimelineStatusPending
+		record.UpdatedAt = now
+		records[i] = copyTimelineRecord(record)
+	}
+	return records, nil
+}
+
+// Proximity
+
+// usersInRadius returns reachable located users within radiusKm, closest first and by user ID
+// at equal distance, the order fanout jobs resume in
+func (repo *MemoryRepository) usersInRadius(lat, lon float64, radiusKm int) []*objects.User {
+	var users []*objects.User
+	for _, user := range repo.users {
+		if !hasLocation(user) || user.Unreachable {
+			continue
+		}
+		if geo.WithinRadius(lat, lon, user.Lat, user.Lon, radiusKm) {
+			users = append(users, copyUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		di := geo.DistanceKm(lat, lon, users[i].Lat, users[i].Lon)
+		dj := geo.DistanceKm(lat, lon, users[j].Lat, users[j].Lon)
+		if di != dj {
+			return di < dj
+		}
+		return users[i].UserId < users[j].UserId
+	})
+	return users
+}
+
+func (repo *MemoryRepository) FindUsersInRadius(lat, lon float64, radiusKm int) ([]*objects.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.usersInRadius(lat, lon, radiusKm), nil
+}
+
+func (repo *MemoryRepository) FindUsersInRadiusPage(lat, lon float64, radiusKm int, after objects.RadiusCursor, limit int) ([]*objects.NearbyUser, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	// Closest matching point of every user, by location or by an active alert
+	matches := make(map[int64]*objects.NearbyUser)
+	match := func(user *objects.User, distance float64, byLocation bool) {
+		nearby, ok := matches[user.UserId]
+		if !ok {
+			nearby = &objects.NearbyUser{User: copyUser(user), DistanceM: distance}
+			matches[user.UserId] = nearby
+		}
+		if distance < nearby.DistanceM {
+			nearby.DistanceM = distance
+		}
+		nearby.ByLocation = nearby.ByLocation || byLocation
+	}
+	for _, user := range repo.usersInRadius(lat, lon, radiusKm) {
+		if radiusCovers(user.SearchRadiusKm, user.Lat, user.Lon, lat, lon) {
+			match(user, geo.DistanceKm(lat, lon, user.Lat, user.Lon)*1000, true)
+		}
+	}
+	for _, alert := range repo.alerts {
+		user := repo.users[alert.UserID]
+		if !alert.Active || user == nil || user.Unreachable {
+			continue
+		}
+		if geo.WithinRadius(lat, lon, alert.Lat, alert.Lon, radiusKm) && geo.WithinRadius(lat, lon, alert.Lat, alert.Lon, alert.RadiusKm) {
+			match(user, geo.DistanceKm(lat, lon, alert.Lat, alert.Lon)*1000, false)
+		}
+	}
+
+	var page []*objects.NearbyUser
+	for _, nearby := range matches {
+		if nearby.Cursor().After(after) {
+			page = append(page, nearby)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool { return page[j].Cursor().After(page[i].Cursor()) })
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page, nil
+}
+
+func (repo *MemoryRepository) CountUsersInRadius(lat, lon float64, radiusKm int) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return len(repo.usersInRadius(lat, lon, radiusKm)), nil
+}
+
+func (repo *MemoryRepository) FindHistoricalExchangesInRadius(lat, lon float64, radiusKm int, excludeUserID int64) ([]*objects.Exchange, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	// Same widening time windows as the SQL implementation
+	for _, days := range []int{3, 7, 14, 30} {
+		since := time.Now().AddDate(0, 0, -days)
+
+		// Newest exchange of every author
+		latestByUser := make(map[int64]*objects.Exchange)
+		for _, exchange := range repo.exchangesWhere(func(exchange *objects.Exchange) bool {
+			return !exchange.IsDeleted &&
+				exchange.Status == objects.ExchangeStatusPosted &&
+				exchange.UserID != excludeUserID &&
+				!exchange.CreatedAt.Before(since) &&
+				geo.WithinRadius(lat, lon, exchange.Lat, exchange.Lon, radiusKm) &&
+				repo.authorRadiusCovers(exchange, lat, lon)
+		}) {
+			if _, ok := latestByUser[exchange.UserID]; !ok {
+				latestByUser[exchange.UserID] = exchange
+			}
+		}
+
+		if len(latestByUser) == 0 {
+			continue
+		}
+
+		var exchanges []*objects.Exchange
+		for _, exchange := range latestByUser {
+			exchanges = append(exchanges, exchange)
+		}
+		sort.Slice(exchanges, func(i, j int) bool {
+			return exchanges[i].CreatedAt.Before(exchanges[j].CreatedAt)
+		})
+		if len(exchanges) > 10 {
+			exchanges = exchanges[:10]
+		}
+		return exchanges, nil
+	}
+
+	return []*objects.Exchange{}, nil
+}
+
+func (repo *MemoryRepository) FindOffers(query *objects.OfferQuery) ([]*objects.Exchange, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	exchanges := repo.exchangesWhere(func(exchange *objects.Exchange) bool {
+		return !exchange.IsDeleted &&
+			exchange.Status == objects.ExchangeStatusPosted &&
+			exchange.UserID != query.ExcludeUserID &&
+			geo.WithinRadius(query.Lat, query.Lon, exchange.Lat, exchange.Lon, query.RadiusKm) &&
+			repo.authorRadiusCovers(exchange, query.Lat, query.Lon) &&
+			query.MatchesFilters(exchange)
+	})
+
+	// exchangesWhere returns the newest first, which is the recent order
+	if query.Sort != objects.OfferSortRecent {
+		sort.Slice(exchanges, func(i, j int) bool {
+			di := geo.DistanceKm(query.Lat, query.Lon, exchanges[i].Lat, exchanges[i].Lon)
+			dj := geo.DistanceKm(query.Lat, query.Lon, exchanges[j].Lat, exchanges[j].Lon)
+			if di != dj {
+				return di < dj
+			}
+			return exchanges[i].ID < exchanges[j].ID
+		})
+	}
+
+	if query.Offset >= len(exchanges) {
+		return []*objects.Exchange{}, nil
+	}
+	exchanges = exchanges[query.Offset:]
+	if query.Limit > 0 && len(exchanges) > query.Limit {
+		exchanges = exchanges[:query.Limit]
+	}
+	return exchanges, nil
+}
+
+// Contact requests
+
+func (repo *MemoryRepository) CheckContactRequestExists(exchangeID, requesterUserID int64) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	_, exists := repo.contactRequests[[2]int64{exchangeID, requesterUserID}]
+	return exists, nil
+}
+
+func (repo *MemoryRepository) CreateContactRequest(exchangeID, requesterUserID int64, username, firstName, lastName string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	key := [2]int64{exchangeID, requesterUserID}
+	if _, exists := repo.contactRequests[key]; !exists {
+		repo.contactRequests[key] = time.Now()
+	}
+	return nil
+}
+
+// Location history
+
+func (repo *MemoryRepository) CreateLocationHistory(userID int64, radiusKm int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.locationHistories = append(repo.locationHistories, &memoryLocationHistory{userID: userID, radiusKm: radiusKm})
+	return nil
+}
+
+// lastLocationHistories returns the newest location histories of the user, newest first
+func (repo *MemoryRepository) lastLocationHistories(userID int64, limit int) []*memoryLocationHistory {
+	var histories []*memoryLocationHistory
+	for i := len(repo.locationHistories) - 1; i >= 0 && len(histories) < limit; i-- {
+		if repo.locationHistories[i].userID == userID {
+			histories = append(histories, repo.locationHistories[i])
+		}
+	}
+	return histories
+}
+
+func (repo *MemoryRepository) UpdateLocationHistory(userID int64, lat, lon float64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	histories := repo.lastLocationHistories(userID, 1)
+	if len(histories) == 0 {
+		return fmt.Errorf("no location history record found for user %d", userID)
+	}
+	histories[0].lat = lat
+	histories[0].lon = lon
+	return nil
+}
+
+func (repo *MemoryRepository) ShouldTriggerHistoricalFanout(userID int64) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	histories := repo.lastLocationHistories(userID, 2)
+	switch len(histories) {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		latest, previous := histories[0], histories[1]
+		return latest.radiusKm != previous.radiusKm || latest.lat != previous.lat || latest.lon != previous.lon, nil
+	}
+}
+
+// Interests
+
+func (repo *MemoryRepository) SaveInterest(interest *objects.Interest) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i, existing := range repo.interests {
+		if existing.UserID == interest.UserID && existing.Direction == interest.Direction && existing.Asset == interest.Asset {
+			interest.ID, interest.CreatedAt = existing.ID, existing.CreatedAt
+			repo.interests[i] = copyInterest(interest)
+			return nil
+		}
+	}
+
+	interest.ID = repo.newID()
+	interest.CreatedAt = time.Now()
+	repo.interests = append(repo.interests, copyInterest(interest))
+	return nil
+}
+
+func (repo *MemoryRepository) GetUserInterests(userID int64) ([]*objects.Interest, error) {
+	interests, err := repo.GetInterestsByUsers([]int64{userID})
+	return interests[userID], err
+}
+
+func (repo *MemoryRepository) GetInterestsByUsers(userIDs []int64) (map[int64][]*objects.Interest, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	wanted := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		wanted[userID] = true
+	}
+
+	interests := make(map[int64][]*objects.Interest)
+	for _, interest := range repo.interests {
+		if wanted[interest.UserID] {
+			interests[interest.UserID] = append(interests[interest.UserID], copyInterest(interest))
+		}
+	}
+	return interests, nil
+}
+
+func (repo *MemoryRepository) DeleteUserInterests(userID int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	kept := repo.interests[:0]
+	for _, interest := range repo.interests {
+		if interest.UserID != userID {
+			kept = append(kept, interest)
+		}
+	}
+	repo.interests = kept
+	return nil
+}
+
+// Alerts
+
+func (repo *MemoryRepository) CreateAlert(alert *objects.Alert) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	alert.ID = repo.newID()
+	alert.CreatedAt = time.Now()
+	repo.alerts = append(repo.alerts, copyAlert(alert))
+	return nil
+}
+
+func (repo *MemoryRepository) UpdateAlert(alert *objects.Alert) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i, existing := range repo.alerts {
+		if existing.ID == alert.ID {
+			repo.alerts[i] = copyAlert(alert)
+			return nil
+		}
+	}
+	return fmt.Errorf("alert %d not found", alert.ID)
+}
+
+func (repo *MemoryRepository) GetAlertDraft(userID int64) (*objects.Alert, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := len(repo.alerts) - 1; i >= 0; i-- {
+		if alert := repo.alerts[i]; alert.UserID == userID && !alert.Active {
+			return copyAlert(alert), nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *MemoryRepository) GetUserAlerts(userID int64) ([]*objects.Alert, error) {
+	alerts, err := repo.GetAlertsByUsers([]int64{userID})
+	return alerts[userID], err
+}
+
+func (repo *MemoryRepository) GetAlertsByUsers(userIDs []int64) (map[int64][]*objects.Alert, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	wanted := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		wanted[userID] = true
+	}
+
+	alerts := make(map[int64][]*objects.Alert)
+	for _, alert := range repo.alerts {
+		if alert.Active && wanted[alert.UserID] {
+			alerts[alert.UserID] = append(alerts[alert.UserID], copyAlert(alert))
+		}
+	}
+	return alerts, nil
+}
+
+// removeAlerts drops the alerts matching drop
+func (repo *MemoryRepository) removeAlerts(drop func(alert *objects.Alert) bool) {
+	kept := repo.alerts[:0]
+	for _, alert := range repo.alerts {
+		if !drop(alert) {
+			kept = append(kept, alert)
+		}
+	}
+	repo.alerts = kept
+}
+
+func (repo *MemoryRepository) DeleteAlert(userID, alertID int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.removeAlerts(func(alert *objects.Alert) bool {
+		return alert.ID == alertID && alert.UserID == userID
+	})
+	return nil
+}
+
+func (repo *MemoryRepository) DeleteAlertDrafts(userID int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.removeAlerts(func(alert *objects.Alert) bool {
+		return alert.UserID == userID && !alert.Active
+	})
+	return nil
+}
+
+// Digests and overflow summaries
+
+// GetCollectedRecords returns the records with the given status collected for the recipient's
+// next digest or overflow summary, oldest first
+func (repo *MemoryRepository) GetCollectedRecords(recipientUserID int64, status string) ([]*objects.TimelineRecord, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var records []*objects.TimelineRecord
+	for _, record := range repo.timelineRecords {
+		if record.RecipientUserID == recipientUserID && record.Status == status && !record.IsDeleted {
+			records = append(records, copyTimelineRecord(record))
+		}
+	}
+	return records, nil
+}
+
+// GetDueCollectedRecipients returns the users with a record of the given status collected at or
+// before collectedBefore
+func (repo *MemoryRepository) GetDueCollectedRecipients(status string, collectedBefore time.Time) ([]int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var userIDs []int64
+	due := make(map[int64]bool)
+	for _, record := range repo.timelineRecords {
+		if record.Status == status && !record.IsDeleted &&
+			!record.CreatedAt.After(collectedBefore) && !due[record.RecipientUserID] {
+			due[record.RecipientUserID] = true
+			userIDs = append(userIDs, record.RecipientUserID)
+		}
+	}
+	return userIDs, nil
+}
+
+// CountSentNotificationsByUsers counts the fanout notifications sent to each user since the given
+// time, not counting digests and the users' own exchanges
+func (repo *MemoryRepository) CountSentNotificationsByUsers(userIDs []int64, since time.Time) (map[int64]int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	wanted := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		wanted[userID] = true
+	}
+	counts := make(map[int64]int)
+	for _, record := range repo.timelineRecords {
+		if !wanted[record.RecipientUserID] || record.SentAt == nil ||
+			record.SentAt.Before(since) || record.DigestID != nil {
+			continue
+		}
+		if exchange, ok := repo.exchanges[record.ExchangeID]; ok && exchange.UserID == record.RecipientUserID {
+			continue
+		}
+		counts[record.RecipientUserID]++
+	}
+	return counts, nil
+}
+
+func (repo *MemoryRepository) CreateDigest(recipientUserID int64, recordIDs []int64) (*objects.Digest, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	digest := &objects.Digest{ID: repo.newID(), RecipientUserID: recipientUserID, CreatedAt: time.Now()}
+	repo.digests = append(repo.digests, copyDigest(digest))
+
+	inDigest := make(map[int64]bool, len(recordIDs))
+	for _, id := range recordIDs {
+		inDigest[id] = true
+	}
+	for _, record := range repo.timelineRecords {
+		if inDigest[record.ID] {
+			digestID := digest.ID
+			record.DigestID = &digestID
+			record.Status = objects.TimelineStatusPending
+			record.UpdatedAt = digest.CreatedAt
+		}
+	}
+	return digest, nil
+}
+
+func (repo *MemoryRepository) GetDigest(id int64) (*objects.Digest, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, digest := range repo.digests {
+		if digest.ID == id {
+			return copyDigest(digest), nil
+		}
+	}
+	return nil, nil
+}
+
+// GetTimelineRecordsByDigest returns the records of a digest in the order they were collected
+func (repo *MemoryRepository) GetTimelineRecordsByDigest(digestID int64) ([]*objects.TimelineRecord, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var records []*objects.TimelineRecord
+	for _, record := range repo.timelineRecords {
+		if record.DigestID != nil && *record.DigestID == digestID {
+			records = append(records, copyTimelineRecord(record))
+		}
+	}
+	return records, nil
+}
+
+func (repo *MemoryRepository) RecordDigestDelivery(digestID int64, telegramMessageID *int, status string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	for _, digest := range repo.digests {
+		if digest.ID == digestID && telegramMessageID != nil {
+			messageID := *telegramMessageID
+			digest.TelegramMessageID = &messageID
+			digest.SentAt = &now
+		}
+	}
+	for _, record := range repo.timelineRecords {
+		if record.DigestID != nil && *record.DigestID == digestID {
+			if telegramMessageID != nil {
+				messageID := *telegramMessageID
+				record.TelegramMessageID = &messageID
+			}
+			record.Status = status
+			record.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+// Notification settings
+
+func (repo *MemoryRepository) GetNotificationSettings(userID int64) (*objects.NotificationSettings, error) {
+	settings, err := repo.GetNotificationSettingsByUsers([]int64{userID})
+	return settings[userID], err
+}
+
+func (repo *MemoryRepository) GetNotificationSettingsByUsers(userIDs []int64) (map[int64]*objects.NotificationSettings, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	settings := make(map[int64]*objects.NotificationSettings, len(userIDs))
+	for _, userID := range userIDs {
+		if stored, ok := repo.settings[userID]; ok {
+			settings[userID] = copyNotificationSettings(stored)
+		} else {
+			settings[userID] = objects.DefaultNotificationSettings(userID)
+		}
+	}
+	return settings, nil
+}
+
+func (repo *MemoryRepository) SaveNotificationSettings(settings *objects.NotificationSettings) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	settings.UpdatedAt = time.Now()
+	repo.settings[settings.UserID] = copyNotificationSettings(settings)
+	return nil
+}
+
+// Fanout outbox
+
+func (repo *MemoryRepository) ClaimOutboxEntries(limit int, lease time.Duration) ([]*objects.OutboxEntry, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	var entries []*objects.OutboxEntry
+	for _, pending := range repo.outbox {
+		if len(entries) >= limit {
+			break
+		}
+		if pending.entry.PublishedAt != nil || pending.lockedUntil.After(now) {
+			continue
+		}
+		pending.lockedUntil = now.Add(lease)
+		entry := pending.entry
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+func (repo *MemoryRepository) findOutboxEntry(id int64) *memoryOutboxEntry {
+	for _, pending := range repo.outbox {
+		if pending.entry.ID == id {
+			return pending
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) MarkOutboxEntryPublished(id int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if pending := repo.findOutboxEntry(id); pending != nil {
+		now := time.Now()
+		pending.entry.PublishedAt = &now
+		pending.entry.Attempts++
+		pending.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) MarkOutboxEntryFailed(id int64, relayErr error, retryIn time.Duration) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if pending := repo.findOutboxEntry(id); pending != nil {
+		lastError := relayErr.Error()
+		pending.entry.Attempts++
+		pending.entry.LastError = &lastError
+		pending.lockedUntil = time.Now().Add(retryIn)
+	}
+	return nil
+}
+
+// Fanout jobs
+
+func (repo *MemoryRepository) findFanoutJob(match func(*memoryFanoutJob) bool) *memoryFanoutJob {
+	for _, pending := range repo.fanoutJobs {
+		if match(pending) {
+			return pending
+		}
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) CreateFanoutJob(exchangeID int64) (*objects.FanoutJob, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	existing := repo.findFanoutJob(func(pending *memoryFanoutJob) bool { return pending.job.ExchangeID == exchangeID })
+	if existing == nil {
+		now := time.Now()
+		existing = &memoryFanoutJob{job: objects.FanoutJob{
+			ID:         repo.newID(),
+			ExchangeID: exchangeID,
+			Status:     objects.FanoutJobPending,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}}
+		repo.fanoutJobs = append(repo.fanoutJobs, existing)
+	}
+	job := existing.job
+	return &job, nil
+}
+
+func (repo *MemoryRepository) ClaimFanoutJobs(limit int, lease time.Duration) ([]*objects.FanoutJob, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	var jobs []*objects.FanoutJob
+	for _, pending := range repo.fanoutJobs {
+		if len(jobs) >= limit {
+			break
+		}
+		if pending.job.Status != objects.FanoutJobPending || pending.lockedUntil.After(now) {
+			continue
+		}
+		pending.lockedUntil = now.Add(lease)
+		job := pending.job
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+func (repo *MemoryRepository) SaveFanoutJobProgress(job *objects.FanoutJob, lease time.Duration) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	pending := repo.findFanoutJob(func(pending *memoryFanoutJob) bool { return pending.job.ID == job.ID })
+	if pending == nil {
+		return nil
+	}
+	now := time.Now()
+	pending.job.Status = job.Status
+	pending.job.Cursor = job.Cursor
+	pending.job.Found = job.Found
+	pending.job.Queued = job.Queued
+	pending.job.UpdatedAt = now
+	pending.job.CompletedAt = nil
+	if job.Status == objects.FanoutJobDone {
+		pending.job.CompletedAt = &now
+	}
+	pending.lockedUntil = now.Add(lease)
+	return nil
+}
+
+func (repo *MemoryRepository) MarkFanoutJobFailed(id int64, jobErr error, retryIn time.Duration) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if pending := repo.findFanoutJob(func(pending *memoryFanoutJob) bool { return pending.job.ID == id }); pending != nil {
+		lastError := jobErr.Error()
+		pending.job.Attempts++
+		pending.job.LastError = &lastError
+		pending.job.UpdatedAt = time.Now()
+		pending.lockedUntil = time.Now().Add(retryIn)
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) RecordFanoutJobDelivery(id int64, sent bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if pending := repo.findFanoutJob(func(pending *memoryFanoutJob) bool { return pending.job.ID == id }); pending != nil {
+		if sent {
+			pending.job.Sent++
+		} else {
+			pending.job.Failed++
+		}
+		pending.job.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) GetFanoutJobByExchange(exchangeID int64) (*objects.FanoutJob, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	pending := repo.findFanoutJob(func(pending *memoryFanoutJob) bool { return pending.job.ExchangeID == exchangeID })
+	if pending == nil {
+		return nil, nil
+	}
+	job := pending.job
+	return &job, nil
+}
+
+func (repo *MemoryRepository) ListFanoutJobs(limit int) ([]*objects.FanoutJob, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var jobs []*objects.FanoutJob
+	for i := len(repo.fanoutJobs) - 1; i >= 0 && len(jobs) < limit; i-- {
+		job := repo.fanoutJobs[i].job
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+// Sent message deduplication
+
+func (repo *MemoryRepository) IsMessageSent(idempotencyKey string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	_, sent := repo.sentMessages[idempotencyKey]
+	return sent, nil
+}
+
+func (repo *MemoryRepository) MarkMessageSent(idempotencyKey string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, sent := repo.sentMessages[idempotencyKey]; !sent {
+		repo.sentMessages[idempotencyKey] = time.Now()
+	}
+	return nil
+}
+
+func (repo *MemoryRepository) PruneSentMessages(maxAge time.Duration) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var pruned int64
+	cutoff := time.Now().Add(-maxAge)
+	for key, sentAt := range repo.sentMessages {
+		if sentAt.Before(cutoff) {
+			delete(repo.sentMessages, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,602 @@
+package repository
+
+import (
+	"librecash/objects"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func saveLocatedUser(t *testing.T, repo *MemoryRepository, userID int64, lat, lon float64) {
+	radius := 10
+	err := repo.SaveUser(&objects.User{UserId: userID, Lat: lat, Lon: lon, SearchRadiusKm: &radius, MenuId: objects.Menu_Main})
+	assert.NoError(t, err)
+}
+
+func TestMemoryRepository_FindUsersInRadius(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	// Bangkok center, a user ~5 km away, Pattaya ~100 km away and a user without location
+	saveLocatedUser(t, repo, 1, 13.7563, 100.5018)
+	saveLocatedUser(t, repo, 2, 13.7563, 100.5480)
+	saveLocatedUser(t, repo, 3, 12.9236, 100.8825)
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: 4}))
+
+	users, err := repo.FindUsersInRadius(13.7563, 100.5018, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, int64(1), users[0].UserId, "Closest user should come first")
+		assert.Equal(t, int64(2), users[1].UserId)
+	}
+
+	count, err := repo.CountUsersInRadius(13.7563, 100.5018, 150)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Unreachable users are excluded like in the SQL implementation
+	assert.NoError(t, repo.SetUserUnreachable(2, true))
+	count, err = repo.CountUsersInRadius(13.7563, 100.5018, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemoryRepository_FindUsersInRadiusPage(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	// Three users at the same spot are ordered by ID, then the one ~5 km away
+	saveLocatedUser(t, repo, 3, 13.7563, 100.5018)
+	saveLocatedUser(t, repo, 1, 13.7563, 100.5018)
+	saveLocatedUser(t, repo, 2, 13.7563, 100.5018)
+	saveLocatedUser(t, repo, 4, 13.7563, 100.5480)
+
+	var seen []int64
+	cursor := objects.FirstPage
+	for {
+		page, err := repo.FindUsersInRadiusPage(13.7563, 100.5018, 10, cursor, 2)
+		assert.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(page), 2)
+		for _, nearby := range page {
+			seen = append(seen, nearby.User.UserId)
+			cursor = nearby.Cursor()
+		}
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4}, seen)
+	assert.Greater(t, cursor.DistanceM, 4000.0, "Distance is in meters")
+}
+
+func TestMemoryRepository_FindUsersInRadiusPageIsSymmetric(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	// All ~5 km from the center, each with a different own radius
+	small, large := 2, 10
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: 1, Lat: 13.7563, Lon: 100.5480, SearchRadiusKm: &small}))
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: 2, Lat: 13.7563, Lon: 100.5480, SearchRadiusKm: &large}))
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: 3, Lat: 13.7563, Lon: 100.5480}))
+
+	page, err := repo.FindUsersInRadiusPage(13.7563, 100.5018, 50, objects.FirstPage, 10)
+	assert.NoError(t, err)
+	var userIDs []int64
+	for _, nearby := range page {
+		userIDs = append(userIDs, nearby.User.UserId)
+	}
+	assert.Equal(t, []int64{2, 3}, userIDs, "A user whose own radius does not reach the center is left out")
+}
+
+func TestMemoryRepository_ReturnsCopies(t *testing.T) {
+	repo := NewMemoryRepository()
+	saveLocatedUser(t, repo, 1, 13.7563, 100.5018)
+
+	user := repo.FindUser(1)
+	user.MenuId = objects.Menu_Blocked
+	*user.SearchRadiusKm = 50
+
+	stored := repo.FindUser(1)
+	assert.Equal(t, objects.Menu_Main, stored.MenuId)
+	assert.Equal(t, 10, *stored.SearchRadiusKm)
+}
+
+func TestMemoryRepository_PostExchangeEnqueuesOutbox(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	exchange := objects.NewExchange(1, objects.ExchangeDirectionCashToCrypto, 13.7563, 100.5018)
+	assert.NoError(t, repo.CreateExchange(exchange))
+
+	amount := 100
+	exchange.AmountUSD = &amount
+	assert.NoError(t, repo.PostExchange(exchange))
+
+	stored, err := repo.GetExchangeByID(exchange.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, objects.ExchangeStatusPosted, stored.Status)
+	assert.Equal(t, 100, *stored.AmountUSD)
+
+	entries, err := repo.ClaimOutboxEntries(10, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, exchange.ID, entries[0].ExchangeID)
+	}
+
+	// Leased entries are not claimed twice
+	entries, err = repo.ClaimOutboxEntries(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestMemoryRepository_FanoutJobs(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	job, err := repo.CreateFanoutJob(7)
+	assert.NoError(t, err)
+	again, err := repo.CreateFanoutJob(7)
+	assert.NoError(t, err)
+	assert.Equal(t, job.ID, again.ID, "An exchange has one job")
+
+	jobs, err := repo.ClaimFanoutJobs(10, time.Minute)
+	assert.NoError(t, err)
+	if !assert.Len(t, jobs, 1) {
+		return
+	}
+	jobs[0].Found, jobs[0].Queued = 3, 3
+	jobs[0].Cursor = objects.RadiusCursor{DistanceM: 120, UserID: 5}
+	assert.NoError(t, repo.SaveFanoutJobProgress(jobs[0], time.Minute))
+	assert.NoError(t, repo.RecordFanoutJobDelivery(job.ID, true))
+
+	claimed, err := repo.ClaimFanoutJobs(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed, "Saving progress renews the lease")
+
+	stored, err := repo.GetFanoutJobByExchange(7)
+	assert.NoError(t, err)
+	assert.Equal(t, objects.RadiusCursor{DistanceM: 120, UserID: 5}, stored.Cursor)
+	assert.Equal(t, 3, stored.Found)
+	assert.Equal(t, 1, stored.Sent)
+
+	missing, err := repo.GetFanoutJobByExchange(8)
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestMemoryRepository_Interests(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	limit := 50
+	interest := &objects.Interest{UserID: 1, Direction: objects.ExchangeDirectionCashToCrypto, Asset: objects.AssetUSDT, MaxAmountUSD: &limit}
+	assert.NoError(t, repo.SaveInterest(interest))
+	assert.NoError(t, repo.SaveInterest(&objects.Interest{UserID: 2, Direction: objects.ExchangeDirectionCryptoToCash, Asset: objects.AssetUSDT}))
+
+	// Saving the same direction and asset again replaces the limit
+	replaced := &objects.Interest{UserID: 1, Direction: objects.ExchangeDirectionCashToCrypto, Asset: objects.AssetUSDT}
+	assert.NoError(t, repo.SaveInterest(replaced))
+	assert.Equal(t, interest.ID, replaced.ID)
+
+	byUser, err := repo.GetInterestsByUsers([]int64{1, 3})
+	assert.NoError(t, err)
+	if assert.Len(t, byUser[1], 1) {
+		assert.Nil(t, byUser[1][0].MaxAmountUSD)
+	}
+	assert.NotContains(t, byUser, int64(2), "Only the requested users are loaded")
+
+	assert.NoError(t, repo.DeleteUserInterests(1))
+	interests, err := repo.GetUserInterests(1)
+	assert.NoError(t, err)
+	assert.Empty(t, interests)
+	interests, err = repo.GetUserInterests(2)
+	assert.NoError(t, err)
+	assert.Len(t, interests, 1)
+}
+
+func TestMemoryRepository_Alerts(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	draft := &objects.Alert{UserID: 1, Name: "Work"}
+	assert.NoError(t, repo.CreateAlert(draft))
+	found, err := repo.GetAlertDraft(1)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, draft.ID, found.ID)
+	}
+
+	alerts, err := repo.GetUserAlerts(1)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts, "Drafts are not listed")
+
+	draft.Lat, draft.Lon, draft.RadiusKm, draft.Active = 13.7563, 100.5018, 5, true
+	assert.NoError(t, repo.UpdateAlert(draft))
+	found, err = repo.GetAlertDraft(1)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	assert.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 1, Name: "Home"}))
+	assert.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 2, Name: "Gym", Active: true}))
+	byUser, err := repo.GetAlertsByUsers([]int64{1})
+	assert.NoError(t, err)
+	if assert.Len(t, byUser[1], 1) {
+		assert.Equal(t, "Work", byUser[1][0].Name)
+	}
+	assert.NotContains(t, byUser, int64(2), "Only the requested users are loaded")
+
+	assert.NoError(t, repo.DeleteAlertDrafts(1))
+	found, err = repo.GetAlertDraft(1)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	assert.NoError(t, repo.DeleteAlert(2, draft.ID), "Deleting another user's alert is a no-op")
+	assert.NoError(t, repo.DeleteAlert(1, draft.ID))
+	alerts, err = repo.GetUserAlerts(1)
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+}
+
+func TestMemoryRepository_FindUsersInRadiusPageIncludesAlerts(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	// User 1 lives far away but has an alert ~5 km from the center, user 2 lives near the
+	// center and has an alert right at it, user 3's alert is too small to reach the center
+	saveLocatedUser(t, repo, 1, 12.9236, 100.8825)
+	saveLocatedUser(t, repo, 2, 13.7563, 100.5480)
+	saveLocatedUser(t, repo, 3, 12.9236, 100.8825)
+	assert.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 1, Name: "Work", Lat: 13.7563, Lon: 100.5480, RadiusKm: 15, Active: true}))
+	assert.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 2, Name: "Office", Lat: 13.7563, Lon: 100.5018, RadiusKm: 5, Active: true}))
+	assert.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 3, Name: "Gym", Lat: 13.7563, Lon: 100.5480, RadiusKm: 2, Active: true}))
+	assert.NoError(t, repo.CreateAlert(&objects.Alert{UserID: 3, Name: "Draft", Lat: 13.7563, Lon: 100.5018, RadiusKm: 50}))
+
+	page, err := repo.FindUsersInRadiusPage(13.7563, 100.5018, 10, objects.FirstPage, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, int64(2), page[0].User.UserId)
+		assert.True(t, page[0].ByLocation)
+		assert.Less(t, page[0].DistanceM, 1.0, "The distance is to the closest matching point")
+
+		assert.Equal(t, int64(1), page[1].User.UserId)
+		assert.False(t, page[1].ByLocation, "Matched by the alert only")
+	}
+}
+
+func TestMemoryRepository_FindHistoricalExchangesInRadius(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	postExchange := func(userID int64, lat, lon float64, age time.Duration) *objects.Exchange {
+		exchange := objects.NewExchange(userID, objects.ExchangeDirectionCashToCrypto, lat, lon)
+		exchange.CreatedAt = time.Now().Add(-age)
+		assert.NoError(t, repo.CreateExchange(exchange))
+		assert.NoError(t, repo.PostExchange(exchange))
+		return exchange
+	}
+
+	older := postExchange(2, 13.7563, 100.5480, 48*time.Hour)
+	newer := postExchange(2, 13.7563, 100.5480, time.Hour)
+	postExchange(3, 12.9236, 100.8825, time.Hour) // outside radius
+	postExchange(1, 13.7563, 100.5018, time.Hour) // excluded user
+
+	exchanges, err := repo.FindHistoricalExchangesInRadius(13.7563, 100.5018, 10, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, exchanges, 1) {
+		assert.Equal(t, newer.ID, exchanges[0].ID, "Only the latest exchange per author is returned")
+	}
+
+	assert.NoError(t, repo.SoftDeleteExchange(newer.ID))
+	exchanges, err = repo.FindHistoricalExchangesInRadius(13.7563, 100.5018, 10, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, exchanges, 1) {
+		assert.Equal(t, older.ID, exchanges[0].ID)
+	}
+}
+
+func TestMemoryRepository_FindHistoricalExchangesInRadiusIsSymmetric(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	// Both authors post in Bangkok center, the new location is ~5 km away
+	small, large := 2, 10
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: 2, Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &small}))
+	assert.NoError(t, repo.SaveUser(&objects.User{UserId: 3, Lat: 13.7563, Lon: 100.5018, SearchRadiusKm: &large}))
+	for _, userID := range []int64{2, 3} {
+		exchange := objects.NewExchange(userID, objects.ExchangeDirectionCashToCrypto, 13.7563, 100.5018)
+		assert.NoError(t, repo.CreateExchange(exchange))
+		assert.NoError(t, repo.PostExchange(exchange))
+	}
+
+	exchanges, err := repo.FindHistoricalExchangesInRadius(13.7563, 100.5480, 50, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, exchanges, 1, "The location must be within the author's radius too") {
+		assert.Equal(t, int64(3), exchanges[0].UserID)
+	}
+}
+
+func TestMemoryRepository_FindOffers(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	postExchange := func(userID int64, direction string, lon float64, amount int, age time.Duration) *objects.Exchange {
+		exchange := objects.NewExchange(userID, direction, 13.7563, lon)
+		exchange.AmountUSD = &amount
+		exchange.CreatedAt = time.Now().Add(-age)
+		assert.NoError(t, repo.CreateExchange(exchange))
+		assert.NoError(t, repo.PostExchange(exchange))
+		return exchange
+	}
+
+	near := postExchange(2, objects.ExchangeDirectionCashToCrypto, 100.5100, 50, 2*time.Hour)
+	far := postExchange(3, objects.ExchangeDirectionCryptoToCash, 100.5480, 200, time.Hour)
+	postExchange(4, objects.ExchangeDirectionCashToCrypto, 100.8825, 50, time.Hour) // outside radius
+	postExchange(1, objects.ExchangeDirectionCashToCrypto, 100.5018, 50, time.Hour) // own exchange
+
+	ids := func(query *objects.OfferQuery) []int64 {
+		exchanges, err := repo.FindOffers(query)
+		assert.NoError(t, err)
+		var ids []int64
+		for _, exchange := range exchanges {
+			ids = append(ids, exchange.ID)
+		}
+		return ids
+	}
+	query := func() *objects.OfferQuery {
+		return &objects.OfferQuery{Lat: 13.7563, Lon: 100.5018, RadiusKm: 10, ExcludeUserID: 1,
+			Sort: objects.OfferSortDistance, Limit: 10}
+	}
+
+	assert.Equal(t, []int64{near.ID, far.ID}, ids(query()))
+
+	recent := query()
+	recent.Sort = objects.OfferSortRecent
+	assert.Equal(t, []int64{far.ID, near.ID}, ids(recent))
+
+	paged := query()
+	paged.Offset, paged.Limit = 1, 1
+	assert.Equal(t, []int64{far.ID}, ids(paged))
+
+	wantCrypto := query()
+	wantCrypto.Direction = objects.ExchangeDirectionCashToCrypto
+	assert.Equal(t, []int64{far.ID}, ids(wantCrypto), "Users who want crypto see the offers of crypto")
+
+	cheap := query()
+	maxAmount := 100
+	cheap.MaxAmountUSD = &maxAmount
+	assert.Equal(t, []int64{near.ID}, ids(cheap))
+
+	assert.NoError(t, repo.SoftDeleteExchange(near.ID))
+	assert.Equal(t, []int64{far.ID}, ids(query()))
+}
+
+func TestMemoryRepository_ShouldTriggerHistoricalFanout(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	shouldTrigger, err := repo.ShouldTriggerHistoricalFanout(1)
+	assert.NoError(t, err)
+	assert.False(t, shouldTrigger, "No history should not trigger fanout")
+
+	assert.Error(t, repo.UpdateLocationHistory(1, 13.7563, 100.5018))
+
+	assert.NoError(t, repo.CreateLocationHistory(1, 10))
+	assert.NoError(t, repo.UpdateLocationHistory(1, 13.7563, 100.5018))
+	shouldTrigger, err = repo.ShouldTriggerHistoricalFanout(1)
+	assert.NoError(t, err)
+	assert.True(t, shouldTrigger, "First history should trigger fanout")
+
+	assert.NoError(t, repo.CreateLocationHistory(1, 10))
+	assert.NoError(t, repo.UpdateLocationHistory(1, 13.7563, 100.5018))
+	shouldTrigger, err = repo.ShouldTriggerHistoricalFanout(1)
+	assert.NoError(t, err)
+	assert.False(t, shouldTrigger, "Unchanged location and radius should not trigger fanout")
+
+	assert.NoError(t, repo.CreateLocationHistory(1, 50))
+	assert.NoError(t, repo.UpdateLocationHistory(1, 13.7563, 100.5018))
+	shouldTrigger, err = repo.ShouldTriggerHistoricalFanout(1)
+	assert.NoError(t, err)
+	assert.True(t, shouldTrigger, "Changed radius should trigger fanout")
+}
+
+func TestMemoryRepository_TimelineRecordUpsert(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	messageID := 42
+	assert.NoError(t, repo.CreateTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, Status: objects.TimelineStatusPending}))
+	assert.NoError(t, repo.CreateTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, TelegramMessageID: &messageID, Status: objects.TimelineStatusSent}))
+
+	records, err := repo.GetTimelineRecordsByExchange(1)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, objects.TimelineStatusSent, records[0].Status)
+		assert.Equal(t, 42, *records[0].TelegramMessageID)
+	}
+}
+
+func TestMemoryRepository_TimelineRecordUpsertKeepsDeletion(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	messageID := 42
+	assert.NoError(t, repo.CreateTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, TelegramMessageID: &messageID, Status: objects.TimelineStatusSent}))
+	assert.NoError(t, repo.MarkTimelineRecordsAsDeleted(1))
+	records, err := repo.GetTimelineRecordsByExchange(1)
+	assert.NoError(t, err)
+	if !assert.Len(t, records, 1) || !assert.NotNil(t, records[0].DeletedAt) {
+		return
+	}
+	deletedAt := *records[0].DeletedAt
+
+	// The send is replayed after the exchange was deleted
+	assert.NoError(t, repo.CreateTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, TelegramMessageID: &messageID, Status: objects.TimelineStatusSent}))
+
+	records, err = repo.GetTimelineRecordsByExchange(1)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.True(t, records[0].IsDeleted, "The replay does not bring the record back")
+		if assert.NotNil(t, records[0].DeletedAt) {
+			assert.Equal(t, deletedAt, *records[0].DeletedAt)
+		}
+	}
+}
+
+func TestMemoryRepository_DeferredTimelineRecords(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	distance := 3.5
+	messageID := 42
+	assert.NoError(t, repo.CreateTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, TelegramMessageID: &messageID, Status: objects.TimelineStatusSent}))
+	assert.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, Status: objects.TimelineStatusDeferred, DistanceKm: &distance}))
+	assert.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{ExchangeID: 2, RecipientUserID: 2, Status: objects.TimelineStatusDeferred, DistanceKm: &distance}))
+
+	records, err := repo.GetDeferredTimelineRecords(2)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1, "An existing record is not replaced by a deferred one") {
+		assert.Equal(t, int64(2), records[0].ExchangeID)
+		assert.Equal(t, 3.5, *records[0].DistanceKm)
+	}
+
+	assert.NoError(t, repo.MarkTimelineRecordsAsDeleted(2))
+	records, err = repo.GetDeferredTimelineRecords(2)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestMemoryRepository_ClaimHeldTimelineRecords(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	now := time.Now()
+	later, sooner := now.Add(time.Hour), now.Add(-time.Minute)
+	assert.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, Status: objects.TimelineStatusHeld, ReleaseAt: &later}))
+	assert.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{ExchangeID: 2, RecipientUserID: 2, Status: objects.TimelineStatusHeld, ReleaseAt: &sooner}))
+
+	records, err := repo.ClaimHeldTimelineRecords(now, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1, "Only the records due are claimed") {
+		assert.Equal(t, int64(2), records[0].ExchangeID)
+		assert.Equal(t, objects.TimelineStatusPending, records[0].Status)
+	}
+
+	records, err = repo.ClaimHeldTimelineRecords(now, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, records, "A claimed record is no longer held")
+
+	records, err = repo.ClaimHeldTimelineRecords(later, 10)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestMemoryRepository_ClaimDeferredTimelineRecords(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	assert.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 2, Status: objects.TimelineStatusDeferred}))
+	assert.NoError(t, repo.CreateDeferredTimelineRecord(&objects.TimelineRecord{ExchangeID: 1, RecipientUserID: 3, Status: objects.TimelineStatusDeferred}))
+
+	records, err := repo.ClaimDeferredTimelineRecords(2)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1, "Only the recipient's records are claimed") {
+		assert.Equal(t, objects.TimelineStatusPending, records[0].Status)
+	}
+
+	records, err = repo.ClaimDeferredTimelineRecords(2)
+	assert.NoError(t, err)
+	assert.Empty(t, records, "A claimed record is no longer deferred")
+
+	releaseAt := time.Now().Add(time.Hour)
+	claimed, err := repo.ClaimDeferredTimelineRecords(3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+	assert.NoError(t, repo.HoldTimelineRecord(claimed[0].ID, releaseAt))
+	records, err = repo.ClaimHeldTimelineRecords(releaseAt, 10)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1, "A held record is released at its release time")
+}
+
+func TestMemoryRepository_NotificationSettings(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	settings, err := repo.GetNotificationSettings(1)
+	assert.NoError(t, err)
+	assert.Equal(t, objects.DefaultNotificationSettings(1), settings)
+
+	start, end := 22, 8
+	settings.QuietStartHour, settings.QuietEndHour = &start, &end
+	settings.Delivery = objects.DeliveryDigest
+	assert.NoError(t, repo.SaveNotificationSettings(settings))
+	start = 0
+
+	byUser, err := repo.GetNotificationSettingsByUsers([]int64{1, 2})
+	assert.NoError(t, err)
+	assert.Len(t, byUser, 2)
+	assert.Equal(t, 22, *byUser[1].QuietStartHour, "Stored settings are copies")
+	assert.Equal(t, objects.DeliveryDigest, byUser[1].Delivery)
+	assert.Equal(t, objects.DeliveryInstant, byUser[2].Delivery)
+}
+
+func TestMemoryRepository_Digests(t *testing.T) {
+	repo := NewMemoryRepository()
+
+	var recordIDs []int64
+	for _, exchangeID := range []int64{10, 11} {
+		record := &objects.TimelineRecord{ExchangeID: exchangeID, RecipientUserID: 2, Status: objects.TimelineStatusDigest}
+		assert.NoError(t, repo.CreateDeferredTimelineRecord(record))
+		recordIDs = append(recordIDs, record.ID)
+	}
+
+	due, err := repo.GetDueCollectedRecipients(objects.TimelineStatusDigest, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, due)
+	due, err = repo.GetDueCollectedRecipients(objects.TimelineStatusDigest, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, due)
+
+	digest, err := repo.CreateDigest(2, recordIDs[:1])
+	assert.NoError(t, err)
+	collected, err := repo.GetCollectedRecords(2, objects.TimelineStatusDigest)
+	assert.NoError(t, err)
+	assert.Len(t, collected, 1, "Only the records not in the digest stay collected")
+
+	// Queueing failed, the record is collected again
+	assert.NoError(t, repo.RecordDigestDelivery(digest.ID, nil, objects.TimelineStatusDigest))
+	collected, err = repo.GetCollectedRecords(2, objects.TimelineStatusDigest)
+	assert.NoError(t, err)
+	assert.Len(t, collected, 2)
+
+	messageID := 5
+	assert.NoError(t, repo.RecordDigestDelivery(digest.ID, &messageID, objects.TimelineStatusSent))
+	stored, err := repo.GetDigest(digest.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, messageID, *stored.TelegramMessageID)
+	assert.NotNil(t, stored.SentAt)
+
+	records, err := repo.GetTimelineRecordsByDigest(digest.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, objects.TimelineStatusSent, records[0].Status)
+		assert.Equal(t, messageID, *records[0].TelegramMessageID)
+	}
+
+	missing, err := repo.GetDigest(999)
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestMemoryRepository_CountSentNotificationsByUsers(t *testing.T) {
+	repo := NewMemoryRepository()
+	own := objects.NewExchange(1, objects.ExchangeDirectionCashToCrypto, 0, 0)
+	other := objects.NewExchange(2, objects.ExchangeDirectionCashToCrypto, 0, 0)
+	assert.NoError(t, repo.CreateExchange(own))
+	assert.NoError(t, repo.CreateExchange(other))
+
+	sentAt := time.Now()
+	for _, exchangeID := range []int64{own.ID, other.ID} {
+		record := objects.NewTimelineRecord(exchangeID, 1)
+		record.Status, record.SentAt = objects.TimelineStatusSent, &sentAt
+		assert.NoError(t, repo.CreateTimelineRecord(record))
+	}
+	pending := objects.NewTimelineRecord(other.ID, 2)
+	assert.NoError(t, repo.CreateTimelineRecord(pending))
+
+	// Sent two hours ago, the record changed since
+	earlier := time.Now().Add(-2 * time.Hour)
+	old := objects.NewTimelineRecord(other.ID, 3)
+	old.Status, old.SentAt = objects.TimelineStatusSent, &earlier
+	assert.NoError(t, repo.CreateTimelineRecord(old))
+
+	counts, err := repo.CountSentNotificationsByUsers([]int64{1, 2, 3}, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]int{1: 1}, counts, "Own exchanges, unsent and earlier notifications are not counted")
+
+	counts, err = repo.CountSentNotificationsByUsers([]int64{1}, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+}
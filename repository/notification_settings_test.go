@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"librecash/objects"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationSettings(t *testing.T) {
+	repo, cleanup := setupTestDBForExchange(t)
+	defer cleanup()
+	if repo == nil {
+		return
+	}
+
+	_, err := repo.db.Exec(`DELETE FROM notification_settings`)
+	assert.NoError(t, err)
+	defer repo.db.Exec(`DELETE FROM notification_settings`)
+
+	for _, userID := range []int64{123494, 123495} {
+		assert.NoError(t, repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main}))
+	}
+
+	settings, err := repo.GetNotificationSettings(123494)
+	require.NoError(t, err)
+	assert.Equal(t, objects.DefaultNotificationSettings(123494), settings, "Users without settings get the defaults")
+
+	start, end := 22, 8
+	pausedUntil := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	settings.QuietStartHour, settings.QuietEndHour = &start, &end
+	settings.PausedUntil = &pausedUntil
+	settings.Delivery = objects.DeliveryDigest
+	require.NoError(t, repo.SaveNotificationSettings(settings))
+
+	byUser, err := repo.GetNotificationSettingsByUsers([]int64{123494, 123495})
+	require.NoError(t, err)
+	require.Len(t, byUser, 2)
+	stored := byUser[123494]
+	assert.Equal(t, 22, *stored.QuietStartHour)
+	assert.Equal(t, 8, *stored.QuietEndHour)
+	assert.True(t, pausedUntil.Equal(*stored.PausedUntil))
+	assert.Equal(t, objects.DeliveryDigest, stored.Delivery)
+	assert.Equal(t, objects.DeliveryInstant, byUser[123495].Delivery)
+
+	// Turning quiet hours off and resuming replaces the stored settings
+	stored.QuietStartHour, stored.QuietEndHour, stored.PausedUntil = nil, nil, nil
+	require.NoError(t, repo.SaveNotificationSettings(stored))
+	settings, err = repo.GetNotificationSettings(123494)
+	require.NoError(t, err)
+	assert.Nil(t, settings.QuietStartHour)
+	assert.Nil(t, settings.PausedUntil)
+	assert.Equal(t, objects.DeliveryDigest, settings.Delivery)
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"errors"
+	"librecash/objects"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostExchangeEnqueuesFanout(t *testing.T) {
+	repo, cleanup := setupTestDBForExchange(t)
+	defer cleanup()
+	if repo == nil {
+		return
+	}
+
+	_, err := repo.db.Exec(`DELETE FROM fanout_outbox`)
+	assert.NoError(t, err)
+
+	user := &objects.User{
+		UserId:       123460,
+		Username:     "outboxuser",
+		LanguageCode: "en",
+		MenuId:       objects.Menu_Main,
+		Lat:          40.7128,
+		Lon:          -74.0060,
+	}
+	assert.NoError(t, repo.SaveUser(user))
+
+	exchange := objects.NewExchange(user.UserId, objects.ExchangeDirectionCashToCrypto, user.Lat, user.Lon)
+	assert.NoError(t, repo.CreateExchange(exchange))
+
+	amount := 100
+	exchange.AmountUSD = &amount
+	assert.NoError(t, repo.PostExchange(exchange))
+
+	posted, err := repo.GetExchangeByID(exchange.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, objects.ExchangeStatusPosted, posted.Status)
+
+	// The pending entry is claimed once and hidden from other relays while leased
+	entries, err := repo.ClaimOutboxEntries(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, exchange.ID, entries[0].ExchangeID)
+
+	again, err := repo.ClaimOutboxEntries(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, again)
+
+	// A failed entry becomes claimable again once its retry delay passes
+	assert.NoError(t, repo.MarkOutboxEntryFailed(entries[0].ID, errors.New("broker down"), 0))
+	entries, err = repo.ClaimOutboxEntries(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Attempts)
+	assert.Equal(t, "broker down", *entries[0].LastError)
+
+	// A published entry is never claimed again
+	assert.NoError(t, repo.MarkOutboxEntryPublished(entries[0].ID))
+	_, err = repo.db.Exec(`UPDATE fanout_outbox SET locked_until = NULL`)
+	assert.NoError(t, err)
+	entries, err = repo.ClaimOutboxEntries(10, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -2,9 +2,11 @@ package repository
 
 import (
 	"librecash/objects"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFindUsersInRadius(t *testing.T) {
@@ -298,3 +300,290 @@ func TestFindUsersInRadiusWithSearchRadius(t *testing.T) {
 	assert.NotNil(t, users[0].SearchRadiusKm)
 	assert.Equal(t, 15, *users[0].SearchRadiusKm)
 }
+
+func TestFindUsersInRadiusSkipsUnreachableUsers(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	// Clean up any existing data
+	var err error
+	_, err = db.Exec(`DELETE FROM contact_requests`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM exchanges`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM location_histories`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM users`)
+	assert.NoError(t, err)
+
+	reachable := &objects.User{
+		UserId:       123456,
+		Username:     "reachable",
+		LanguageCode: "en",
+		MenuId:       objects.Menu_Main,
+		Lat:          40.7128,
+		Lon:          -74.0060,
+	}
+	err = repo.SaveUser(reachable)
+	assert.NoError(t, err)
+
+	blocked := &objects.User{
+		UserId:       789012,
+		Username:     "blocked",
+		LanguageCode: "en",
+		MenuId:       objects.Menu_Main,
+		Lat:          40.7128,
+		Lon:          -74.0060,
+	}
+	err = repo.SaveUser(blocked)
+	assert.NoError(t, err)
+
+	// User blocked the bot
+	err = repo.SetUserUnreachable(blocked.UserId, true)
+	assert.NoError(t, err)
+	assert.True(t, repo.FindUser(blocked.UserId).Unreachable)
+
+	users, err := repo.FindUsersInRadius(reachable.Lat, reachable.Lon, 5)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, reachable.UserId, users[0].UserId)
+
+	count, err := repo.CountUsersInRadius(reachable.Lat, reachable.Lon, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// SaveUser must not clear the flag behind the sender's back
+	blocked.MenuId = objects.Menu_Init
+	err = repo.SaveUser(blocked)
+	assert.NoError(t, err)
+	assert.True(t, repo.FindUser(blocked.UserId).Unreachable)
+
+	// User came back with /start
+	err = repo.SetUserUnreachable(blocked.UserId, false)
+	assert.NoError(t, err)
+
+	users, err = repo.FindUsersInRadius(reachable.Lat, reachable.Lon, 5)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+}
+
+func TestFindUsersInRadiusPage(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	var err error
+	_, err = db.Exec(`DELETE FROM contact_requests`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM exchanges`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM location_histories`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM users`)
+	assert.NoError(t, err)
+
+	// Three users at the same spot are ordered by ID, then the one in Times Square
+	for _, userID := range []int64{300, 100, 200} {
+		err = repo.SaveUser(&objects.User{UserId: userID, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7128, Lon: -74.0060})
+		assert.NoError(t, err)
+	}
+	err = repo.SaveUser(&objects.User{UserId: 50, LanguageCode: "th", MenuId: objects.Menu_Main, Lat: 40.7589, Lon: -73.9851})
+	assert.NoError(t, err)
+
+	var seen []int64
+	cursor := objects.FirstPage
+	for {
+		page, err := repo.FindUsersInRadiusPage(40.7128, -74.0060, 10, cursor, 2)
+		assert.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(page), 2)
+		for _, nearby := range page {
+			seen = append(seen, nearby.User.UserId)
+			cursor = nearby.Cursor()
+		}
+	}
+	assert.Equal(t, []int64{100, 200, 300, 50}, seen)
+	assert.Greater(t, cursor.DistanceM, 4000.0, "Distance is in meters")
+
+	// Only the columns fanout needs are loaded
+	page, err := repo.FindUsersInRadiusPage(40.7128, -74.0060, 10, objects.RadiusCursor{DistanceM: 0, UserID: 300}, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, "th", page[0].User.LanguageCode)
+		assert.Equal(t, objects.Menu_Main, page[0].User.MenuId)
+	}
+}
+
+func TestFindUsersInRadiusPageIsSymmetric(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	var err error
+	_, err = db.Exec(`DELETE FROM contact_requests`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM exchanges`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM location_histories`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM users`)
+	assert.NoError(t, err)
+
+	// All in Times Square, ~5 km from the exchange, each with a different own radius
+	small, large := 2, 10
+	for _, user := range []*objects.User{
+		{UserId: 100, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7589, Lon: -73.9851, SearchRadiusKm: &small},
+		{UserId: 200, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7589, Lon: -73.9851, SearchRadiusKm: &large},
+		{UserId: 300, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7589, Lon: -73.9851},
+	} {
+		err = repo.SaveUser(user)
+		assert.NoError(t, err)
+	}
+
+	page, err := repo.FindUsersInRadiusPage(40.7128, -74.0060, 50, objects.FirstPage, 10)
+	assert.NoError(t, err)
+	var userIDs []int64
+	for _, nearby := range page {
+		userIDs = append(userIDs, nearby.User.UserId)
+	}
+	assert.Equal(t, []int64{200, 300}, userIDs, "A user whose own radius does not reach the exchange is left out")
+}
+
+func TestFindHistoricalExchangesInRadiusIsSymmetric(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	var err error
+	_, err = db.Exec(`DELETE FROM contact_requests`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM exchanges`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM location_histories`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`DELETE FROM users`)
+	assert.NoError(t, err)
+
+	// Both authors post in lower Manhattan, the new location is Times Square ~5 km away
+	small, large := 2, 10
+	for _, author := range []*objects.User{
+		{UserId: 100, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7128, Lon: -74.0060, SearchRadiusKm: &small},
+		{UserId: 200, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7128, Lon: -74.0060, SearchRadiusKm: &large},
+	} {
+		err = repo.SaveUser(author)
+		assert.NoError(t, err)
+		exchange := objects.NewExchange(author.UserId, objects.ExchangeDirectionCashToCrypto, author.Lat, author.Lon)
+		err = repo.CreateExchange(exchange)
+		assert.NoError(t, err)
+		err = repo.PostExchange(exchange)
+		assert.NoError(t, err)
+	}
+
+	exchanges, err := repo.FindHistoricalExchangesInRadius(40.7589, -73.9851, 50, 300)
+	assert.NoError(t, err)
+	if assert.Len(t, exchanges, 1, "The location must be within the author's radius too") {
+		assert.Equal(t, int64(200), exchanges[0].UserID)
+	}
+}
+
+func TestFindUsersInRadiusPageWithManyAlerts(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	var err error
+	for _, table := range []string{"alerts", "contact_requests", "exchanges", "location_histories", "users"} {
+		_, err = db.Exec(`DELETE FROM ` + table)
+		assert.NoError(t, err)
+	}
+	defer db.Exec(`DELETE FROM alerts`)
+
+	// User 100 lives at the center and has more alerts nearby than fit a page, user 200 is
+	// found through one alert behind them, user 300 lives behind that
+	err = repo.SaveUser(&objects.User{UserId: 100, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7128, Lon: -74.0060})
+	assert.NoError(t, err)
+	err = repo.SaveUser(&objects.User{UserId: 200, LanguageCode: "en", MenuId: objects.Menu_Main})
+	assert.NoError(t, err)
+	err = repo.SaveUser(&objects.User{UserId: 300, LanguageCode: "en", MenuId: objects.Menu_Main, Lat: 40.7589, Lon: -73.9851})
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		alert := &objects.Alert{UserID: 100, Name: "Near"}
+		require.NoError(t, repo.CreateAlert(alert))
+		alert.Lat, alert.Lon, alert.RadiusKm, alert.Active = 40.7128+float64(i+1)*0.001, -74.0060, 10, true
+		require.NoError(t, repo.UpdateAlert(alert))
+	}
+	alert := &objects.Alert{UserID: 200, Name: "Midtown"}
+	require.NoError(t, repo.CreateAlert(alert))
+	alert.Lat, alert.Lon, alert.RadiusKm, alert.Active = 40.7300, -74.0000, 10, true
+	require.NoError(t, repo.UpdateAlert(alert))
+
+	var seen []int64
+	cursor := objects.FirstPage
+	for {
+		page, err := repo.FindUsersInRadiusPage(40.7128, -74.0060, 10, cursor, 2)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		assert.LessOrEqual(t, len(page), 2)
+		for _, nearby := range page {
+			assert.True(t, nearby.Cursor().After(cursor), "Pages move forward")
+			seen = append(seen, nearby.User.UserId)
+			cursor = nearby.Cursor()
+		}
+	}
+	assert.Equal(t, []int64{100, 200, 300}, seen, "Every user once, by their closest point")
+}
+
+func TestFindUsersInRadiusPageScansIndexes(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	// The test tables are tiny, so keep the planner from reading them whole
+	_, err = tx.Exec(`SET LOCAL enable_seqscan = off`)
+	require.NoError(t, err)
+	rows, err := tx.Query(`EXPLAIN `+radiusHeadsQuery, -74.0060, 40.7128, 10, 4000.0, int64(100), 50)
+	require.NoError(t, err)
+	var plan []string
+	for rows.Next() {
+		var line string
+		require.NoError(t, rows.Scan(&line))
+		plan = append(plan, line)
+	}
+	require.NoError(t, rows.Err())
+	explain := strings.Join(plan, "\n")
+
+	// Each branch stops after the limit on an index scan ordered by distance, ties on the
+	// user ID are at most sorted incrementally; nothing sorts or groups the whole radius
+	assert.Equal(t, 2, strings.Count(explain, "Limit"), explain)
+	assert.Contains(t, explain, "Index Scan using users_geog_idx", explain)
+	assert.Contains(t, explain, "Index Scan using idx_alerts_active_geog", explain)
+	assert.Equal(t, 2, strings.Count(explain, "Order By:"), explain)
+	assert.NotContains(t, strings.ReplaceAll(explain, "Incremental Sort", ""), "Sort  (", explain)
+	assert.NotContains(t, explain, "Aggregate", explain)
+}
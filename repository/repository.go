@@ -5,19 +5,23 @@ import (
 	"fmt"
 	"librecash/objects"
 	"log"
+	"sort"
 	"time"
+
+	"github.com/lib/pq"
 )
 
-type Repository struct {
+// PostgresRepository is the Repository backed by PostgreSQL with PostGIS
+type PostgresRepository struct {
 	db *sql.DB
 }
 
-func NewRepository(db *sql.DB) *Repository {
+func NewRepository(db *sql.DB) *PostgresRepository {
 	log.Println("[REPOSITORY] Repository initialized")
-	return &Repository{db: db}
+	return &PostgresRepository{db: db}
 }
 
-func (repo *Repository) FindUser(userId int64) *objects.User {
+func (repo *PostgresRepository) FindUser(userId int64) *objects.User {
 	log.Printf("[REPOSITORY] Finding user with ID: %d", userId)
 	user := &objects.User{}
 
@@ -25,12 +29,12 @@ func (repo *Repository) FindUser(userId int64) *objects.User {
 	var searchRadiusKm sql.NullInt64
 	var phoneNumber sql.NullString
 	err := repo.db.QueryRow(
-		`SELECT "userId", "menuId", "username", "firstName", "lastName", "languageCode", "lon", "lat", "search_radius_km", "phone_number"
+		`SELECT "userId", "menuId", "username", "firstName", "lastName", "languageCode", "lon", "lat", "search_radius_km", "phone_number", "unreachable"
 		FROM users
 		WHERE "userId" = $1
 		LIMIT 1`,
 		userId,
-	).Scan(&user.UserId, &user.MenuId, &user.Username, &user.FirstName, &user.LastName, &user.LanguageCode, &lon, &lat, &searchRadiusKm, &phoneNumber)
+	).Scan(&user.UserId, &user.MenuId, &user.Username, &user.FirstName, &user.LastName, &user.LanguageCode, &lon, &lat, &searchRadiusKm, &phoneNumber, &user.Unreachable)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -63,7 +67,7 @@ func (repo *Repository) FindUser(userId int64) *objects.User {
 	return user
 }
 
-func (repo *Repository) SaveUser(user *objects.User) error {
+func (repo *PostgresRepository) SaveUser(user *objects.User) error {
 	log.Printf("[REPOSITORY] Saving user %d (username: %s, language: %s, location: %f,%f)",
 		user.UserId, user.Username, user.LanguageCode, user.Lon, user.Lat)
 
@@ -128,7 +132,7 @@ func (repo *Repository) SaveUser(user *objects.User) error {
 	return nil
 }
 
-func (repo *Repository) ShowCallout(userId int64, featureName string) bool {
+func (repo *PostgresRepository) ShowCallout(userId int64, featureName string) bool {
 	log.Printf("[REPOSITORY] Checking callout '%s' for user %d", featureName, userId)
 
 	var count int
@@ -148,7 +152,7 @@ func (repo *Repository) ShowCallout(userId int64, featureName string) bool {
 	return shouldShow
 }
 
-func (repo *Repository) DismissCallout(userId int64, featureName string) error {
+func (repo *PostgresRepository) DismissCallout(userId int64, featureName string) error {
 	log.Printf("[REPOSITORY] Dismissing callout '%s' for user %d", featureName, userId)
 
 	_, err := repo.db.Exec(
@@ -169,7 +173,7 @@ func (repo *Repository) DismissCallout(userId int64, featureName string) error {
 
 // UpdateUserLocation updates the user's location and PostGIS geography column
 // Returns true if historical fanout should be triggered (location changed for existing user with search radius)
-func (repo *Repository) UpdateUserLocation(userId int64, lon, lat float64) (bool, error) {
+func (repo *PostgresRepository) UpdateUserLocation(userId int64, lon, lat float64) (bool, error) {
 	log.Printf("[REPOSITORY] Updating location for user %d: lon=%f, lat=%f", userId, lon, lat)
 
 	// Get old coordinates before updating (PRD011 requirement)
@@ -213,7 +217,7 @@ func (repo *Repository) UpdateUserLocation(userId int64, lon, lat float64) (bool
 }
 
 // UpdateUserSearchRadius updates the user's search radius preference
-func (repo *Repository) UpdateUserSearchRadius(userId int64, radiusKm int) error {
+func (repo *PostgresRepository) UpdateUserSearchRadius(userId int64, radiusKm int) error {
 	log.Printf("[REPOSITORY] Updating search radius for user %d: %d km", userId, radiusKm)
 
 	_, err := repo.db.Exec(
@@ -232,8 +236,29 @@ func (repo *Repository) UpdateUserSearchRadius(userId int64, radiusKm int) error
 	return nil
 }
 
+// SetUserUnreachable flags or unflags a user the bot can no longer message
+// (blocked the bot, deleted the chat). Unreachable users are skipped by fanout.
+func (repo *PostgresRepository) SetUserUnreachable(userId int64, unreachable bool) error {
+	log.Printf("[REPOSITORY] Setting unreachable=%v for user %d", unreachable, userId)
+
+	_, err := repo.db.Exec(
+		`UPDATE users
+		SET "unreachable" = $2
+		WHERE "userId" = $1`,
+		userId, unreachable,
+	)
+
+	if err != nil {
+		log.Printf("[REPOSITORY] Error setting unreachable flag for user %d: %v", userId, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Unreachable flag updated successfully for user %d", userId)
+	return nil
+}
+
 // CreateExchange creates a new exchange history record
-func (repo *Repository) CreateExchange(exchange *objects.Exchange) error {
+func (repo *PostgresRepository) CreateExchange(exchange *objects.Exchange) error {
 	log.Printf("[REPOSITORY] Creating exchange for user %d: direction=%s, status=%s",
 		exchange.UserID, exchange.ExchangeDirection, exchange.Status)
 
@@ -263,7 +288,7 @@ func (repo *Repository) CreateExchange(exchange *objects.Exchange) error {
 }
 
 // GetExchangeByID retrieves an exchange record by ID (only non-deleted)
-func (repo *Repository) GetExchangeByID(id int64) (*objects.Exchange, error) {
+func (repo *PostgresRepository) GetExchangeByID(id int64) (*objects.Exchange, error) {
 	log.Printf("[REPOSITORY] Getting exchange by ID: %d", id)
 
 	exchange := &objects.Exchange{}
@@ -304,7 +329,7 @@ func (repo *Repository) GetExchangeByID(id int64) (*objects.Exchange, error) {
 }
 
 // GetUserExchanges retrieves all non-deleted exchanges for a specific user
-func (repo *Repository) GetUserExchanges(userID int64) ([]*objects.Exchange, error) {
+func (repo *PostgresRepository) GetUserExchanges(userID int64) ([]*objects.Exchange, error) {
 	log.Printf("[REPOSITORY] Getting exchanges for user: %d", userID)
 
 	rows, err := repo.db.Query(
@@ -353,7 +378,7 @@ func (repo *Repository) GetUserExchanges(userID int64) ([]*objects.Exchange, err
 }
 
 // UpdateExchangeStatus updates the status of an exchange
-func (repo *Repository) UpdateExchangeStatus(id int64, status string) error {
+func (repo *PostgresRepository) UpdateExchangeStatus(id int64, status string) error {
 	log.Printf("[REPOSITORY] Updating exchange %d status to: %s", id, status)
 
 	_, err := repo.db.Exec(
@@ -373,7 +398,7 @@ func (repo *Repository) UpdateExchangeStatus(id int64, status string) error {
 }
 
 // GetLastUserExchange retrieves the most recent non-deleted exchange record for a user
-func (repo *Repository) GetLastUserExchange(userID int64) (*objects.Exchange, error) {
+func (repo *PostgresRepository) GetLastUserExchange(userID int64) (*objects.Exchange, error) {
 	log.Printf("[REPOSITORY] Getting last exchange for user %d", userID)
 
 	var exchange objects.Exchange
@@ -416,7 +441,7 @@ func (repo *Repository) GetLastUserExchange(userID int64) (*objects.Exchange, er
 }
 
 // UpdateExchange updates an existing exchange record
-func (repo *Repository) UpdateExchange(exchange *objects.Exchange) error {
+func (repo *PostgresRepository) UpdateExchange(exchange *objects.Exchange) error {
 	log.Printf("[REPOSITORY] Updating exchange ID %d", exchange.ID)
 
 	var amountUSD sql.NullInt64
@@ -449,8 +474,57 @@ func (repo *Repository) UpdateExchange(exchange *objects.Exchange) error {
 	return nil
 }
 
+// PostExchange saves a posted exchange and enqueues its fanout in the outbox in one transaction,
+// so a posted exchange is never left without a pending fanout
+func (repo *PostgresRepository) PostExchange(exchange *objects.Exchange) error {
+	log.Printf("[REPOSITORY] Posting exchange ID %d", exchange.ID)
+
+	var amountUSD sql.NullInt64
+	if exchange.AmountUSD != nil {
+		amountUSD = sql.NullInt64{Int64: int64(*exchange.AmountUSD), Valid: true}
+	}
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		log.Printf("[REPOSITORY] Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if Commit() succeeds
+
+	exchange.Status = objects.ExchangeStatusPosted
+	exchange.UpdatedAt = time.Now()
+
+	_, err = tx.Exec(
+		`UPDATE exchanges
+		SET status = $2, amount_usd = $3, updated_at = $4
+		WHERE id = $1`,
+		exchange.ID, exchange.Status, amountUSD, exchange.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error updating posted exchange: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO fanout_outbox (exchange_id) VALUES ($1)`,
+		exchange.ID,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error creating outbox entry: %v", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[REPOSITORY] Error committing posted exchange transaction: %v", err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Exchange %d posted, fanout enqueued", exchange.ID)
+	return nil
+}
+
 // SoftDeleteExchange marks an exchange as deleted
-func (repo *Repository) SoftDeleteExchange(exchangeID int64) error {
+func (repo *PostgresRepository) SoftDeleteExchange(exchangeID int64) error {
 	log.Printf("[REPOSITORY] Soft deleting exchange %d", exchangeID)
 
 	_, err := repo.db.Exec(
@@ -470,7 +544,7 @@ func (repo *Repository) SoftDeleteExchange(exchangeID int64) error {
 }
 
 // GetActiveExchanges retrieves all non-deleted exchanges
-func (repo *Repository) GetActiveExchanges() ([]*objects.Exchange, error) {
+func (repo *PostgresRepository) GetActiveExchanges() ([]*objects.Exchange, error) {
 	log.Printf("[REPOSITORY] Getting all active exchanges")
 
 	rows, err := repo.db.Query(
@@ -519,17 +593,26 @@ func (repo *Repository) GetActiveExchanges() ([]*objects.Exchange, error) {
 
 // Timeline Records Methods
 
-// CreateTimelineRecord creates a new timeline record
-func (repo *Repository) CreateTimelineRecord(record *objects.TimelineRecord) error {
+// CreateTimelineRecord creates a timeline record, or updates the existing record
+// of the same exchange and recipient (e.g. when a notification is redelivered)
+func (repo *PostgresRepository) CreateTimelineRecord(record *objects.TimelineRecord) error {
 	log.Printf("[REPOSITORY] Creating timeline record for exchange %d, recipient %d",
 		record.ExchangeID, record.RecipientUserID)
 
 	err := repo.db.QueryRow(
-		`INSERT INTO timeline_records (exchange_id, recipient_user_id, telegram_message_id, status, is_deleted, deleted_at, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`INSERT INTO timeline_records (exchange_id, recipient_user_id, telegram_message_id, status, is_deleted, deleted_at, created_at, updated_at, distance_km, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (exchange_id, recipient_user_id) DO UPDATE
+		SET telegram_message_id = COALESCE(EXCLUDED.telegram_message_id, timeline_records.telegram_message_id),
+		    status = EXCLUDED.status,
+		    is_deleted = timeline_records.is_deleted OR EXCLUDED.is_deleted,
+		    deleted_at = COALESCE(timeline_records.deleted_at, EXCLUDED.deleted_at),
+		    updated_at = EXCLUDED.updated_at,
+		    distance_km = COALESCE(EXCLUDED.distance_km, timeline_records.distance_km),
+		    sent_at = COALESCE(timeline_records.sent_at, EXCLUDED.sent_at)
 		RETURNING id`,
 		record.ExchangeID, record.RecipientUserID, record.TelegramMessageID, record.Status,
-		record.IsDeleted, record.DeletedAt, record.CreatedAt, record.UpdatedAt,
+		record.IsDeleted, record.DeletedAt, record.CreatedAt, record.UpdatedAt, record.DistanceKm, record.SentAt,
 	).Scan(&record.ID)
 
 	if err != nil {
@@ -542,11 +625,11 @@ func (repo *Repository) CreateTimelineRecord(record *objects.TimelineRecord) err
 }
 
 // GetTimelineRecordsByExchange retrieves all timeline records for an exchange
-func (repo *Repository) GetTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error) {
+func (repo *PostgresRepository) GetTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error) {
 	log.Printf("[REPOSITORY] Getting timeline records for exchange: %d", exchangeID)
 
 	rows, err := repo.db.Query(
-		`SELECT id, exchange_id, recipient_user_id, telegram_message_id, status, is_deleted, deleted_at, created_at, updated_at
+		`SELECT id, exchange_id, recipient_user_id, telegram_message_id, status, is_deleted, deleted_at, created_at, updated_at, digest_id
 		FROM timeline_records
 		WHERE exchange_id = $1
 		ORDER BY created_at DESC`,
@@ -563,10 +646,11 @@ func (repo *Repository) GetTimelineRecordsByExchange(exchangeID int64) ([]*objec
 		record := &objects.TimelineRecord{}
 		var telegramMessageID sql.NullInt64
 		var deletedAt sql.NullTime
+		var digestID sql.NullInt64
 
 		err := rows.Scan(&record.ID, &record.ExchangeID, &record.RecipientUserID,
 			&telegramMessageID, &record.Status, &record.IsDeleted, &deletedAt,
-			&record.CreatedAt, &record.UpdatedAt)
+			&record.CreatedAt, &record.UpdatedAt, &digestID)
 		if err != nil {
 			log.Printf("[REPOSITORY] Error scanning timeline record: %v", err)
 			continue
@@ -580,6 +664,9 @@ func (repo *Repository) GetTimelineRecordsByExchange(exchangeID int64) ([]*objec
 		if deletedAt.Valid {
 			record.DeletedAt = &deletedAt.Time
 		}
+		if digestID.Valid {
+			record.DigestID = &digestID.Int64
+		}
 
 		records = append(records, record)
 	}
@@ -589,7 +676,7 @@ func (repo *Repository) GetTimelineRecordsByExchange(exchangeID int64) ([]*objec
 }
 
 // UpdateTimelineRecord updates a timeline record with Telegram message ID and status
-func (repo *Repository) UpdateTimelineRecord(id int64, telegramMessageID int, status string) error {
+func (repo *PostgresRepository) UpdateTimelineRecord(id int64, telegramMessageID int, status string) error {
 	log.Printf("[REPOSITORY] Updating timeline record %d with message ID %d, status %s",
 		id, telegramMessageID, status)
 
@@ -610,7 +697,7 @@ func (repo *Repository) UpdateTimelineRecord(id int64, telegramMessageID int, st
 }
 
 // UpdateTimelineRecordStatus updates only the status of a timeline record
-func (repo *Repository) UpdateTimelineRecordStatus(id int64, status string) error {
+func (repo *PostgresRepository) UpdateTimelineRecordStatus(id int64, status string) error {
 	log.Printf("[REPOSITORY] Updating timeline record %d status to %s", id, status)
 
 	_, err := repo.db.Exec(
@@ -630,7 +717,7 @@ func (repo *Repository) UpdateTimelineRecordStatus(id int64, status string) erro
 }
 
 // MarkTimelineRecordsAsDeleted soft deletes all timeline records for an exchange
-func (repo *Repository) MarkTimelineRecordsAsDeleted(exchangeID int64) error {
+func (repo *PostgresRepository) MarkTimelineRecordsAsDeleted(exchangeID int64) error {
 	log.Printf("[REPOSITORY] Marking timeline records as deleted for exchange %d", exchangeID)
 
 	_, err := repo.db.Exec(
@@ -651,16 +738,16 @@ func (repo *Repository) MarkTimelineRecordsAsDeleted(exchangeID int64) error {
 
 // SoftDeleteExchangeTimeline soft deletes all timeline records for an exchange
 // This is an alias for MarkTimelineRecordsAsDeleted to match PRD009 specification
-func (repo *Repository) SoftDeleteExchangeTimeline(exchangeID int64) error {
+func (repo *PostgresRepository) SoftDeleteExchangeTimeline(exchangeID int64) error {
 	return repo.MarkTimelineRecordsAsDeleted(exchangeID)
 }
 
 // GetActiveTimelineRecordsByExchange retrieves only non-deleted timeline records for an exchange
-func (repo *Repository) GetActiveTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error) {
+func (repo *PostgresRepository) GetActiveTimelineRecordsByExchange(exchangeID int64) ([]*objects.TimelineRecord, error) {
 	log.Printf("[REPOSITORY] Getting active timeline records for exchange: %d", exchangeID)
 
 	rows, err := repo.db.Query(
-		`SELECT id, exchange_id, recipient_user_id, telegram_message_id, status, is_deleted, deleted_at, created_at, updated_at
+		`SELECT id, exchange_id, recipient_user_id, telegram_message_id, status, is_deleted, deleted_at, created_at, updated_at, digest_id
 		FROM timeline_records
 		WHERE exchange_id = $1 AND is_deleted = FALSE
 		ORDER BY created_at DESC`,
@@ -677,10 +764,11 @@ func (repo *Repository) GetActiveTimelineRecordsByExchange(exchangeID int64) ([]
 		record := &objects.TimelineRecord{}
 		var telegramMessageID sql.NullInt64
 		var deletedAt sql.NullTime
+		var digestID sql.NullInt64
 
 		err := rows.Scan(&record.ID, &record.ExchangeID, &record.RecipientUserID,
 			&telegramMessageID, &record.Status, &record.IsDeleted, &deletedAt,
-			&record.CreatedAt, &record.UpdatedAt)
+			&record.CreatedAt, &record.UpdatedAt, &digestID)
 		if err != nil {
 			log.Printf("[REPOSITORY] Error scanning active timeline record: %v", err)
 			continue
@@ -694,6 +782,9 @@ func (repo *Repository) GetActiveTimelineRecordsByExchange(exchangeID int64) ([]
 		if deletedAt.Valid {
 			record.DeletedAt = &deletedAt.Time
 		}
+		if digestID.Valid {
+			record.DigestID = &digestID.Int64
+		}
 
 		records = append(records, record)
 	}
@@ -702,10 +793,174 @@ func (repo *Repository) GetActiveTimelineRecordsByExchange(exchangeID int64) ([]
 	return records, nil
 }
 
+// CreateDeferredTimelineRecord inserts a deferred or held record, unless the recipient already
+// has a record for the exchange, e.g. a historical notification that was sent already
+func (repo *PostgresRepository) CreateDeferredTimelineRecord(record *objects.TimelineRecord) error {
+	log.Printf("[REPOSITORY] Recording %s notification of exchange %d for recipient %d",
+		record.Status, record.ExchangeID, record.RecipientUserID)
+
+	_, err := repo.db.Exec(
+		`INSERT INTO timeline_records (exchange_id, recipient_user_id, status, distance_km, release_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (exchange_id, recipient_user_id) DO NOTHING`,
+		record.ExchangeID, record.RecipientUserID, record.Status, record.DistanceKm, record.ReleaseAt,
+		record.CreatedAt, record.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error creating deferred timeline record: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetDeferredTimelineRecords retrieves the deferred records of a recipient, oldest first
+func (repo *PostgresRepository) GetDeferredTimelineRecords(recipientUserID int64) ([]*objects.TimelineRecord, error) {
+	rows, err := repo.db.Query(
+		`SELECT id, exchange_id, recipient_user_id, status, distance_km, created_at, updated_at
+		FROM timeline_records
+		WHERE recipient_user_id = $1 AND status = $2 AND is_deleted = FALSE
+		ORDER BY created_at, id`,
+		recipientUserID, objects.TimelineStatusDeferred,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting deferred timeline records: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*objects.TimelineRecord
+	for rows.Next() {
+		record := &objects.TimelineRecord{}
+		var distanceKm sql.NullFloat64
+		if err := rows.Scan(&record.ID, &record.ExchangeID, &record.RecipientUserID, &record.Status,
+			&distanceKm, &record.CreatedAt, &record.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if distanceKm.Valid {
+			record.DistanceKm = &distanceKm.Float64
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// ClaimDeferredTimelineRecords flips the deferred records of a recipient to pending and returns
+// them, oldest first. Claimed records are no longer deferred, so a notification is delivered
+// once even when the user opens the main menu twice at the same time, and the sender's write of
+// the sent message is never overwritten.
+func (repo *PostgresRepository) ClaimDeferredTimelineRecords(recipientUserID int64) ([]*objects.TimelineRecord, error) {
+	rows, err := repo.db.Query(
+		`UPDATE timeline_records
+		SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE recipient_user_id = $2 AND status = $3 AND is_deleted = FALSE
+		RETURNING id, exchange_id, recipient_user_id, status, distance_km, created_at, updated_at`,
+		objects.TimelineStatusPending, recipientUserID, objects.TimelineStatusDeferred,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error claiming deferred timeline records: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*objects.TimelineRecord
+	for rows.Next() {
+		record := &objects.TimelineRecord{}
+		var distanceKm sql.NullFloat64
+		if err := rows.Scan(&record.ID, &record.ExchangeID, &record.RecipientUserID, &record.Status,
+			&distanceKm, &record.CreatedAt, &record.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if distanceKm.Valid {
+			record.DistanceKm = &distanceKm.Float64
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING keeps no order
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].ID < records[j].ID
+		}
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+// HoldTimelineRecord holds a record for the recipient's quiet hours until releaseAt
+func (repo *PostgresRepository) HoldTimelineRecord(id int64, releaseAt time.Time) error {
+	_, err := repo.db.Exec(
+		`UPDATE timeline_records
+		SET status = $2, release_at = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		id, objects.TimelineStatusHeld, releaseAt.UTC(),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error holding timeline record %d: %v", id, err)
+	}
+	return err
+}
+
+// ClaimHeldTimelineRecords flips the held records due at now to pending and returns them,
+// oldest release first. Claimed records are no longer held, so concurrent workers never
+// release a notification twice.
+func (repo *PostgresRepository) ClaimHeldTimelineRecords(now time.Time, limit int) ([]*objects.TimelineRecord, error) {
+	rows, err := repo.db.Query(
+		`UPDATE timeline_records
+		SET status = $1, updated_at = $3
+		WHERE id IN (
+			SELECT id FROM timeline_records
+			WHERE status = $2 AND is_deleted = FALSE AND release_at <= $3
+			ORDER BY release_at, id
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, exchange_id, recipient_user_id, status, distance_km, release_at, created_at, updated_at`,
+		objects.TimelineStatusPending, objects.TimelineStatusHeld, now.UTC(), limit,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error claiming held timeline records: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*objects.TimelineRecord
+	for rows.Next() {
+		record := &objects.TimelineRecord{}
+		var distanceKm sql.NullFloat64
+		var releaseAt sql.NullTime
+		if err := rows.Scan(&record.ID, &record.ExchangeID, &record.RecipientUserID, &record.Status,
+			&distanceKm, &releaseAt, &record.CreatedAt, &record.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if distanceKm.Valid {
+			record.DistanceKm = &distanceKm.Float64
+		}
+		if releaseAt.Valid {
+			record.ReleaseAt = &releaseAt.Time
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ReleaseAt.Before(*records[j].ReleaseAt)
+	})
+	if len(records) > 0 {
+		log.Printf("[REPOSITORY] Claimed %d held timeline record(s)", len(records))
+	}
+	return records, nil
+}
+
 // User Proximity Methods
 
-// FindUsersInRadius finds all users within specified radius of given coordinates
-func (repo *Repository) FindUsersInRadius(lat, lon float64, radiusKm int) ([]*objects.User, error) {
+// FindUsersInRadius finds all reachable users within specified radius of given coordinates,
+// closest first and by user ID at equal distance, so fanout jobs resume in the same order
+func (repo *PostgresRepository) FindUsersInRadius(lat, lon float64, radiusKm int) ([]*objects.User, error) {
 	log.Printf("[REPOSITORY] Finding users within %d km of coordinates (%f, %f)",
 		radiusKm, lat, lon)
 
@@ -713,8 +968,9 @@ func (repo *Repository) FindUsersInRadius(lat, lon float64, radiusKm int) ([]*ob
 		SELECT "userId", "menuId", "username", "firstName", "lastName", "languageCode", "lon", "lat", "search_radius_km", "phone_number"
 		FROM users
 		WHERE "geog" IS NOT NULL
+		AND "unreachable" = FALSE
 		AND ST_DWithin("geog", ST_MakePoint($1, $2)::geography, $3 * 1000)
-		ORDER BY ST_Distance("geog", ST_MakePoint($1, $2)::geography)
+		ORDER BY ST_Distance("geog", ST_MakePoint($1, $2)::geography), "userId"
 	`
 
 	rows, err := repo.db.Query(query, lon, lat, radiusKm)
@@ -762,10 +1018,184 @@ func (repo *Repository) FindUsersInRadius(lat, lon float64, radiusKm int) ([]*ob
 	return users, nil
 }
 
+// radiusHeadsQuery takes the next limit matching points after the cursor from each branch,
+// users by their location and active alerts. Each branch is a KNN scan of its GiST index
+// ordered by "geog" <-> center, so it stops after limit rows instead of sorting the whole
+// radius; the scan still walks the points before the cursor, but nothing is grouped, joined
+// or sorted for them. EXPLAIN shows a Limit over an Index Scan with an Order By for both.
+const radiusHeadsQuery = `
+	(SELECT "userId" AS user_id, "geog" <-> ST_MakePoint($1, $2)::geography AS distance, TRUE AS by_location
+	FROM users
+	WHERE "geog" IS NOT NULL
+	AND "unreachable" = FALSE
+	AND ST_DWithin("geog", ST_MakePoint($1, $2)::geography, $3 * 1000)
+	AND ("search_radius_km" IS NULL
+		OR ST_DWithin("geog", ST_MakePoint($1, $2)::geography, "search_radius_km" * 1000))
+	AND ("geog" <-> ST_MakePoint($1, $2)::geography, "userId") > ($4, $5)
+	ORDER BY "geog" <-> ST_MakePoint($1, $2)::geography, "userId"
+	LIMIT $6)
+	UNION ALL
+	(SELECT user_id, geog <-> ST_MakePoint($1, $2)::geography, FALSE
+	FROM alerts
+	WHERE active
+	AND ST_DWithin(geog, ST_MakePoint($1, $2)::geography, LEAST($3, radius_km) * 1000)
+	AND (geog <-> ST_MakePoint($1, $2)::geography, user_id) > ($4, $5)
+	ORDER BY geog <-> ST_MakePoint($1, $2)::geography, user_id
+	LIMIT $6)`
+
+// radiusCandidatesQuery loads the users behind the heads with the distance to their closest
+// matching point, looked up per user through the primary key and idx_alerts_user_id
+const radiusCandidatesQuery = `
+	SELECT u."userId", u."menuId", u."languageCode", u."lon", u."lat", nearby.distance, nearby.by_location
+	FROM users u
+	CROSS JOIN LATERAL (
+		SELECT MIN(distance) AS distance, BOOL_OR(by_location) AS by_location
+		FROM (
+			SELECT u."geog" <-> ST_MakePoint($1, $2)::geography AS distance, TRUE AS by_location
+			WHERE u."geog" IS NOT NULL
+			AND ST_DWithin(u."geog", ST_MakePoint($1, $2)::geography, $3 * 1000)
+			AND (u."search_radius_km" IS NULL
+				OR ST_DWithin(u."geog", ST_MakePoint($1, $2)::geography, u."search_radius_km" * 1000))
+			UNION ALL
+			SELECT a.geog <-> ST_MakePoint($1, $2)::geography, FALSE
+			FROM alerts a
+			WHERE a.user_id = u."userId"
+			AND a.active
+			AND ST_DWithin(a.geog, ST_MakePoint($1, $2)::geography, LEAST($3, a.radius_km) * 1000)
+		) points
+	) nearby
+	WHERE u."userId" = ANY($4)
+	AND u."unreachable" = FALSE
+	AND nearby.distance IS NOT NULL`
+
+// FindUsersInRadiusPage returns up to limit reachable users within radiusKm after the cursor,
+// ordered by distance and then user ID. A user matches by their location or by one of their
+// active alerts. Matching is symmetric: the user's own search radius, or the alert's radius,
+// must reach the center as well; users without a radius only need to be within radiusKm.
+// The distance is to the closest matching point. Pass objects.FirstPage for the first page and
+// the cursor of the last user for the next one; an empty page means the search is done, a
+// short one does not. Only the columns fanout needs are loaded.
+//
+// Each page merges the limit-sized heads of radiusHeadsQuery. Every point up to the smallest
+// last key of a full head is in some head, so the users whose closest point lies in there are
+// exactly the next ones; users past it wait for the next page. When all of them were already
+// paged, e.g. a user with many alerts, the search moves on from that key.
+func (repo *PostgresRepository) FindUsersInRadiusPage(lat, lon float64, radiusKm int, after objects.RadiusCursor, limit int) ([]*objects.NearbyUser, error) {
+	for {
+		candidates, boundary, err := repo.findRadiusHeads(lat, lon, radiusKm, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			log.Printf("[REPOSITORY] Found 0 users within %d km after %.1f m/%d", radiusKm, after.DistanceM, after.UserID)
+			return nil, nil
+		}
+
+		nearby, err := repo.findRadiusCandidates(lat, lon, radiusKm, candidates)
+		if err != nil {
+			return nil, err
+		}
+
+		var users []*objects.NearbyUser
+		for _, user := range nearby {
+			cursor := user.Cursor()
+			if !cursor.After(after) || (boundary != nil && cursor.After(*boundary)) {
+				continue
+			}
+			users = append(users, user)
+		}
+		sort.Slice(users, func(i, j int) bool { return users[j].Cursor().After(users[i].Cursor()) })
+		if len(users) > limit {
+			users = users[:limit]
+		}
+
+		if len(users) > 0 || boundary == nil {
+			log.Printf("[REPOSITORY] Found %d users within %d km after %.1f m/%d", len(users), radiusKm, after.DistanceM, after.UserID)
+			return users, nil
+		}
+		after = *boundary
+	}
+}
+
+// findRadiusHeads returns the users behind the next heads after the cursor and the smallest
+// last key of a full head, nil when no head was full and so every match is in
+func (repo *PostgresRepository) findRadiusHeads(lat, lon float64, radiusKm int, after objects.RadiusCursor, limit int) ([]int64, *objects.RadiusCursor, error) {
+	rows, err := repo.db.Query(radiusHeadsQuery, lon, lat, radiusKm, after.DistanceM, after.UserID, limit)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error finding users in radius page: %v", err)
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	// Count and last key of each branch, by location and by alert
+	var userIDs []int64
+	seen := make(map[int64]bool)
+	counts := make(map[bool]int)
+	lasts := make(map[bool]objects.RadiusCursor)
+	for rows.Next() {
+		var key objects.RadiusCursor
+		var byLocation bool
+		if err := rows.Scan(&key.UserID, &key.DistanceM, &byLocation); err != nil {
+			log.Printf("[REPOSITORY] Error scanning user in radius page: %v", err)
+			return nil, nil, err
+		}
+		counts[byLocation]++
+		if counts[byLocation] == 1 || key.After(lasts[byLocation]) {
+			lasts[byLocation] = key
+		}
+		if !seen[key.UserID] {
+			seen[key.UserID] = true
+			userIDs = append(userIDs, key.UserID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[REPOSITORY] Error reading users in radius page: %v", err)
+		return nil, nil, err
+	}
+
+	var boundary *objects.RadiusCursor
+	for byLocation, count := range counts {
+		if last := lasts[byLocation]; count == limit && (boundary == nil || boundary.After(last)) {
+			boundary = &last
+		}
+	}
+	return userIDs, boundary, nil
+}
+
+// findRadiusCandidates loads the given reachable users with their closest matching point
+func (repo *PostgresRepository) findRadiusCandidates(lat, lon float64, radiusKm int, userIDs []int64) ([]*objects.NearbyUser, error) {
+	rows, err := repo.db.Query(radiusCandidatesQuery, lon, lat, radiusKm, pq.Array(userIDs))
+	if err != nil {
+		log.Printf("[REPOSITORY] Error loading users in radius page: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*objects.NearbyUser
+	for rows.Next() {
+		user := &objects.User{}
+		nearby := &objects.NearbyUser{User: user}
+		var lon, lat sql.NullFloat64
+
+		if err := rows.Scan(&user.UserId, &user.MenuId, &user.LanguageCode, &lon, &lat, &nearby.DistanceM, &nearby.ByLocation); err != nil {
+			log.Printf("[REPOSITORY] Error scanning user in radius page: %v", err)
+			return nil, err
+		}
+		user.Lon, user.Lat = lon.Float64, lat.Float64
+
+		users = append(users, nearby)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[REPOSITORY] Error reading users in radius page: %v", err)
+		return nil, err
+	}
+	return users, nil
+}
+
 // Contact Request Methods
 
 // CheckContactRequestExists checks if user already requested contact for this exchange
-func (repo *Repository) CheckContactRequestExists(exchangeID, requesterUserID int64) (bool, error) {
+func (repo *PostgresRepository) CheckContactRequestExists(exchangeID, requesterUserID int64) (bool, error) {
 	log.Printf("[REPOSITORY] Checking if contact request exists: exchange=%d, requester=%d", exchangeID, requesterUserID)
 
 	var count int
@@ -786,7 +1216,7 @@ func (repo *Repository) CheckContactRequestExists(exchangeID, requesterUserID in
 }
 
 // CreateContactRequest creates a new contact request record with duplicate prevention
-func (repo *Repository) CreateContactRequest(exchangeID, requesterUserID int64, username, firstName, lastName string) error {
+func (repo *PostgresRepository) CreateContactRequest(exchangeID, requesterUserID int64, username, firstName, lastName string) error {
 	log.Printf("[REPOSITORY] Creating contact request: exchange=%d, requester=%d, username=%s",
 		exchangeID, requesterUserID, username)
 
@@ -839,8 +1269,8 @@ func (repo *Repository) CreateContactRequest(exchangeID, requesterUserID int64,
 	return nil
 }
 
-// CountUsersInRadius counts users within specified radius of given coordinates
-func (repo *Repository) CountUsersInRadius(lat, lon float64, radiusKm int) (int, error) {
+// CountUsersInRadius counts reachable users within specified radius of given coordinates
+func (repo *PostgresRepository) CountUsersInRadius(lat, lon float64, radiusKm int) (int, error) {
 	log.Printf("[REPOSITORY] Counting users within %d km of coordinates (%f, %f)",
 		radiusKm, lat, lon)
 
@@ -848,6 +1278,7 @@ func (repo *Repository) CountUsersInRadius(lat, lon float64, radiusKm int) (int,
 		SELECT COUNT(*)
 		FROM users
 		WHERE "geog" IS NOT NULL
+		AND "unreachable" = FALSE
 		AND ST_DWithin("geog", ST_MakePoint($1, $2)::geography, $3 * 1000)
 	`
 
@@ -862,8 +1293,10 @@ func (repo *Repository) CountUsersInRadius(lat, lon float64, radiusKm int) (int,
 	return count, nil
 }
 
-// FindHistoricalExchangesInRadius finds historical active exchanges in radius for new location users
-func (repo *Repository) FindHistoricalExchangesInRadius(lat, lon float64, radiusKm int, excludeUserID int64) ([]*objects.Exchange, error) {
+// FindHistoricalExchangesInRadius finds historical active exchanges in radius for new location users.
+// Like fanout, matching is symmetric: the location must also be within the author's search radius
+// of the exchange.
+func (repo *PostgresRepository) FindHistoricalExchangesInRadius(lat, lon float64, radiusKm int, excludeUserID int64) ([]*objects.Exchange, error) {
 	log.Printf("[REPOSITORY] Finding historical exchanges within %d km of coordinates (%f, %f), excluding user %d",
 		radiusKm, lat, lon, excludeUserID)
 
@@ -886,11 +1319,14 @@ func (repo *Repository) FindHistoricalExchangesInRadius(lat, lon float64, radius
 				SELECT e.*,
 					   ROW_NUMBER() OVER (PARTITION BY e.user_id ORDER BY e.created_at DESC) as rn
 				FROM exchanges e
+				LEFT JOIN users author ON author."userId" = e.user_id
 				WHERE e.is_deleted = FALSE
 				  AND e.status = 'posted'
 				  AND e.user_id != $4
 				  AND e.created_at >= NOW() - INTERVAL '%d days'
 				  AND ST_DWithin(ST_MakePoint(e.lon, e.lat)::geography, ST_MakePoint($1, $2)::geography, $3 * 1000)
+				  AND (author.search_radius_km IS NULL
+					OR ST_DWithin(ST_MakePoint(e.lon, e.lat)::geography, ST_MakePoint($1, $2)::geography, author.search_radius_km * 1000))
 			)
 			SELECT id, user_id, exchange_direction, status, amount_usd, lat, lon, is_deleted, deleted_at, created_at, updated_at
 			FROM ranked_exchanges
@@ -949,8 +1385,75 @@ func (repo *Repository) FindHistoricalExchangesInRadius(lat, lon float64, radius
 	return []*objects.Exchange{}, nil
 }
 
+// FindOffers finds the live exchanges matching an /offers query, one page of them in the query's
+// sort order
+func (repo *PostgresRepository) FindOffers(query *objects.OfferQuery) ([]*objects.Exchange, error) {
+	log.Printf("[REPOSITORY] Finding offers within %d km of coordinates (%f, %f) for user %d, sorted by %s",
+		query.RadiusKm, query.Lat, query.Lon, query.ExcludeUserID, query.Sort)
+
+	orderBy := "distance, e.id"
+	if query.Sort == objects.OfferSortRecent {
+		orderBy = "e.created_at DESC, e.id DESC"
+	}
+
+	var direction sql.NullString
+	if query.Direction != "" {
+		direction = sql.NullString{String: objects.OppositeDirection(query.Direction), Valid: true}
+	}
+	var maxAmountUSD sql.NullInt64
+	if query.MaxAmountUSD != nil {
+		maxAmountUSD = sql.NullInt64{Int64: int64(*query.MaxAmountUSD), Valid: true}
+	}
+
+	rows, err := repo.db.Query(`
+		SELECT e.id, e.user_id, e.exchange_direction, e.status, e.amount_usd, e.lat, e.lon, e.is_deleted,
+		       e.deleted_at, e.created_at, e.updated_at,
+		       ST_Distance(ST_MakePoint(e.lon, e.lat)::geography, ST_MakePoint($1, $2)::geography) AS distance
+		FROM exchanges e
+		LEFT JOIN users author ON author."userId" = e.user_id
+		WHERE e.is_deleted = FALSE
+		  AND e.status = 'posted'
+		  AND e.user_id != $4
+		  AND ST_DWithin(ST_MakePoint(e.lon, e.lat)::geography, ST_MakePoint($1, $2)::geography, $3 * 1000)
+		  AND (author.search_radius_km IS NULL
+			OR ST_DWithin(ST_MakePoint(e.lon, e.lat)::geography, ST_MakePoint($1, $2)::geography, author.search_radius_km * 1000))
+		  AND ($5::text IS NULL OR e.exchange_direction = $5)
+		  AND ($6::int IS NULL OR e.amount_usd IS NULL OR e.amount_usd <= $6)
+		ORDER BY `+orderBy+`
+		LIMIT $7 OFFSET $8`,
+		query.Lon, query.Lat, query.RadiusKm, query.ExcludeUserID, direction, maxAmountUSD, query.Limit, query.Offset,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error finding offers: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	exchanges := []*objects.Exchange{}
+	for rows.Next() {
+		exchange := &objects.Exchange{}
+		var amountUSD sql.NullInt64
+		var deletedAt sql.NullTime
+		var distance float64
+		if err := rows.Scan(&exchange.ID, &exchange.UserID, &exchange.ExchangeDirection, &exchange.Status,
+			&amountUSD, &exchange.Lat, &exchange.Lon, &exchange.IsDeleted, &deletedAt,
+			&exchange.CreatedAt, &exchange.UpdatedAt, &distance); err != nil {
+			return nil, err
+		}
+		if amountUSD.Valid {
+			amount := int(amountUSD.Int64)
+			exchange.AmountUSD = &amount
+		}
+		if deletedAt.Valid {
+			exchange.DeletedAt = &deletedAt.Time
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, rows.Err()
+}
+
 // CreateLocationHistory creates a new location history record
-func (repo *Repository) CreateLocationHistory(userID int64, radiusKm int) error {
+func (repo *PostgresRepository) CreateLocationHistory(userID int64, radiusKm int) error {
 	log.Printf("[REPOSITORY] Creating location history for user %d with radius %d km", userID, radiusKm)
 
 	_, err := repo.db.Exec(
@@ -969,7 +1472,7 @@ func (repo *Repository) CreateLocationHistory(userID int64, radiusKm int) error
 }
 
 // UpdateLocationHistory updates coordinates in the latest location history record
-func (repo *Repository) UpdateLocationHistory(userID int64, lat, lon float64) error {
+func (repo *PostgresRepository) UpdateLocationHistory(userID int64, lat, lon float64) error {
 	log.Printf("[REPOSITORY] Updating location history for user %d: lat=%f, lon=%f", userID, lat, lon)
 
 	result, err := repo.db.Exec(
@@ -1004,7 +1507,7 @@ func (repo *Repository) UpdateLocationHistory(userID int64, lat, lon float64) er
 }
 
 // ShouldTriggerHistoricalFanout checks if historical fanout should be triggered
-func (repo *Repository) ShouldTriggerHistoricalFanout(userID int64) (bool, error) {
+func (repo *PostgresRepository) ShouldTriggerHistoricalFanout(userID int64) (bool, error) {
 	log.Printf("[REPOSITORY] Checking if historical fanout should be triggered for user %d", userID)
 
 	var shouldFanout bool
@@ -1037,3 +1540,872 @@ func (repo *Repository) ShouldTriggerHistoricalFanout(userID int64) (bool, error
 	log.Printf("[REPOSITORY] Historical fanout should be triggered for user %d: %v", userID, shouldFanout)
 	return shouldFanout, nil
 }
+
+// Interest Methods
+
+// interestColumns are the columns scanned by scanInterest, in order
+const interestColumns = `id, user_id, direction, asset, max_amount_usd, created_at`
+
+func scanInterest(row rowScanner) (*objects.Interest, error) {
+	interest := &objects.Interest{}
+	var maxAmountUSD sql.NullInt64
+
+	err := row.Scan(&interest.ID, &interest.UserID, &interest.Direction, &interest.Asset, &maxAmountUSD, &interest.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if maxAmountUSD.Valid {
+		amount := int(maxAmountUSD.Int64)
+		interest.MaxAmountUSD = &amount
+	}
+	return interest, nil
+}
+
+// SaveInterest stores an interest, replacing the user's interest in the same direction and asset
+func (repo *PostgresRepository) SaveInterest(interest *objects.Interest) error {
+	var maxAmountUSD interface{}
+	if interest.MaxAmountUSD != nil {
+		maxAmountUSD = *interest.MaxAmountUSD
+	}
+
+	err := repo.db.QueryRow(
+		`INSERT INTO interests (user_id, direction, asset, max_amount_usd)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, direction, asset) DO UPDATE SET max_amount_usd = EXCLUDED.max_amount_usd
+		RETURNING id, created_at`,
+		interest.UserID, interest.Direction, interest.Asset, maxAmountUSD,
+	).Scan(&interest.ID, &interest.CreatedAt)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error saving interest of user %d: %v", interest.UserID, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Saved interest %d of user %d: %s %s", interest.ID, interest.UserID, interest.Direction, interest.Asset)
+	return nil
+}
+
+// GetUserInterests returns the interests of a user, oldest first
+func (repo *PostgresRepository) GetUserInterests(userID int64) ([]*objects.Interest, error) {
+	interests, err := repo.GetInterestsByUsers([]int64{userID})
+	if err != nil {
+		return nil, err
+	}
+	return interests[userID], nil
+}
+
+// GetInterestsByUsers returns the interests of several users by user ID, users without
+// interests are missing from the map. Fanout loads a page of recipients at once.
+func (repo *PostgresRepository) GetInterestsByUsers(userIDs []int64) (map[int64][]*objects.Interest, error) {
+	rows, err := repo.db.Query(
+		`SELECT `+interestColumns+` FROM interests WHERE user_id = ANY($1) ORDER BY id`,
+		pq.Array(userIDs),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting interests of %d user(s): %v", len(userIDs), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	interests := make(map[int64][]*objects.Interest)
+	for rows.Next() {
+		interest, err := scanInterest(rows)
+		if err != nil {
+			log.Printf("[REPOSITORY] Error scanning interest row: %v", err)
+			return nil, err
+		}
+		interests[interest.UserID] = append(interests[interest.UserID], interest)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[REPOSITORY] Error reading interests: %v", err)
+		return nil, err
+	}
+	return interests, nil
+}
+
+// DeleteUserInterests removes all interests of a user, who then gets every offer again
+func (repo *PostgresRepository) DeleteUserInterests(userID int64) error {
+	_, err := repo.db.Exec(`DELETE FROM interests WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error deleting interests of user %d: %v", userID, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Deleted interests of user %d", userID)
+	return nil
+}
+
+// Alert Methods
+
+// alertColumns are the columns scanned by scanAlert, in order
+const alertColumns = `id, user_id, name, lat, lon, radius_km, direction, max_amount_usd, active, created_at`
+
+func scanAlert(row rowScanner) (*objects.Alert, error) {
+	alert := &objects.Alert{}
+	var direction sql.NullString
+	var maxAmountUSD sql.NullInt64
+
+	err := row.Scan(&alert.ID, &alert.UserID, &alert.Name, &alert.Lat, &alert.Lon, &alert.RadiusKm,
+		&direction, &maxAmountUSD, &alert.Active, &alert.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	alert.Direction = direction.String
+	if maxAmountUSD.Valid {
+		amount := int(maxAmountUSD.Int64)
+		alert.MaxAmountUSD = &amount
+	}
+	return alert, nil
+}
+
+// alertArgs returns the nullable columns of an alert
+func alertArgs(alert *objects.Alert) (direction, maxAmountUSD interface{}) {
+	if alert.Direction != "" {
+		direction = alert.Direction
+	}
+	if alert.MaxAmountUSD != nil {
+		maxAmountUSD = *alert.MaxAmountUSD
+	}
+	return direction, maxAmountUSD
+}
+
+// CreateAlert stores a new alert, usually a draft the user is still setting up
+func (repo *PostgresRepository) CreateAlert(alert *objects.Alert) error {
+	direction, maxAmountUSD := alertArgs(alert)
+	err := repo.db.QueryRow(
+		`INSERT INTO alerts (user_id, name, lat, lon, radius_km, direction, max_amount_usd, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`,
+		alert.UserID, alert.Name, alert.Lat, alert.Lon, alert.RadiusKm, direction, maxAmountUSD, alert.Active,
+	).Scan(&alert.ID, &alert.CreatedAt)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error creating alert for user %d: %v", alert.UserID, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Created alert %d for user %d", alert.ID, alert.UserID)
+	return nil
+}
+
+// UpdateAlert saves the settings of an alert
+func (repo *PostgresRepository) UpdateAlert(alert *objects.Alert) error {
+	direction, maxAmountUSD := alertArgs(alert)
+	_, err := repo.db.Exec(
+		`UPDATE alerts
+		SET name = $2, lat = $3, lon = $4, radius_km = $5, direction = $6, max_amount_usd = $7, active = $8
+		WHERE id = $1`,
+		alert.ID, alert.Name, alert.Lat, alert.Lon, alert.RadiusKm, direction, maxAmountUSD, alert.Active,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error updating alert %d: %v", alert.ID, err)
+		return err
+	}
+	return nil
+}
+
+// GetAlertDraft returns the alert the user is setting up, nil if none
+func (repo *PostgresRepository) GetAlertDraft(userID int64) (*objects.Alert, error) {
+	alert, err := scanAlert(repo.db.QueryRow(
+		`SELECT `+alertColumns+` FROM alerts WHERE user_id = $1 AND NOT active ORDER BY id DESC LIMIT 1`,
+		userID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting alert draft of user %d: %v", userID, err)
+		return nil, err
+	}
+	return alert, nil
+}
+
+// GetUserAlerts returns the active alerts of a user, oldest first
+func (repo *PostgresRepository) GetUserAlerts(userID int64) ([]*objects.Alert, error) {
+	alerts, err := repo.GetAlertsByUsers([]int64{userID})
+	if err != nil {
+		return nil, err
+	}
+	return alerts[userID], nil
+}
+
+// GetAlertsByUsers returns the active alerts of several users by user ID, users without alerts
+// are missing from the map
+func (repo *PostgresRepository) GetAlertsByUsers(userIDs []int64) (map[int64][]*objects.Alert, error) {
+	rows, err := repo.db.Query(
+		`SELECT `+alertColumns+` FROM alerts WHERE user_id = ANY($1) AND active ORDER BY id`,
+		pq.Array(userIDs),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting alerts of %d user(s): %v", len(userIDs), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := make(map[int64][]*objects.Alert)
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			log.Printf("[REPOSITORY] Error scanning alert row: %v", err)
+			return nil, err
+		}
+		alerts[alert.UserID] = append(alerts[alert.UserID], alert)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[REPOSITORY] Error reading alerts: %v", err)
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// DeleteAlert removes an alert of the user, alerts of other users are left alone
+func (repo *PostgresRepository) DeleteAlert(userID, alertID int64) error {
+	_, err := repo.db.Exec(`DELETE FROM alerts WHERE id = $1 AND user_id = $2`, alertID, userID)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error deleting alert %d of user %d: %v", alertID, userID, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Deleted alert %d of user %d", alertID, userID)
+	return nil
+}
+
+// DeleteAlertDrafts removes the alerts the user did not finish setting up
+func (repo *PostgresRepository) DeleteAlertDrafts(userID int64) error {
+	_, err := repo.db.Exec(`DELETE FROM alerts WHERE user_id = $1 AND NOT active`, userID)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error deleting alert drafts of user %d: %v", userID, err)
+		return err
+	}
+	return nil
+}
+
+// Digest and Overflow Summary Methods
+
+// collectedRecordColumns are the columns of timeline records scanned by scanCollectedRecord
+const collectedRecordColumns = `id, exchange_id, recipient_user_id, telegram_message_id, status, is_deleted, distance_km, digest_id, created_at, updated_at`
+
+// scanCollectedRecord scans a timeline record selected with collectedRecordColumns
+func scanCollectedRecord(rows *sql.Rows) (*objects.TimelineRecord, error) {
+	record := &objects.TimelineRecord{}
+	var telegramMessageID, digestID sql.NullInt64
+	var distanceKm sql.NullFloat64
+	if err := rows.Scan(&record.ID, &record.ExchangeID, &record.RecipientUserID, &telegramMessageID, &record.Status,
+		&record.IsDeleted, &distanceKm, &digestID, &record.CreatedAt, &record.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if telegramMessageID.Valid {
+		messageID := int(telegramMessageID.Int64)
+		record.TelegramMessageID = &messageID
+	}
+	if distanceKm.Valid {
+		record.DistanceKm = &distanceKm.Float64
+	}
+	if digestID.Valid {
+		record.DigestID = &digestID.Int64
+	}
+	return record, nil
+}
+
+// queryCollectedRecords runs a query selecting collectedRecordColumns
+func (repo *PostgresRepository) queryCollectedRecords(query string, args ...interface{}) ([]*objects.TimelineRecord, error) {
+	rows, err := repo.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*objects.TimelineRecord
+	for rows.Next() {
+		record, err := scanCollectedRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetCollectedRecords retrieves the records with the given status collected for the recipient's
+// next digest or overflow summary, oldest first
+func (repo *PostgresRepository) GetCollectedRecords(recipientUserID int64, status string) ([]*objects.TimelineRecord, error) {
+	records, err := repo.queryCollectedRecords(
+		`SELECT `+collectedRecordColumns+` FROM timeline_records
+		WHERE recipient_user_id = $1 AND status = $2 AND is_deleted = FALSE
+		ORDER BY created_at, id`,
+		recipientUserID, status,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting collected %s records of user %d: %v", status, recipientUserID, err)
+	}
+	return records, err
+}
+
+// GetDueCollectedRecipients returns the users with a record of the given status collected at or
+// before collectedBefore, so each user gets at most one digest or summary per interval
+func (repo *PostgresRepository) GetDueCollectedRecipients(status string, collectedBefore time.Time) ([]int64, error) {
+	rows, err := repo.db.Query(
+		`SELECT recipient_user_id FROM timeline_records
+		WHERE status = $1 AND is_deleted = FALSE
+		GROUP BY recipient_user_id
+		HAVING MIN(created_at) <= $2
+		ORDER BY recipient_user_id`,
+		status, collectedBefore,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting due %s recipients: %v", status, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// CountSentNotificationsByUsers counts the fanout notifications sent to each user since the given
+// time, by when they were sent, so later changes to a record do not count it again. Digests and
+// the users' own exchanges are not counted, users without any are left out.
+func (repo *PostgresRepository) CountSentNotificationsByUsers(userIDs []int64, since time.Time) (map[int64]int, error) {
+	counts := make(map[int64]int)
+	if len(userIDs) == 0 {
+		return counts, nil
+	}
+
+	rows, err := repo.db.Query(
+		`SELECT t.recipient_user_id, COUNT(*) FROM timeline_records t
+		JOIN exchanges e ON e.id = t.exchange_id
+		WHERE t.recipient_user_id = ANY($1) AND t.sent_at IS NOT NULL AND t.sent_at >= $2
+		  AND t.digest_id IS NULL AND e.user_id <> t.recipient_user_id
+		GROUP BY t.recipient_user_id`,
+		pq.Array(userIDs), since,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error counting sent notifications: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		var count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			return nil, err
+		}
+		counts[userID] = count
+	}
+	return counts, rows.Err()
+}
+
+// CreateDigest creates a digest of the given collected records and moves them into it, pending
+// until the sender records the sent message
+func (repo *PostgresRepository) CreateDigest(recipientUserID int64, recordIDs []int64) (*objects.Digest, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	digest := &objects.Digest{RecipientUserID: recipientUserID}
+	err = tx.QueryRow(
+		`INSERT INTO digests (recipient_user_id) VALUES ($1) RETURNING id, created_at`,
+		recipientUserID,
+	).Scan(&digest.ID, &digest.CreatedAt)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error creating digest for user %d: %v", recipientUserID, err)
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE timeline_records SET digest_id = $1, status = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ANY($3) AND recipient_user_id = $4`,
+		digest.ID, objects.TimelineStatusPending, pq.Array(recordIDs), recipientUserID,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error moving records into digest %d: %v", digest.ID, err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Printf("[REPOSITORY] Created digest %d of %d offer(s) for user %d", digest.ID, len(recordIDs), recipientUserID)
+	return digest, nil
+}
+
+// GetDigest retrieves a digest by ID, nil if there is none
+func (repo *PostgresRepository) GetDigest(id int64) (*objects.Digest, error) {
+	digest := &objects.Digest{}
+	var telegramMessageID sql.NullInt64
+	var sentAt sql.NullTime
+	err := repo.db.QueryRow(
+		`SELECT id, recipient_user_id, telegram_message_id, created_at, sent_at FROM digests WHERE id = $1`,
+		id,
+	).Scan(&digest.ID, &digest.RecipientUserID, &telegramMessageID, &digest.CreatedAt, &sentAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting digest %d: %v", id, err)
+		return nil, err
+	}
+	if telegramMessageID.Valid {
+		messageID := int(telegramMessageID.Int64)
+		digest.TelegramMessageID = &messageID
+	}
+	if sentAt.Valid {
+		digest.SentAt = &sentAt.Time
+	}
+	return digest, nil
+}
+
+// GetTimelineRecordsByDigest retrieves the records of a digest, deleted ones included, in the
+// order they were collected
+func (repo *PostgresRepository) GetTimelineRecordsByDigest(digestID int64) ([]*objects.TimelineRecord, error) {
+	records, err := repo.queryCollectedRecords(
+		`SELECT `+collectedRecordColumns+` FROM timeline_records
+		WHERE digest_id = $1
+		ORDER BY created_at, id`,
+		digestID,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting records of digest %d: %v", digestID, err)
+	}
+	return records, err
+}
+
+// RecordDigestDelivery stores the outcome of sending a digest on the digest and its records:
+// the Telegram message they share when sent, and the status
+func (repo *PostgresRepository) RecordDigestDelivery(digestID int64, telegramMessageID *int, status string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if telegramMessageID != nil {
+		if _, err := tx.Exec(
+			`UPDATE digests SET telegram_message_id = $2, sent_at = CURRENT_TIMESTAMP WHERE id = $1`,
+			digestID, *telegramMessageID,
+		); err != nil {
+			log.Printf("[REPOSITORY] Error recording digest %d delivery: %v", digestID, err)
+			return err
+		}
+	}
+	if _, err := tx.Exec(
+		`UPDATE timeline_records
+		SET telegram_message_id = COALESCE($2, telegram_message_id), status = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE digest_id = $1`,
+		digestID, telegramMessageID, status,
+	); err != nil {
+		log.Printf("[REPOSITORY] Error recording delivery of digest %d records: %v", digestID, err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Notification Settings Methods
+
+// GetNotificationSettings returns the notification settings of a user, the defaults if the
+// user never changed them
+func (repo *PostgresRepository) GetNotificationSettings(userID int64) (*objects.NotificationSettings, error) {
+	settings, err := repo.GetNotificationSettingsByUsers([]int64{userID})
+	if err != nil {
+		return nil, err
+	}
+	return settings[userID], nil
+}
+
+// GetNotificationSettingsByUsers returns the notification settings of each of the users,
+// loaded in one query for a fanout page
+func (repo *PostgresRepository) GetNotificationSettingsByUsers(userIDs []int64) (map[int64]*objects.NotificationSettings, error) {
+	rows, err := repo.db.Query(
+		`SELECT user_id, quiet_start_hour, quiet_end_hour, paused_until, delivery, updated_at
+		FROM notification_settings WHERE user_id = ANY($1)`,
+		pq.Array(userIDs),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting notification settings of %d user(s): %v", len(userIDs), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[int64]*objects.NotificationSettings, len(userIDs))
+	for rows.Next() {
+		stored := &objects.NotificationSettings{}
+		var quietStart, quietEnd sql.NullInt64
+		var pausedUntil sql.NullTime
+		if err := rows.Scan(&stored.UserID, &quietStart, &quietEnd, &pausedUntil, &stored.Delivery, &stored.UpdatedAt); err != nil {
+			log.Printf("[REPOSITORY] Error scanning notification settings row: %v", err)
+			return nil, err
+		}
+		if quietStart.Valid && quietEnd.Valid {
+			start, end := int(quietStart.Int64), int(quietEnd.Int64)
+			stored.QuietStartHour, stored.QuietEndHour = &start, &end
+		}
+		if pausedUntil.Valid {
+			stored.PausedUntil = &pausedUntil.Time
+		}
+		settings[stored.UserID] = stored
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[REPOSITORY] Error reading notification settings: %v", err)
+		return nil, err
+	}
+
+	for _, userID := range userIDs {
+		if _, ok := settings[userID]; !ok {
+			settings[userID] = objects.DefaultNotificationSettings(userID)
+		}
+	}
+	return settings, nil
+}
+
+// SaveNotificationSettings creates or replaces the notification settings of a user
+func (repo *PostgresRepository) SaveNotificationSettings(settings *objects.NotificationSettings) error {
+	var pausedUntil interface{}
+	if settings.PausedUntil != nil {
+		pausedUntil = settings.PausedUntil.UTC()
+	}
+
+	err := repo.db.QueryRow(
+		`INSERT INTO notification_settings (user_id, quiet_start_hour, quiet_end_hour, paused_until, delivery, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET quiet_start_hour = EXCLUDED.quiet_start_hour,
+		    quiet_end_hour = EXCLUDED.quiet_end_hour,
+		    paused_until = EXCLUDED.paused_until,
+		    delivery = EXCLUDED.delivery,
+		    updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		settings.UserID, settings.QuietStartHour, settings.QuietEndHour, pausedUntil, settings.Delivery,
+	).Scan(&settings.UpdatedAt)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error saving notification settings of user %d: %v", settings.UserID, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Saved notification settings of user %d", settings.UserID)
+	return nil
+}
+
+// Fanout Outbox Methods
+
+// ClaimOutboxEntries leases up to limit pending outbox entries for the given duration.
+// Entries leased by another relay are skipped; an entry whose lease expired is claimed again.
+func (repo *PostgresRepository) ClaimOutboxEntries(limit int, lease time.Duration) ([]*objects.OutboxEntry, error) {
+	rows, err := repo.db.Query(
+		`UPDATE fanout_outbox
+		SET locked_until = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM fanout_outbox
+			WHERE published_at IS NULL
+			  AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, exchange_id, attempts, last_error, published_at, created_at`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error claiming outbox entries: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*objects.OutboxEntry
+	for rows.Next() {
+		entry := &objects.OutboxEntry{}
+		var lastError sql.NullString
+		var publishedAt sql.NullTime
+
+		err := rows.Scan(&entry.ID, &entry.ExchangeID, &entry.Attempts, &lastError, &publishedAt, &entry.CreatedAt)
+		if err != nil {
+			log.Printf("[REPOSITORY] Error scanning outbox entry row: %v", err)
+			continue
+		}
+		if lastError.Valid {
+			entry.LastError = &lastError.String
+		}
+		if publishedAt.Valid {
+			entry.PublishedAt = &publishedAt.Time
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(entries) > 0 {
+		log.Printf("[REPOSITORY] Claimed %d outbox entries", len(entries))
+	}
+	return entries, nil
+}
+
+// MarkOutboxEntryPublished removes an outbox entry from the pending set
+func (repo *PostgresRepository) MarkOutboxEntryPublished(id int64) error {
+	_, err := repo.db.Exec(
+		`UPDATE fanout_outbox
+		SET published_at = NOW(), locked_until = NULL, attempts = attempts + 1
+		WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error marking outbox entry %d as published: %v", id, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Outbox entry %d published", id)
+	return nil
+}
+
+// MarkOutboxEntryFailed records a failed relay attempt and keeps the entry leased for retryIn
+func (repo *PostgresRepository) MarkOutboxEntryFailed(id int64, relayErr error, retryIn time.Duration) error {
+	_, err := repo.db.Exec(
+		`UPDATE fanout_outbox
+		SET attempts = attempts + 1, last_error = $2, locked_until = NOW() + $3 * INTERVAL '1 second'
+		WHERE id = $1`,
+		id, relayErr.Error(), retryIn.Seconds(),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error marking outbox entry %d as failed: %v", id, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Outbox entry %d failed, retry in %v", id, retryIn)
+	return nil
+}
+
+// Fanout Job Methods
+
+// fanoutJobColumns are the columns scanned by scanFanoutJob, in order
+const fanoutJobColumns = `id, exchange_id, status, cursor_distance, cursor_user_id, recipients_found,
+	recipients_queued, recipients_sent, recipients_failed, attempts, last_error, created_at, updated_at, completed_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFanoutJob(row rowScanner) (*objects.FanoutJob, error) {
+	job := &objects.FanoutJob{}
+	var lastError sql.NullString
+	var completedAt sql.NullTime
+
+	err := row.Scan(&job.ID, &job.ExchangeID, &job.Status, &job.Cursor.DistanceM, &job.Cursor.UserID, &job.Found,
+		&job.Queued, &job.Sent, &job.Failed, &job.Attempts, &lastError, &job.CreatedAt, &job.UpdatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastError.Valid {
+		job.LastError = &lastError.String
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return job, nil
+}
+
+// CreateFanoutJob enqueues the fanout of an exchange. An exchange has at most one job,
+// creating it again returns the existing one.
+func (repo *PostgresRepository) CreateFanoutJob(exchangeID int64) (*objects.FanoutJob, error) {
+	_, err := repo.db.Exec(
+		`INSERT INTO fanout_jobs (exchange_id) VALUES ($1)
+		ON CONFLICT (exchange_id) DO NOTHING`,
+		exchangeID,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error creating fanout job for exchange %d: %v", exchangeID, err)
+		return nil, err
+	}
+
+	job, err := repo.GetFanoutJobByExchange(exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[REPOSITORY] Fanout job %d for exchange %d is %s", job.ID, exchangeID, job.Status)
+	return job, nil
+}
+
+// ClaimFanoutJobs leases up to limit pending jobs for the given duration.
+// Jobs leased by another worker are skipped; a job whose lease expired is claimed again.
+func (repo *PostgresRepository) ClaimFanoutJobs(limit int, lease time.Duration) ([]*objects.FanoutJob, error) {
+	rows, err := repo.db.Query(
+		`UPDATE fanout_jobs
+		SET locked_until = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM fanout_jobs
+			WHERE status = 'pending'
+			  AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+fanoutJobColumns,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error claiming fanout jobs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*objects.FanoutJob
+	for rows.Next() {
+		job, err := scanFanoutJob(rows)
+		if err != nil {
+			log.Printf("[REPOSITORY] Error scanning fanout job row: %v", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	if len(jobs) > 0 {
+		log.Printf("[REPOSITORY] Claimed %d fanout jobs", len(jobs))
+	}
+	return jobs, nil
+}
+
+// SaveFanoutJobProgress records the progress of a running job and extends its lease by lease,
+// 0 releases the job. Sent and failed are counted by RecordFanoutJobDelivery and left alone.
+func (repo *PostgresRepository) SaveFanoutJobProgress(job *objects.FanoutJob, lease time.Duration) error {
+	_, err := repo.db.Exec(
+		`UPDATE fanout_jobs
+		SET status = $2, cursor_distance = $3, cursor_user_id = $4, recipients_found = $5, recipients_queued = $6,
+			completed_at = CASE WHEN $2 = 'done' THEN NOW() END,
+			locked_until = NOW() + $7 * INTERVAL '1 second', updated_at = NOW()
+		WHERE id = $1`,
+		job.ID, job.Status, job.Cursor.DistanceM, job.Cursor.UserID, job.Found, job.Queued, lease.Seconds(),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error saving fanout job %d progress: %v", job.ID, err)
+		return err
+	}
+	return nil
+}
+
+// MarkFanoutJobFailed records a failed run and keeps the job leased for retryIn
+func (repo *PostgresRepository) MarkFanoutJobFailed(id int64, jobErr error, retryIn time.Duration) error {
+	_, err := repo.db.Exec(
+		`UPDATE fanout_jobs
+		SET attempts = attempts + 1, last_error = $2, locked_until = NOW() + $3 * INTERVAL '1 second',
+			updated_at = NOW()
+		WHERE id = $1`,
+		id, jobErr.Error(), retryIn.Seconds(),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error marking fanout job %d as failed: %v", id, err)
+		return err
+	}
+
+	log.Printf("[REPOSITORY] Fanout job %d failed, retry in %v", id, retryIn)
+	return nil
+}
+
+// RecordFanoutJobDelivery counts a notification of the job as delivered or as rejected for good
+func (repo *PostgresRepository) RecordFanoutJobDelivery(id int64, sent bool) error {
+	column := "recipients_failed"
+	if sent {
+		column = "recipients_sent"
+	}
+
+	_, err := repo.db.Exec(
+		`UPDATE fanout_jobs SET `+column+` = `+column+` + 1, updated_at = NOW() WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error recording delivery for fanout job %d: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// GetFanoutJobByExchange returns the fanout job of an exchange, nil if it has none
+func (repo *PostgresRepository) GetFanoutJobByExchange(exchangeID int64) (*objects.FanoutJob, error) {
+	job, err := scanFanoutJob(repo.db.QueryRow(
+		`SELECT `+fanoutJobColumns+` FROM fanout_jobs WHERE exchange_id = $1`,
+		exchangeID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("[REPOSITORY] Error getting fanout job for exchange %d: %v", exchangeID, err)
+		return nil, err
+	}
+	return job, nil
+}
+
+// ListFanoutJobs returns the most recent fanout jobs, newest first
+func (repo *PostgresRepository) ListFanoutJobs(limit int) ([]*objects.FanoutJob, error) {
+	rows, err := repo.db.Query(
+		`SELECT `+fanoutJobColumns+` FROM fanout_jobs ORDER BY id DESC LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error listing fanout jobs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*objects.FanoutJob
+	for rows.Next() {
+		job, err := scanFanoutJob(rows)
+		if err != nil {
+			log.Printf("[REPOSITORY] Error scanning fanout job row: %v", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Sent Message Deduplication Methods
+
+// IsMessageSent reports whether a message with the idempotency key was already delivered
+func (repo *PostgresRepository) IsMessageSent(idempotencyKey string) (bool, error) {
+	var exists bool
+	err := repo.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM sent_messages WHERE idempotency_key = $1)`,
+		idempotencyKey,
+	).Scan(&exists)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error checking sent message %s: %v", idempotencyKey, err)
+		return false, err
+	}
+	return exists, nil
+}
+
+// MarkMessageSent records that the message with the idempotency key was delivered
+func (repo *PostgresRepository) MarkMessageSent(idempotencyKey string) error {
+	_, err := repo.db.Exec(
+		`INSERT INTO sent_messages (idempotency_key) VALUES ($1)
+		ON CONFLICT (idempotency_key) DO NOTHING`,
+		idempotencyKey,
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error marking message %s as sent: %v", idempotencyKey, err)
+		return err
+	}
+	return nil
+}
+
+// PruneSentMessages removes idempotency keys older than maxAge. Returns the number of removed keys.
+func (repo *PostgresRepository) PruneSentMessages(maxAge time.Duration) (int64, error) {
+	result, err := repo.db.Exec(
+		`DELETE FROM sent_messages WHERE sent_at < NOW() - $1 * INTERVAL '1 second'`,
+		maxAge.Seconds(),
+	)
+	if err != nil {
+		log.Printf("[REPOSITORY] Error pruning sent messages: %v", err)
+		return 0, err
+	}
+
+	pruned, _ := result.RowsAffected()
+	log.Printf("[REPOSITORY] Pruned %d sent message keys older than %v", pruned, maxAge)
+	return pruned, nil
+}
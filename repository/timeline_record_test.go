@@ -455,3 +455,367 @@ func TestGetTimelineRecordsByExchange_IncludesDeleted(t *testing.T) {
 func testIntPtr(i int) *int {
 	return &i
 }
+
+func TestCreateTimelineRecordUpsertsRedelivery(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	author := &objects.User{UserId: 123470, Username: "upsertauthor", LanguageCode: "en", MenuId: objects.Menu_Main}
+	recipient := &objects.User{UserId: 123471, Username: "upsertrecipient", LanguageCode: "en", MenuId: objects.Menu_Main}
+	assert.NoError(t, repo.SaveUser(author))
+	assert.NoError(t, repo.SaveUser(recipient))
+
+	exchange := &objects.Exchange{
+		UserID:            author.UserId,
+		ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+		Status:            objects.ExchangeStatusPosted,
+	}
+	assert.NoError(t, repo.CreateExchange(exchange))
+
+	// First delivery fails, the redelivery succeeds
+	failed := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	failed.Status = objects.TimelineStatusFailed
+	assert.NoError(t, repo.CreateTimelineRecord(failed))
+
+	messageID := 42
+	sent := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	sent.Status = objects.TimelineStatusSent
+	sent.TelegramMessageID = &messageID
+	assert.NoError(t, repo.CreateTimelineRecord(sent))
+
+	assert.Equal(t, failed.ID, sent.ID)
+
+	records, err := repo.GetTimelineRecordsByExchange(exchange.ID)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, objects.TimelineStatusSent, records[0].Status)
+	assert.Equal(t, messageID, *records[0].TelegramMessageID)
+}
+
+func TestCreateTimelineRecordKeepsDeletion(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	author := &objects.User{UserId: 123472, Username: "stickyauthor", LanguageCode: "en", MenuId: objects.Menu_Main}
+	recipient := &objects.User{UserId: 123473, Username: "stickyrecipient", LanguageCode: "en", MenuId: objects.Menu_Main}
+	assert.NoError(t, repo.SaveUser(author))
+	assert.NoError(t, repo.SaveUser(recipient))
+
+	exchange := &objects.Exchange{
+		UserID:            author.UserId,
+		ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+		Status:            objects.ExchangeStatusPosted,
+	}
+	assert.NoError(t, repo.CreateExchange(exchange))
+
+	messageID := 42
+	sent := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	sent.Status = objects.TimelineStatusSent
+	sent.TelegramMessageID = &messageID
+	assert.NoError(t, repo.CreateTimelineRecord(sent))
+
+	// The exchange is deleted, then the send is replayed, e.g. after a lost ack
+	assert.NoError(t, repo.MarkTimelineRecordsAsDeleted(exchange.ID))
+	records, err := repo.GetTimelineRecordsByExchange(exchange.ID)
+	assert.NoError(t, err)
+	if !assert.Len(t, records, 1) || !assert.NotNil(t, records[0].DeletedAt) {
+		return
+	}
+	deletedAt := *records[0].DeletedAt
+
+	replayed := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	replayed.Status = objects.TimelineStatusSent
+	replayed.TelegramMessageID = &messageID
+	assert.NoError(t, repo.CreateTimelineRecord(replayed))
+
+	records, err = repo.GetTimelineRecordsByExchange(exchange.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.True(t, records[0].IsDeleted, "The replay does not bring the record back")
+		if assert.NotNil(t, records[0].DeletedAt) {
+			assert.WithinDuration(t, deletedAt, *records[0].DeletedAt, 0)
+		}
+	}
+}
+
+func TestDeferredTimelineRecords(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	author := &objects.User{UserId: 123490, Username: "deferauthor", LanguageCode: "en", MenuId: objects.Menu_Main}
+	recipient := &objects.User{UserId: 123491, Username: "deferrecipient", LanguageCode: "en", MenuId: objects.Menu_Amount}
+	assert.NoError(t, repo.SaveUser(author))
+	assert.NoError(t, repo.SaveUser(recipient))
+
+	var exchanges []*objects.Exchange
+	for i := 0; i < 2; i++ {
+		exchange := &objects.Exchange{
+			UserID:            author.UserId,
+			ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+			Status:            objects.ExchangeStatusPosted,
+		}
+		assert.NoError(t, repo.CreateExchange(exchange))
+		exchanges = append(exchanges, exchange)
+	}
+
+	// The first exchange was already sent, e.g. by the historical fanout
+	messageID := 42
+	sent := objects.NewTimelineRecord(exchanges[0].ID, recipient.UserId)
+	sent.Status = objects.TimelineStatusSent
+	sent.TelegramMessageID = &messageID
+	assert.NoError(t, repo.CreateTimelineRecord(sent))
+
+	distance := 3.5
+	for _, exchange := range exchanges {
+		deferred := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+		deferred.Status = objects.TimelineStatusDeferred
+		deferred.DistanceKm = &distance
+		assert.NoError(t, repo.CreateDeferredTimelineRecord(deferred))
+	}
+
+	records, err := repo.GetDeferredTimelineRecords(recipient.UserId)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1, "A sent record is not replaced by a deferred one") {
+		assert.Equal(t, exchanges[1].ID, records[0].ExchangeID)
+		assert.Equal(t, 3.5, *records[0].DistanceKm)
+	}
+
+	assert.NoError(t, repo.MarkTimelineRecordsAsDeleted(exchanges[1].ID))
+	records, err = repo.GetDeferredTimelineRecords(recipient.UserId)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestSentMessageDeduplication(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	key := "exchange_notification:1:" + time.Now().Format("150405.000000")
+
+	sent, err := repo.IsMessageSent(key)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	assert.NoError(t, repo.MarkMessageSent(key))
+	assert.NoError(t, repo.MarkMessageSent(key)) // marking twice is not an error
+
+	sent, err = repo.IsMessageSent(key)
+	assert.NoError(t, err)
+	assert.True(t, sent)
+}
+
+func TestClaimHeldTimelineRecords(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	author := &objects.User{UserId: 123492, Username: "heldauthor", LanguageCode: "en", MenuId: objects.Menu_Main}
+	recipient := &objects.User{UserId: 123493, Username: "heldrecipient", LanguageCode: "en", MenuId: objects.Menu_Main}
+	assert.NoError(t, repo.SaveUser(author))
+	assert.NoError(t, repo.SaveUser(recipient))
+
+	now := time.Now().UTC()
+	releases := []time.Time{now.Add(time.Hour), now.Add(-time.Minute)}
+	var exchanges []*objects.Exchange
+	for _, releaseAt := range releases {
+		exchange := &objects.Exchange{
+			UserID:            author.UserId,
+			ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+			Status:            objects.ExchangeStatusPosted,
+		}
+		assert.NoError(t, repo.CreateExchange(exchange))
+		exchanges = append(exchanges, exchange)
+
+		releaseAt := releaseAt
+		held := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+		held.Status = objects.TimelineStatusHeld
+		held.ReleaseAt = &releaseAt
+		assert.NoError(t, repo.CreateDeferredTimelineRecord(held))
+	}
+
+	records, err := repo.ClaimHeldTimelineRecords(now, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1, "Only the records due are claimed") {
+		assert.Equal(t, exchanges[1].ID, records[0].ExchangeID)
+		assert.Equal(t, objects.TimelineStatusPending, records[0].Status)
+	}
+
+	records, err = repo.ClaimHeldTimelineRecords(now, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, records, "A claimed record is no longer held")
+}
+
+func TestClaimDeferredTimelineRecords(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	author := &objects.User{UserId: 123510, Username: "deferredauthor", LanguageCode: "en", MenuId: objects.Menu_Main}
+	recipient := &objects.User{UserId: 123511, Username: "deferredrecipient", LanguageCode: "en", MenuId: objects.Menu_Main}
+	assert.NoError(t, repo.SaveUser(author))
+	assert.NoError(t, repo.SaveUser(recipient))
+
+	var exchanges []*objects.Exchange
+	for i := 0; i < 2; i++ {
+		exchange := &objects.Exchange{
+			UserID:            author.UserId,
+			ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+			Status:            objects.ExchangeStatusPosted,
+		}
+		assert.NoError(t, repo.CreateExchange(exchange))
+		exchanges = append(exchanges, exchange)
+
+		deferred := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+		deferred.Status = objects.TimelineStatusDeferred
+		assert.NoError(t, repo.CreateDeferredTimelineRecord(deferred))
+	}
+
+	records, err := repo.ClaimDeferredTimelineRecords(recipient.UserId)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, exchanges[0].ID, records[0].ExchangeID, "Oldest first")
+		assert.Equal(t, objects.TimelineStatusPending, records[0].Status)
+	}
+
+	claimed, err := repo.ClaimDeferredTimelineRecords(recipient.UserId)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed, "A claimed record is no longer deferred")
+
+	releaseAt := time.Now().UTC().Add(time.Hour)
+	assert.NoError(t, repo.HoldTimelineRecord(records[0].ID, releaseAt))
+	held, err := repo.ClaimHeldTimelineRecords(releaseAt, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, held, 1) {
+		assert.Equal(t, records[0].ID, held[0].ID)
+	}
+}
+
+func TestDigests(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	author := &objects.User{UserId: 123496, Username: "digestauthor", LanguageCode: "en", MenuId: objects.Menu_Main}
+	recipient := &objects.User{UserId: 123497, Username: "digestrecipient", LanguageCode: "en", MenuId: objects.Menu_Main}
+	assert.NoError(t, repo.SaveUser(author))
+	assert.NoError(t, repo.SaveUser(recipient))
+
+	var recordIDs []int64
+	for i := 0; i < 2; i++ {
+		exchange := &objects.Exchange{
+			UserID:            author.UserId,
+			ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+			Status:            objects.ExchangeStatusPosted,
+		}
+		assert.NoError(t, repo.CreateExchange(exchange))
+
+		collected := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+		collected.Status = objects.TimelineStatusDigest
+		assert.NoError(t, repo.CreateDeferredTimelineRecord(collected))
+		recordIDs = append(recordIDs, collected.ID)
+	}
+
+	records, err := repo.GetCollectedRecords(recipient.UserId, objects.TimelineStatusDigest)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	due, err := repo.GetDueCollectedRecipients(objects.TimelineStatusDigest, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.NotContains(t, due, recipient.UserId, "Collected too recently")
+	due, err = repo.GetDueCollectedRecipients(objects.TimelineStatusDigest, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Contains(t, due, recipient.UserId)
+
+	digest, err := repo.CreateDigest(recipient.UserId, recordIDs)
+	assert.NoError(t, err)
+	records, err = repo.GetCollectedRecords(recipient.UserId, objects.TimelineStatusDigest)
+	assert.NoError(t, err)
+	assert.Empty(t, records, "Records in a digest are no longer collected")
+
+	messageID := 777
+	assert.NoError(t, repo.RecordDigestDelivery(digest.ID, &messageID, objects.TimelineStatusSent))
+	stored, err := repo.GetDigest(digest.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, messageID, *stored.TelegramMessageID)
+		assert.NotNil(t, stored.SentAt)
+	}
+
+	records, err = repo.GetTimelineRecordsByDigest(digest.ID)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	for _, record := range records {
+		assert.Equal(t, objects.TimelineStatusSent, record.Status)
+		assert.Equal(t, messageID, *record.TelegramMessageID)
+		assert.Equal(t, digest.ID, *record.DigestID)
+	}
+}
+
+func TestCountSentNotificationsByUsers(t *testing.T) {
+	db := setupTestDB(t)
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	author := &objects.User{UserId: 123498, Username: "capauthor", LanguageCode: "en", MenuId: objects.Menu_Main}
+	recipient := &objects.User{UserId: 123499, Username: "caprecipient", LanguageCode: "en", MenuId: objects.Menu_Main}
+	assert.NoError(t, repo.SaveUser(author))
+	assert.NoError(t, repo.SaveUser(recipient))
+
+	for _, userID := range []int64{author.UserId, author.UserId, recipient.UserId} {
+		exchange := &objects.Exchange{
+			UserID:            userID,
+			ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+			Status:            objects.ExchangeStatusPosted,
+		}
+		assert.NoError(t, repo.CreateExchange(exchange))
+
+		record := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+		record.Status, record.SentAt = objects.TimelineStatusSent, &record.CreatedAt
+		assert.NoError(t, repo.CreateTimelineRecord(record))
+	}
+
+	// Sent two hours ago, the exchange was deleted just now
+	exchange := &objects.Exchange{
+		UserID:            author.UserId,
+		ExchangeDirection: objects.ExchangeDirectionCashToCrypto,
+		Status:            objects.ExchangeStatusPosted,
+	}
+	assert.NoError(t, repo.CreateExchange(exchange))
+	sentAt := time.Now().Add(-2 * time.Hour)
+	record := objects.NewTimelineRecord(exchange.ID, recipient.UserId)
+	record.Status, record.SentAt = objects.TimelineStatusSent, &sentAt
+	assert.NoError(t, repo.CreateTimelineRecord(record))
+	assert.NoError(t, repo.MarkTimelineRecordsAsDeleted(exchange.ID))
+
+	counts, err := repo.CountSentNotificationsByUsers([]int64{author.UserId, recipient.UserId}, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]int{recipient.UserId: 2}, counts, "The recipient's own exchange and earlier sends are not counted")
+}
@@ -1,31 +1,84 @@
 package sender
 
 import (
+	"context"
 	"encoding/json"
-	"librecash/context"
+	"errors"
+	"fmt"
+	librecashContext "librecash/context"
 	"librecash/metrics"
 	"librecash/objects"
 	"librecash/rabbit"
 	"log"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/streadway/amqp"
 )
 
 type Sender struct {
-	context *context.Context
+	context   *librecashContext.Context
+	stopPrune chan struct{} // closed by Stop
+	pruneDone chan struct{} // closed when the prune loop returned
 }
 
-func NewSender(context *context.Context) *Sender {
+func NewSender(context *librecashContext.Context) *Sender {
 	log.Println("[SENDER] Creating new message sender")
 	return &Sender{
-		context: context,
+		context:   context,
+		stopPrune: make(chan struct{}),
+		pruneDone: make(chan struct{}),
 	}
 }
 
-func (s *Sender) Handler(data []byte, headers amqp.Table) {
+// sentMessageRetention is how long idempotency keys of delivered messages are kept.
+// It must outlive the retry schedule; dead letters replayed later than this may be sent twice.
+const sentMessageRetention = 7 * 24 * time.Hour
+
+// sentMessagePruneInterval is how often expired idempotency keys are removed
+const sentMessagePruneInterval = time.Hour
+
+// Handler delivers a message unless its idempotency key shows it was already sent,
+// e.g. when the process crashed between the Telegram send and the RabbitMQ ack
+func (s *Sender) Handler(data []byte, headers amqp.Table) error {
+	key := rabbit.IdempotencyKeyFromHeaders(headers)
+	if key != "" {
+		sent, err := s.context.Repo.IsMessageSent(key)
+		if err != nil {
+			// Prefer a possible duplicate over a lost message
+			log.Printf("[SENDER] ERROR checking idempotency key %s, sending anyway: %v", key, err)
+		} else if sent {
+			log.Printf("[SENDER] Skipping already delivered message %s", key)
+			metrics.RecordTelegramMessage(messageTypeOf(headers), "duplicate", "none")
+			return nil
+		}
+	}
+
+	if err := s.dispatch(data, headers); err != nil {
+		return err
+	}
+
+	if key != "" {
+		if err := s.context.Repo.MarkMessageSent(key); err != nil {
+			log.Printf("[SENDER] ERROR recording idempotency key %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// messageTypeOf returns the message_type header, "regular" when missing
+func messageTypeOf(headers amqp.Table) string {
+	if messageType, ok := headers["message_type"].(string); ok {
+		return messageType
+	}
+	return "regular"
+}
+
+// dispatch routes the message to the handler of its type
+func (s *Sender) dispatch(data []byte, headers amqp.Table) error {
 	// Check message type from headers
 	if messageType, ok := headers["message_type"]; ok {
 		switch messageType {
@@ -33,32 +86,29 @@ func (s *Sender) Handler(data []byte, headers amqp.Table) {
 			var messageBag rabbit.MessageBag
 			if err := json.Unmarshal(data, &messageBag); err != nil {
 				log.Printf("[SENDER] Failed to unmarshal exchange notification: %v", err)
-				return
+				return rabbit.Permanent(err)
 			}
 			log.Printf("[SENDER] Processing exchange notification for chat %d with priority %d",
 				messageBag.Message.ChatID, messageBag.Priority)
-			s.handleExchangeNotification(&messageBag, headers)
-			return
+			return s.handleExchangeNotification(&messageBag, headers)
 		case "callback_answer":
 			var callbackBag rabbit.CallbackAnswerBag
 			if err := json.Unmarshal(data, &callbackBag); err != nil {
 				log.Printf("[SENDER] Failed to unmarshal callback answer: %v", err)
-				return
+				return rabbit.Permanent(err)
 			}
 			log.Printf("[SENDER] Processing callback answer %s with priority %d",
 				callbackBag.CallbackAnswer.CallbackQueryID, callbackBag.Priority)
-			s.handleCallbackAnswer(&callbackBag)
-			return
+			return s.handleCallbackAnswer(&callbackBag)
 		case "edit_message":
 			var editBag rabbit.EditMessageBag
 			if err := json.Unmarshal(data, &editBag); err != nil {
 				log.Printf("[SENDER] Failed to unmarshal edit message: %v", err)
-				return
+				return rabbit.Permanent(err)
 			}
 			log.Printf("[SENDER] Processing message edit for message %d in chat %d with priority %d",
 				editBag.EditMessage.MessageID, editBag.EditMessage.ChatID, editBag.Priority)
-			s.handleEditMessage(&editBag)
-			return
+			return s.handleEditMessage(&editBag)
 		}
 	}
 
@@ -66,14 +116,14 @@ func (s *Sender) Handler(data []byte, headers amqp.Table) {
 	var messageBag rabbit.MessageBag
 	if err := json.Unmarshal(data, &messageBag); err != nil {
 		log.Printf("[SENDER] Failed to unmarshal regular message: %v", err)
-		return
+		return rabbit.Permanent(err)
 	}
 	log.Printf("[SENDER] Processing regular message for chat %d with priority %d",
 		messageBag.Message.ChatID, messageBag.Priority)
-	s.handleRegularMessage(&messageBag)
+	return s.handleRegularMessage(&messageBag)
 }
 
-func (s *Sender) handleRegularMessage(messageBag *rabbit.MessageBag) {
+func (s *Sender) handleRegularMessage(messageBag *rabbit.MessageBag) error {
 	log.Printf("[SENDER] Processing regular message for chat %d", messageBag.Message.ChatID)
 
 	startTime := time.Now()
@@ -92,28 +142,31 @@ func (s *Sender) handleRegularMessage(messageBag *rabbit.MessageBag) {
 			errorCode = strconv.Itoa(extractErrorCode(err))
 		}
 		metrics.RecordTelegramMessage("regular", "failed", errorCode)
-	} else {
-		log.Printf("[SENDER] Successfully sent message to chat %d (duration: %v)",
-			messageBag.Message.ChatID, duration)
-		// Record successful telegram message metric
-		metrics.RecordTelegramMessage("regular", "sent", "none")
+		s.markUnreachableIfGone(messageBag.Message.ChatID, err)
+		return classifySendError(err)
 	}
+
+	log.Printf("[SENDER] Successfully sent message to chat %d (duration: %v)",
+		messageBag.Message.ChatID, duration)
+	// Record successful telegram message metric
+	metrics.RecordTelegramMessage("regular", "sent", "none")
+	return nil
 }
 
-func (s *Sender) handleExchangeNotification(messageBag *rabbit.MessageBag, headers amqp.Table) {
+func (s *Sender) handleExchangeNotification(messageBag *rabbit.MessageBag, headers amqp.Table) error {
 	log.Printf("[SENDER] Processing exchange notification for chat %d", messageBag.Message.ChatID)
 
 	// Extract exchange information from headers
 	exchangeID, ok := headers["exchange_id"].(int64)
 	if !ok {
 		log.Printf("[SENDER] ERROR: Invalid exchange_id in headers")
-		return
+		return rabbit.Permanent(fmt.Errorf("invalid exchange_id header: %v", headers["exchange_id"]))
 	}
 
 	recipientUserID, ok := headers["recipient_user_id"].(int64)
 	if !ok {
 		log.Printf("[SENDER] ERROR: Invalid recipient_user_id in headers")
-		return
+		return rabbit.Permanent(fmt.Errorf("invalid recipient_user_id header: %v", headers["recipient_user_id"]))
 	}
 
 	startTime := time.Now()
@@ -133,31 +186,68 @@ func (s *Sender) handleExchangeNotification(messageBag *rabbit.MessageBag, heade
 			errorCode = strconv.Itoa(extractErrorCode(err))
 		}
 		metrics.RecordTelegramMessage("exchange_notification", "failed", errorCode)
+		s.markUnreachableIfGone(recipientUserID, err)
 
-		// Create timeline record with 'failed' status (no Telegram message ID)
-		record := objects.NewTimelineRecord(exchangeID, recipientUserID)
-		record.Status = objects.TimelineStatusFailed
-		if createErr := s.context.Repo.CreateTimelineRecord(record); createErr != nil {
-			log.Printf("[SENDER] ERROR creating failed timeline record: %v", createErr)
+		sendErr := classifySendError(err)
+		if !rabbit.IsPermanent(sendErr) && !rabbit.IsLastAttempt(headers) {
+			// Retryable - the timeline record is written once the retry settles
+			return sendErr
 		}
-	} else {
-		log.Printf("[SENDER] Successfully sent exchange notification to chat %d (duration: %v)",
-			messageBag.Message.ChatID, duration)
 
-		// Record successful telegram message metric
-		metrics.RecordTelegramMessage("exchange_notification", "sent", "none")
+		// Failed for good or out of attempts: create timeline record with 'failed' status
+		// (no Telegram message ID)
+		s.recordTimeline(headers, exchangeID, recipientUserID, nil, objects.TimelineStatusFailed)
+		s.recordFanoutJobDelivery(headers, false)
+		return sendErr
+	}
+
+	log.Printf("[SENDER] Successfully sent exchange notification to chat %d (duration: %v)",
+		messageBag.Message.ChatID, duration)
+
+	// Record successful telegram message metric
+	metrics.RecordTelegramMessage("exchange_notification", "sent", "none")
+
+	// Create timeline record with Telegram message ID and 'sent' status
+	s.recordTimeline(headers, exchangeID, recipientUserID, &sentMessage.MessageID, objects.TimelineStatusSent)
+	s.recordFanoutJobDelivery(headers, true)
+	return nil
+}
 
-		// Create timeline record with Telegram message ID and 'sent' status
-		record := objects.NewTimelineRecord(exchangeID, recipientUserID)
-		record.TelegramMessageID = &sentMessage.MessageID
-		record.Status = objects.TimelineStatusSent
-		if createErr := s.context.Repo.CreateTimelineRecord(record); createErr != nil {
-			log.Printf("[SENDER] ERROR creating sent timeline record: %v", createErr)
+// recordTimeline stores the outcome of a notification: on the timeline record of the exchange,
+// or for a digest on the records of all its offers
+func (s *Sender) recordTimeline(headers amqp.Table, exchangeID, recipientUserID int64, telegramMessageID *int, status string) {
+	if digestID, ok := headers["digest_id"].(int64); ok {
+		if err := s.context.Repo.RecordDigestDelivery(digestID, telegramMessageID, status); err != nil {
+			log.Printf("[SENDER] ERROR recording %s digest %d: %v", status, digestID, err)
 		}
+		return
+	}
+
+	record := objects.NewTimelineRecord(exchangeID, recipientUserID)
+	record.TelegramMessageID = telegramMessageID
+	record.Status = status
+	if status == objects.TimelineStatusSent {
+		sentAt := time.Now()
+		record.SentAt = &sentAt
+	}
+	if err := s.context.Repo.CreateTimelineRecord(record); err != nil {
+		log.Printf("[SENDER] ERROR creating %s timeline record: %v", status, err)
 	}
 }
 
-func (s *Sender) handleCallbackAnswer(callbackBag *rabbit.CallbackAnswerBag) {
+// recordFanoutJobDelivery counts a delivered or finally failed notification in the stats
+// of the fanout job it belongs to
+func (s *Sender) recordFanoutJobDelivery(headers amqp.Table, sent bool) {
+	jobID, ok := headers["fanout_job_id"].(int64)
+	if !ok {
+		return
+	}
+	if err := s.context.Repo.RecordFanoutJobDelivery(jobID, sent); err != nil {
+		log.Printf("[SENDER] ERROR recording delivery for fanout job %d: %v", jobID, err)
+	}
+}
+
+func (s *Sender) handleCallbackAnswer(callbackBag *rabbit.CallbackAnswerBag) error {
 	log.Printf("[SENDER] Processing callback answer %s", callbackBag.CallbackAnswer.CallbackQueryID)
 
 	startTime := time.Now()
@@ -176,15 +266,17 @@ func (s *Sender) handleCallbackAnswer(callbackBag *rabbit.CallbackAnswerBag) {
 			errorCode = strconv.Itoa(extractErrorCode(err))
 		}
 		metrics.RecordTelegramMessage("callback_answer", "failed", errorCode)
-	} else {
-		log.Printf("[SENDER] Successfully answered callback query %s (duration: %v)",
-			callbackBag.CallbackAnswer.CallbackQueryID, duration)
-		// Record successful telegram callback metric
-		metrics.RecordTelegramMessage("callback_answer", "sent", "none")
+		return classifySendError(err)
 	}
+
+	log.Printf("[SENDER] Successfully answered callback query %s (duration: %v)",
+		callbackBag.CallbackAnswer.CallbackQueryID, duration)
+	// Record successful telegram callback metric
+	metrics.RecordTelegramMessage("callback_answer", "sent", "none")
+	return nil
 }
 
-func (s *Sender) handleEditMessage(editBag *rabbit.EditMessageBag) {
+func (s *Sender) handleEditMessage(editBag *rabbit.EditMessageBag) error {
 	log.Printf("[SENDER] Processing message edit for message %d in chat %d",
 		editBag.EditMessage.MessageID, editBag.EditMessage.ChatID)
 
@@ -195,6 +287,13 @@ func (s *Sender) handleEditMessage(editBag *rabbit.EditMessageBag) {
 
 	duration := time.Since(startTime)
 
+	if reason := settledEditReason(err); reason != "" {
+		// Retrying can't change the outcome, and dead-lettering would hide real failures
+		log.Printf("[SENDER] Nothing to edit for message %d in chat %d (%s)",
+			editBag.EditMessage.MessageID, editBag.EditMessage.ChatID, reason)
+		metrics.RecordTelegramMessage("edit_message", "skipped", reason)
+		return nil
+	}
 	if err != nil {
 		log.Printf("[SENDER] ERROR editing message %d in chat %d: %v (duration: %v)",
 			editBag.EditMessage.MessageID, editBag.EditMessage.ChatID, err, duration)
@@ -204,12 +303,31 @@ func (s *Sender) handleEditMessage(editBag *rabbit.EditMessageBag) {
 			errorCode = strconv.Itoa(extractErrorCode(err))
 		}
 		metrics.RecordTelegramMessage("edit_message", "failed", errorCode)
-	} else {
-		log.Printf("[SENDER] Successfully edited message %d in chat %d (duration: %v)",
-			editBag.EditMessage.MessageID, editBag.EditMessage.ChatID, duration)
-		// Record successful telegram edit metric
-		metrics.RecordTelegramMessage("edit_message", "sent", "none")
+		s.markUnreachableIfGone(editBag.EditMessage.ChatID, err)
+		return classifySendError(err)
 	}
+
+	log.Printf("[SENDER] Successfully edited message %d in chat %d (duration: %v)",
+		editBag.EditMessage.MessageID, editBag.EditMessage.ChatID, duration)
+	// Record successful telegram edit metric
+	metrics.RecordTelegramMessage("edit_message", "sent", "none")
+	return nil
+}
+
+// markUnreachableIfGone flags the user as unreachable when Telegram says the bot was blocked
+// or the chat no longer exists, so fanout stops spending rate-limit budget on them
+func (s *Sender) markUnreachableIfGone(userId int64, err error) {
+	reason := unreachableReason(err)
+	if reason == "" {
+		return
+	}
+
+	log.Printf("[SENDER] User %d is unreachable (%s), flagging", userId, reason)
+	if markErr := s.context.Repo.SetUserUnreachable(userId, true); markErr != nil {
+		log.Printf("[SENDER] ERROR flagging user %d as unreachable: %v", userId, markErr)
+		return
+	}
+	metrics.RecordUserUnreachable(reason)
 }
 
 func (s *Sender) Start() {
@@ -220,9 +338,51 @@ func (s *Sender) Start() {
 	// The rate limiting is handled in the RabbitClient
 	s.context.RabbitConsume.RegisterHandler(s.Handler)
 
+	// Keep the deduplication store bounded
+	go s.pruneSentMessages()
+
 	log.Println("[SENDER] Message sender service started successfully")
 }
 
+// Stop stops pruning the idempotency keys and waits for a prune in progress, so the database
+// can be closed afterwards. The consumers are stopped by the transport.
+func (s *Sender) Stop(ctx context.Context) error {
+	select {
+	case <-s.stopPrune:
+	default:
+		close(s.stopPrune)
+	}
+
+	select {
+	case <-s.pruneDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pruneSentMessages removes expired idempotency keys every sentMessagePruneInterval until Stop
+func (s *Sender) pruneSentMessages() {
+	defer close(s.pruneDone)
+
+	ticker := time.NewTicker(sentMessagePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if pruned, err := s.context.Repo.PruneSentMessages(sentMessageRetention); err != nil {
+			log.Printf("[SENDER] ERROR pruning idempotency keys: %v", err)
+		} else if pruned > 0 {
+			log.Printf("[SENDER] Pruned %d expired idempotency key(s)", pruned)
+		}
+
+		select {
+		case <-s.stopPrune:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // httpErrorCodeRegex matches HTTP status codes (4xx or 5xx) in error messages
 // Uses negative lookbehind/lookahead to avoid matching phone numbers or other contexts
 var httpErrorCodeRegex = regexp.MustCompile(`(?:^|\s|:|\(|-)([4-5]\d{2})(?:\s|$|:|!|\)|,)`)
@@ -246,3 +406,118 @@ func extractErrorCode(err error) int {
 
 	return 0 // Unknown error - no HTTP code found
 }
+
+// classifySendError wraps Telegram errors that will never succeed on retry as permanent,
+// so the consumer dead-letters them instead of retrying, and flood waits with their
+// retry_after, so the retry is not attempted before Telegram allows it
+func classifySendError(err error) error {
+	if !isRetryableSendError(err) {
+		return rabbit.Permanent(err)
+	}
+	var apiErr tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return rabbit.RetryAfter(err, time.Duration(apiErr.RetryAfter)*time.Second)
+	}
+	return err
+}
+
+// isRetryableSendError reports whether a failed Telegram call is worth retrying:
+// rate limits (429), server errors (5xx) and network failures are retryable,
+// client errors (400, 403, ...) are permanent
+func isRetryableSendError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Telegram tells us explicitly when to come back
+	var apiErr tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return true
+	}
+
+	code := extractErrorCode(err)
+	if code == 429 || code >= 500 {
+		return true
+	}
+	if code >= 400 {
+		return false
+	}
+
+	// Telegram descriptions carry the status text instead of the code,
+	// e.g. "Forbidden: bot was blocked by the user"
+	description := strings.ToLower(err.Error())
+	for _, prefix := range retryableDescriptionPrefixes {
+		if strings.HasPrefix(description, prefix) {
+			return true
+		}
+	}
+	for _, prefix := range permanentDescriptionPrefixes {
+		if strings.HasPrefix(description, prefix) {
+			return false
+		}
+	}
+
+	// Anything else is a transport-level failure (timeouts, connection resets, DNS)
+	return true
+}
+
+// unreachableErrors maps Telegram error descriptions meaning the user can't be messaged anymore
+// to a short reason used in logs and metrics
+var unreachableErrors = map[string]string{
+	"bot was blocked by the user": "blocked",
+	"chat not found":              "chat_not_found",
+	"user is deactivated":         "deactivated",
+}
+
+// unreachableReason returns why the user can't be messaged, or "" if the error is unrelated
+func unreachableReason(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	description := strings.ToLower(err.Error())
+	for text, reason := range unreachableErrors {
+		if strings.Contains(description, text) {
+			return reason
+		}
+	}
+	return ""
+}
+
+// settledEditErrors maps Telegram edit errors meaning there is nothing left to do, the message
+// already shows the new text or is gone, to a short reason used in logs and metrics
+var settledEditErrors = map[string]string{
+	"message is not modified":   "not_modified",
+	"message to edit not found": "not_found",
+}
+
+// settledEditReason returns why a failed edit needs no retry, or "" if it is a real failure
+func settledEditReason(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	description := strings.ToLower(err.Error())
+	for text, reason := range settledEditErrors {
+		if strings.Contains(description, text) {
+			return reason
+		}
+	}
+	return ""
+}
+
+var retryableDescriptionPrefixes = []string{
+	"too many requests",
+	"internal server error",
+	"bad gateway",
+	"service unavailable",
+	"gateway timeout",
+}
+
+var permanentDescriptionPrefixes = []string{
+	"bad request",
+	"unauthorized",
+	"forbidden",
+	"not found",
+	"conflict",
+}
@@ -1,8 +1,19 @@
 package sender
 
 import (
+	stdcontext "context"
+	"encoding/json"
 	"errors"
+	"librecash/context"
+	"librecash/objects"
+	"librecash/rabbit"
+	"librecash/repository"
+	"librecash/telegramtest"
 	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/streadway/amqp"
 )
 
 func TestExtractErrorCode(t *testing.T) {
@@ -181,3 +192,314 @@ func TestExtractErrorCodeEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestIsRetryableSendError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "nil error is not retried",
+			err:      nil,
+			expected: false,
+		},
+		{
+			name:     "rate limit with retry_after",
+			err:      tgbotapi.Error{Message: "Too Many Requests: retry after 5", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}},
+			expected: true,
+		},
+		{
+			name:     "rate limit description",
+			err:      errors.New("Too Many Requests: retry after 35"),
+			expected: true,
+		},
+		{
+			name:     "HTTP 502 Bad Gateway",
+			err:      errors.New("Bad Gateway: 502 upstream error"),
+			expected: true,
+		},
+		{
+			name:     "internal server error description",
+			err:      errors.New("Internal Server Error"),
+			expected: true,
+		},
+		{
+			name:     "network failure",
+			err:      errors.New("Post \"https://api.telegram.org/bot/sendMessage\": dial tcp: i/o timeout"),
+			expected: true,
+		},
+		{
+			name:     "bot blocked by user",
+			err:      errors.New("Forbidden: bot was blocked by the user"),
+			expected: false,
+		},
+		{
+			name:     "chat not found",
+			err:      errors.New("Bad Request: chat not found"),
+			expected: false,
+		},
+		{
+			name:     "HTTP 400 code",
+			err:      errors.New("Request failed with code 400"),
+			expected: false,
+		},
+		{
+			name:     "HTTP 403 code",
+			err:      errors.New("Forbidden: 403 bot blocked"),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := isRetryableSendError(tt.err)
+			if result != tt.expected {
+				t.Errorf("isRetryableSendError() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClassifySendError(t *testing.T) {
+	permanent := classifySendError(errors.New("Forbidden: bot was blocked by the user"))
+	if !rabbit.IsPermanent(permanent) {
+		t.Errorf("expected blocked-by-user error to be permanent")
+	}
+
+	retryable := classifySendError(errors.New("Too Many Requests: retry after 3"))
+	if rabbit.IsPermanent(retryable) {
+		t.Errorf("expected rate limit error to be retryable")
+	}
+}
+
+func TestUnreachableReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "nil error",
+			err:      nil,
+			expected: "",
+		},
+		{
+			name:     "bot blocked by user",
+			err:      errors.New("Forbidden: bot was blocked by the user"),
+			expected: "blocked",
+		},
+		{
+			name:     "chat not found",
+			err:      errors.New("Bad Request: chat not found"),
+			expected: "chat_not_found",
+		},
+		{
+			name:     "deactivated account",
+			err:      errors.New("Forbidden: user is deactivated"),
+			expected: "deactivated",
+		},
+		{
+			name:     "message to edit not found is not about the user",
+			err:      errors.New("Bad Request: message to edit not found"),
+			expected: "",
+		},
+		{
+			name:     "rate limit",
+			err:      errors.New("Too Many Requests: retry after 5"),
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := unreachableReason(tt.err)
+			if result != tt.expected {
+				t.Errorf("unreachableReason() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEditWithNothingToChangeSucceeds(t *testing.T) {
+	server := telegramtest.NewServer()
+	defer server.Close()
+	bot, err := server.NewBot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	appContext := &context.Context{Repo: repository.NewMemoryRepository()}
+	appContext.SetBot(bot)
+	s := NewSender(appContext)
+
+	data, err := json.Marshal(rabbit.EditMessageBag{EditMessage: tgbotapi.NewEditMessageText(2, 42, "offer")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := amqp.Table{"message_type": "edit_message"}
+
+	tests := []struct {
+		description string
+		permanent   bool
+	}{
+		{"Bad Request: message is not modified: specified new message content and reply markup are exactly the same", false},
+		{"Bad Request: message to edit not found", false},
+		{"Bad Request: message text is empty", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			server.Fail("editMessageText", 400, tt.description)
+			err := s.Handler(data, headers)
+			if tt.permanent && !rabbit.IsPermanent(err) {
+				t.Errorf("expected a permanent error, got %v", err)
+			}
+			if !tt.permanent && err != nil {
+				t.Errorf("expected the edit to be settled, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClassifySendErrorKeepsRetryAfter(t *testing.T) {
+	err := classifySendError(tgbotapi.Error{Message: "Too Many Requests: retry after 30", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 30}})
+
+	var retryAfterErr *rabbit.RetryAfterError
+	if !errors.As(err, &retryAfterErr) {
+		t.Fatalf("expected the flood wait to carry retry_after, got %v", err)
+	}
+	if retryAfterErr.After != 30*time.Second {
+		t.Errorf("retry after = %v, expected 30s", retryAfterErr.After)
+	}
+	if rabbit.IsPermanent(err) {
+		t.Errorf("expected flood wait to be retryable")
+	}
+}
+
+func TestExchangeNotificationOutOfAttemptsIsRecordedAsFailed(t *testing.T) {
+	server := telegramtest.NewServer()
+	defer server.Close()
+	bot, err := server.NewBot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewMemoryRepository()
+	appContext := &context.Context{Repo: repo}
+	appContext.SetBot(bot)
+	s := NewSender(appContext)
+
+	job, err := repo.CreateFanoutJob(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(rabbit.MessageBag{Message: tgbotapi.NewMessage(2, "offer")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := amqp.Table{
+		"message_type":      "exchange_notification",
+		"exchange_id":       int64(1),
+		"recipient_user_id": int64(2),
+		"fanout_job_id":     job.ID,
+	}
+
+	// A retryable failure with attempts left is recorded once the retry settles
+	server.Fail("sendMessage", 502, "Bad Gateway")
+	if err := s.Handler(data, headers); err == nil || rabbit.IsPermanent(err) {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+	if records, _ := repo.GetTimelineRecordsByExchange(1); len(records) != 0 {
+		t.Fatalf("expected no timeline record before the last attempt, got %d", len(records))
+	}
+
+	// The last attempt is recorded like a permanent failure
+	headers["x-librecash-attempts"] = int32(rabbit.MaxDeliveryAttempts - 1)
+	server.Fail("sendMessage", 502, "Bad Gateway")
+	if err := s.Handler(data, headers); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+	records, _ := repo.GetTimelineRecordsByExchange(1)
+	if len(records) != 1 || records[0].Status != objects.TimelineStatusFailed {
+		t.Fatalf("expected one failed timeline record, got %+v", records)
+	}
+	if records[0].SentAt != nil {
+		t.Errorf("expected no sent time for a failed notification, got %v", records[0].SentAt)
+	}
+	job, _ = repo.GetFanoutJobByExchange(1)
+	if job.Failed != 1 {
+		t.Errorf("fanout job failures = %d, expected 1", job.Failed)
+	}
+}
+
+func TestExchangeNotificationRecordsSentTime(t *testing.T) {
+	server := telegramtest.NewServer()
+	defer server.Close()
+	bot, err := server.NewBot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewMemoryRepository()
+	appContext := &context.Context{Repo: repo}
+	appContext.SetBot(bot)
+	s := NewSender(appContext)
+
+	data, err := json.Marshal(rabbit.MessageBag{Message: tgbotapi.NewMessage(2, "offer")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := amqp.Table{
+		"message_type":      "exchange_notification",
+		"exchange_id":       int64(1),
+		"recipient_user_id": int64(2),
+	}
+
+	before := time.Now()
+	if err := s.Handler(data, headers); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := repo.GetTimelineRecordsByExchange(1)
+	if len(records) != 1 || records[0].Status != objects.TimelineStatusSent {
+		t.Fatalf("expected one sent timeline record, got %+v", records)
+	}
+	if records[0].SentAt == nil || records[0].SentAt.Before(before) {
+		t.Errorf("sent time = %v, expected the time of the send", records[0].SentAt)
+	}
+}
+
+// countingRepository counts the prunes of the idempotency keys
+type countingRepository struct {
+	*repository.MemoryRepository
+	prunes chan struct{}
+}
+
+func (r *countingRepository) PruneSentMessages(maxAge time.Duration) (int64, error) {
+	r.prunes <- struct{}{}
+	return 0, errors.New("database is closed")
+}
+
+func TestSenderStopEndsPruning(t *testing.T) {
+	repo := &countingRepository{MemoryRepository: repository.NewMemoryRepository(), prunes: make(chan struct{}, 10)}
+	transport := rabbit.NewMemoryTransport(1)
+	defer transport.Close()
+	s := NewSender(&context.Context{Repo: repo, RabbitConsume: transport})
+	s.Start()
+
+	select {
+	case <-repo.prunes:
+	case <-time.After(time.Second):
+		t.Fatal("expected a prune on start")
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("stopping twice = %v", err)
+	}
+	select {
+	case <-repo.prunes:
+		t.Error("pruned after Stop")
+	default:
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"librecash/bugsink"
+	"librecash/metrics"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds the whole graceful shutdown, work still running after it is cut off
+const shutdownTimeout = 30 * time.Second
+
+// shutdownStage orders the shutdown steps: stop taking work, finish what was taken, disconnect
+type shutdownStage int
+
+const (
+	stageIntake      shutdownStage = iota // stop polling Telegram and relaying the outbox
+	stageDrain                            // let consumers finish and ack the current message
+	stageConnections                      // close RabbitMQ and database connections
+)
+
+// shutdownStep stops one component and describes what it drained
+type shutdownStep struct {
+	stage shutdownStage
+	name  string
+	stop  func(ctx context.Context) (string, error)
+}
+
+// shutdownSequence collects the steps registered by the components as they start
+type shutdownSequence struct {
+	mu    sync.Mutex
+	steps []shutdownStep
+}
+
+// appShutdown is the sequence of the running bot
+var appShutdown = &shutdownSequence{}
+
+// register adds a step, steps of one stage run in registration order
+func (s *shutdownSequence) register(stage shutdownStage, name string, stop func(ctx context.Context) (string, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.steps = append(s.steps, shutdownStep{stage: stage, name: name, stop: stop})
+}
+
+// run stops every registered component stage by stage and returns one report line per step.
+// Steps still run after ctx ends, so connections get closed, but they see the expired context.
+func (s *shutdownSequence) run(ctx context.Context) []string {
+	s.mu.Lock()
+	steps := append([]shutdownStep(nil), s.steps...)
+	s.steps = nil
+	s.mu.Unlock()
+
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].stage < steps[j].stage })
+
+	report := make([]string, 0, len(steps))
+	for _, step := range steps {
+		started := time.Now()
+		drained, err := step.stop(ctx)
+		line := fmt.Sprintf("%s: %s (%v)", step.name, drained, time.Since(started).Round(time.Millisecond))
+		if err != nil {
+			line = fmt.Sprintf("%s: %s, incomplete: %v", step.name, drained, err)
+		}
+		log.Printf("[SHUTDOWN] %s", line)
+		report = append(report, line)
+	}
+	return report
+}
+
+// waitForShutdownSignal blocks until SIGINT or SIGTERM
+func waitForShutdownSignal() os.Signal {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	return <-sigChan
+}
+
+// gracefulShutdown stops producer and consumer, closes connections and flushes metrics and
+// BugSink, all within timeout. Returns the drain report.
+func gracefulShutdown(timeout time.Duration) []string {
+	log.Printf("[SHUTDOWN] Starting graceful shutdown (max %v)", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	report := appShutdown.run(ctx)
+
+	if err := metrics.Shutdown(ctx); err != nil {
+		log.Printf("[SHUTDOWN] Metrics server did not stop cleanly: %v", err)
+	}
+
+	// Close BugSink error tracking, flushes pending events
+	bugsink.Close()
+
+	if ctx.Err() != nil {
+		log.Printf("[SHUTDOWN] Timeout reached, remaining work was cut off")
+	}
+	log.Printf("[SHUTDOWN] Graceful shutdown completed")
+	return report
+}
@@ -25,7 +25,7 @@ show_usage() {
     echo -e "${CYAN}Available commands:${NC}"
     echo -e "  ${GREEN}up${NC}       - Start Docker services (PostgreSQL, RabbitMQ, VictoriaMetrics)"
     echo -e "  ${GREEN}run${NC}      - Build and run LibreCash bot"
-    echo -e "  ${GREEN}initdb${NC}   - Initialize/reset database schema"
+    echo -e "  ${GREEN}initdb${NC}   - Apply database migrations"
     echo -e "  ${GREEN}down${NC}     - Stop all services"
     echo ""
     echo -e "  ${YELLOW}status${NC}   - Show service status"
@@ -50,7 +50,7 @@ show_menu() {
     echo ""
     echo -e "  ${GREEN}1)${NC} ${BOLD}up${NC}     - Start Docker services (PostgreSQL, RabbitMQ)"
     echo -e "  ${GREEN}2)${NC} ${BOLD}run${NC}    - Build and run LibreCash bot"
-    echo -e "  ${GREEN}3)${NC} ${BOLD}initdb${NC} - Initialize/reset database schema"
+    echo -e "  ${GREEN}3)${NC} ${BOLD}initdb${NC} - Apply database migrations"
     echo -e "  ${GREEN}4)${NC} ${BOLD}down${NC}   - Stop all services"
     echo ""
     echo -e "  ${YELLOW}5)${NC} ${BOLD}status${NC} - Show service status"
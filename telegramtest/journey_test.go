@@ -0,0 +1,203 @@
+package telegramtest_test
+
+import (
+	"librecash/context"
+	"librecash/fanout"
+	"librecash/menu"
+	"librecash/objects"
+	"librecash/rabbit"
+	"librecash/repository"
+	"librecash/sender"
+	"librecash/telegramtest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// End-to-end journeys: fake Bot API -> menus -> in-memory transport -> sender -> fake Bot API
+
+const waitTimeout = 5 * time.Second
+
+func TestMain(m *testing.M) {
+	// Locales are loaded relative to the repository root
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+type journey struct {
+	server *telegramtest.Server
+	repo   *repository.MemoryRepository
+}
+
+// newJourney wires producer and consumer like the bot binary does, with fakes for Telegram, PostgreSQL and RabbitMQ
+func newJourney(t *testing.T) *journey {
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+
+	bot, err := server.NewBot()
+	require.NoError(t, err)
+
+	repo := repository.NewMemoryRepository()
+	transport := rabbit.NewMemoryTransport(1000)
+	t.Cleanup(transport.Close)
+
+	appContext := &context.Context{Repo: repo, RabbitPublish: transport, RabbitConsume: transport}
+	appContext.SetBot(bot)
+
+	sender.NewSender(appContext).Start()
+
+	stopFanout := make(chan struct{})
+	fanoutService := fanout.NewFanoutService(appContext)
+	go fanoutService.RunOutboxRelay(stopFanout)
+	go fanoutService.RunFanoutJobs(stopFanout)
+	t.Cleanup(func() { close(stopFanout) })
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 1
+	updates, err := bot.GetUpdatesChan(u)
+	require.NoError(t, err)
+	go func() {
+		for update := range updates {
+			menu.HandleUpdate(appContext, update)
+		}
+	}()
+	t.Cleanup(bot.StopReceivingUpdates)
+
+	return &journey{server: server, repo: repo}
+}
+
+// waitForButton waits for a message to the user offering the callback button, newer than afterMessageID
+func (j *journey) waitForButton(t *testing.T, user tgbotapi.User, data string, afterMessageID int) telegramtest.Call {
+	t.Helper()
+	call, ok := j.server.WaitForCall(waitTimeout, func(call telegramtest.Call) bool {
+		if call.Method != "sendMessage" || call.ChatID() != int64(user.ID) || call.SentMessageID <= afterMessageID {
+			return false
+		}
+		for _, buttonData := range call.CallbackData() {
+			if buttonData == data {
+				return true
+			}
+		}
+		return false
+	})
+	require.True(t, ok, "user %d never got a %q button", user.ID, data)
+	return call
+}
+
+// waitForKeyboard waits for a message to the user with a reply keyboard containing marker
+func (j *journey) waitForKeyboard(t *testing.T, user tgbotapi.User, marker string) telegramtest.Call {
+	t.Helper()
+	call, ok := j.server.WaitForCall(waitTimeout, func(call telegramtest.Call) bool {
+		return call.Method == "sendMessage" && call.ChatID() == int64(user.ID) &&
+			strings.Contains(call.Params.Get("reply_markup"), marker)
+	})
+	require.True(t, ok, "user %d never got a keyboard with %s", user.ID, marker)
+	return call
+}
+
+// onboard drives a new user from /start to the main menu and returns the main menu message
+func (j *journey) onboard(t *testing.T, user tgbotapi.User, lat, lon float64, phone string) telegramtest.Call {
+	t.Helper()
+
+	j.server.PushText(user, "/start")
+	question := j.waitForButton(t, user, "us_compliance_no", 0)
+
+	j.server.PushCallback(user, question, "us_compliance_no")
+	radiusMenu := j.waitForButton(t, user, "radius_15", 0)
+
+	j.server.PushCallback(user, radiusMenu, "radius_15")
+	j.waitForKeyboard(t, user, "request_location")
+
+	j.server.PushLocation(user, lat, lon)
+	j.waitForKeyboard(t, user, "request_contact")
+
+	j.server.PushContact(user, phone)
+	return j.waitForButton(t, user, "main:cash_to_crypto", 0)
+}
+
+func TestJourney_PostExchangeAndRevealContact(t *testing.T) {
+	j := newJourney(t)
+
+	alice := tgbotapi.User{ID: 2001, FirstName: "Alice", UserName: "alice", LanguageCode: "en"}
+	bob := tgbotapi.User{ID: 2002, FirstName: "Bob", UserName: "bob", LanguageCode: "en"}
+
+	// Both users onboard in Bangkok, ~5 km apart
+	aliceMenu := j.onboard(t, alice, 13.7563, 100.5018, "+66800000001")
+	j.onboard(t, bob, 13.7563, 100.5480, "+66800000002")
+
+	stored := j.repo.FindUser(int64(alice.ID))
+	require.NotNil(t, stored)
+	assert.Equal(t, objects.Menu_Main, stored.MenuId)
+	assert.Equal(t, "+66800000001", stored.PhoneNumber)
+	assert.Equal(t, 15, *stored.SearchRadiusKm)
+
+	// Alice posts a cash to crypto exchange for $100
+	j.server.PushCallback(alice, aliceMenu, "main:cash_to_crypto")
+	amountMenu := j.waitForButton(t, alice, "amount:100", aliceMenu.SentMessageID)
+	j.server.PushCallback(alice, amountMenu, "amount:100")
+
+	exchange := waitForExchange(t, j.repo, int64(alice.ID))
+
+	// A fanout job notifies both: Bob can reveal the contact, Alice can delete it
+	notification := j.waitForButton(t, bob, "contact:"+itoa(exchange.ID), 0)
+	j.waitForButton(t, alice, "delete:"+itoa(exchange.ID), 0)
+
+	assert.Eventually(t, func() bool {
+		job, _ := j.repo.GetFanoutJobByExchange(exchange.ID)
+		return job != nil && job.Status == objects.FanoutJobDone && job.Found == 2 && job.Queued == 2 && job.Sent == 2
+	}, waitTimeout, 10*time.Millisecond, "Job stats count both deliveries")
+
+	j.server.PushCallback(bob, notification, "contact:"+itoa(exchange.ID))
+
+	edit, ok := j.server.WaitForCall(waitTimeout, func(call telegramtest.Call) bool {
+		return call.Method == "editMessageText" && call.ChatID() == int64(bob.ID) &&
+			call.MessageID() == notification.SentMessageID
+	})
+	require.True(t, ok, "Bob's notification was never edited")
+	assert.Contains(t, edit.Text(), "@alice")
+	assert.Contains(t, edit.Text(), "+66800000001")
+
+	contactNotice, ok := j.server.WaitForCall(waitTimeout, func(call telegramtest.Call) bool {
+		return call.Method == "sendMessage" && call.ChatID() == int64(alice.ID) && strings.Contains(call.Text(), "@bob")
+	})
+	require.True(t, ok, "Alice was never told about the contact request")
+	assert.Contains(t, contactNotice.Text(), "+66800000002")
+
+	// Every button press was answered
+	assert.GreaterOrEqual(t, len(j.server.CallsTo("answerCallbackQuery")), 5)
+}
+
+func TestJourney_BlockedRecipientIsMarkedUnreachable(t *testing.T) {
+	j := newJourney(t)
+
+	carol := tgbotapi.User{ID: 2003, FirstName: "Carol", LanguageCode: "en"}
+	j.server.Fail("sendMessage", 403, "Forbidden: bot was blocked by the user")
+	j.server.PushText(carol, "/start")
+
+	assert.Eventually(t, func() bool {
+		user := j.repo.FindUser(int64(carol.ID))
+		return user != nil && user.Unreachable
+	}, waitTimeout, 10*time.Millisecond)
+}
+
+func waitForExchange(t *testing.T, repo *repository.MemoryRepository, userID int64) *objects.Exchange {
+	t.Helper()
+	var exchange *objects.Exchange
+	require.Eventually(t, func() bool {
+		exchange, _ = repo.GetLastUserExchange(userID)
+		return exchange != nil && exchange.Status == objects.ExchangeStatusPosted
+	}, waitTimeout, 10*time.Millisecond)
+	return exchange
+}
+
+func itoa(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,468 @@
+// Package telegramtest provides a fake Telegram Bot API server for end-to-end tests.
+//
+// The server implements the subset of the Bot API the bot uses (getMe, getUpdates, sendMessage,
+// editMessageText, answerCallbackQuery, deleteMessage, setWebhook and deleteWebhook). Tests
+// script incoming updates with the Push* methods and assert on the recorded outgoing calls.
+// tgbotapi has the API host hardcoded, so the bot returned by NewBot talks to the server
+// through a rewriting HTTP transport.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Token is the bot token used by NewBot
+const Token = "123456:telegramtest"
+
+// maxPollTimeout caps getUpdates long polling so stopped bots exit quickly
+const maxPollTimeout = time.Second
+
+// Call is an outgoing Bot API request recorded by the server
+type Call struct {
+	Method string
+	Params url.Values
+
+	// SentMessageID is the message_id returned for sendMessage and editMessageText
+	SentMessageID int
+}
+
+// ChatID returns the chat_id parameter of the call, 0 when missing
+func (c Call) ChatID() int64 {
+	chatID, _ := strconv.ParseInt(c.Params.Get("chat_id"), 10, 64)
+	return chatID
+}
+
+// Text returns the text parameter of the call
+func (c Call) Text() string {
+	return c.Params.Get("text")
+}
+
+// MessageID returns the message_id parameter of the call, 0 when missing
+func (c Call) MessageID() int {
+	messageID, _ := strconv.Atoi(c.Params.Get("message_id"))
+	return messageID
+}
+
+// InlineKeyboard decodes the inline keyboard of the call, nil when it has none
+func (c Call) InlineKeyboard() *tgbotapi.InlineKeyboardMarkup {
+	markup := c.Params.Get("reply_markup")
+	if markup == "" {
+		return nil
+	}
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(markup), &keyboard); err != nil || keyboard.InlineKeyboard == nil {
+		return nil
+	}
+	return &keyboard
+}
+
+// CallbackData returns the callback data of all inline buttons of the call
+func (c Call) CallbackData() []string {
+	keyboard := c.InlineKeyboard()
+	if keyboard == nil {
+		return nil
+	}
+	var data []string
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil {
+				data = append(data, *button.CallbackData)
+			}
+		}
+	}
+	return data
+}
+
+// failure is a scripted error response for the next call to a method
+type failure struct {
+	code        int
+	description string
+	retryAfter  int
+}
+
+// Server is a fake Telegram Bot API server
+type Server struct {
+	mu            sync.Mutex
+	httpServer    *httptest.Server
+	self          tgbotapi.User
+	updates       []tgbotapi.Update
+	calls         []Call
+	failures      map[string][]failure
+	nextUpdateID  int
+	nextMessageID int
+	nextCallback  int
+	changed       chan struct{} // closed and replaced whenever an update or call is recorded
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewServer starts a fake Bot API server. Close it when the test is done.
+func NewServer() *Server {
+	s := &Server{
+		self:          tgbotapi.User{ID: 1, FirstName: "LibreCash", UserName: "librecash_test_bot", IsBot: true},
+		failures:      make(map[string][]failure),
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		changed:       make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	log.Printf("[TELEGRAMTEST] Fake Bot API listening on %s", s.httpServer.URL)
+	return s
+}
+
+// URL returns the base URL of the server
+func (s *Server) URL() string {
+	return s.httpServer.URL
+}
+
+// Close stops the server and wakes up pending long polls
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.httpServer.Close()
+	})
+}
+
+// Client returns an HTTP client sending Bot API requests to the server instead of api.telegram.org
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.httpServer.URL)
+	return &http.Client{Transport: &rewriteTransport{target: target, next: http.DefaultTransport}}
+}
+
+// NewBot returns a bot connected to the server
+func (s *Server) NewBot() (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithClient(Token, s.Client())
+}
+
+// rewriteTransport redirects every request to the fake server, keeping the path
+type rewriteTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = t.target.Host
+	return t.next.RoundTrip(req)
+}
+
+// notify wakes up everyone waiting for a new update or call, must hold mu
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// PushUpdate queues an incoming update and returns its update_id
+func (s *Server) PushUpdate(update tgbotapi.Update) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+	s.notify()
+	return update.UpdateID
+}
+
+// newIncomingMessage builds a private chat message from the user, must hold mu
+func (s *Server) newIncomingMessage(from tgbotapi.User) *tgbotapi.Message {
+	message := &tgbotapi.Message{
+		MessageID: s.nextMessageID,
+		From:      &from,
+		Chat:      &tgbotapi.Chat{ID: int64(from.ID), Type: "private", UserName: from.UserName, FirstName: from.FirstName},
+		Date:      int(time.Now().Unix()),
+	}
+	s.nextMessageID++
+	return message
+}
+
+// pushMessage queues a message update built by fill
+func (s *Server) pushMessage(from tgbotapi.User, fill func(*tgbotapi.Message)) int {
+	s.mu.Lock()
+	message := s.newIncomingMessage(from)
+	s.mu.Unlock()
+
+	fill(message)
+	return s.PushUpdate(tgbotapi.Update{Message: message})
+}
+
+// PushText queues a text message from the user, commands included
+func (s *Server) PushText(from tgbotapi.User, text string) int {
+	return s.pushMessage(from, func(message *tgbotapi.Message) {
+		message.Text = text
+		if strings.HasPrefix(text, "/") {
+			length := len(text)
+			if space := strings.Index(text, " "); space > 0 {
+				length = space
+			}
+			message.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+		}
+	})
+}
+
+// PushLocation queues a shared location from the user
+func (s *Server) PushLocation(from tgbotapi.User, lat, lon float64) int {
+	return s.pushMessage(from, func(message *tgbotapi.Message) {
+		message.Location = &tgbotapi.Location{Latitude: lat, Longitude: lon}
+	})
+}
+
+// PushContact queues the user sharing their own phone number
+func (s *Server) PushContact(from tgbotapi.User, phoneNumber string) int {
+	return s.pushMessage(from, func(message *tgbotapi.Message) {
+		message.Contact = &tgbotapi.Contact{
+			PhoneNumber: phoneNumber,
+			FirstName:   from.FirstName,
+			LastName:    from.LastName,
+			UserID:      from.ID,
+		}
+	})
+}
+
+// PushCallback queues an inline button press on a message the bot sent, described by the recorded call
+func (s *Server) PushCallback(from tgbotapi.User, message Call, data string) int {
+	s.mu.Lock()
+	s.nextCallback++
+	callbackID := strconv.Itoa(s.nextCallback)
+	s.mu.Unlock()
+
+	return s.PushUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:           callbackID,
+		From:         &from,
+		ChatInstance: strconv.Itoa(from.ID),
+		Data:         data,
+		Message: &tgbotapi.Message{
+			MessageID: message.SentMessageID,
+			From:      &s.self,
+			Chat:      &tgbotapi.Chat{ID: message.ChatID(), Type: "private"},
+			Date:      int(time.Now().Unix()),
+			Text:      message.Text(),
+		},
+	}})
+}
+
+// Calls returns all recorded outgoing calls except getMe and getUpdates
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the recorded calls of one Bot API method
+func (s *Server) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range s.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// WaitForCall waits until a recorded call matches, including calls recorded before the wait
+func (s *Server) WaitForCall(timeout time.Duration, match func(Call) bool) (Call, bool) {
+	deadline := time.After(timeout)
+	seen := 0
+	for {
+		s.mu.Lock()
+		calls := s.calls[seen:]
+		seen = len(s.calls)
+		changed := s.changed
+		s.mu.Unlock()
+
+		for _, call := range calls {
+			if match(call) {
+				return call, true
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return Call{}, false
+		case <-s.closed:
+			return Call{}, false
+		}
+	}
+}
+
+// WaitForMessage waits for a sendMessage call to the chat
+func (s *Server) WaitForMessage(timeout time.Duration, chatID int64) (Call, bool) {
+	return s.WaitForCall(timeout, func(call Call) bool {
+		return call.Method == "sendMessage" && call.ChatID() == chatID
+	})
+}
+
+// Fail makes the next call to the method fail with the Telegram error code and description,
+// e.g. Fail("sendMessage", 403, "Forbidden: bot was blocked by the user")
+func (s *Server) Fail(method string, code int, description string) {
+	s.FailWithRetryAfter(method, code, description, 0)
+}
+
+// FailWithRetryAfter is Fail with a retry_after response parameter, as sent with 429 errors
+func (s *Server) FailWithRetryAfter(method string, code int, description string, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[method] = append(s.failures[method], failure{code: code, description: description, retryAfter: retryAfter})
+}
+
+// takeFailure pops the next scripted failure of the method, must hold mu
+func (s *Server) takeFailure(method string) *failure {
+	pending := s.failures[method]
+	if len(pending) == 0 {
+		return nil
+	}
+	s.failures[method] = pending[1:]
+	return &pending[0]
+}
+
+// serveHTTP dispatches /bot<token>/<method> requests
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "bot"+Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", 0)
+		return
+	}
+	method := parts[1]
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: %v", err), 0)
+		return
+	}
+
+	switch method {
+	case "getMe":
+		writeResult(w, s.self)
+	case "getUpdates":
+		s.handleGetUpdates(w, r.Form)
+	case "sendMessage", "editMessageText", "answerCallbackQuery", "deleteMessage", "setWebhook", "deleteWebhook":
+		s.handleCall(w, method, r.Form)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found: method not implemented by telegramtest", 0)
+	}
+}
+
+// handleGetUpdates returns pending updates from offset, long polling up to timeout seconds
+func (s *Server) handleGetUpdates(w http.ResponseWriter, params url.Values) {
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	limit, _ := strconv.Atoi(params.Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	timeoutSeconds, _ := strconv.Atoi(params.Get("timeout"))
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	if timeout > maxPollTimeout {
+		timeout = maxPollTimeout
+	}
+	deadline := time.After(timeout)
+
+	for {
+		s.mu.Lock()
+		// Like Telegram, requesting an offset confirms all earlier updates
+		kept := s.updates[:0]
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				kept = append(kept, update)
+			}
+		}
+		s.updates = kept
+
+		updates := append([]tgbotapi.Update{}, s.updates...)
+		if len(updates) > limit {
+			updates = updates[:limit]
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(updates) > 0 || timeout == 0 {
+			writeResult(w, updates)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			writeResult(w, updates)
+			return
+		case <-s.closed:
+			writeResult(w, updates)
+			return
+		}
+	}
+}
+
+// handleCall records an outgoing call and answers it like Telegram would
+func (s *Server) handleCall(w http.ResponseWriter, method string, params url.Values) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	call := Call{Method: method, Params: params}
+	defer func() {
+		s.calls = append(s.calls, call)
+		s.notify()
+	}()
+
+	if f := s.takeFailure(method); f != nil {
+		writeError(w, f.code, f.description, f.retryAfter)
+		return
+	}
+
+	switch method {
+	case "sendMessage":
+		call.SentMessageID = s.nextMessageID
+		s.nextMessageID++
+		writeResult(w, s.outgoingMessage(call))
+	case "editMessageText":
+		call.SentMessageID = call.MessageID()
+		writeResult(w, s.outgoingMessage(call))
+	default:
+		writeResult(w, true)
+	}
+}
+
+// outgoingMessage is the Message returned for a sent or edited message
+func (s *Server) outgoingMessage(call Call) tgbotapi.Message {
+	return tgbotapi.Message{
+		MessageID: call.SentMessageID,
+		From:      &s.self,
+		Chat:      &tgbotapi.Chat{ID: call.ChatID(), Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      call.Text(),
+	}
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), 0)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
+
+func writeError(w http.ResponseWriter, code int, description string, retryAfter int) {
+	response := tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description}
+	if retryAfter > 0 {
+		response.Parameters = &tgbotapi.ResponseParameters{RetryAfter: retryAfter}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
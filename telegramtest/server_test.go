@@ -0,0 +1,101 @@
+package telegramtest
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_GetMe(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	bot, err := server.NewBot()
+	require.NoError(t, err)
+	assert.Equal(t, "librecash_test_bot", bot.Self.UserName)
+}
+
+func TestServer_GetUpdatesConfirmsOffset(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	bot, err := server.NewBot()
+	require.NoError(t, err)
+
+	user := tgbotapi.User{ID: 42, FirstName: "Test"}
+	first := server.PushText(user, "/start")
+	server.PushCallback(user, Call{SentMessageID: 7}, "radius_5")
+
+	updates, err := bot.GetUpdates(tgbotapi.NewUpdate(0))
+	require.NoError(t, err)
+	require.Len(t, updates, 2)
+	assert.Equal(t, "/start", updates[0].Message.Text)
+	assert.True(t, updates[0].Message.IsCommand())
+	assert.Equal(t, "radius_5", updates[1].CallbackQuery.Data)
+	assert.Equal(t, 7, updates[1].CallbackQuery.Message.MessageID)
+
+	// Requesting the next offset drops confirmed updates
+	updates, err = bot.GetUpdates(tgbotapi.NewUpdate(first + 1))
+	require.NoError(t, err)
+	assert.Len(t, updates, 1)
+
+	updates, err = bot.GetUpdates(tgbotapi.NewUpdate(first + 2))
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+}
+
+func TestServer_LongPollWakesOnPush(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	bot, err := server.NewBot()
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.PushText(tgbotapi.User{ID: 42}, "hello")
+	}()
+
+	config := tgbotapi.NewUpdate(0)
+	config.Timeout = 1
+	updates, err := bot.GetUpdates(config)
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "hello", updates[0].Message.Text)
+}
+
+func TestServer_RecordsCallsAndFailures(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	bot, err := server.NewBot()
+	require.NoError(t, err)
+
+	msg := tgbotapi.NewMessage(42, "first")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Yes", "yes"),
+	))
+	sent, err := bot.Send(msg)
+	require.NoError(t, err)
+
+	server.Fail("sendMessage", 403, "Forbidden: bot was blocked by the user")
+	_, err = bot.Send(tgbotapi.NewMessage(42, "second"))
+	assert.EqualError(t, err, "Forbidden: bot was blocked by the user")
+
+	_, err = bot.Send(tgbotapi.NewEditMessageText(42, sent.MessageID, "edited"))
+	require.NoError(t, err)
+
+	calls := server.CallsTo("sendMessage")
+	require.Len(t, calls, 2)
+	assert.Equal(t, int64(42), calls[0].ChatID())
+	assert.Equal(t, sent.MessageID, calls[0].SentMessageID)
+	assert.Equal(t, []string{"yes"}, calls[0].CallbackData())
+
+	edit, ok := server.WaitForCall(time.Second, func(call Call) bool { return call.Method == "editMessageText" })
+	require.True(t, ok)
+	assert.Equal(t, sent.MessageID, edit.MessageID())
+	assert.Equal(t, "edited", edit.Text())
+}
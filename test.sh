@@ -46,7 +46,7 @@ done
 echo -e "${YELLOW}🔄 Reinitializing test database schema...${NC}"
 docker compose exec -T -e PGPASSWORD=librecash db_test psql -h localhost -U librecash -d librecash_test -c "DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public;" >/dev/null 2>&1
 docker compose exec -T -e PGPASSWORD=librecash db_test psql -h localhost -U librecash -d librecash_test -c "CREATE EXTENSION IF NOT EXISTS postgis;" >/dev/null 2>&1
-docker compose exec -T -e PGPASSWORD=librecash db_test psql -h localhost -U librecash -d librecash_test < db/init.sql >/dev/null 2>&1
+go run . migrate up -db "host=localhost port=15433 user=librecash password=librecash dbname=librecash_test sslmode=disable" >/dev/null
 
 echo -e "${GREEN}✅ Test database is ready${NC}"
 
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"librecash/metrics"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// defaultUpdateWorkers is used when producer_workers is not set
+const defaultUpdateWorkers = 8
+
+// updateQueueSize bounds the updates waiting for one worker. While a queue is full the loop
+// stops taking updates from Telegram until that worker catches up.
+const updateQueueSize = 100
+
+// queuedUpdate is an update waiting for its worker
+type queuedUpdate struct {
+	update   tgbotapi.Update
+	received time.Time
+}
+
+// updateLoop hands Telegram updates to a pool of workers until stopped. Updates are sharded by
+// user, so one user's updates are handled in order while different users are handled in parallel
+// and a slow update only holds up the users sharing its worker.
+type updateLoop struct {
+	updates tgbotapi.UpdatesChannel
+	handle  func(update tgbotapi.Update)
+	queues  []chan queuedUpdate
+	workers sync.WaitGroup
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu       sync.Mutex
+	inFlight int // updates being handled right now
+	handled  int // updates handled since start
+}
+
+func newUpdateLoop(updates tgbotapi.UpdatesChannel, workers int, handle func(update tgbotapi.Update)) *updateLoop {
+	if workers < 1 {
+		workers = 1
+	}
+	queues := make([]chan queuedUpdate, workers)
+	for i := range queues {
+		queues[i] = make(chan queuedUpdate, updateQueueSize)
+	}
+
+	return &updateLoop{
+		updates: updates,
+		handle:  handle,
+		queues:  queues,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// run blocks until shutdown is called and the buffered updates are handled
+func (l *updateLoop) run() {
+	defer close(l.done)
+
+	for i := range l.queues {
+		l.workers.Add(1)
+		go l.work(i)
+	}
+
+	for {
+		// Check stop first, select picks randomly when an update is ready as well
+		select {
+		case <-l.stop:
+			l.drainBuffered()
+			return
+		default:
+		}
+
+		select {
+		case <-l.stop:
+			l.drainBuffered()
+			return
+		case update := <-l.updates:
+			l.dispatch(update)
+		}
+	}
+}
+
+// drainBuffered queues the updates already received and waits for the workers to handle them.
+// Telegram considers them delivered once the next poll confirmed the offset, so dropping them
+// would lose user input.
+func (l *updateLoop) drainBuffered() {
+	for {
+		select {
+		case update := <-l.updates:
+			l.dispatch(update)
+		default:
+			for _, queue := range l.queues {
+				close(queue)
+			}
+			l.workers.Wait()
+			return
+		}
+	}
+}
+
+// dispatch queues update for the worker of its user, waiting while that queue is full
+func (l *updateLoop) dispatch(update tgbotapi.Update) {
+	worker := l.workerFor(update)
+	l.queues[worker] <- queuedUpdate{update: update, received: time.Now()}
+	metrics.SetUpdateQueueDepth(worker, len(l.queues[worker]))
+}
+
+// workerFor picks the worker by user ID. Updates without a user carry no ordering
+// requirement and are spread by update ID.
+func (l *updateLoop) workerFor(update tgbotapi.Update) int {
+	key := updateUserID(update)
+	if key == 0 {
+		key = int64(update.UpdateID)
+	}
+	if key < 0 {
+		key = -key // group chats have negative IDs
+	}
+	return int(key % int64(len(l.queues)))
+}
+
+// work handles the updates of one queue in order until the queue is closed
+func (l *updateLoop) work(worker int) {
+	defer l.workers.Done()
+
+	for queued := range l.queues[worker] {
+		metrics.SetUpdateQueueDepth(worker, len(l.queues[worker]))
+
+		l.mu.Lock()
+		l.inFlight++
+		l.mu.Unlock()
+
+		started := time.Now()
+		l.handle(queued.update)
+		metrics.RecordUpdateHandled(updateType(queued.update), started.Sub(queued.received), time.Since(started))
+
+		l.mu.Lock()
+		l.inFlight--
+		l.handled++
+		l.mu.Unlock()
+	}
+}
+
+// queued counts the updates received but not picked up by a worker yet
+func (l *updateLoop) queued() int {
+	queued := len(l.updates)
+	for _, queue := range l.queues {
+		queued += len(queue)
+	}
+	return queued
+}
+
+// shutdown stops the loop after the updates being handled and the buffered ones, and waits for
+// it or for ctx to end. The caller stops polling first; updates fetched by a poll still running
+// are not confirmed to Telegram and come again after restart.
+func (l *updateLoop) shutdown(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	inFlight, handled := l.inFlight, l.handled
+	l.mu.Unlock()
+
+	close(l.stop)
+
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return fmt.Sprintf("%d update(s) in progress, %d queued", l.inFlight, l.queued()), ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buffered := l.handled - handled - inFlight
+	return fmt.Sprintf("finished %d in-flight and %d buffered update(s)", inFlight, buffered), nil
+}
+
+// updateUserID returns the user an update comes from, 0 when there is none
+func updateUserID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return int64(update.CallbackQuery.From.ID)
+	}
+	return 0
+}
+
+// updateType labels an update for the latency metrics
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.CallbackQuery != nil:
+		return "callback"
+	}
+	return "other"
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"librecash/telegramtest"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func textUpdate(updateID int, userID int64) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: userID}, Text: "hello"},
+	}
+}
+
+func TestUpdateLoopKeepsOrderPerUser(t *testing.T) {
+	updates := make(chan tgbotapi.Update, 100)
+	var mu sync.Mutex
+	handled := map[int64][]int{}
+	loop := newUpdateLoop(updates, 4, func(update tgbotapi.Update) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		userID := updateUserID(update)
+		handled[userID] = append(handled[userID], update.UpdateID)
+	})
+	go loop.run()
+
+	expected := map[int64][]int{}
+	for i := 1; i <= 60; i++ {
+		userID := int64(1000 + i%3)
+		updates <- textUpdate(i, userID)
+		expected[userID] = append(expected[userID], i)
+	}
+
+	drained, err := loop.shutdown(context.Background())
+	require.NoError(t, err, drained)
+	assert.Equal(t, expected, handled, "Each user's updates are handled in the order received")
+}
+
+func TestUpdateLoopHandlesUsersInParallel(t *testing.T) {
+	updates := make(chan tgbotapi.Update, 10)
+	release := make(chan struct{})
+	slowStarted := make(chan struct{})
+	fastHandled := make(chan int, 10)
+	loop := newUpdateLoop(updates, 2, func(update tgbotapi.Update) {
+		if updateUserID(update) == 1 {
+			close(slowStarted)
+			<-release
+			return
+		}
+		fastHandled <- update.UpdateID
+	})
+	go loop.run()
+
+	updates <- textUpdate(1, 1) // user 1 on worker 1, blocks
+	<-slowStarted
+	updates <- textUpdate(2, 2) // user 2 on worker 0
+	updates <- textUpdate(3, 2)
+
+	for _, expected := range []int{2, 3} {
+		select {
+		case updateID := <-fastHandled:
+			assert.Equal(t, expected, updateID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("another user's update waited for the slow one")
+		}
+	}
+
+	close(release)
+	_, err := loop.shutdown(context.Background())
+	require.NoError(t, err)
+}
+
+func TestUpdateLoopWorkerFor(t *testing.T) {
+	loop := newUpdateLoop(make(chan tgbotapi.Update), 4, func(tgbotapi.Update) {})
+
+	callback := tgbotapi.Update{UpdateID: 8, CallbackQuery: &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 6}}}
+	assert.Equal(t, 2, loop.workerFor(callback), "Callbacks go to the worker of the user who pressed")
+	assert.Equal(t, loop.workerFor(textUpdate(1, 6)), loop.workerFor(callback))
+	assert.Equal(t, 1, loop.workerFor(textUpdate(1, -1001)), "Negative chat IDs are valid")
+	assert.Equal(t, 3, loop.workerFor(tgbotapi.Update{UpdateID: 7}), "Updates without a user spread by update ID")
+
+	assert.Len(t, newUpdateLoop(nil, 0, nil).queues, 1, "At least one worker")
+}
+
+func TestUpdateLoopFinishesInFlightAndBufferedUpdates(t *testing.T) {
+	server := telegramtest.NewServer()
+	defer server.Close()
+	bot, err := server.NewBot()
+	require.NoError(t, err)
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 1
+	updates, err := bot.GetUpdatesChan(u)
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled []int
+	loop := newUpdateLoop(updates, 4, func(update tgbotapi.Update) {
+		if len(handled) == 0 {
+			close(started)
+			<-release
+		}
+		handled = append(handled, update.UpdateID)
+	})
+	go loop.run()
+
+	user := tgbotapi.User{ID: 3001, FirstName: "Dana", LanguageCode: "en"}
+	first := server.PushText(user, "/start")
+	<-started
+	second := server.PushText(user, "hello")
+	require.Eventually(t, func() bool { return loop.queued() == 1 }, 5*time.Second, 10*time.Millisecond,
+		"second update waits behind the first one of the same user")
+
+	bot.StopReceivingUpdates()
+	type result struct {
+		drained string
+		err     error
+	}
+	stopped := make(chan result, 1)
+	go func() {
+		drained, err := loop.shutdown(context.Background())
+		stopped <- result{drained, err}
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned while an update was being handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case r := <-stopped:
+		require.NoError(t, r.err)
+		assert.Equal(t, "finished 1 in-flight and 1 buffered update(s)", r.drained)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	assert.Equal(t, []int{first, second}, handled)
+}
+
+func TestUpdateLoopShutdownTimeout(t *testing.T) {
+	updates := make(chan tgbotapi.Update, 1)
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	loop := newUpdateLoop(updates, 2, func(update tgbotapi.Update) {
+		close(started)
+		<-release
+	})
+	go loop.run()
+
+	updates <- tgbotapi.Update{UpdateID: 1}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	drained, err := loop.shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, "1 update(s) in progress, 0 queued", drained)
+}
@@ -0,0 +1,192 @@
+// Package webhook receives Telegram updates over HTTPS as an alternative to long polling,
+// so several producer instances can run behind a load balancer.
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// SecretHeader carries the secret_token given to setWebhook on every update Telegram posts
+const SecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxUpdateSize bounds a request body, updates are a few KB at most
+const maxUpdateSize = 1024 * 1024
+
+// Telegram accepts 1-256 characters A-Z, a-z, 0-9, _ and - as secret token
+var secretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Config selects where Telegram posts updates and how the server listens
+type Config struct {
+	URL            string // public HTTPS URL registered with Telegram, including the path
+	Listen         string // local listen address, e.g. ":8443"
+	Path           string // request path the handler serves, e.g. "/telegram/webhook"
+	Secret         string // secret_token, required
+	TLSCert        string // certificate file, empty when TLS is terminated by a proxy
+	TLSKey         string // key file for TLSCert
+	MaxConnections int    // concurrent connections Telegram may open, 0 keeps Telegram's default (40)
+}
+
+// Validate reports configuration errors before anything is registered with Telegram
+func (c Config) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("webhook_url is required in webhook mode")
+	}
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme != "https" {
+		return fmt.Errorf("webhook_url must be an https URL, got %q", c.URL)
+	}
+	if !secretPattern.MatchString(c.Secret) {
+		return fmt.Errorf("webhook_secret must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("webhook_tls_cert and webhook_tls_key must be set together")
+	}
+	if c.Path == "" || c.Path[0] != '/' {
+		return fmt.Errorf("webhook_path must start with /, got %q", c.Path)
+	}
+	return nil
+}
+
+// NewHandler returns the HTTP handler Telegram posts updates to. Requests without the secret
+// are rejected. Accepted updates are passed to updates; while it is full the request waits,
+// so Telegram slows down and retries instead of updates being dropped.
+func NewHandler(secret string, updates chan<- tgbotapi.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretHeader)), []byte(secret)) != 1 {
+			log.Printf("[WEBHOOK] Rejected request from %s: missing or wrong secret token", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxUpdateSize))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var update tgbotapi.Update
+		if err := json.Unmarshal(body, &update); err != nil {
+			log.Printf("[WEBHOOK] Rejected malformed update: %v", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+			// Not acknowledged, Telegram delivers the update again
+			log.Printf("[WEBHOOK] Update %d not accepted before the request ended", update.UpdateID)
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// Server registers the webhook with Telegram and serves it
+type Server struct {
+	config  Config
+	bot     *tgbotapi.BotAPI
+	updates chan tgbotapi.Update
+	http    *http.Server
+	errors  chan error
+}
+
+// NewServer creates a webhook server for bot, updates are buffered up to bufferSize
+func NewServer(config Config, bot *tgbotapi.BotAPI, bufferSize int) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	updates := make(chan tgbotapi.Update, bufferSize)
+	mux := http.NewServeMux()
+	mux.Handle(config.Path, NewHandler(config.Secret, updates))
+
+	return &Server{
+		config:  config,
+		bot:     bot,
+		updates: updates,
+		http:    &http.Server{Addr: config.Listen, Handler: mux},
+		errors:  make(chan error, 1),
+	}, nil
+}
+
+// Start listens for updates and then registers the webhook, so Telegram never posts to a
+// server that is not up yet. Returns the channel updates are delivered to.
+func (s *Server) Start() (tgbotapi.UpdatesChannel, error) {
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", s.config.Listen, err)
+	}
+	return s.serve(listener)
+}
+
+func (s *Server) serve(listener net.Listener) (tgbotapi.UpdatesChannel, error) {
+	tls := s.config.TLSCert != ""
+	log.Printf("[WEBHOOK] Serving %s on %s (tls: %v)", s.config.Path, listener.Addr(), tls)
+
+	go func() {
+		var err error
+		if tls {
+			err = s.http.ServeTLS(listener, s.config.TLSCert, s.config.TLSKey)
+		} else {
+			err = s.http.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("[WEBHOOK] Server stopped: %v", err)
+			s.errors <- err
+		}
+	}()
+
+	if err := s.register(); err != nil {
+		s.http.Close()
+		return nil, err
+	}
+	return s.updates, nil
+}
+
+// register calls setWebhook. The library predates secret_token, so the request is built here.
+func (s *Server) register() error {
+	params := url.Values{}
+	params.Set("url", s.config.URL)
+	params.Set("secret_token", s.config.Secret)
+	if s.config.MaxConnections > 0 {
+		params.Set("max_connections", strconv.Itoa(s.config.MaxConnections))
+	}
+
+	response, err := s.bot.MakeRequest("setWebhook", params)
+	if err != nil {
+		return fmt.Errorf("setWebhook failed: %v", err)
+	}
+	if !response.Ok {
+		return fmt.Errorf("setWebhook failed: %s", response.Description)
+	}
+	log.Printf("[WEBHOOK] Registered webhook %s", s.config.URL)
+	return nil
+}
+
+// Errors reports a server that stopped on its own, e.g. an invalid TLS certificate
+func (s *Server) Errors() <-chan error {
+	return s.errors
+}
+
+// Shutdown stops accepting updates and waits for requests in progress to hand over theirs.
+// The webhook stays registered, other instances or the next start keep receiving updates.
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Printf("[WEBHOOK] Stopping webhook server")
+	return s.http.Shutdown(ctx)
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"librecash/telegramtest"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "s3cret_token-1"
+
+func validConfig() Config {
+	return Config{
+		URL:    "https://bot.example.com/telegram/webhook",
+		Listen: "127.0.0.1:0",
+		Path:   "/telegram/webhook",
+		Secret: testSecret,
+	}
+}
+
+func post(handler http.Handler, secret, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", bytes.NewBufferString(body))
+	if secret != "" {
+		req.Header.Set(SecretHeader, secret)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerVerifiesSecret(t *testing.T) {
+	updates := make(chan tgbotapi.Update, 1)
+	handler := NewHandler(testSecret, updates)
+	body := `{"update_id":7,"message":{"message_id":1,"text":"/start","chat":{"id":42}}}`
+
+	assert.Equal(t, http.StatusForbidden, post(handler, "", body).Code)
+	assert.Equal(t, http.StatusForbidden, post(handler, "wrong", body).Code)
+	assert.Empty(t, updates, "Rejected requests are not dispatched")
+
+	assert.Equal(t, http.StatusOK, post(handler, testSecret, body).Code)
+	update := <-updates
+	assert.Equal(t, 7, update.UpdateID)
+	assert.Equal(t, "/start", update.Message.Text)
+}
+
+func TestHandlerRejectsBadRequests(t *testing.T) {
+	handler := NewHandler(testSecret, make(chan tgbotapi.Update, 1))
+
+	assert.Equal(t, http.StatusBadRequest, post(handler, testSecret, "not json").Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/telegram/webhook", nil)
+	req.Header.Set(SecretHeader, testSecret)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestHandlerWaitsWhileBufferIsFull(t *testing.T) {
+	updates := make(chan tgbotapi.Update) // nobody reads
+	handler := NewHandler(testSecret, updates)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", bytes.NewBufferString(`{"update_id":1}`)).WithContext(ctx)
+	req.Header.Set(SecretHeader, testSecret)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// Not acknowledged, so Telegram delivers the update again
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+
+	config := validConfig()
+	config.URL = "http://bot.example.com/hook"
+	assert.EqualError(t, config.Validate(), `webhook_url must be an https URL, got "http://bot.example.com/hook"`)
+
+	config = validConfig()
+	config.Secret = ""
+	assert.Error(t, config.Validate(), "A secret is required")
+
+	config = validConfig()
+	config.Secret = "has spaces"
+	assert.Error(t, config.Validate())
+
+	config = validConfig()
+	config.TLSCert = "cert.pem"
+	assert.EqualError(t, config.Validate(), "webhook_tls_cert and webhook_tls_key must be set together")
+}
+
+func TestServerRegistersAndReceives(t *testing.T) {
+	telegram := telegramtest.NewServer()
+	defer telegram.Close()
+	bot, err := telegram.NewBot()
+	require.NoError(t, err)
+
+	config := validConfig()
+	config.MaxConnections = 10
+	server, err := NewServer(config, bot, 10)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	updates, err := server.serve(listener)
+	require.NoError(t, err)
+
+	registrations := telegram.CallsTo("setWebhook")
+	require.Len(t, registrations, 1)
+	assert.Equal(t, config.URL, registrations[0].Params.Get("url"))
+	assert.Equal(t, testSecret, registrations[0].Params.Get("secret_token"))
+	assert.Equal(t, "10", registrations[0].Params.Get("max_connections"))
+
+	// Telegram posts an update to the registered path
+	req, err := http.NewRequest(http.MethodPost, "http://"+listener.Addr().String()+config.Path,
+		bytes.NewBufferString(`{"update_id":5,"callback_query":{"id":"cb","data":"radius_5","from":{"id":42}}}`))
+	require.NoError(t, err)
+	req.Header.Set(SecretHeader, testSecret)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case update := <-updates:
+		assert.Equal(t, "radius_5", update.CallbackQuery.Data)
+	case <-time.After(time.Second):
+		t.Fatal("update was not delivered")
+	}
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	_, err = http.DefaultClient.Do(req)
+	assert.Error(t, err, "Server no longer accepts connections")
+}
+
+func TestServerFailsWhenRegistrationFails(t *testing.T) {
+	telegram := telegramtest.NewServer()
+	defer telegram.Close()
+	bot, err := telegram.NewBot()
+	require.NoError(t, err)
+	telegram.Fail("setWebhook", 400, "Bad Request: bad webhook: HTTPS url must be provided for webhook")
+
+	server, err := NewServer(validConfig(), bot, 10)
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = server.serve(listener)
+	assert.Error(t, err)
+}